@@ -2,26 +2,96 @@ package archiving
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/mholt/archiver/v4"
 )
 
+// NullSink is the dst of Extract reading the files of the archive without writing them.
+// It checks the archive can be extracted.
+const NullSink = os.DevNull
+
 type extract struct {
-	dst string
+	dst     string
+	targets map[string]string // Directories of the top level names, overriding dst
 }
 
-func NewExtract(dst string) *extract {
+func NewExtract(dst string, targets map[string]string) *extract {
 	return &extract{
-		dst: dst,
+		dst:     dst,
+		targets: targets,
+	}
+}
+
+// path returns the path on the disk of the archive entry.
+// The longest target matching the start of the name wins, the others entries go inside dst.
+// Archives come from the storages, so the names leaving their root (zip-slip) are rejected.
+func (e *extract) path(nameInArchive string) (string, error) {
+
+	name := path.Clean(strings.TrimSuffix(nameInArchive, "/"))
+	if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return "", fmt.Errorf("entry %s is outside of the archive", nameInArchive)
+	}
+
+	matched := ""
+	for prefix := range e.targets {
+		if (name == prefix || strings.HasPrefix(name, prefix+"/")) && len(prefix) > len(matched) {
+			matched = prefix
+		}
+	}
+
+	root, rel := e.dst, name
+	if matched != "" {
+		root, rel = e.targets[matched], strings.TrimPrefix(name, matched)
+	} else if e.dst == "" {
+		return "", fmt.Errorf("no target to extract %s", nameInArchive)
+	}
+
+	filePath := filepath.Join(root, filepath.FromSlash(rel))
+	if !inside(root, filePath) {
+		return "", fmt.Errorf("entry %s is outside of %s", nameInArchive, root)
+	}
+
+	return filePath, nil
+}
+
+// inside returns whether the path is the root or one of its descendants
+func inside(root, filePath string) bool {
+
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return false
 	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// ExtractPath returns the path on the disk of the name inside an archive, resolved as the extraction does
+func ExtractPath(nameInArchive, dst string, targets map[string]string) (string, error) {
+	return NewExtract(dst, targets).path(nameInArchive)
 }
 
 func (e *extract) handler(ctx context.Context, f archiver.File) error {
 
-	relativePath := filepath.Join(e.dst, f.NameInArchive)
+	// The rules of the archive are not part of the restored files.
+	// The deleted names are only extracted when a target asks for them.
+	if f.NameInArchive == RulesFileName || (f.NameInArchive == DeletedFileName && e.targets[DeletedFileName] == "") {
+		return nil
+	}
+
+	if e.dst == NullSink && len(e.targets) == 0 {
+		return discard(f)
+	}
+
+	relativePath, err := e.path(f.NameInArchive)
+	if err != nil {
+		return err
+	}
 
 	if f.FileInfo.IsDir() {
 		return os.MkdirAll(relativePath, os.ModePerm)
@@ -50,3 +120,20 @@ func (e *extract) handler(ctx context.Context, f archiver.File) error {
 	_, err = io.Copy(dstFile, srcFile)
 	return err
 }
+
+// discard reads the content of the archive file without writing it
+func discard(f archiver.File) error {
+
+	if f.FileInfo.IsDir() {
+		return nil
+	}
+
+	srcFile, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	_, err = io.Copy(io.Discard, srcFile)
+	return err
+}
@@ -0,0 +1,83 @@
+package archiving
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtractPath(t *testing.T) {
+
+	dst := filepath.Join("restore", "dst")
+	targets := map[string]string{"nginx": filepath.Join("etc", "nginx")}
+
+	for name, want := range map[string]string{
+		"data/file.txt":     filepath.Join(dst, "data", "file.txt"),
+		"./data/":           filepath.Join(dst, "data"),
+		"nginx/nginx.conf":  filepath.Join("etc", "nginx", "nginx.conf"),
+		"nginx":             filepath.Join("etc", "nginx"),
+		"data/../file.txt":  filepath.Join(dst, "file.txt"),
+		"nginx/../file.txt": filepath.Join(dst, "file.txt"),
+	} {
+		got, err := ExtractPath(name, dst, targets)
+		if err != nil || got != want {
+			t.Fatalf("Unexpected path of %s: %s %v", name, got, err)
+		}
+	}
+
+	// Names leaving the dst or their target are rejected
+	for _, name := range []string{"../../etc/cron.d/x", "..", "/abs/path", "data/../../x", "nginx/../../x"} {
+		if got, err := ExtractPath(name, dst, targets); err == nil {
+			t.Fatalf("Name %s extracted to %s", name, got)
+		}
+	}
+}
+
+func TestExtractSlip(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst")
+	outside := filepath.Join(dir, "evil.txt")
+
+	tarData := &bytes.Buffer{}
+	gw := gzip.NewWriter(tarData)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "../evil.txt", Mode: 0o644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("evil"))
+	tw.Close()
+	gw.Close()
+
+	zipData := &bytes.Buffer{}
+	zw := zip.NewWriter(zipData)
+	w, _ := zw.Create("../evil.txt")
+	w.Write([]byte("evil"))
+	zw.Close()
+
+	for entity, data := range map[string][]byte{"tar": tarData.Bytes(), "zip": zipData.Bytes()} {
+
+		p, err := GetProvider(TarProvider, testTarConf)
+		if entity == "zip" {
+			p, err = GetProvider(ZipProvider, testZipConf)
+		}
+		if err != nil {
+			t.Fatalf("Error creating %s provider: %s", entity, err.Error())
+		}
+
+		err = p.Extract(ctx, bytes.NewReader(data), dst, nil, false)
+		if err == nil {
+			t.Fatalf("%s: entry leaving the dst extracted", entity)
+		}
+		if _, err := os.Stat(outside); !os.IsNotExist(err) {
+			t.Fatalf("%s: file written outside of the dst: %v", entity, err)
+		}
+	}
+}
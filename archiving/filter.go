@@ -0,0 +1,623 @@
+package archiving
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver/v4"
+)
+
+const (
+	// IgnoreFileName is the name of the per-directory files holding exclude patterns
+	IgnoreFileName = ".harpoignore"
+
+	// RulesFileName is the file recording the applied rules, at the root of the filtered archives
+	RulesFileName = ".harpo-rules.json"
+)
+
+// Filter selects the files of the archives.
+// Patterns use the gitignore syntax and are relative to the archived folder.
+type Filter struct {
+	Include []string      // When set, only the matching files are archived
+	Exclude []string      // Matching files are not archived. The .harpoignore files override them
+	MaxSize int64         // Files larger than MaxSize bytes are not archived. Disabled when 0
+	MaxAge  time.Duration // Files modified more than MaxAge ago are not archived. Disabled when 0
+	MinAge  time.Duration // Files modified less than MinAge ago are not archived. Disabled when 0
+
+	Base     *Index // When set, only the files changed since the base are archived, with the list of the deleted ones
+	Index    *Index // When set, receives the files of the archive, unchanged ones included. It is the base of the next archives
+	Manifest *Index // When set, receives the files written to the archive, with the SHA-256 of their content
+}
+
+// Rules records the rules applied to an archive
+type Rules struct {
+	Include     []string  `json:"include,omitempty"`
+	Exclude     []string  `json:"exclude,omitempty"`
+	IgnoreFiles []string  `json:"ignore_files,omitempty"` // .harpoignore files found in the folder, with their patterns
+	MaxSize     int64     `json:"max_size,omitempty"`
+	MaxAge      string    `json:"max_age,omitempty"`
+	MinAge      string    `json:"min_age,omitempty"`
+	Excluded    int       `json:"excluded"` // Count of the files and folders left out
+	Date        time.Time `json:"date"`
+}
+
+// pattern is a compiled gitignore pattern
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool // The pattern starts with !: matching files are included again
+	dirOnly bool // The pattern ends with /: only directories match
+}
+
+// patternList holds the patterns of a directory, matched against the paths relative to it
+type patternList struct {
+	base     string // Directory of the patterns, relative to the archived folder. Empty for the folder itself
+	patterns []pattern
+}
+
+// compilePattern converts a gitignore pattern to a regular expression.
+// Blank lines and comments return a nil pattern.
+func compilePattern(line string) (*pattern, error) {
+
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	source := line
+
+	p := &pattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, fmt.Errorf("invalid pattern %q", source)
+	}
+
+	// Patterns without inner slash match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := &strings.Builder{}
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(line); i++ {
+
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(line[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", source, err)
+	}
+	p.re = re
+
+	return p, nil
+}
+
+// compilePatterns compiles the gitignore patterns of a directory
+func compilePatterns(base string, lines []string) (*patternList, error) {
+
+	l := &patternList{base: base}
+	for _, line := range lines {
+
+		p, err := compilePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			l.patterns = append(l.patterns, *p)
+		}
+	}
+
+	return l, nil
+}
+
+// ValidatePatterns checks the gitignore patterns
+func ValidatePatterns(lines []string) error {
+	_, err := compilePatterns("", lines)
+	return err
+}
+
+// match returns whether the last pattern matching the path negates it, and whether a pattern matched at all.
+// rel is relative to the archived folder.
+func (l *patternList) match(rel string, isDir bool) (bool, bool) {
+
+	if l.base != "" {
+		if !strings.HasPrefix(rel, l.base+"/") {
+			return false, false
+		}
+		rel = rel[len(l.base)+1:]
+	}
+
+	for i := len(l.patterns) - 1; i >= 0; i-- {
+
+		p := l.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			return !p.negate, true
+		}
+	}
+
+	return false, false
+}
+
+// walker lists the files of a folder which go through the filter
+type walker struct {
+	filter    *Filter
+	name      string // Name of the walked source inside the archive
+	all       bool   // The files of the walked source are not filtered
+	includes  *patternList
+	excludes  []*patternList // Exclude patterns of the config, then of the .harpoignore files from the top
+	now       time.Time
+	rules     Rules
+	files     []archiver.File
+	pending   map[string]archiver.File // Directories emitted only when they hold an included file
+	included  map[string]bool          // Included directories
+	emitted   map[string]bool
+	dirs      map[string]bool // Walked directories
+	seen      map[string]bool // Names of the selected files and directories, changed or not. Only filled with an index
+	filtering bool            // At least one rule applies
+}
+
+// filesFromDisk lists the files of the sources to archive, like archiver.FilesFromDisk does, without the ones left out by the filter.
+// The patterns apply to each source separately. The directories left out are not walked.
+// With ignoreErrors, entries which can not be read are skipped.
+// When rules were applied, a file recording them is added at the root of the archive.
+// With a base index, only the changed files are listed, along with a file holding the deleted names.
+func filesFromDisk(srcs []Source, filter *Filter, ignoreErrors bool) ([]archiver.File, error) {
+
+	if filter == nil {
+		filter = &Filter{}
+	}
+
+	includes, err := compilePatterns("", filter.Include)
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := compilePatterns("", filter.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &walker{
+		filter:   filter,
+		includes: includes,
+		now:      time.Now(),
+		rules: Rules{
+			Include: filter.Include,
+			Exclude: filter.Exclude,
+			MaxSize: filter.MaxSize,
+		},
+		seen:      map[string]bool{},
+		filtering: len(filter.Include) > 0 || len(filter.Exclude) > 0 || filter.MaxSize > 0 || filter.MaxAge > 0 || filter.MinAge > 0,
+	}
+	if filter.MaxAge > 0 {
+		w.rules.MaxAge = filter.MaxAge.String()
+	}
+	if filter.MinAge > 0 {
+		w.rules.MinAge = filter.MinAge.String()
+	}
+
+	for _, src := range srcs {
+
+		root := filepath.Clean(sanitizePath(src.Path))
+		w.name = src.ArchiveName()
+		w.all = src.Unfiltered
+		w.excludes = []*patternList{excludes}
+		w.pending = map[string]archiver.File{}
+		w.included = map[string]bool{"": len(filter.Include) == 0}
+		w.emitted = map[string]bool{}
+		w.dirs = map[string]bool{}
+
+		if src.Streams != nil {
+			w.stream(src)
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+			return w.visit(root, filePath, d, err, ignoreErrors)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if w.filtering {
+		w.rules.Date = w.now
+		w.files = append(w.files, jsonFile(RulesFileName, w.rules, w.now))
+	}
+
+	if filter.Base != nil {
+		w.files = append(w.files, jsonFile(DeletedFileName, deletedNames(filter.Base, w.seen), w.now))
+	}
+
+	return w.files, nil
+}
+
+// FilesFromDisk lists the files of the sources which go through the filter, named as inside the archives.
+// Other storage formats use it to store the same selection of files.
+func FilesFromDisk(srcs []Source, filter *Filter, ignoreErrors bool) ([]archiver.File, error) {
+	return filesFromDisk(srcs, filter, ignoreErrors)
+}
+
+func (w *walker) visit(root, filePath string, d fs.DirEntry, err error, ignoreErrors bool) error {
+
+	if err != nil {
+		if !ignoreErrors || filePath == root {
+			return err
+		}
+		if d != nil && d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	parent := path.Dir(rel)
+	if parent == "." {
+		parent = ""
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		if ignoreErrors {
+			return nil
+		}
+		return err
+	}
+
+	// The patterns of the directories left by the walk do not apply anymore
+	for top := w.excludes[len(w.excludes)-1]; top.base != "" && !strings.HasPrefix(rel, top.base+"/"); top = w.excludes[len(w.excludes)-1] {
+		w.excludes = w.excludes[:len(w.excludes)-1]
+	}
+
+	if rel != "" && !w.all && w.skipped(rel, info) {
+		w.rules.Excluded++
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	file, err := diskFile(filePath, path.Join(w.name, rel), info)
+	if err != nil {
+		if ignoreErrors {
+			return nil
+		}
+		return err
+	}
+
+	included := w.included[parent]
+	if rel == "" || w.all {
+		included = true
+	} else if matched, ok := w.includes.match(rel, d.IsDir()); ok {
+		included = matched
+	}
+
+	if d.IsDir() {
+
+		if !w.all {
+			if err := w.loadIgnoreFile(filePath, rel); err != nil && !ignoreErrors {
+				return err
+			}
+		}
+
+		w.included[rel] = included && (rel != "" || len(w.filter.Include) == 0)
+		w.pending[rel] = file
+		w.dirs[rel] = true
+		if included {
+			w.keep(rel)
+
+			// Directories of the base are only emitted when they hold a changed file
+			if w.filter.Base == nil || !w.filter.Base.has(path.Join(w.name, rel)) {
+				w.emit(rel)
+			}
+		}
+		return nil
+	}
+
+	if !included {
+		w.rules.Excluded++
+		return nil
+	}
+
+	name := path.Join(w.name, rel)
+	w.keep(parent)
+	if !w.changed(name, filePath, info) {
+		return nil
+	}
+
+	w.emit(parent)
+	w.files = append(w.files, w.indexed(file, name, info))
+
+	return nil
+}
+
+// skipped checks the exclude patterns, the size and the age of the entry
+func (w *walker) skipped(rel string, info fs.FileInfo) bool {
+
+	excluded := false
+	for _, l := range w.excludes {
+		if matched, ok := l.match(rel, info.IsDir()); ok {
+			excluded = matched
+		}
+	}
+	if excluded || info.IsDir() {
+		return excluded
+	}
+
+	if w.filter.MaxSize > 0 && info.Size() > w.filter.MaxSize {
+		return true
+	}
+	age := w.now.Sub(info.ModTime())
+	if w.filter.MaxAge > 0 && age > w.filter.MaxAge {
+		return true
+	}
+	if w.filter.MinAge > 0 && age < w.filter.MinAge {
+		return true
+	}
+
+	return false
+}
+
+// loadIgnoreFile reads the .harpoignore file of the directory. Its patterns apply to the whole directory tree.
+func (w *walker) loadIgnoreFile(dirPath, rel string) error {
+
+	data, err := os.ReadFile(filepath.Join(dirPath, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	l, err := compilePatterns(rel, lines)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Join(dirPath, IgnoreFileName), err)
+	}
+
+	w.excludes = append(w.excludes, l)
+	w.rules.IgnoreFiles = append(w.rules.IgnoreFiles, path.Join(w.name, rel, IgnoreFileName))
+	w.filtering = true
+
+	return nil
+}
+
+// emit adds the directory to the files, after its parents
+func (w *walker) emit(rel string) {
+
+	if w.emitted[rel] {
+		return
+	}
+	if rel != "" {
+		parent := path.Dir(rel)
+		if parent == "." {
+			parent = ""
+		}
+		w.emit(parent)
+	}
+
+	w.emitted[rel] = true
+	if file, ok := w.pending[rel]; ok {
+		w.files = append(w.files, file)
+		delete(w.pending, rel)
+	}
+}
+
+// keep records the directory and its parents as selected, changed or not
+func (w *walker) keep(rel string) {
+
+	if w.filter.Base == nil && w.filter.Index == nil {
+		return
+	}
+
+	for w.dirs[rel] {
+
+		name := path.Join(w.name, rel)
+		if w.seen[name] {
+			return
+		}
+		w.seen[name] = true
+		if w.filter.Index != nil {
+			w.filter.Index.set(name, IndexEntry{Dir: true})
+		}
+
+		if rel == "" {
+			return
+		}
+		rel = path.Dir(rel)
+		if rel == "." {
+			rel = ""
+		}
+	}
+}
+
+// changed returns whether the file changed since the base index. Unchanged files are recorded in the new index as is.
+// A file with the same size and content is unchanged, even when its times or its inode changed.
+func (w *walker) changed(name, filePath string, info fs.FileInfo) bool {
+
+	if w.filter.Base == nil && w.filter.Index == nil {
+		return true
+	}
+	w.seen[name] = true
+
+	if w.filter.Base == nil {
+		return true
+	}
+	base, ok := w.filter.Base.get(name)
+	if !ok || base.Dir {
+		return true
+	}
+
+	entry := newIndexEntry(info)
+	unchanged := entry.unchanged(base)
+	if !unchanged && base.Hash != "" && entry.Size == base.Size && info.Mode().IsRegular() {
+		h := sha256.New()
+		_, err := HashFile(filePath, h)
+		unchanged = err == nil && hex.EncodeToString(h.Sum(nil)) == base.Hash
+	}
+	if !unchanged {
+		return true
+	}
+
+	entry.Hash = base.Hash
+	if w.filter.Index != nil {
+		w.filter.Index.set(name, entry)
+	}
+
+	return false
+}
+
+// indexed returns the file recording itself inside the new index and the manifest once archived
+func (w *walker) indexed(file archiver.File, name string, info fs.FileInfo) archiver.File {
+
+	indexes := w.indexes()
+	if len(indexes) == 0 {
+		return file
+	}
+
+	// Links and the other special files have no content to hash
+	entry := newIndexEntry(info)
+	if !info.Mode().IsRegular() {
+		for _, index := range indexes {
+			index.set(name, entry)
+		}
+		return file
+	}
+
+	open := file.Open
+	file.Open = func() (io.ReadCloser, error) {
+
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &indexReader{ReadCloser: rc, indexes: indexes, name: name, entry: entry, hash: sha256.New()}, nil
+	}
+
+	return file
+}
+
+// diskFile returns the archiver file of the entry, as archiver.FilesFromDisk does. Symbolic links are preserved.
+func diskFile(filePath, nameInArchive string, info fs.FileInfo) (archiver.File, error) {
+
+	linkTarget := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+
+		var err error
+		linkTarget, err = os.Readlink(filePath)
+		if err != nil {
+			return archiver.File{}, fmt.Errorf("%s: readlink: %w", filePath, err)
+		}
+	}
+
+	return archiver.File{
+		FileInfo:      info,
+		NameInArchive: nameInArchive,
+		LinkTarget:    linkTarget,
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(filePath)
+		},
+	}, nil
+}
+
+// virtualFileInfo describes the files which only exist inside the archives, such as the rules file
+type virtualFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (v virtualFileInfo) Name() string { return v.name }
+func (v virtualFileInfo) Size() int64  { return v.size }
+func (v virtualFileInfo) Mode() fs.FileMode {
+	if v.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+func (v virtualFileInfo) ModTime() time.Time { return v.modTime }
+func (v virtualFileInfo) IsDir() bool        { return v.dir }
+func (v virtualFileInfo) Sys() interface{}   { return nil }
+
+// jsonFile returns the file holding the value encoded in JSON, at the root of the archive
+func jsonFile(name string, v interface{}, modTime time.Time) archiver.File {
+
+	data, _ := json.MarshalIndent(v, "", "  ")
+
+	return archiver.File{
+		FileInfo:      virtualFileInfo{name: name, size: int64(len(data)), modTime: modTime},
+		NameInArchive: name,
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+	}
+}
@@ -0,0 +1,207 @@
+package archiving
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatterns(t *testing.T) {
+
+	cases := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		match   bool
+	}{
+		{"*.log", "app.log", false, true},
+		{"*.log", "logs/app.log", false, true},
+		{"*.log", "app.log.gz", false, false},
+		{"node_modules/", "web/node_modules", true, true},
+		{"node_modules/", "web/node_modules", false, false},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"docs/*.md", "docs/readme.md", false, true},
+		{"docs/*.md", "docs/api/readme.md", false, false},
+		{"docs/**/*.md", "docs/api/readme.md", false, true},
+		{"**/cache", "a/b/cache", true, true},
+		{"tmp/**", "tmp/a/b", false, true},
+		{"file?.txt", "file1.txt", false, true},
+		{"file[0-9].txt", "filea.txt", false, false},
+		{"file[!0-9].txt", "filea.txt", false, true},
+	}
+
+	for _, c := range cases {
+
+		l, err := compilePatterns("", []string{c.pattern})
+		if err != nil {
+			t.Fatalf("Error compiling pattern %s: %s", c.pattern, err.Error())
+		}
+		matched, ok := l.match(c.path, c.isDir)
+		if (matched && ok) != c.match {
+			t.Fatalf("Pattern %s on %s: got %v, want %v", c.pattern, c.path, matched && ok, c.match)
+		}
+	}
+
+	// The last matching pattern wins
+	l, _ := compilePatterns("", []string{"*.txt", "!keep.txt"})
+	if matched, ok := l.match("keep.txt", false); !ok || matched {
+		t.Fatalf("Negated pattern should include keep.txt again")
+	}
+
+	if err := ValidatePatterns([]string{"# comment", "", "/"}); err == nil {
+		t.Fatalf("Invalid pattern accepted")
+	}
+}
+
+func TestFilesFromDisk(t *testing.T) {
+
+	// Init
+	TestInit(t)
+	defer TestEnd(t)
+
+	for name, content := range map[string]string{
+		"test_dummies/node_modules/lib/index.js": "module",
+		"test_dummies/texts/big.txt":             strings.Repeat("a", 1024),
+		"test_dummies/texts/notes.log":           "log",
+		"test_dummies/texts/keep.log":            "log",
+		"test_dummies/texts/.harpoignore":        "*.log\n!keep.log\n",
+		"test_dummies/old.txt":                   "old",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			t.Fatalf("Error creating directory: %s", err.Error())
+		}
+		if err := os.WriteFile(name, []byte(content), os.ModePerm); err != nil {
+			t.Fatalf("Error creating file: %s", err.Error())
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes("test_dummies/old.txt", old, old); err != nil {
+		t.Fatalf("Error changing times: %s", err.Error())
+	}
+
+	filter := &Filter{
+		Exclude: []string{"node_modules/"},
+		MaxSize: 512,
+		MaxAge:  24 * time.Hour,
+	}
+	files, err := filesFromDisk(testSources, filter, false)
+	if err != nil {
+		t.Fatalf("Error listing files: %s", err.Error())
+	}
+
+	names := []string{}
+	var rules Rules
+	for _, f := range files {
+
+		names = append(names, f.NameInArchive)
+		if f.NameInArchive != RulesFileName {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Error opening rules: %s", err.Error())
+		}
+		err = json.NewDecoder(r).Decode(&rules)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Error reading rules: %s", err.Error())
+		}
+	}
+	sort.Strings(names)
+
+	expected := []string{
+		RulesFileName,
+		"test_dummies",
+		"test_dummies/file1.txt",
+		"test_dummies/file2.txt",
+		"test_dummies/texts",
+		"test_dummies/texts/.harpoignore",
+		"test_dummies/texts/file1.txt",
+		"test_dummies/texts/keep.log",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected files:\n%v\nwant:\n%v", names, expected)
+	}
+
+	// node_modules, big.txt, notes.log and old.txt
+	if rules.Excluded != 4 || len(rules.IgnoreFiles) != 1 || rules.IgnoreFiles[0] != "test_dummies/texts/.harpoignore" || rules.MaxSize != 512 {
+		t.Fatalf("Unexpected rules: %+v", rules)
+	}
+
+	// Include patterns keep the matching files and their folders only
+	files, err = filesFromDisk(testSources, &Filter{Include: []string{"texts/*.txt"}}, false)
+	if err != nil {
+		t.Fatalf("Error listing files: %s", err.Error())
+	}
+	names = []string{}
+	for _, f := range files {
+		names = append(names, f.NameInArchive)
+	}
+	sort.Strings(names)
+
+	expected = []string{
+		RulesFileName,
+		"test_dummies",
+		"test_dummies/texts",
+		"test_dummies/texts/big.txt",
+		"test_dummies/texts/file1.txt",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected included files:\n%v\nwant:\n%v", names, expected)
+	}
+
+	// Unfiltered sources are listed whole
+	unfiltered := []Source{{Path: "test_dummies/texts", Name: "dumps", Unfiltered: true}}
+	files, err = filesFromDisk(unfiltered, filter, false)
+	if err != nil {
+		t.Fatalf("Error listing files: %s", err.Error())
+	}
+	names = []string{}
+	for _, f := range files {
+		names = append(names, f.NameInArchive)
+	}
+	sort.Strings(names)
+
+	expected = []string{
+		RulesFileName,
+		"dumps",
+		"dumps/.harpoignore",
+		"dumps/big.txt",
+		"dumps/file1.txt",
+		"dumps/keep.log",
+		"dumps/notes.log",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected unfiltered files:\n%v\nwant:\n%v", names, expected)
+	}
+
+	// The rules are recorded inside the archive, but not restored
+	p, err := GetProvider(ZipProvider, testZipConf)
+	if err != nil {
+		t.Fatalf("Error creating Zip provider: %s", err.Error())
+	}
+	f, err := os.Create("test_dummies.zip")
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err.Error())
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err = p.Archive(ctx, testSources, f, filter, false)
+	if err != nil {
+		t.Fatalf("Error archiving: %s", err.Error())
+	}
+	f.Close()
+
+	testExtract(t, "test_dummies.zip")
+}
@@ -0,0 +1,185 @@
+package archiving
+
+import (
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeletedFileName is the file listing the names deleted since the base index, at the root of the incremental archives
+const DeletedFileName = ".harpo-deleted.json"
+
+// IndexEntry describes a file or a directory of an archive
+type IndexEntry struct {
+	Dir     bool        `json:"dir,omitempty"`
+	Size    int64       `json:"size,omitempty"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	ModTime time.Time   `json:"mod_time,omitempty"`
+	Inode   uint64      `json:"inode,omitempty"`
+	Hash    string      `json:"hash,omitempty"` // SHA-256 of the content of the regular files
+}
+
+// Index records the entries of the archived folders by their name inside the archive.
+// It is the base of the next archives, which only hold the files changed since.
+type Index struct {
+	Files map[string]IndexEntry `json:"files"`
+	mu    sync.Mutex
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{Files: map[string]IndexEntry{}}
+}
+
+// set records the entry of the name
+func (i *Index) set(name string, entry IndexEntry) {
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.Files == nil {
+		i.Files = map[string]IndexEntry{}
+	}
+	i.Files[name] = entry
+}
+
+// has returns whether the name is recorded
+func (i *Index) has(name string) bool {
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	_, ok := i.Files[name]
+	return ok
+}
+
+// get returns the entry of the name
+func (i *Index) get(name string) (IndexEntry, bool) {
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, ok := i.Files[name]
+	return entry, ok
+}
+
+// newIndexEntry describes the file from its info. The hash is not computed.
+func newIndexEntry(info fs.FileInfo) IndexEntry {
+
+	if info.IsDir() {
+		return IndexEntry{Dir: true}
+	}
+
+	return IndexEntry{
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime().UTC(),
+		Inode:   inode(info),
+	}
+}
+
+// unchanged returns whether the entry describes the same file as the base one
+func (e IndexEntry) unchanged(base IndexEntry) bool {
+	return !base.Dir && e.Size == base.Size && e.ModTime.Equal(base.ModTime) && e.Inode == base.Inode
+}
+
+// HashFile writes the content of the file to the hashes and returns its size
+func HashFile(filePath string, hashes ...io.Writer) (int64, error) {
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(io.MultiWriter(hashes...), f)
+}
+
+// indexReader hashes the file while it is archived.
+// The entry is recorded inside the indexes on close, only when the whole file has been read.
+type indexReader struct {
+	io.ReadCloser
+	indexes []*Index
+	name    string
+	entry   IndexEntry
+	hash    hash.Hash
+	read    int64
+}
+
+func (r *indexReader) Read(p []byte) (int, error) {
+
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	r.read += int64(n)
+
+	return n, err
+}
+
+func (r *indexReader) Close() error {
+
+	if r.read == r.entry.Size {
+		r.entry.Hash = hex.EncodeToString(r.hash.Sum(nil))
+		for _, index := range r.indexes {
+			index.set(r.name, r.entry)
+		}
+	}
+
+	return r.ReadCloser.Close()
+}
+
+// deletedNames returns the names of the base missing from the seen ones, sorted.
+// The content of the deleted directories is not listed.
+func deletedNames(base *Index, seen map[string]bool) []string {
+
+	names := []string{}
+	for name := range base.Files {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	deleted := []string{}
+	for _, name := range names {
+
+		if n := len(deleted); n > 0 && strings.HasPrefix(name, deleted[n-1]+"/") {
+			continue
+		}
+		deleted = append(deleted, name)
+	}
+
+	return deleted
+}
+
+// RemoveDeleted removes from the disk the deleted names of an incremental archive,
+// resolved the same way as the extracted files. Names without target are ignored.
+func RemoveDeleted(names []string, dst string, targets map[string]string) error {
+
+	e := NewExtract(dst, targets)
+	for _, name := range names {
+
+		name = path.Clean(name)
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			continue
+		}
+
+		filePath, err := e.path(name)
+		if err != nil {
+			continue
+		}
+
+		err = os.RemoveAll(filePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
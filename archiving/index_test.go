@@ -0,0 +1,162 @@
+package archiving
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
+)
+
+func TestIncrementalArchive(t *testing.T) {
+
+	// Init
+	TestInit(t)
+	defer TestEnd(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	p, err := GetProvider(ZipProvider, testZipConf)
+	if err != nil {
+		t.Fatalf("Error creating Zip provider: %s", err.Error())
+	}
+
+	// The full archive records all the files
+	full := NewIndex()
+	fullArchive := &bytes.Buffer{}
+	err = p.Archive(ctx, testSources, fullArchive, &Filter{Index: full}, false)
+	if err != nil {
+		t.Fatalf("Error archiving: %s", err.Error())
+	}
+	entry, ok := full.Files["test_dummies/texts/file1.txt"]
+	if !ok || entry.Size != 12 || entry.Hash == "" || !full.Files["test_dummies/texts"].Dir {
+		t.Fatalf("Unexpected index: %+v", full.Files)
+	}
+
+	// One file changes, one is added, one is deleted and one is only touched
+	later := time.Now().Add(time.Hour)
+	for name, content := range map[string]string{
+		"test_dummies/file1.txt":     "Hello World! 1 changed",
+		"test_dummies/new/file3.txt": "Hello World! 3",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			t.Fatalf("Error creating directory: %s", err.Error())
+		}
+		if err := os.WriteFile(name, []byte(content), os.ModePerm); err != nil {
+			t.Fatalf("Error writing file: %s", err.Error())
+		}
+	}
+	if err := os.Remove("test_dummies/file2.txt"); err != nil {
+		t.Fatalf("Error removing file: %s", err.Error())
+	}
+	if err := os.Chtimes("test_dummies/texts/file1.txt", later, later); err != nil {
+		t.Fatalf("Error changing times: %s", err.Error())
+	}
+
+	files, err := filesFromDisk(testSources, &Filter{Base: full, Index: NewIndex()}, false)
+	if err != nil {
+		t.Fatalf("Error listing files: %s", err.Error())
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.NameInArchive)
+	}
+	sort.Strings(names)
+
+	expected := []string{
+		DeletedFileName,
+		"test_dummies",
+		"test_dummies/file1.txt",
+		"test_dummies/new",
+		"test_dummies/new/file3.txt",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected incremental files:\n%v\nwant:\n%v", names, expected)
+	}
+
+	incremental := NewIndex()
+	incrementalArchive := &bytes.Buffer{}
+	err = p.Archive(ctx, testSources, incrementalArchive, &Filter{Base: full, Index: incremental}, false)
+	if err != nil {
+		t.Fatalf("Error archiving: %s", err.Error())
+	}
+
+	// The new index holds the unchanged files too, with their new times
+	if len(incremental.Files) != 6 || !incremental.Files["test_dummies/texts/file1.txt"].ModTime.Equal(later.UTC()) {
+		t.Fatalf("Unexpected incremental index: %+v", incremental.Files)
+	}
+
+	// Replaying the full and the incremental archives rebuilds the folder
+	dst := "test_dummies_extract"
+	defer os.RemoveAll(dst)
+	deletedFile := filepath.Join(dst, "deleted.json")
+
+	for _, archive := range []*bytes.Buffer{fullArchive, incrementalArchive} {
+
+		err = p.Extract(ctx, bytes.NewReader(archive.Bytes()), dst, map[string]string{DeletedFileName: deletedFile}, false)
+		if err != nil {
+			t.Fatalf("Error extracting: %s", err.Error())
+		}
+	}
+
+	data, err := os.ReadFile(deletedFile)
+	if err != nil {
+		t.Fatalf("Error reading deleted names: %s", err.Error())
+	}
+	deleted := []string{}
+	if err := json.Unmarshal(data, &deleted); err != nil {
+		t.Fatalf("Error decoding deleted names: %s", err.Error())
+	}
+	if strings.Join(deleted, ",") != "test_dummies/file2.txt" {
+		t.Fatalf("Unexpected deleted names: %v", deleted)
+	}
+
+	err = RemoveDeleted(deleted, dst, nil)
+	if err != nil {
+		t.Fatalf("Error removing deleted names: %s", err.Error())
+	}
+
+	for name, content := range map[string]string{
+		"test_dummies/file1.txt":       "Hello World! 1 changed",
+		"test_dummies/new/file3.txt":   "Hello World! 3",
+		"test_dummies/texts/file1.txt": "Hello World!",
+	} {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil || string(data) != content {
+			t.Fatalf("File %s restored as %q: %v", name, string(data), err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "test_dummies/file2.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Deleted file has been restored: %v", err)
+	}
+
+	// All the providers record the archived files
+	for entity, conf := range map[models.ProviderEntity]models.ProviderConfig{TarProvider: testTarConf, SevenZipProvider: testSevenZipConf} {
+
+		p, err := GetProvider(entity, conf)
+		if err != nil {
+			t.Fatalf("Error creating %s provider: %s", entity, err.Error())
+		}
+		index := NewIndex()
+		err = p.Archive(ctx, testSources, &bytes.Buffer{}, &Filter{Index: index}, false)
+		if err != nil {
+			t.Fatalf("Error archiving with %s: %s", entity, err.Error())
+		}
+		if index.Files["test_dummies/new/file3.txt"].Hash != incremental.Files["test_dummies/new/file3.txt"].Hash || len(index.Files) != 6 {
+			t.Fatalf("Unexpected %s index: %+v", entity, index.Files)
+		}
+	}
+
+	// Deleted directories are listed without their content
+	deleted = deletedNames(incremental, map[string]bool{"test_dummies": true})
+	if strings.Join(deleted, ",") != "test_dummies/file1.txt,test_dummies/new,test_dummies/texts" {
+		t.Fatalf("Unexpected deleted names: %v", deleted)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package archiving
+
+import "io/fs"
+
+// inode returns 0, the inode number is not available on this platform
+func inode(info fs.FileInfo) uint64 {
+	return 0
+}
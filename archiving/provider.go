@@ -1,29 +1,61 @@
 package archiving
 
 import (
+	"archive/zip"
 	"context"
 	"io"
+	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/Polo44444/harpo/models"
 )
 
 const (
-	ZipProvider models.ProviderEntity = "ZIP"
-	TarProvider models.ProviderEntity = "TAR"
+	ZipProvider      models.ProviderEntity = "ZIP"
+	TarProvider      models.ProviderEntity = "TAR"
+	SevenZipProvider models.ProviderEntity = "SEVENZIP"
 )
 
+// DefaultLevel selects the default level of the compression algorithm
+const DefaultLevel = -1
+
+// Source is a file or a directory to archive
+type Source struct {
+	Path string // Path on the disk
+	Name string // Name inside the archive. Default is the base name of the path
+
+	// Unfiltered sources are archived whole, whatever the filter and the .harpoignore files. Used for the database dumps
+	Unfiltered bool
+
+	// Streams replace the path: the source is a directory holding one file per stream, named after its key.
+	// Their content is written by the functions while the files are archived, without touching the disk. Used for the database dumps
+	Streams map[string]StreamFunc
+}
+
+// ArchiveName returns the name of the source inside the archive
+func (s Source) ArchiveName() string {
+
+	if s.Name != "" {
+		return path.Clean(filepath.ToSlash(s.Name))
+	}
+	return filepath.Base(filepath.Clean(sanitizePath(s.Path)))
+}
+
 // Provider interface
 type Provider interface {
 
-	/*Archive creates an archive from the src and writes it to the dst.
-	`src` can be a file or a directory.
+	/*Archive creates an archive from the srcs and writes it to the dst.
+	Each source can be a file or a directory, stored under its name inside the archive.
+	`filter` selects the files of the archive. Nil archives all the files.
 	`ignoreErrors` is a flag that indicates if the provider should ignore errors when creating the archive.
 	*/
-	Archive(ctx context.Context, src string, dst io.Writer, ignoreErrors bool) error
+	Archive(ctx context.Context, srcs []Source, dst io.Writer, filter *Filter, ignoreErrors bool) error
 
 	// Extract extracts the archive from the src and writes it to the dst.
-	// The dst must be a directory.
-	Extract(ctx context.Context, src io.Reader, dst string, ignoreErrors bool) error
+	// The dst must be a directory. `targets` maps top level names of the archive to the directories
+	// where they are extracted instead of the dst, e.g. "nginx" to "/etc/nginx". It can be nil.
+	Extract(ctx context.Context, src io.Reader, dst string, targets map[string]string, ignoreErrors bool) error
 
 	// Ext returns the extension of the archive with the dot.
 	Ext() string
@@ -40,9 +72,35 @@ func GetProvider(entity models.ProviderEntity, config models.ProviderConfig) (Pr
 		prvd, err = newZipProvider(config)
 	case TarProvider:
 		prvd, err = newTarProvider(config)
+	case SevenZipProvider:
+		prvd, err = newSevenZipProvider(config)
 	default:
 		err = models.ErrProviderNotSupported
 	}
 
 	return prvd, err
 }
+
+// GetProviderFromExt returns a provider able to extract the archive located at filePath.
+// The provider is picked from the extension of the file.
+func GetProviderFromExt(filePath string) (Provider, error) {
+
+	lowerPath := strings.ToLower(filePath)
+
+	if strings.HasSuffix(lowerPath, ".zip") {
+		return GetProvider(ZipProvider, BuildZipConfig(zip.Deflate, DefaultLevel))
+	}
+
+	// The archives are extracted without password. Encrypted ones need the provider of their folder
+	if strings.HasSuffix(lowerPath, ".7z") {
+		return GetProvider(SevenZipProvider, BuildSevenZipConfig(DefaultLevel, ""))
+	}
+
+	for compressionType, tc := range tarCompressions {
+		if strings.HasSuffix(lowerPath, tc.ext) {
+			return GetProvider(TarProvider, BuildTarConfig(DefaultLevel, compressionType, true))
+		}
+	}
+
+	return nil, models.ErrProviderNotSupported
+}
@@ -2,18 +2,30 @@ package archiving
 
 import (
 	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
 )
 
 var (
 	testZipConf = BuildZipConfig(
 		zip.Deflate,
+		DefaultLevel,
 	)
 	testTarConf = BuildTarConfig(
 		9,
 		GzCompressionType,
+		true,
 	)
+	testSources = []Source{{Path: "test_dummies"}}
 )
 
 func TestInit(t *testing.T) {
@@ -50,3 +62,225 @@ func TestEnd(t *testing.T) {
 		t.Fatalf("Error removing directory: %s", err.Error())
 	}
 }
+
+// testExtract extracts the archive located at archivePath and checks its content against test_dummies.
+func testExtract(t *testing.T, archivePath string) {
+
+	// We pick the provider from the archive extension
+	p, err := GetProviderFromExt(archivePath)
+	if err != nil {
+		t.Fatalf("Error getting provider of %s: %s", archivePath, err.Error())
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("Error opening archive: %s", err.Error())
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dst := "test_dummies_extract"
+	defer os.RemoveAll(dst)
+
+	err = p.Extract(ctx, f, dst, nil, false)
+	if err != nil {
+		t.Fatalf("Error extracting: %s", err.Error())
+	}
+
+	// We check the extracted files. The archive holds the folder itself.
+	expected := map[string]string{
+		"test_dummies/texts/file1.txt": "Hello World!",
+		"test_dummies/file1.txt":       "Hello World! 1",
+		"test_dummies/file2.txt":       "Hello World! 2",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatalf("Error reading extracted file %s: %s", name, err.Error())
+		}
+		if string(data) != content {
+			t.Fatalf("Extracted file %s content mismatch: got %q, want %q", name, string(data), content)
+		}
+	}
+
+	// The null sink reads the archive without random access and writes nothing. A truncated archive fails.
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("Error reading archive: %s", err.Error())
+	}
+	err = p.Extract(ctx, io.MultiReader(bytes.NewReader(data)), NullSink, nil, false)
+	if err != nil {
+		t.Fatalf("Error extracting into the null sink: %s", err.Error())
+	}
+	err = p.Extract(ctx, bytes.NewReader(data[:len(data)/2]), NullSink, nil, false)
+	if err == nil {
+		t.Fatalf("Truncated archive %s extracted into the null sink", archivePath)
+	}
+}
+
+func TestSources(t *testing.T) {
+
+	// Init
+	TestInit(t)
+	defer TestEnd(t)
+
+	err := os.MkdirAll("test_dummies_other/conf", os.ModePerm)
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err.Error())
+	}
+	defer os.RemoveAll("test_dummies_other")
+	err = os.WriteFile("test_dummies_other/conf/app.conf", []byte("conf"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err.Error())
+	}
+
+	// Both sources land in one archive under their names
+	srcs := []Source{
+		{Path: "test_dummies", Name: "data"},
+		{Path: "test_dummies_other/"},
+	}
+
+	for _, conf := range []struct {
+		entity models.ProviderEntity
+		config models.ProviderConfig
+	}{
+		{ZipProvider, testZipConf},
+		{TarProvider, testTarConf},
+		{SevenZipProvider, testSevenZipConf},
+	} {
+
+		p, err := GetProvider(conf.entity, conf.config)
+		if err != nil {
+			t.Fatalf("Error creating %s provider: %s", conf.entity, err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		f, err := os.CreateTemp("", "harpo-sources-*")
+		if err != nil {
+			t.Fatalf("Error creating file: %s", err.Error())
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		err = p.Archive(ctx, srcs, f, nil, false)
+		if err != nil {
+			t.Fatalf("%s: error archiving: %s", conf.entity, err.Error())
+		}
+
+		// Each source is extracted to its own target, the others inside the dst
+		dst := "test_dummies_extract"
+		target := "test_dummies_target"
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatalf("Error seeking archive: %s", err.Error())
+		}
+		err = p.Extract(ctx, f, dst, map[string]string{"test_dummies_other": target}, false)
+		if err != nil {
+			t.Fatalf("%s: error extracting: %s", conf.entity, err.Error())
+		}
+
+		for _, name := range []string{filepath.Join(dst, "data/file1.txt"), filepath.Join(target, "conf/app.conf")} {
+			if _, err := os.Stat(name); err != nil {
+				t.Fatalf("%s: %s not extracted: %s", conf.entity, name, err.Error())
+			}
+		}
+		os.RemoveAll(dst)
+		os.RemoveAll(target)
+	}
+}
+
+func TestStreams(t *testing.T) {
+
+	// Init
+	TestInit(t)
+	defer TestEnd(t)
+
+	// Larger than the pipe buffers, so the stream is read while it is written
+	big := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 100000)
+	srcs := []Source{
+		{Path: "test_dummies"},
+		{Name: "dumps", Unfiltered: true, Streams: map[string]StreamFunc{
+			"app.sql": func(dst io.Writer) error {
+				_, err := dst.Write(big)
+				return err
+			},
+			"empty.sql": func(dst io.Writer) error {
+				return nil
+			},
+		}},
+	}
+
+	for _, conf := range []struct {
+		entity models.ProviderEntity
+		config models.ProviderConfig
+	}{
+		{ZipProvider, testZipConf},
+		{TarProvider, testTarConf},
+		{SevenZipProvider, testSevenZipConf},
+	} {
+
+		p, err := GetProvider(conf.entity, conf.config)
+		if err != nil {
+			t.Fatalf("Error creating %s provider: %s", conf.entity, err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		f, err := os.CreateTemp("", "harpo-streams-*")
+		if err != nil {
+			t.Fatalf("Error creating file: %s", err.Error())
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		// The streamed files are recorded inside the manifest once archived
+		manifest := NewIndex()
+		err = p.Archive(ctx, srcs, f, &Filter{Manifest: manifest}, false)
+		if err != nil {
+			t.Fatalf("%s: error archiving: %s", conf.entity, err.Error())
+		}
+		entry, ok := manifest.get("dumps/app.sql")
+		if !ok || entry.Size != int64(len(big)) || entry.Hash == "" {
+			t.Fatalf("%s: streamed file recorded as %+v inside the manifest", conf.entity, entry)
+		}
+		if _, ok := manifest.get("dumps/empty.sql"); !ok {
+			t.Fatalf("%s: empty streamed file not recorded inside the manifest", conf.entity)
+		}
+
+		dst := "test_dummies_extract"
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatalf("Error seeking archive: %s", err.Error())
+		}
+		err = p.Extract(ctx, f, dst, nil, false)
+		if err != nil {
+			t.Fatalf("%s: error extracting: %s", conf.entity, err.Error())
+		}
+
+		data, err := os.ReadFile(filepath.Join(dst, "dumps/app.sql"))
+		if err != nil || !bytes.Equal(data, big) {
+			t.Fatalf("%s: streamed file not extracted: %v", conf.entity, err)
+		}
+		if _, err := os.Stat(filepath.Join(dst, "test_dummies/file1.txt")); err != nil {
+			t.Fatalf("%s: path not extracted along with the streams: %s", conf.entity, err.Error())
+		}
+		os.RemoveAll(dst)
+
+		// A failing stream fails the archive
+		failing := []Source{{Name: "dumps", Streams: map[string]StreamFunc{
+			"app.sql": func(dst io.Writer) error {
+				dst.Write(big[:1024])
+				return errors.New("dump failed")
+			},
+		}}}
+		err = p.Archive(ctx, failing, io.Discard, nil, false)
+		if err == nil || !strings.Contains(err.Error(), "dump failed") {
+			t.Fatalf("%s: failing stream archived: %v", conf.entity, err)
+		}
+	}
+}
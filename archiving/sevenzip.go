@@ -0,0 +1,202 @@
+package archiving
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Polo44444/harpo/models"
+	"github.com/mholt/archiver/v4"
+)
+
+// sevenZipDictCaps holds the LZMA2 dictionary size of each level, from 1 to 9, close to the 7-Zip ones
+var sevenZipDictCaps = []int{
+	64 << 10,
+	256 << 10,
+	1 << 20,
+	4 << 20,
+	16 << 20,
+	16 << 20,
+	32 << 20,
+	32 << 20,
+	64 << 20,
+}
+
+const sevenZipDefaultLevel = 5
+
+type sevenZipProvider struct {
+	level    int    // Level of the LZMA2 compression, from 1 to 9. It sets the dictionary size
+	password string // Password of the AES-256 encryption of the content and of the header. Empty without encryption
+}
+
+func BuildSevenZipConfig(level int, password string) models.ProviderConfig {
+	return models.ProviderConfig{
+		"level":    level,
+		"password": password,
+	}
+}
+
+func newSevenZipProvider(config models.ProviderConfig) (*sevenZipProvider, error) {
+
+	prvd := &sevenZipProvider{
+		level:    config["level"].(int),
+		password: config["password"].(string),
+	}
+
+	// check if the level is valid
+	if prvd.level != DefaultLevel && (prvd.level < 1 || prvd.level > len(sevenZipDictCaps)) {
+		return nil, fmt.Errorf("invalid LZMA2 compression level: %d. Must be between 1 and %d", prvd.level, len(sevenZipDictCaps))
+	}
+
+	return prvd, nil
+}
+
+// Archive creates a 7z archive from the srcs and writes it to the dst.
+// The offsets of the header are written at the start of the archive once its end is known,
+// so the archive is built inside a temporary file when the dst can not seek. This is why 7z archives can not be streamed.
+func (s *sevenZipProvider) Archive(ctx context.Context, srcs []Source, dst io.Writer, filter *Filter, ignoreErrors bool) error {
+
+	files, err := filesFromDisk(srcs, filter, ignoreErrors)
+	if err != nil {
+		return err
+	}
+
+	ws, ok := dst.(io.WriteSeeker)
+	if ok {
+		_, err = ws.Seek(0, io.SeekCurrent)
+		ok = err == nil
+	}
+	if !ok {
+		return s.archiveWithTempFile(ctx, files, dst, ignoreErrors)
+	}
+
+	return s.archive(ctx, files, ws, ignoreErrors)
+}
+
+// archiveWithTempFile builds the archive inside a temporary file and copies it to the dst
+func (s *sevenZipProvider) archiveWithTempFile(ctx context.Context, files []archiver.File, dst io.Writer, ignoreErrors bool) error {
+
+	tmp, err := os.CreateTemp("", "harpo-*.7z")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = s.archive(ctx, files, tmp, ignoreErrors)
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, tmp)
+	return err
+}
+
+func (s *sevenZipProvider) archive(ctx context.Context, files []archiver.File, dst io.WriteSeeker, ignoreErrors bool) error {
+
+	start, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	// The signature header is written last
+	_, err = dst.Write(make([]byte, sevenZipSignatureSize))
+	if err != nil {
+		return err
+	}
+
+	level := s.level
+	if level == DefaultLevel {
+		level = sevenZipDefaultLevel
+	}
+	szw := newSevenZipWriter(dst, sevenZipDictCaps[level-1], s.password)
+
+	for _, file := range files {
+
+		err := s.archiveFile(ctx, szw, file, ignoreErrors)
+		if err != nil {
+			return err
+		}
+	}
+
+	nextHeaderOffset, nextHeaderSize, nextHeaderCRC, err := szw.Close()
+	if err != nil {
+		return err
+	}
+
+	end, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(sevenZipSignatureHeader(nextHeaderOffset, nextHeaderSize, nextHeaderCRC))
+	if err != nil {
+		return err
+	}
+	_, err = dst.Seek(end, io.SeekStart)
+
+	return err
+}
+
+// archiveFile writes the file inside the 7z archive.
+// With ignoreErrors, files which can not be opened are skipped.
+func (s *sevenZipProvider) archiveFile(ctx context.Context, szw *sevenZipWriter, file archiver.File, ignoreErrors bool) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mode := uint32(file.Mode().Perm())
+	if file.IsDir() {
+		szw.addDir(file.NameInArchive, file.ModTime(), mode)
+		return nil
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		if ignoreErrors {
+			return nil
+		}
+		return fmt.Errorf("unable to open %s: %w", file.Name(), err)
+	}
+	defer r.Close()
+
+	err = szw.addFile(file.NameInArchive, file.ModTime(), mode, r)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", file.Name(), err)
+	}
+
+	return nil
+}
+
+// Extract extracts the 7z archive from the src and writes it to the dst. The dst must be a directory.
+// The header is located at the end of the archive, so the src is copied to a temporary file when it can not be read at random.
+func (s *sevenZipProvider) Extract(ctx context.Context, src io.Reader, dst string, targets map[string]string, ignoreErrors bool) error {
+
+	format := archiver.SevenZip{
+		ContinueOnError: ignoreErrors,
+		Password:        s.password,
+	}
+
+	src, cleanup, err := seekable(src, "harpo-*.7z")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return format.Extract(ctx, src, nil, NewExtract(dst, targets).handler)
+}
+
+// Ext returns the extension of the archive.
+func (s *sevenZipProvider) Ext() string {
+	return ".7z"
+}
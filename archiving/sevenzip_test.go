@@ -0,0 +1,245 @@
+package archiving
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testSevenZipConf = BuildSevenZipConfig(
+	DefaultLevel,
+	"",
+)
+
+func TestSevenZip(t *testing.T) {
+
+	// Init
+	TestInit(t)
+
+	// Empty files and folders have no stream inside the archive
+	err := os.MkdirAll("test_dummies/empty", os.ModePerm)
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err.Error())
+	}
+	err = os.WriteFile("test_dummies/texts/empty.txt", nil, os.ModePerm)
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err.Error())
+	}
+
+	// We create the 7z provider
+	p, err := GetProvider(SevenZipProvider, testSevenZipConf)
+	if err != nil {
+		t.Fatalf("Error creating SevenZip provider: %s", err.Error())
+	}
+
+	// We create a 7z file
+	f, err := os.Create("test_dummies.7z")
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err.Error())
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Create a context with a timeout of 1 minute
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// We archive the folder test_dummies
+	err = p.Archive(ctx, testSources, f, nil, true)
+	if err != nil {
+		t.Fatalf("Error archiving: %s", err.Error())
+	}
+	f.Close()
+
+	// We extract the archive back
+	testExtract(t, "test_dummies.7z")
+
+	// The archive is built the same way when the dst can not seek
+	buf := &bytes.Buffer{}
+	err = p.Archive(ctx, testSources, buf, nil, true)
+	if err != nil {
+		t.Fatalf("Error archiving to a buffer: %s", err.Error())
+	}
+
+	dst := "test_dummies_extract"
+	defer os.RemoveAll(dst)
+	err = p.Extract(ctx, buf, dst, nil, false)
+	if err != nil {
+		t.Fatalf("Error extracting from a buffer: %s", err.Error())
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "test_dummies/empty"))
+	if err != nil || !info.IsDir() {
+		t.Fatalf("Empty directory not extracted: %v", err)
+	}
+	info, err = os.Stat(filepath.Join(dst, "test_dummies/texts/empty.txt"))
+	if err != nil || info.IsDir() || info.Size() != 0 {
+		t.Fatalf("Empty file not extracted: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "test_dummies/file2.txt"))
+	if err != nil || string(data) != "Hello World! 2" {
+		t.Fatalf("File not extracted: %q %v", string(data), err)
+	}
+
+	TestEnd(t)
+}
+
+func TestSevenZipPassword(t *testing.T) {
+
+	// Init
+	TestInit(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	p, err := GetProvider(SevenZipProvider, BuildSevenZipConfig(9, "my-password"))
+	if err != nil {
+		t.Fatalf("Error creating SevenZip provider: %s", err.Error())
+	}
+
+	buf := &bytes.Buffer{}
+	err = p.Archive(ctx, testSources, buf, nil, true)
+	if err != nil {
+		t.Fatalf("Error archiving: %s", err.Error())
+	}
+	archive := buf.Bytes()
+
+	// The header is encrypted, the file names can not be read
+	if bytes.Contains(archive, []byte("f\x00i\x00l\x00e\x00")) {
+		t.Fatalf("File names are readable inside the encrypted archive")
+	}
+
+	dst := "test_dummies_extract"
+	defer os.RemoveAll(dst)
+
+	// Without the password or with a wrong one, the archive can not be extracted
+	for _, password := range []string{"", "wrong-password"} {
+
+		wrong, err := GetProvider(SevenZipProvider, BuildSevenZipConfig(DefaultLevel, password))
+		if err != nil {
+			t.Fatalf("Error creating SevenZip provider: %s", err.Error())
+		}
+		err = wrong.Extract(ctx, bytes.NewReader(archive), dst, nil, false)
+		if err == nil {
+			t.Fatalf("Archive extracted with password %q", password)
+		}
+	}
+
+	err = p.Extract(ctx, bytes.NewReader(archive), dst, nil, false)
+	if err != nil {
+		t.Fatalf("Error extracting: %s", err.Error())
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst, "test_dummies/texts/file1.txt"))
+	if err != nil || string(data) != "Hello World!" {
+		t.Fatalf("File not extracted: %q %v", string(data), err)
+	}
+
+	TestEnd(t)
+}
+
+func TestSevenZipKey(t *testing.T) {
+
+	// Known answers of the 7-Zip key derivation: SHA-256 of 2^19 rounds of the UTF-16LE password and a counter
+	for password, want := range map[string]string{
+		"password":  "97bc6e1f9adb6f6a2507fe6657ee8854b7091a0a117b746421b839210f5d7eef",
+		"pässwörd€": "c8d7ad9d7cf421f5acd2d9dec0245264f988929e4dcbdec7e8c72ecfc21f6806",
+	} {
+		if got := hex.EncodeToString(sevenZipKey(password)); got != want {
+			t.Fatalf("Key of %q is %s, want %s", password, got, want)
+		}
+	}
+
+	// The encrypted header of an archive created by 7-Zip with the password "password"
+	// (testdata/t2.7z of github.com/bodgit/sevenzip). Its coder is AES-256 alone, without salt.
+	packed, _ := hex.DecodeString("" +
+		"2936990d9181d33c0371f1b578aece6518ab9dce9bc5fab04de467e0356ebd98" +
+		"96fda7be1deb50c71681fd62f8c0bc51791c02241999ee97a2933bd66f8e78d9" +
+		"6ef257c56242a9fc403848a34e55db629da6278f463aa51250261453551597f5" +
+		"78129cdddf774b05c0a9a7747ee2d1631d3348110b863a738c12b61664575925" +
+		"947bd8be07089e84a75de7d180a055690df8902642ab4c68da0f74f8949ba0ef")
+	iv, _ := hex.DecodeString("f4c1ea750f99e7630000000000000000")
+	const unpackSize, unpackCRC = 150, 0x3b4cf0f0
+
+	block, err := aes.NewCipher(sevenZipKey("password"))
+	if err != nil {
+		t.Fatalf("Error creating cipher: %s", err.Error())
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(packed, packed)
+	if packed[0] != szHeader || crc32.ChecksumIEEE(packed[:unpackSize]) != unpackCRC {
+		t.Fatalf("The header encrypted by 7-Zip has not been decrypted with the derived key")
+	}
+}
+
+// TestSevenZipWith7Zip checks that 7-Zip reads the archives, encrypted or not. It is skipped when 7-Zip is not installed.
+func TestSevenZipWith7Zip(t *testing.T) {
+
+	program := ""
+	for _, name := range []string{"7zz", "7z", "7za"} {
+		if p, err := exec.LookPath(name); err == nil {
+			program = p
+			break
+		}
+	}
+	if program == "" {
+		t.Skip("7-Zip is not installed")
+	}
+
+	// Init
+	TestInit(t)
+	defer TestEnd(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, password := range []string{"", "my-password"} {
+
+		p, err := GetProvider(SevenZipProvider, BuildSevenZipConfig(DefaultLevel, password))
+		if err != nil {
+			t.Fatalf("Error creating SevenZip provider: %s", err.Error())
+		}
+
+		archive := filepath.Join(t.TempDir(), "test_dummies.7z")
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatalf("Error creating file: %s", err.Error())
+		}
+		err = p.Archive(ctx, testSources, f, nil, true)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Error archiving: %s", err.Error())
+		}
+
+		// 7-Zip tests the CRC of every file
+		out, err := exec.CommandContext(ctx, program, "t", "-p"+password, archive).CombinedOutput()
+		if err != nil {
+			t.Fatalf("7-Zip refused the archive with password %q: %s\n%s", password, err.Error(), out)
+		}
+		out, err = exec.CommandContext(ctx, program, "l", "-p"+password, archive).CombinedOutput()
+		if err != nil || !bytes.Contains(out, []byte("file2.txt")) {
+			t.Fatalf("7-Zip has not listed the files with password %q: %v\n%s", password, err, out)
+		}
+	}
+}
+
+func TestSevenZipLevels(t *testing.T) {
+
+	for level, valid := range map[int]bool{DefaultLevel: true, 1: true, 9: true, 0: false, 10: false} {
+
+		_, err := GetProvider(SevenZipProvider, BuildSevenZipConfig(level, ""))
+		if valid && err != nil {
+			t.Fatalf("Level %d should be valid: %s", level, err.Error())
+		}
+		if !valid && err == nil {
+			t.Fatalf("Level %d should be invalid", level)
+		}
+	}
+}
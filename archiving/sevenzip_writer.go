@@ -0,0 +1,518 @@
+package archiving
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"time"
+	"unicode/utf16"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Property ids of the 7z headers
+const (
+	szEnd = iota
+	szHeader
+	_ // ArchiveProperties
+	_ // AdditionalStreamsInfo
+	szMainStreamsInfo
+	szFilesInfo
+	szPackInfo
+	szUnpackInfo
+	szSubStreamsInfo
+	szSize
+	szCRC
+	szFolder
+	szCodersUnpackSize
+	szNumUnpackStream
+	szEmptyStream
+	szEmptyFile
+	_ // Anti
+	szName
+	_ // CTime
+	_ // ATime
+	szMTime
+	szWinAttributes
+	_ // Comment
+	szEncodedHeader
+)
+
+const (
+	sevenZipSignatureSize = 32
+	sevenZipAESCycles     = 19 // The key is derived with 2^19 SHA-256 rounds, as 7-Zip does
+	sevenZipUnixExtension = 0x8000
+	sevenZipDirAttribute  = 0x10
+	sevenZipFileAttribute = 0x20
+
+	// Seconds between the FILETIME epoch (1601) and the unix epoch
+	filetimeEpochOffset = 11644473600
+)
+
+var (
+	sevenZipSignature = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c, 0x00, 0x04}
+	lzma2CoderID      = []byte{0x21}
+	aesCoderID        = []byte{0x06, 0xf1, 0x07, 0x01}
+)
+
+// sevenZipEntry is a file of the 7z archive
+type sevenZipEntry struct {
+	name    string
+	modTime time.Time
+	attrib  uint32
+	size    uint64
+	crc     uint32
+}
+
+// countWriter counts the bytes written to w
+type countWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+// cbcWriter encrypts the data with AES-CBC. The last block is padded with zeros on Close.
+type cbcWriter struct {
+	w    io.Writer
+	mode cipher.BlockMode
+	buf  []byte
+}
+
+func (c *cbcWriter) Write(p []byte) (int, error) {
+
+	c.buf = append(c.buf, p...)
+	full := len(c.buf) - len(c.buf)%aes.BlockSize
+	if full == 0 {
+		return len(p), nil
+	}
+
+	c.mode.CryptBlocks(c.buf[:full], c.buf[:full])
+	if _, err := c.w.Write(c.buf[:full]); err != nil {
+		return 0, err
+	}
+	c.buf = append(c.buf[:0], c.buf[full:]...)
+
+	return len(p), nil
+}
+
+func (c *cbcWriter) Close() error {
+
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	c.buf = append(c.buf, make([]byte, aes.BlockSize-len(c.buf))...)
+	c.mode.CryptBlocks(c.buf, c.buf)
+	_, err := c.w.Write(c.buf)
+	return err
+}
+
+// sevenZipFolder compresses a stream with LZMA2 and encrypts it with AES-256 when a key is set.
+// The whole stream is a single packed stream of the archive.
+type sevenZipFolder struct {
+	packed    *countWriter // Packed bytes written to the archive
+	aes       *cbcWriter   // Nil without key
+	lz        *lzma.Writer2
+	crc       uint32 // CRC of the unpacked stream
+	size      uint64 // Size of the unpacked stream
+	lzmaProps byte
+	aesProps  []byte
+}
+
+func newSevenZipFolder(dst io.Writer, dictCap int, key []byte) (*sevenZipFolder, error) {
+
+	f := &sevenZipFolder{
+		packed:    &countWriter{w: dst},
+		lzmaProps: lzma.EncodeDictCap(int64(dictCap)),
+	}
+
+	var lzOut io.Writer = f.packed
+	if key != nil {
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+
+		// Cycles and IV, without salt
+		f.aesProps = append([]byte{sevenZipAESCycles | 0x40, byte(len(iv) - 1)}, iv...)
+		f.aes = &cbcWriter{w: f.packed, mode: cipher.NewCBCEncrypter(block, iv)}
+		lzOut = f.aes
+	}
+
+	lz, err := lzma.Writer2Config{DictCap: dictCap}.NewWriter2(lzOut)
+	if err != nil {
+		return nil, err
+	}
+	f.lz = lz
+
+	return f, nil
+}
+
+func (f *sevenZipFolder) Write(p []byte) (int, error) {
+
+	n, err := f.lz.Write(p)
+	f.crc = crc32.Update(f.crc, crc32.IEEETable, p[:n])
+	f.size += uint64(n)
+	return n, err
+}
+
+// Close flushes the stream to the archive
+func (f *sevenZipFolder) Close() error {
+
+	if err := f.lz.Close(); err != nil {
+		return err
+	}
+	if f.aes != nil {
+		return f.aes.Close()
+	}
+	return nil
+}
+
+// writeStreamsInfo describes the folder and its packed stream located at packPos
+func (f *sevenZipFolder) writeStreamsInfo(buf *bytes.Buffer, packPos uint64, withCRC bool) {
+
+	buf.WriteByte(szPackInfo)
+	writeNumber(buf, packPos)
+	writeNumber(buf, 1)
+	buf.WriteByte(szSize)
+	writeNumber(buf, f.packed.n)
+	buf.WriteByte(szEnd)
+
+	buf.WriteByte(szUnpackInfo)
+	buf.WriteByte(szFolder)
+	writeNumber(buf, 1)
+	buf.WriteByte(0) // Not external
+
+	// Coders are listed from the packed stream to the unpacked one
+	if f.aes != nil {
+		writeNumber(buf, 2)
+		writeCoder(buf, aesCoderID, f.aesProps)
+		writeCoder(buf, lzma2CoderID, []byte{f.lzmaProps})
+		writeNumber(buf, 1) // LZMA2 input is bound to the AES output
+		writeNumber(buf, 0)
+	} else {
+		writeNumber(buf, 1)
+		writeCoder(buf, lzma2CoderID, []byte{f.lzmaProps})
+	}
+
+	buf.WriteByte(szCodersUnpackSize)
+	if f.aes != nil {
+		writeNumber(buf, f.packed.n) // The decrypted stream keeps its padding
+	}
+	writeNumber(buf, f.size)
+
+	if withCRC {
+		buf.WriteByte(szCRC)
+		buf.WriteByte(1) // All defined
+		binary.Write(buf, binary.LittleEndian, f.crc)
+	}
+	buf.WriteByte(szEnd)
+}
+
+// sevenZipWriter writes a solid 7z archive: the content of all the files is compressed in a single LZMA2 stream.
+// The destination must be positioned right after the signature header, which is written by the caller.
+type sevenZipWriter struct {
+	dst     io.Writer
+	dictCap int
+	key     []byte          // AES key. Nil without password
+	folder  *sevenZipFolder // Created with the first byte of content
+	empties []sevenZipEntry // Directories and empty files
+	streams []sevenZipEntry // Files with content, in the order of the stream
+	err     error
+}
+
+func newSevenZipWriter(dst io.Writer, dictCap int, password string) *sevenZipWriter {
+
+	w := &sevenZipWriter{
+		dst:     dst,
+		dictCap: dictCap,
+	}
+	if password != "" {
+		w.key = sevenZipKey(password)
+	}
+
+	return w
+}
+
+// sevenZipKey derives the AES-256 key of the password, as 7-Zip does
+func sevenZipKey(password string) []byte {
+
+	data := []byte{}
+	for _, c := range utf16.Encode([]rune(password)) {
+		data = binary.LittleEndian.AppendUint16(data, c)
+	}
+
+	h := sha256.New()
+	counter := make([]byte, 8)
+	for i := uint64(0); i < 1<<sevenZipAESCycles; i++ {
+		binary.LittleEndian.PutUint64(counter, i)
+		h.Write(data)
+		h.Write(counter)
+	}
+
+	return h.Sum(nil)
+}
+
+// Write appends content to the stream of the archive
+func (w *sevenZipWriter) Write(p []byte) (int, error) {
+
+	if w.folder == nil && len(p) > 0 {
+		w.folder, w.err = newSevenZipFolder(w.dst, w.dictCap, w.key)
+		if w.err != nil {
+			return 0, w.err
+		}
+	}
+	if w.folder == nil {
+		return 0, nil
+	}
+
+	return w.folder.Write(p)
+}
+
+// addDir adds a directory to the archive
+func (w *sevenZipWriter) addDir(name string, modTime time.Time, mode uint32) {
+
+	w.empties = append(w.empties, sevenZipEntry{
+		name:    name,
+		modTime: modTime,
+		attrib:  sevenZipDirAttribute | sevenZipUnixExtension | (0o040000|mode)<<16,
+	})
+}
+
+// addFile adds a file to the archive, with the content read from r
+func (w *sevenZipWriter) addFile(name string, modTime time.Time, mode uint32, r io.Reader) error {
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return err
+	}
+
+	entry := sevenZipEntry{
+		name:    name,
+		modTime: modTime,
+		attrib:  sevenZipFileAttribute | sevenZipUnixExtension | (0o100000|mode)<<16,
+		size:    uint64(n),
+		crc:     h.Sum32(),
+	}
+	if n == 0 {
+		w.empties = append(w.empties, entry)
+	} else {
+		w.streams = append(w.streams, entry)
+	}
+
+	return nil
+}
+
+// Close ends the stream and writes the header. It returns the position, relative to the end of
+// the signature header, the size and the CRC of the header: the values of the signature header.
+func (w *sevenZipWriter) Close() (uint64, uint64, uint32, error) {
+
+	if w.err != nil {
+		return 0, 0, 0, w.err
+	}
+
+	packPos := uint64(0)
+	if w.folder != nil {
+		if err := w.folder.Close(); err != nil {
+			return 0, 0, 0, err
+		}
+		packPos = w.folder.packed.n
+	}
+
+	header := &bytes.Buffer{}
+	w.writeHeader(header)
+
+	// With a password, the header is compressed and encrypted too, so the file names are hidden
+	if w.key != nil {
+
+		folder, err := newSevenZipFolder(w.dst, w.dictCap, w.key)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if _, err := folder.Write(header.Bytes()); err != nil {
+			return 0, 0, 0, err
+		}
+		if err := folder.Close(); err != nil {
+			return 0, 0, 0, err
+		}
+
+		header = &bytes.Buffer{}
+		header.WriteByte(szEncodedHeader)
+		folder.writeStreamsInfo(header, packPos, true)
+		header.WriteByte(szEnd)
+		packPos += folder.packed.n
+	}
+
+	if _, err := w.dst.Write(header.Bytes()); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return packPos, uint64(header.Len()), crc32.ChecksumIEEE(header.Bytes()), nil
+}
+
+// writeHeader writes the plain header of the archive
+func (w *sevenZipWriter) writeHeader(buf *bytes.Buffer) {
+
+	buf.WriteByte(szHeader)
+
+	if w.folder != nil {
+
+		buf.WriteByte(szMainStreamsInfo)
+		w.folder.writeStreamsInfo(buf, 0, false)
+
+		buf.WriteByte(szSubStreamsInfo)
+		buf.WriteByte(szNumUnpackStream)
+		writeNumber(buf, uint64(len(w.streams)))
+		if len(w.streams) > 1 {
+			buf.WriteByte(szSize)
+			for _, e := range w.streams[:len(w.streams)-1] {
+				writeNumber(buf, e.size)
+			}
+		}
+		buf.WriteByte(szCRC)
+		buf.WriteByte(1) // All defined
+		for _, e := range w.streams {
+			binary.Write(buf, binary.LittleEndian, e.crc)
+		}
+		buf.WriteByte(szEnd)
+
+		buf.WriteByte(szEnd)
+	}
+
+	// Entries without stream come first. Some readers expect it to match the empty files with the entries.
+	entries := append(append([]sevenZipEntry{}, w.empties...), w.streams...)
+
+	buf.WriteByte(szFilesInfo)
+	writeNumber(buf, uint64(len(entries)))
+
+	if len(w.empties) > 0 {
+
+		emptyStreams := make([]bool, len(entries))
+		emptyFiles := make([]bool, len(w.empties))
+		hasEmptyFiles := false
+		for i, e := range w.empties {
+			emptyStreams[i] = true
+			emptyFiles[i] = e.attrib&sevenZipDirAttribute == 0
+			hasEmptyFiles = hasEmptyFiles || emptyFiles[i]
+		}
+
+		writeProperty(buf, szEmptyStream, boolVector(emptyStreams))
+		if hasEmptyFiles {
+			writeProperty(buf, szEmptyFile, boolVector(emptyFiles))
+		}
+	}
+
+	names := []byte{0} // Not external
+	for _, e := range entries {
+		for _, c := range utf16.Encode([]rune(e.name)) {
+			names = binary.LittleEndian.AppendUint16(names, c)
+		}
+		names = append(names, 0, 0)
+	}
+	writeProperty(buf, szName, names)
+
+	times := []byte{1, 0} // All defined, not external
+	for _, e := range entries {
+		times = binary.LittleEndian.AppendUint64(times, filetime(e.modTime))
+	}
+	writeProperty(buf, szMTime, times)
+
+	attributes := []byte{1, 0} // All defined, not external
+	for _, e := range entries {
+		attributes = binary.LittleEndian.AppendUint32(attributes, e.attrib)
+	}
+	writeProperty(buf, szWinAttributes, attributes)
+
+	buf.WriteByte(szEnd)
+	buf.WriteByte(szEnd)
+}
+
+// signatureHeader returns the header starting the archive
+func sevenZipSignatureHeader(nextHeaderOffset, nextHeaderSize uint64, nextHeaderCRC uint32) []byte {
+
+	startHeader := binary.LittleEndian.AppendUint64(nil, nextHeaderOffset)
+	startHeader = binary.LittleEndian.AppendUint64(startHeader, nextHeaderSize)
+	startHeader = binary.LittleEndian.AppendUint32(startHeader, nextHeaderCRC)
+
+	header := append([]byte{}, sevenZipSignature...)
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(startHeader))
+	return append(header, startHeader...)
+}
+
+// writeNumber writes v with the variable length encoding of 7z:
+// the count of leading ones of the first byte is the count of the following bytes
+func writeNumber(buf *bytes.Buffer, v uint64) {
+
+	first := byte(0)
+	mask := byte(0x80)
+	i := 0
+	for ; i < 8; i++ {
+		if v < uint64(1)<<(7*(i+1)) {
+			first |= byte(v >> (8 * i))
+			break
+		}
+		first |= mask
+		mask >>= 1
+	}
+
+	buf.WriteByte(first)
+	for ; i > 0; i-- {
+		buf.WriteByte(byte(v))
+		v >>= 8
+	}
+}
+
+// writeCoder writes a coder with one input and one output stream
+func writeCoder(buf *bytes.Buffer, id []byte, props []byte) {
+
+	buf.WriteByte(byte(len(id)) | 0x20) // Properties follow
+	buf.Write(id)
+	writeNumber(buf, uint64(len(props)))
+	buf.Write(props)
+}
+
+// writeProperty writes a property of the files
+func writeProperty(buf *bytes.Buffer, id byte, data []byte) {
+
+	buf.WriteByte(id)
+	writeNumber(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// boolVector packs the values in bits, from the most significant one
+func boolVector(values []bool) []byte {
+
+	vector := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			vector[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	return vector
+}
+
+// filetime converts t to a Windows FILETIME: 100ns intervals since 1601
+func filetime(t time.Time) uint64 {
+
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.Unix()+filetimeEpochOffset)*1e7 + uint64(t.Nanosecond()/100)
+}
@@ -0,0 +1,194 @@
+package archiving
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/mholt/archiver/v4"
+)
+
+// errStreamClosed stops the stream of a file closed before its end
+var errStreamClosed = errors.New("stream closed before its end")
+
+// StreamFunc writes the content of a streamed file to the dst
+type StreamFunc func(dst io.Writer) error
+
+// streamInfo describes a streamed file. Its size is only known once it has been read.
+type streamInfo struct {
+	virtualFileInfo
+}
+
+// streamed returns whether the content of the file is streamed, without a known size
+func streamed(file archiver.File) bool {
+	_, ok := file.FileInfo.(streamInfo)
+	return ok
+}
+
+// stream adds the directory of the source, then its streamed files sorted by name. They are always archived whole.
+func (w *walker) stream(src Source) {
+
+	w.record(w.name, IndexEntry{Dir: true})
+	w.files = append(w.files, archiver.File{
+		FileInfo:      virtualFileInfo{name: path.Base(w.name), modTime: w.now, dir: true},
+		NameInArchive: w.name,
+	})
+
+	keys := []string{}
+	for key := range src.Streams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	indexes := w.indexes()
+	for _, key := range keys {
+
+		name := path.Join(w.name, key)
+		fn := src.Streams[key]
+		info := streamInfo{virtualFileInfo{name: path.Base(name), modTime: w.now}}
+		w.seenName(name)
+
+		w.files = append(w.files, archiver.File{
+			FileInfo:      info,
+			NameInArchive: name,
+			Open: func() (io.ReadCloser, error) {
+				return newStreamReader(fn, indexes, name, info), nil
+			},
+		})
+	}
+}
+
+// seenName records the name as part of the sources, so the incremental archives do not list it as deleted
+func (w *walker) seenName(name string) {
+
+	if w.filter.Base != nil || w.filter.Index != nil {
+		w.seen[name] = true
+	}
+}
+
+// record records the entry of the name inside the new index and the manifest
+func (w *walker) record(name string, entry IndexEntry) {
+
+	w.seenName(name)
+	for _, index := range w.indexes() {
+		index.set(name, entry)
+	}
+}
+
+// indexes returns the indexes recording the archived files
+func (w *walker) indexes() []*Index {
+
+	indexes := []*Index{}
+	for _, index := range []*Index{w.filter.Index, w.filter.Manifest} {
+		if index != nil {
+			indexes = append(indexes, index)
+		}
+	}
+
+	return indexes
+}
+
+// streamReader reads the content of a streamed file while its function writes it.
+// The entry of the file is recorded inside the indexes on close, only when the whole stream has been read.
+type streamReader struct {
+	pr      *io.PipeReader
+	done    chan error
+	indexes []*Index
+	name    string
+	entry   IndexEntry
+	hash    hash.Hash
+	ended   bool
+}
+
+// newStreamReader runs the function, writing to the returned reader
+func newStreamReader(fn StreamFunc, indexes []*Index, name string, info streamInfo) *streamReader {
+
+	pr, pw := io.Pipe()
+	r := &streamReader{
+		pr:      pr,
+		done:    make(chan error, 1),
+		indexes: indexes,
+		name:    name,
+		entry:   IndexEntry{Mode: info.Mode(), ModTime: info.ModTime().UTC()},
+		hash:    sha256.New(),
+	}
+
+	go func() {
+		err := fn(pw)
+		pw.CloseWithError(err) // A nil error ends the reader with io.EOF
+		r.done <- err
+	}()
+
+	return r
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+
+	n, err := r.pr.Read(p)
+	r.hash.Write(p[:n])
+	r.entry.Size += int64(n)
+	if err == io.EOF {
+		r.ended = true
+	}
+
+	return n, err
+}
+
+// Close stops the function when the stream has not been read to its end, and waits for it
+func (r *streamReader) Close() error {
+
+	if !r.ended {
+		r.pr.CloseWithError(errStreamClosed)
+	}
+	err := <-r.done
+
+	if r.ended && err == nil {
+		r.entry.Hash = hex.EncodeToString(r.hash.Sum(nil))
+		for _, index := range r.indexes {
+			index.set(r.name, r.entry)
+		}
+	}
+
+	return nil
+}
+
+// spool writes the streamed file inside a temporary file, removed by the returned cleanup.
+// The returned file has the size of its content, for the formats writing it before the content.
+func spool(file archiver.File) (archiver.File, func(), error) {
+
+	tmp, err := os.CreateTemp("", "harpo-stream-*")
+	if err != nil {
+		return file, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		cleanup()
+		return file, nil, err
+	}
+	size, err := io.Copy(tmp, rc)
+	rc.Close()
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return file, nil, err
+	}
+
+	file.FileInfo = virtualFileInfo{name: file.Name(), size: size, modTime: file.ModTime()}
+	file.Open = func() (io.ReadCloser, error) {
+		return io.NopCloser(tmp), nil
+	}
+
+	return file, cleanup, nil
+}
@@ -1,100 +1,253 @@
 package archiving
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"runtime"
 
 	"github.com/Polo44444/harpo/models"
+	"github.com/klauspost/compress/zstd"
 	"github.com/mholt/archiver/v4"
+	"github.com/pierrec/lz4/v4"
 )
 
 type CompressionType string
 
+// errArchiveStopped is returned for the files left when the tar archive stopped
+var errArchiveStopped = errors.New("archive stopped")
+
 const (
-	GzCompressionType CompressionType = "Gz"
+	GzCompressionType     CompressionType = "Gz"
+	ZstdCompressionType   CompressionType = "Zstd"
+	XzCompressionType     CompressionType = "Xz"
+	Bz2CompressionType    CompressionType = "Bz2"
+	Lz4CompressionType    CompressionType = "Lz4"
+	BrotliCompressionType CompressionType = "Brotli"
 )
 
+// tarCompression describes a compression algorithm of the tar archives
+type tarCompression struct {
+	ext      string // Extension of the archives
+	minLevel int    // Levels range of the algorithm. DefaultLevel is always accepted
+	maxLevel int
+	magic    []byte // First bytes of the compressed streams. Nil when the stream can not be recognized
+}
+
+var tarCompressions = map[CompressionType]tarCompression{
+	GzCompressionType:     {ext: ".tar.gz", minLevel: 0, maxLevel: 9, magic: []byte{0x1f, 0x8b}},
+	ZstdCompressionType:   {ext: ".tar.zst", minLevel: 1, maxLevel: 22, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	XzCompressionType:     {ext: ".tar.xz", minLevel: DefaultLevel, maxLevel: DefaultLevel, magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	Bz2CompressionType:    {ext: ".tar.bz2", minLevel: 1, maxLevel: 9, magic: []byte("BZh")},
+	Lz4CompressionType:    {ext: ".tar.lz4", minLevel: 1, maxLevel: 9, magic: []byte{0x04, 0x22, 0x4d, 0x18}},
+	BrotliCompressionType: {ext: ".tar.br", minLevel: 0, maxLevel: 11},
+}
+
+// lz4 levels from 1 to 9
+var lz4Levels = []lz4.CompressionLevel{lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
 type tarProvider struct {
-	compression string // Compression method: Gz
-	method      int    // Method/level of compression
+	compression   string // Compression method: Gz, Zstd, Xz, Bz2, Lz4 or Brotli
+	method        int    // Method/level of compression
+	multithreaded bool   // Compress with several goroutines. Only used by Gz and Zstd
 }
 
-func BuildTarConfig(method int, compression CompressionType) models.ProviderConfig {
+func BuildTarConfig(method int, compression CompressionType, multithreaded bool) models.ProviderConfig {
 	return models.ProviderConfig{
-		"compression": string(compression),
-		"method":      method,
+		"compression":   string(compression),
+		"method":        method,
+		"multithreaded": multithreaded,
 	}
 }
 
 func newTarProvider(config models.ProviderConfig) (*tarProvider, error) {
 
 	prvd := &tarProvider{
-		compression: config["compression"].(string),
-		method:      config["method"].(int),
+		compression:   config["compression"].(string),
+		method:        config["method"].(int),
+		multithreaded: config["multithreaded"].(bool),
 	}
 
-	if prvd.compression != string(GzCompressionType) {
+	tc, ok := tarCompressions[CompressionType(prvd.compression)]
+	if !ok {
 		return nil, fmt.Errorf("invalid compression type: %s", prvd.compression)
 	}
 
 	// check if the method is valid
-	if prvd.method < 0 || prvd.method > 9 { // Gzip compression methods
-		return nil, fmt.Errorf("invalid compression method: %d", prvd.method)
+	if prvd.method != DefaultLevel && (prvd.method < tc.minLevel || prvd.method > tc.maxLevel) {
+		if tc.minLevel == tc.maxLevel {
+			return nil, fmt.Errorf("%s compression has no level", prvd.compression)
+		}
+		return nil, fmt.Errorf("invalid %s compression method: %d. Must be between %d and %d", prvd.compression, prvd.method, tc.minLevel, tc.maxLevel)
 	}
 
 	return prvd, nil
 }
 
-// Archive creates a tar archive from the src and writes it to the dst.
-func (t *tarProvider) Archive(ctx context.Context, src string, dst io.Writer, ignoreErrors bool) error {
+// archiverCompression returns the archiver compression of the provider
+func (t *tarProvider) archiverCompression() archiver.Compression {
 
-	sanitizedSrc := sanitizePath(src)
-	files, err := archiver.FilesFromDisk(nil, map[string]string{
-		sanitizedSrc: "",
-	})
-	if err != nil {
-		return err
-	}
+	switch CompressionType(t.compression) {
+	case ZstdCompressionType:
 
-	var compression archiver.Compression
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if t.multithreaded {
+			options[0] = zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0))
+		}
+		if t.method != DefaultLevel {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(t.method)))
+		}
+		return archiver.Zstd{EncoderOptions: options}
+	case XzCompressionType:
+		return archiver.Xz{}
+	case Bz2CompressionType:
+
+		level := 9
+		if t.method != DefaultLevel {
+			level = t.method
+		}
+		return archiver.Bz2{CompressionLevel: level}
+	case Lz4CompressionType:
 
-	switch t.compression {
-	case string(GzCompressionType):
-		compression = archiver.Gz{
-			CompressionLevel: t.method,
-			Multithreaded:    true,
+		level := 0 // lz4.Fast
+		if t.method != DefaultLevel {
+			level = int(lz4Levels[t.method-1])
 		}
+		return archiver.Lz4{CompressionLevel: level}
+	case BrotliCompressionType:
+
+		quality := 6
+		if t.method != DefaultLevel {
+			quality = t.method
+		}
+		return archiver.Brotli{Quality: quality}
 	default:
-		compression = archiver.Gz{
+		return archiver.Gz{
 			CompressionLevel: t.method,
-			Multithreaded:    true,
+			Multithreaded:    t.multithreaded,
 		}
 	}
+}
+
+// Archive creates a tar archive from the srcs and writes it to the dst.
+// The tar headers hold the size of the files before their content, so each streamed file is spooled
+// to a temporary file right before it is archived, and removed right after.
+func (t *tarProvider) Archive(ctx context.Context, srcs []Source, dst io.Writer, filter *Filter, ignoreErrors bool) error {
+
+	files, err := filesFromDisk(srcs, filter, ignoreErrors)
+	if err != nil {
+		return err
+	}
 
 	format := archiver.CompressedArchive{
-		Compression: compression,
-		Archival: archiver.Tar{
-			ContinueOnError: ignoreErrors,
-		},
+		Compression: t.archiverCompression(),
+		Archival:    archiver.Tar{},
+	}
+
+	jobs := make(chan archiver.ArchiveAsyncJob)
+	stopped := make(chan struct{})
+	var archiveErr error
+	go func() {
+		defer close(stopped)
+		archiveErr = format.ArchiveAsync(ctx, dst, jobs)
+	}()
+
+	err = t.archiveFiles(ctx, files, jobs, stopped, ignoreErrors)
+	close(jobs)
+	<-stopped
+
+	// The archive stopping on its own is the cause of the other errors
+	if archiveErr != nil {
+		return archiveErr
+	}
+	return err
+}
+
+// archiveFiles hands the files to the archive one at a time.
+// With ignoreErrors, the files which can not be archived are logged and left out, as archiver.Tar does.
+func (t *tarProvider) archiveFiles(ctx context.Context, files []archiver.File, jobs chan<- archiver.ArchiveAsyncJob, stopped <-chan struct{}, ignoreErrors bool) error {
+
+	for _, file := range files {
+
+		err := t.archiveFile(ctx, file, jobs, stopped)
+		if errors.Is(err, errArchiveStopped) {
+			return err
+		}
+		if err != nil && ignoreErrors && ctx.Err() == nil {
+			log.Printf("Unable to archive %s, it is left out: %v\n", file.NameInArchive, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveFile hands the file to the archive and waits for it to be written
+func (t *tarProvider) archiveFile(ctx context.Context, file archiver.File, jobs chan<- archiver.ArchiveAsyncJob, stopped <-chan struct{}) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if streamed(file) {
+
+		var cleanup func()
+		var err error
+		file, cleanup, err = spool(file)
+		if err != nil {
+			return fmt.Errorf("file %s: %w", file.NameInArchive, err)
+		}
+		defer cleanup()
+	}
+
+	result := make(chan error, 1)
+	select {
+	case jobs <- archiver.ArchiveAsyncJob{File: file, Result: result}:
+		return <-result
+	case <-stopped:
+		return errArchiveStopped
+	}
+}
+
+// detectCompression returns the compression of the tar stream, recognized from its first bytes.
+// Streams which can not be recognized (brotli) are expected to use the compression of the provider.
+func (t *tarProvider) detectCompression(src *bufio.Reader) archiver.Compression {
+
+	header, _ := src.Peek(8)
+	for compressionType, tc := range tarCompressions {
+
+		if tc.magic != nil && bytes.HasPrefix(header, tc.magic) {
+			detected := &tarProvider{compression: string(compressionType), method: DefaultLevel}
+			return detected.archiverCompression()
+		}
 	}
 
-	return format.Archive(ctx, dst, files)
+	return t.archiverCompression()
 }
 
 // Extract extracts the tar archive from the src and writes it to the dst. The dst must be a directory.
-func (t *tarProvider) Extract(ctx context.Context, src io.Reader, dst string, ignoreErrors bool) error {
+// The compression is detected from the stream, whatever the compression of the provider.
+func (t *tarProvider) Extract(ctx context.Context, src io.Reader, dst string, targets map[string]string, ignoreErrors bool) error {
 
+	bufSrc := bufio.NewReader(src)
 	format := archiver.CompressedArchive{
+		Compression: t.detectCompression(bufSrc),
 		Archival: archiver.Tar{
 			ContinueOnError: ignoreErrors,
 		},
 	}
 
-	return format.Extract(ctx, src, nil, NewExtract(dst).handler)
+	return format.Extract(ctx, bufSrc, nil, NewExtract(dst, targets).handler)
 }
 
 // Ext returns the extension of the archive.
 func (t *tarProvider) Ext() string {
-	return ".tar.gz"
+	return tarCompressions[CompressionType(t.compression)].ext
 }
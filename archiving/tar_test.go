@@ -23,6 +23,7 @@ func TestTar(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating file: %s", err.Error())
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
 
 	// Create a context with a timeout of 1 minute
@@ -30,10 +31,103 @@ func TestTar(t *testing.T) {
 	defer cancel()
 
 	// We archive the folder test_dummies
-	err = p.Archive(ctx, "test_dummies", f, true)
+	err = p.Archive(ctx, testSources, f, nil, true)
 	if err != nil {
 		t.Fatalf("Error archiving: %s", err.Error())
 	}
+	f.Close()
+
+	// We extract the archive back
+	testExtract(t, "test_dummies.tar.gz")
+
+	TestEnd(t)
+}
+
+func TestTarCompressions(t *testing.T) {
+
+	// Init
+	TestInit(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Gz provider used to extract archives of other compressions
+	gzProvider, err := GetProvider(TarProvider, testTarConf)
+	if err != nil {
+		t.Fatalf("Error creating Tar provider: %s", err.Error())
+	}
+
+	for _, c := range []struct {
+		compression CompressionType
+		level       int
+		ext         string
+	}{
+		{GzCompressionType, 1, ".tar.gz"},
+		{ZstdCompressionType, 3, ".tar.zst"},
+		{XzCompressionType, DefaultLevel, ".tar.xz"},
+		{Bz2CompressionType, 9, ".tar.bz2"},
+		{Lz4CompressionType, 5, ".tar.lz4"},
+		{BrotliCompressionType, 4, ".tar.br"},
+	} {
+
+		p, err := GetProvider(TarProvider, BuildTarConfig(c.level, c.compression, true))
+		if err != nil {
+			t.Fatalf("%s: error creating Tar provider: %s", c.compression, err.Error())
+		}
+		if p.Ext() != c.ext {
+			t.Fatalf("%s: extension is %s, want %s", c.compression, p.Ext(), c.ext)
+		}
+
+		archivePath := "test_dummies" + c.ext
+		f, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatalf("%s: error creating file: %s", c.compression, err.Error())
+		}
+		defer os.Remove(archivePath)
+
+		err = p.Archive(ctx, testSources, f, nil, true)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: error archiving: %s", c.compression, err.Error())
+		}
+
+		// The provider is picked from the extension
+		testExtract(t, archivePath)
+
+		// The compression is detected from the stream
+		if c.compression == BrotliCompressionType {
+			continue // Brotli streams have no signature
+		}
+		f, err = os.Open(archivePath)
+		if err != nil {
+			t.Fatalf("%s: error opening archive: %s", c.compression, err.Error())
+		}
+		dst := "test_dummies_detect"
+		err = gzProvider.Extract(ctx, f, dst, nil, false)
+		f.Close()
+		os.RemoveAll(dst)
+		if err != nil {
+			t.Fatalf("%s: error extracting with detection: %s", c.compression, err.Error())
+		}
+	}
+
+	// Levels are checked against the algorithm range
+	for _, c := range []struct {
+		compression CompressionType
+		level       int
+	}{
+		{ZstdCompressionType, 23},
+		{XzCompressionType, 5},
+		{Bz2CompressionType, 0},
+		{Lz4CompressionType, 10},
+		{BrotliCompressionType, 12},
+		{CompressionType("Rar"), DefaultLevel},
+	} {
+		_, err := GetProvider(TarProvider, BuildTarConfig(c.level, c.compression, false))
+		if err == nil {
+			t.Fatalf("%s: level %d accepted", c.compression, c.level)
+		}
+	}
 
 	TestEnd(t)
 }
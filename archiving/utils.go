@@ -1,6 +1,8 @@
 package archiving
 
 import (
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -12,3 +14,35 @@ func sanitizePath(p string) string {
 	p = strings.ReplaceAll(p, "\\", separator)
 	return p
 }
+
+// seekable returns the src when it can be read at any offset. Otherwise, the src is copied inside a temporary
+// file named after the pattern, removed by the returned cleanup.
+func seekable(src io.Reader, pattern string) (io.Reader, func(), error) {
+
+	if _, ok := src.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		return src, func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	_, err = io.Copy(tmp, src)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return tmp, cleanup, nil
+}
@@ -2,21 +2,25 @@ package archiving
 
 import (
 	"archive/zip"
+	"compress/flate"
 	"context"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/Polo44444/harpo/models"
 	"github.com/mholt/archiver/v4"
 )
 
 type zipProvider struct {
-	method uint16 // Method/level of compression
+	method uint16 // Method of compression: Deflate or Store
+	level  int    // Level of the Deflate compression
 }
 
-func BuildZipConfig(method uint16) models.ProviderConfig {
+func BuildZipConfig(method uint16, level int) models.ProviderConfig {
 	return models.ProviderConfig{
 		"method": method,
+		"level":  level,
 	}
 }
 
@@ -24,6 +28,7 @@ func newZipProvider(config models.ProviderConfig) (*zipProvider, error) {
 
 	prvd := &zipProvider{
 		method: config["method"].(uint16),
+		level:  config["level"].(int),
 	}
 
 	// check if the method is valid
@@ -31,36 +36,111 @@ func newZipProvider(config models.ProviderConfig) (*zipProvider, error) {
 		return nil, fmt.Errorf("invalid compression method: %d", prvd.method)
 	}
 
+	// check if the level is valid
+	if prvd.method == zip.Store && prvd.level != DefaultLevel {
+		return nil, fmt.Errorf("store method has no compression level")
+	}
+	if prvd.level < DefaultLevel || prvd.level > flate.BestCompression {
+		return nil, fmt.Errorf("invalid compression level: %d", prvd.level)
+	}
+
 	return prvd, nil
 }
 
-// Archive creates a zip archive from the src and writes it to the dst.
-func (z *zipProvider) Archive(ctx context.Context, src string, dst io.Writer, ignoreErrors bool) error {
+// Archive creates a zip archive from the srcs and writes it to the dst.
+func (z *zipProvider) Archive(ctx context.Context, srcs []Source, dst io.Writer, filter *Filter, ignoreErrors bool) error {
 
-	sanitizedSrc := sanitizePath(src)
-	files, err := archiver.FilesFromDisk(nil, map[string]string{
-		sanitizedSrc: "",
-	})
+	files, err := filesFromDisk(srcs, filter, ignoreErrors)
 	if err != nil {
 		return err
 	}
 
-	format := archiver.Zip{
-		Compression:     z.method,
-		ContinueOnError: ignoreErrors,
+	// The zip writer is built here, as archiver.Zip can not set the Deflate level
+	zw := zip.NewWriter(dst)
+	if z.method == zip.Deflate && z.level != DefaultLevel {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, z.level)
+		})
+	}
+
+	for _, file := range files {
+
+		err := z.archiveFile(ctx, zw, file, ignoreErrors)
+		if err != nil {
+			zw.Close()
+			return err
+		}
+	}
+
+	// Close writes the central directory
+	return zw.Close()
+}
+
+// archiveFile writes the file inside the zip archive.
+// With ignoreErrors, files which can not be opened are skipped.
+func (z *zipProvider) archiveFile(ctx context.Context, zw *zip.Writer, file archiver.File, ignoreErrors bool) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hdr, err := zip.FileInfoHeader(file)
+	if err != nil {
+		return fmt.Errorf("unable to get header of %s: %w", file.Name(), err)
+	}
+	hdr.Name = file.NameInArchive
+	hdr.Method = z.method
+
+	// Directories have no content
+	if file.IsDir() {
+		if !strings.HasSuffix(hdr.Name, "/") {
+			hdr.Name += "/"
+		}
+		hdr.Method = zip.Store
 	}
 
-	return format.Archive(ctx, dst, files)
+	if file.IsDir() {
+		_, err = zw.CreateHeader(hdr)
+		return err
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		if ignoreErrors {
+			return nil
+		}
+		return fmt.Errorf("unable to open %s: %w", file.Name(), err)
+	}
+	defer r.Close()
+
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("unable to create header of %s: %w", file.Name(), err)
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", file.Name(), err)
+	}
+
+	return nil
 }
 
 // Extract extracts the zip archive from the src and writes it to the dst. The dst must be a directory.
-func (z *zipProvider) Extract(ctx context.Context, src io.Reader, dst string, ignoreErrors bool) error {
+func (z *zipProvider) Extract(ctx context.Context, src io.Reader, dst string, targets map[string]string, ignoreErrors bool) error {
 
 	format := archiver.Zip{
 		ContinueOnError: ignoreErrors,
 	}
 
-	return format.Extract(ctx, src, nil, NewExtract(dst).handler)
+	// The central directory is at the end of the archive
+	src, cleanup, err := seekable(src, "harpo-*.zip")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return format.Extract(ctx, src, nil, NewExtract(dst, targets).handler)
 }
 
 // Ext returns the extension of the archive.
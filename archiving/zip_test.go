@@ -23,6 +23,7 @@ func TestZip(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating file: %s", err.Error())
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
 
 	// Create a context with a timeout of 1 minute
@@ -30,10 +31,14 @@ func TestZip(t *testing.T) {
 	defer cancel()
 
 	// We archive the folder test_dummies
-	err = p.Archive(ctx, "test_dummies", f, true)
+	err = p.Archive(ctx, testSources, f, nil, true)
 	if err != nil {
 		t.Fatalf("Error archiving: %s", err.Error())
 	}
+	f.Close()
+
+	// We extract the archive back
+	testExtract(t, "test_dummies.zip")
 
 	TestEnd(t)
 }
@@ -1,12 +1,11 @@
 package backup
 
 import (
-	"archive/zip"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/Polo44444/harpo/alerting"
@@ -33,30 +32,34 @@ func (a *archiver) setNext(p processor) processor {
 	return p
 }
 
-func (a *archiver) process(ctx context.Context, folder config.Folder, storages map[string]storing.Provider, notifiers map[string]alerting.Provider) {
+// getArchiverProvider returns the archiver provider of the given folder and the content type of its archives
+func getArchiverProvider(folder config.Folder) (archiving.Provider, string, error) {
 
-	archiveType := strings.ToUpper(folder.Archiver)
-	var (
-		p   archiving.Provider
-		err error
-	)
+	entity, archiverConfig, err := folder.ArchiverConfig()
+	if err != nil {
+		return nil, "", err
+	}
 
 	contentType := ""
-
-	switch archiveType {
-	case string(archiving.ZipProvider):
-		p, err = archiving.GetProvider(archiving.ZipProvider, archiving.BuildZipConfig(zip.Deflate))
-		contentType = "application/zip"
-	case string(archiving.TarProvider):
-		p, err = archiving.GetProvider(archiving.TarProvider, archiving.BuildTarConfig(9, archiving.GzCompressionType))
+	switch entity {
+	case archiving.TarProvider:
 		contentType = "application/x-tar"
+	case archiving.SevenZipProvider:
+		contentType = "application/x-7z-compressed"
 	default:
-		p, err = archiving.GetProvider(archiving.ZipProvider, archiving.BuildZipConfig(zip.Deflate))
 		contentType = "application/zip"
 	}
 
+	p, err := archiving.GetProvider(entity, archiverConfig)
+
+	return p, contentType, err
+}
+
+func (a *archiver) process(ctx context.Context, folder config.Folder, storages map[string]storing.Provider, notifiers map[string]alerting.Provider) {
+
+	p, contentType, err := getArchiverProvider(folder)
 	if err != nil {
-		log.Printf("Unable to get archiver provider of folder %s: %v\n", err, folder.Path)
+		log.Printf("Unable to get archiver provider of folder %s: %v\n", folder.Name, err)
 		NotifyError(
 			ctx,
 			folder.Name,
@@ -68,20 +71,38 @@ func (a *archiver) process(ctx context.Context, folder config.Folder, storages m
 		return
 	}
 
+	filter, err := folder.ArchiveFilter()
+	if err != nil {
+		log.Printf("Unable to get archive filter of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to get archive filter of folder %s", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	// Incremental and differential archives only hold the changes since their base
+	kind, chain := nextArchive(folder, filter)
+	filter.Manifest = archiving.NewIndex()
+
 	// ─── Start Archiving Process ─────────────────────────────────────────
 	NotifyInfo(
 		ctx,
 		folder.Name,
 		fmt.Sprintf("Backup 💾 process of folder %s started🌴", folder.Name),
-		"",
+		fmt.Sprintf("Archive: %s", kind),
 		notifiers,
 	)
 
-	// Create a file to write the archive
-	fileName := uuid.Must(uuid.NewRandom()).String() + p.Ext()
+	// Create a file to write the archive. Its extension marks the kind of archive
+	fileName := uuid.Must(uuid.NewRandom()).String() + kindExt(kind) + p.Ext()
 	file, err := os.Create(fileName)
 	if err != nil {
-		log.Printf("Unable to create archive file of folder %s: %v\n", err, folder.Path)
+		log.Printf("Unable to create archive file of folder %s: %v\n", folder.Name, err)
 		NotifyError(
 			ctx,
 			folder.Name,
@@ -94,16 +115,20 @@ func (a *archiver) process(ctx context.Context, folder config.Folder, storages m
 	}
 	defer func() {
 		err := file.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, os.ErrClosed) {
 			log.Printf("Unable to close file %s: %v\n", fileName, err)
 		}
 	}()
 
 	pCtx, cancel := context.WithTimeout(ctx, archiveTimeout) // TODO: Calculate the timeout based on the folder size
 	defer cancel()
-	err = p.Archive(pCtx, folder.Path, file, folder.IgnoreArchiveErrors)
+	err = p.Archive(pCtx, folder.Sources(), file, filter, folder.IgnoreArchiveErrors)
+	getHookRun(ctx).afterArchive(ctx, fileName, err)
 	if err != nil {
-		log.Printf("Unable to archive folder %s: %v\n", err, folder.Path)
+		// A failed archive is incomplete, e.g. when a database dump failed
+		file.Close()
+		os.Remove(fileName) // No need to check errors here. The archive is not used anymore
+		log.Printf("Unable to archive folder %s: %v\n", folder.Name, err)
 		NotifyError(
 			ctx,
 			folder.Name,
@@ -127,6 +152,8 @@ func (a *archiver) process(ctx context.Context, folder config.Folder, storages m
 	// Hold the file inside the context
 	newCtx := context.WithValue(ctx, ArchiveCtxKey, fileName)
 	newCtx = context.WithValue(newCtx, ContentTypeCtxKey, contentType)
+	newCtx = context.WithValue(newCtx, ChainCtxKey, chain)
+	newCtx = context.WithValue(newCtx, ManifestCtxKey, newManifest(ctx, folder, kind, filter.Manifest))
 
 	if a.next != nil {
 		file.Close()
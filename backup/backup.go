@@ -9,6 +9,7 @@ import (
 	"github.com/Polo44444/harpo/config"
 	"github.com/Polo44444/harpo/storing"
 	"github.com/go-co-op/gocron/v2"
+	"github.com/google/uuid"
 )
 
 type CtxString string
@@ -20,6 +21,9 @@ type Engine struct {
 	folders   []config.Folder
 	storages  map[string]storing.Provider  // TODO: Should be changed to sync.Map
 	notifiers map[string]alerting.Provider // TODO: Should be changed to sync.Map
+
+	encryptions map[string]config.Encryption // Encryption of the storages overriding the folders one
+	verifyJobs  []config.VerifyJob           // Jobs checking the archives stored on the storages
 }
 
 // Processes contexts keys
@@ -28,12 +32,40 @@ const (
 	// Please make sure you close the file after you are done with it.
 	ArchiveCtxKey     CtxString = "archive"
 	ContentTypeCtxKey CtxString = "content-type"
+
+	// Holds the encrypted archive file name (string) of each storage name, set by the encrypt process.
+	// Storages missing from the map upload the archive of ArchiveCtxKey.
+	StorageArchivesCtxKey CtxString = "storage-archives"
+
+	// Hold the unique ID (string) and the start time (time.Time) of the current run.
+	RunIDCtxKey     CtxString = "run-id"
+	StartTimeCtxKey CtxString = "start-time"
+
+	// Holds the chain state (*pendingChain) of incremental backups, saved once the archive is uploaded.
+	ChainCtxKey CtxString = "chain"
+
+	// Holds the manifest (*Manifest) of the archive, completed and uploaded next to the archive on each storage.
+	ManifestCtxKey CtxString = "manifest"
+
+	// Holds true when an uploaded archive did not match the local one. The source of the folder is then kept.
+	UnverifiedCtxKey CtxString = "unverified"
+
+	// Holds the names (map[string]bool) of the storages which failed to store the archive of the run.
+	// Their older archives are not pruned.
+	FailedStoragesCtxKey CtxString = "failed-storages"
+
+	// Holds the hooks (*hookRun) of the run, which track the errors notified during the run.
+	HooksCtxKey CtxString = "hooks"
 )
 
 const (
 	harpoTag             = "harpo"
 	harpoBackupTag       = "harpo:backup"
 	harpoBackupFolderTag = "harpo:backup:%s"
+	harpoDrillTag        = "harpo:drill"
+	harpoDrillFolderTag  = "harpo:drill:%s"
+	harpoVerifyTag       = "harpo:verify"
+	harpoVerifyJobTag    = "harpo:verify:%s"
 )
 
 var (
@@ -54,6 +86,16 @@ func NewEngine(folders []config.Folder, storages map[string]storing.Provider, no
 	}
 }
 
+// SetStorageEncryptions sets the encryption of the storages which overrides the encryption of the folders
+func (e *Engine) SetStorageEncryptions(encryptions map[string]config.Encryption) {
+	e.encryptions = encryptions
+}
+
+// getEncryption returns the encryption of the archives of the folder uploaded to the given storage
+func (e *Engine) getEncryption(folder config.Folder, storageName string) config.Encryption {
+	return folder.Encryption.Merge(e.encryptions[storageName])
+}
+
 // Build Jobs build a list of jobs for the given folders
 func (e *Engine) BuildJobs() error {
 
@@ -68,21 +110,8 @@ func (e *Engine) BuildJobs() error {
 	// Loop trough folders to create cron jobs
 	for _, folder := range e.folders {
 
-		// Build folder storages
-		folderStorages := map[string]storing.Provider{}
-		for _, storageName := range folder.Storages {
-			if storage, ok := e.storages[storageName]; ok {
-				folderStorages[storageName] = storage
-			}
-		}
-
-		// Build folder notifiers
-		folderNotifiers := map[string]alerting.Provider{}
-		for _, notifierName := range folder.Notifiers {
-			if notifier, ok := e.notifiers[notifierName]; ok {
-				folderNotifiers[notifierName] = notifier
-			}
-		}
+		folderStorages := e.getFolderStorages(folder)
+		folderNotifiers := e.getFolderNotifiers(folder)
 
 		// Create cron job
 		_, err := e.sch.NewJob(
@@ -100,11 +129,85 @@ func (e *Engine) BuildJobs() error {
 			e.RemoveJobs()
 			return fmt.Errorf("unable to create cron job of folder %s: %w", folder.Name, err)
 		}
+
+		// Restore drills have their own schedule
+		if !folder.Drill.Enabled() {
+			continue
+		}
+		_, err = e.sch.NewJob(
+			gocron.CronJob(folder.Drill.Schedule, false),
+			gocron.NewTask(func(folderName string) {
+				e.Drill(folderName)
+			},
+				folder.Name,
+			),
+			gocron.WithTags(harpoTag, harpoDrillTag, fmt.Sprintf(harpoDrillFolderTag, folder.Name)),
+		)
+		if err != nil {
+			e.RemoveJobs()
+			return fmt.Errorf("unable to create drill cron job of folder %s: %w", folder.Name, err)
+		}
+	}
+
+	// Verify jobs have their own schedule
+	for _, job := range e.verifyJobs {
+
+		_, err := e.sch.NewJob(
+			gocron.CronJob(job.Schedule, false),
+			gocron.NewTask(func(job config.VerifyJob) {
+				e.Scrub(job)
+			},
+				job,
+			),
+			gocron.WithTags(harpoTag, harpoVerifyTag, fmt.Sprintf(harpoVerifyJobTag, job.Name)),
+		)
+		if err != nil {
+			e.RemoveJobs()
+			return fmt.Errorf("unable to create cron job of verify job %s: %w", job.Name, err)
+		}
 	}
 
 	return nil
 }
 
+// getFolder returns the folder with the given name
+func (e *Engine) getFolder(folderName string) (config.Folder, bool) {
+
+	for _, folder := range e.folders {
+		if folder.Name == folderName {
+			return folder, true
+		}
+	}
+
+	return config.Folder{}, false
+}
+
+// getFolderStorages returns the registered storages used by the given folder
+func (e *Engine) getFolderStorages(folder config.Folder) map[string]storing.Provider {
+
+	folderStorages := map[string]storing.Provider{}
+	for _, storageName := range folder.Storages {
+		if storage, ok := e.storages[storageName]; ok {
+			folderStorages[storageName] = storage
+		}
+	}
+
+	return folderStorages
+}
+
+// getFolderNotifiers returns the registered notifiers used by the given folder
+func (e *Engine) getFolderNotifiers(folder config.Folder) map[string]alerting.Provider {
+
+	folderNotifiers := map[string]alerting.Provider{}
+	for _, notifierName := range folder.Notifiers {
+		if notifier, ok := e.notifiers[notifierName]; ok {
+			folderNotifiers[notifierName] = notifier
+		}
+	}
+
+	return folderNotifiers
+}
+
 // start starts the backup process in background and returns immediately.
 func (e *Engine) Start() {
 
@@ -141,16 +244,57 @@ func (e *Engine) StopJobs() error {
 func (e *Engine) ProcessFolder(folder config.Folder, storages map[string]storing.Provider, notifiers map[string]alerting.Provider) error {
 
 	// Setup chain
-	chain := NewArchiver()
-	chain.
-		setNext(NewUploader()).
-		setNext(NewCleaner()).
-		setNext(nil)
+	// Streaming archives straight to the storages. Otherwise, the archive is written inside a temporary file first.
+	// Repositories store the new chunks of the files and a snapshot instead of an archive.
+	var chain processor
+	if folder.IsRepository() {
+		chain = NewRepositoryBackup()
+		chain.
+			setNext(NewPruner()).
+			setNext(NewCleaner()).
+			setNext(nil)
+	} else if folder.Streaming {
+		chain = NewStreamer(e.encryptions)
+		chain.
+			setNext(NewPruner()).
+			setNext(NewCleaner()).
+			setNext(nil)
+	} else {
+		chain = NewArchiver()
+		chain.
+			setNext(NewEncrypter(e.encryptions)).
+			setNext(NewUploader(e.encryptions)).
+			setNext(NewPruner()).
+			setNext(NewCleaner()).
+			setNext(nil)
+	}
 
 	// Execute chain
 	ctx, cancel := context.WithTimeout(e.ctx, ProcessTimeout)
 	defer cancel()
-	chain.process(ctx, folder, storages, notifiers)
+	ctx = context.WithValue(ctx, RunIDCtxKey, uuid.Must(uuid.NewRandom()).String())
+	ctx = context.WithValue(ctx, StartTimeCtxKey, time.Now())
+
+	// The hooks of the run see every error notified
+	run := newHookRun(folder, notifiers)
+	ctx = context.WithValue(ctx, HooksCtxKey, run)
+
+	// A failing pre_archive hook aborts the backup. The post_archive hooks run anyway, with the error
+	// of the pre_archive hooks or of the dumps, or once the run finishes when the chain stopped before the end of the archive.
+	// The databases are listed once the pre_archive hooks ran, then dumped while they are archived along with the paths.
+	err := run.preArchive(ctx)
+	if err == nil {
+
+		var dumped config.Folder
+		dumped, err = dumpDatabases(ctx, folder, notifiers)
+		if err == nil {
+			chain.process(ctx, dumped, storages, notifiers)
+		}
+	}
+	if err != nil {
+		run.afterArchive(ctx, "", err)
+	}
+	run.finish(ctx)
 
 	return nil
 }
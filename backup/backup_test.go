@@ -0,0 +1,294 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/storing"
+	storing_local "github.com/Polo44444/harpo/storing/local"
+)
+
+// testEngine creates an engine backing up a dummy folder to a local storage
+func testEngine(t *testing.T, archiver string) (*Engine, config.Folder) {
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	err := os.MkdirAll(filepath.Join(src, "texts"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err.Error())
+	}
+	err = os.WriteFile(filepath.Join(src, "texts", "file1.txt"), []byte("Hello World!"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err.Error())
+	}
+
+	storage, err := storing.GetProvider(storing.LocalProvider, storing_local.BuildLocalConfig(filepath.Join(dir, "storage"), false, 0600, 0700))
+	if err != nil {
+		t.Fatalf("Error creating local provider: %s", err.Error())
+	}
+
+	folder := config.Folder{
+		Name:        "Dummies",
+		Path:        src,
+		Destination: "backup/dummies",
+		Schedule:    "0 1 * * *",
+		Archiver:    archiver,
+		Storages:    []string{"local"},
+	}
+
+	return NewEngine([]config.Folder{folder}, map[string]storing.Provider{"local": storage}, map[string]alerting.Provider{}), folder
+}
+
+func TestBackupAndRestore(t *testing.T) {
+
+	// The latest alias keeps the extension of the first releases
+	latestKeys := map[string]string{
+		"ZIP": "backup/dummies/dummies.harpo.zip",
+		"TAR": "backup/dummies/dummies.harpo.gz",
+	}
+
+	for _, archiver := range []string{"ZIP", "TAR"} {
+
+		e, folder := testEngine(t, archiver)
+
+		// Two runs produce two versioned archives and the latest alias
+		for i := 0; i < 2; i++ {
+			e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+		}
+
+		archives, err := e.List(folder.Name, "local")
+		if err != nil {
+			t.Fatalf("%s: error listing archives: %s", archiver, err.Error())
+		}
+		if len(archives) != 3 {
+			t.Fatalf("%s: listed %d archives, want 3", archiver, len(archives))
+		}
+		latest := 0
+		for _, archive := range archives {
+			if archive.Latest && archive.Key == latestKeys[archiver] && archive.Kind == FullKind {
+				latest++
+			}
+		}
+		if latest != 1 {
+			t.Fatalf("%s: latest alias %s not listed: %+v", archiver, latestKeys[archiver], archives)
+		}
+
+		// Restore the latest alias
+		target := filepath.Join(t.TempDir(), "restored")
+		err = e.Restore(folder.Name, "local", "", target)
+		if err != nil {
+			t.Fatalf("%s: error restoring: %s", archiver, err.Error())
+		}
+
+		data, err := os.ReadFile(filepath.Join(target, "src", "texts", "file1.txt"))
+		if err != nil {
+			t.Fatalf("%s: error reading restored file: %s", archiver, err.Error())
+		}
+		if string(data) != "Hello World!" {
+			t.Fatalf("%s: restored file content is %q", archiver, string(data))
+		}
+	}
+}
+
+func TestMultiplePathsBackupAndRestore(t *testing.T) {
+
+	for _, archiver := range []string{"ZIP", "TAR", "SEVENZIP"} {
+
+		e, folder := testEngine(t, archiver)
+
+		// A second path, archived under another name
+		etc := filepath.Join(t.TempDir(), "etc")
+		err := os.MkdirAll(etc, os.ModePerm)
+		if err != nil {
+			t.Fatalf("Error creating directory: %s", err.Error())
+		}
+		err = os.WriteFile(filepath.Join(etc, "app.conf"), []byte("debug = false"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("Error creating file: %s", err.Error())
+		}
+
+		folder.Paths = []config.Source{{Path: folder.Path}, {Path: etc, Name: "config/app"}}
+		folder.Path = ""
+		folder.Remove = true
+		e.folders = []config.Folder{folder}
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+		// Both paths have been cleared after the upload
+		for _, src := range folder.Sources() {
+			entries, err := os.ReadDir(src.Path)
+			if err != nil || len(entries) != 0 {
+				t.Fatalf("%s: path %s has not been cleared: %v", archiver, src.Path, err)
+			}
+		}
+
+		// Inside a target, each path is restored under its name
+		target := filepath.Join(t.TempDir(), "restored")
+		err = e.Restore(folder.Name, "local", "", target)
+		if err != nil {
+			t.Fatalf("%s: error restoring: %s", archiver, err.Error())
+		}
+		data, err := os.ReadFile(filepath.Join(target, "config", "app", "app.conf"))
+		if err != nil || string(data) != "debug = false" {
+			t.Fatalf("%s: restored file content is %q: %v", archiver, string(data), err)
+		}
+
+		// Without target, each path is restored at its original location
+		err = e.Restore(folder.Name, "local", "", "")
+		if err != nil {
+			t.Fatalf("%s: error restoring in place: %s", archiver, err.Error())
+		}
+		for file, content := range map[string]string{
+			filepath.Join(folder.Paths[0].Path, "texts", "file1.txt"): "Hello World!",
+			filepath.Join(etc, "app.conf"):                            "debug = false",
+		} {
+			data, err := os.ReadFile(file)
+			if err != nil || string(data) != content {
+				t.Fatalf("%s: file %s restored in place is %q: %v", archiver, file, string(data), err)
+			}
+		}
+	}
+}
+
+func TestEncryptedBackupAndRestore(t *testing.T) {
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Error generating identity: %s", err.Error())
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	err = os.WriteFile(identityFile, []byte(id.String()+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing identity: %s", err.Error())
+	}
+
+	e, folder := testEngine(t, "TAR")
+
+	// Backups only need the public key
+	folder.Encryption = config.Encryption{Recipients: []string{id.Recipient().String()}}
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+	archives, err := e.List(folder.Name, "local")
+	if err != nil {
+		t.Fatalf("Error listing archives: %s", err.Error())
+	}
+	if len(archives) != 2 {
+		t.Fatalf("Listed %d archives, want 2", len(archives))
+	}
+	for _, archive := range archives {
+		ext := ".tar.gz.age"
+		if archive.Latest {
+			ext = ".harpo.gz.age"
+		}
+		if !strings.HasSuffix(archive.Key, ext) {
+			t.Fatalf("Archive %s is not encrypted", archive.Key)
+		}
+	}
+
+	// Restore fails without identity
+	target := filepath.Join(t.TempDir(), "restored")
+	err = e.Restore(folder.Name, "local", "", target)
+	if err == nil {
+		t.Fatalf("Encrypted archive restored without identity")
+	}
+
+	// Restore decrypts the archive with the identity
+	folder.Encryption.Identities = []string{identityFile}
+	e.folders[0] = folder
+	err = e.Restore(folder.Name, "local", archives[1].Key, target)
+	if err != nil {
+		t.Fatalf("Error restoring: %s", err.Error())
+	}
+
+	data, err := os.ReadFile(filepath.Join(target, "src", "texts", "file1.txt"))
+	if err != nil {
+		t.Fatalf("Error reading restored file: %s", err.Error())
+	}
+	if string(data) != "Hello World!" {
+		t.Fatalf("Restored file content is %q", string(data))
+	}
+
+	// The storage encryption overrides the folder one
+	e.SetStorageEncryptions(map[string]config.Encryption{"local": {Passphrase: "secret"}})
+	if e.getEncryption(folder, "local").Passphrase != "secret" {
+		t.Fatalf("Storage encryption does not override the folder one")
+	}
+}
+
+// brokenProvider fails the uploads after reading a part of the data
+type brokenProvider struct {
+	storing.Provider
+}
+
+func (b *brokenProvider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
+
+	io.CopyN(io.Discard, data, 64)
+	return errors.New("connection reset")
+}
+
+func TestBackupFailureKeepsFolder(t *testing.T) {
+
+	// A folder is only removed once its archive is stored on every storage
+	for _, streaming := range []bool{false, true} {
+		for name, storages := range map[string][]string{"one failing": {"local", "broken"}, "all failing": {"broken"}} {
+
+			e, folder := testEngine(t, "ZIP")
+			folder.Streaming = streaming
+			folder.Remove = true
+			folder.Storages = storages
+			e.folders[0] = folder
+			e.storages["broken"] = &brokenProvider{Provider: e.storages["local"]}
+
+			e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+			data, err := os.ReadFile(filepath.Join(folder.Path, "texts", "file1.txt"))
+			if err != nil || string(data) != "Hello World!" {
+				t.Fatalf("streaming %v, %s: folder has been removed: %q %v", streaming, name, string(data), err)
+			}
+		}
+	}
+}
+
+func TestStreamingBackupAndRestore(t *testing.T) {
+
+	for _, archiver := range []string{"ZIP", "TAR"} {
+
+		e, folder := testEngine(t, archiver)
+
+		// A failing storage does not stop the others
+		folder.Streaming = true
+		folder.Storages = append(folder.Storages, "broken")
+		e.folders[0] = folder
+		e.storages["broken"] = &brokenProvider{Provider: e.storages["local"]}
+
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+		archives, err := e.List(folder.Name, "local")
+		if err != nil {
+			t.Fatalf("%s: error listing archives: %s", archiver, err.Error())
+		}
+		if len(archives) != 2 {
+			t.Fatalf("%s: listed %d archives, want 2", archiver, len(archives))
+		}
+
+		target := filepath.Join(t.TempDir(), "restored")
+		err = e.Restore(folder.Name, "local", "", target)
+		if err != nil {
+			t.Fatalf("%s: error restoring: %s", archiver, err.Error())
+		}
+
+		data, err := os.ReadFile(filepath.Join(target, "src", "texts", "file1.txt"))
+		if err != nil || string(data) != "Hello World!" {
+			t.Fatalf("%s: restored file content is %q, error %v", archiver, string(data), err)
+		}
+	}
+}
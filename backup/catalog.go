@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/storing"
+	"github.com/gosimple/slug"
+)
+
+const harpoExt = ".harpo"
+
+// Archive is an archive of a folder stored on a storage
+type Archive struct {
+	Folder   string            `json:"folder"`
+	Storage  string            `json:"storage"`
+	Key      string            `json:"key"`
+	Size     int64             `json:"size"`
+	ModTime  time.Time         `json:"mod_time"`
+	Time     time.Time         `json:"time"`               // Start time of the run which produced the archive. Falls back to ModTime.
+	Latest   bool              `json:"latest"`             // True when the archive is the latest alias of the folder
+	Kind     string            `json:"kind"`               // full, incremental, differential or snapshot
+	Manifest string            `json:"manifest,omitempty"` // Key of the manifest of the archive. Empty when it has none
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// List returns the archives of the given folder stored on the given storage, newest first.
+// Empty names select all the folders and all their storages.
+// Storages which can not be listed are skipped and their errors returned along with the other archives.
+func (e *Engine) List(folderName, storageName string) ([]Archive, error) {
+
+	if _, ok := e.getFolder(folderName); folderName != "" && !ok {
+		return nil, fmt.Errorf("folder %s is not valid or have not been declared", folderName)
+	}
+	if _, ok := e.storages[storageName]; storageName != "" && !ok {
+		return nil, fmt.Errorf("storage %s is not valid or have not been declared", storageName)
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, pruneTimeout)
+	defer cancel()
+
+	archives := []Archive{}
+	errs := []error{}
+	for _, folder := range e.folders {
+
+		if folderName != "" && folder.Name != folderName {
+			continue
+		}
+
+		for name, storage := range e.getFolderStorages(folder) {
+
+			if storageName != "" && name != storageName {
+				continue
+			}
+
+			folderArchives, err := listArchives(ctx, folder, storage)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("unable to list archives of folder %s on storage %s: %w", folder.Name, name, err))
+				continue
+			}
+
+			for i := range folderArchives {
+				folderArchives[i].Storage = name
+			}
+			archives = append(archives, folderArchives...)
+		}
+	}
+
+	return archives, errors.Join(errs...)
+}
+
+// getDestPrefix returns the prefix of all the archives of the folder
+func getDestPrefix(folder config.Folder) string {
+
+	prefix := strings.ReplaceAll(filepath.Join(folder.Destination), "\\", "/")
+	if prefix == "." {
+		return ""
+	}
+
+	return strings.TrimSuffix(prefix, "/") + "/"
+}
+
+// listArchives returns the archives of the folder stored on the storage, newest first.
+func listArchives(ctx context.Context, folder config.Folder, stor storing.Provider) ([]Archive, error) {
+
+	// The snapshots of a repository are its archives
+	if folder.IsRepository() {
+		return listSnapshots(ctx, folder, stor)
+	}
+
+	pattern, err := folder.NamePattern()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := getDestPrefix(folder)
+	// Manifests are matched with their archives, so the whole folder is listed
+	files, err := storing.ListAll(ctx, stor, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Manifests are stored next to their archives
+	manifests := map[string]bool{}
+	for _, file := range files {
+		if isManifestKey(file.Key) {
+			manifests[file.Key] = true
+		}
+	}
+
+	latestName := slug.Make(folder.Name)
+	archives := []Archive{}
+	for _, file := range files {
+
+		if manifests[file.Key] {
+			continue
+		}
+
+		// Archives are named <name>.harpo<ext>
+		relPath := strings.TrimPrefix(file.Key, prefix)
+		i := strings.LastIndex(relPath, harpoExt+".")
+		if i <= 0 {
+			continue
+		}
+		name := relPath[:i]
+
+		archive := Archive{
+			Folder:   folder.Name,
+			Key:      file.Key,
+			Size:     file.Size,
+			ModTime:  file.ModTime,
+			Time:     file.ModTime,
+			Kind:     archiveKind(relPath[i+len(harpoExt):]),
+			Metadata: file.Metadata,
+		}
+		if manifests[manifestKey(file.Key)] {
+			archive.Manifest = manifestKey(file.Key)
+		}
+
+		if name == latestName {
+			archive.Latest = true
+			archives = append(archives, archive)
+			continue
+		}
+
+		ok, t := pattern.Match(name)
+		if !ok {
+			continue
+		}
+		if !t.IsZero() {
+			archive.Time = t
+		}
+
+		archives = append(archives, archive)
+	}
+
+	// Runs started within the same second are ordered by their upload time
+	sort.SliceStable(archives, func(i, j int) bool {
+		if archives[i].Time.Equal(archives[j].Time) {
+			return archives[i].ModTime.After(archives[j].ModTime)
+		}
+		return archives[i].Time.After(archives[j].Time)
+	})
+
+	return archives, nil
+}
@@ -2,10 +2,13 @@ package backup
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/Polo44444/harpo/alerting"
 	"github.com/Polo44444/harpo/config"
@@ -29,15 +32,29 @@ func (c *cleaner) process(ctx context.Context, folder config.Folder, storages ma
 
 	defer func() {
 
-		// We remove archive file
+		// We remove archive file. Streamed archives have no file
 		archiveFile, ok := ctx.Value(ArchiveCtxKey).(string)
-		if !ok {
-			log.Printf("Unable to get archive file from context\n")
+		if ok {
+			err := os.Remove(archiveFile)
+			if err != nil {
+				log.Printf("Unable to remove archive file %s: %v\n", archiveFile, err)
+			}
 		}
 
-		err := os.Remove(archiveFile)
-		if err != nil {
-			log.Printf("Unable to remove archive file %s: %v\n", archiveFile, err)
+		// We remove encrypted archive files. Several storages can share the same file
+		storageArchives, _ := ctx.Value(StorageArchivesCtxKey).(map[string]string)
+		removed := map[string]bool{}
+		for _, encryptedFile := range storageArchives {
+
+			if removed[encryptedFile] {
+				continue
+			}
+			removed[encryptedFile] = true
+
+			err := os.Remove(encryptedFile)
+			if err != nil {
+				log.Printf("Unable to remove encrypted archive file %s: %v\n", encryptedFile, err)
+			}
 		}
 	}()
 
@@ -46,37 +63,79 @@ func (c *cleaner) process(ctx context.Context, folder config.Folder, storages ma
 		return
 	}
 
-	// We get the folder path
-	folderPath := folder.Path
-
-	// We clear the folder content without removing the folder itself
-	entries, err := os.ReadDir(folderPath)
-	if err != nil {
-		log.Printf("Unable to read folder 📁 %s: %v\n", folderPath, err)
+	// A stored archive which does not match the local one may not be restorable
+	if unverified, _ := ctx.Value(UnverifiedCtxKey).(bool); unverified {
+		log.Printf("Folder 📁 %s has not been removed, an uploaded archive failed its verification\n", folder.Name)
 		NotifyError(
 			ctx,
 			folder.Name,
-			fmt.Sprintf("Unable to read folder 📁 %s", folder.Name),
-			"",
-			err,
+			fmt.Sprintf("Folder 📁 %s has not been removed", folder.Name),
+			"An uploaded archive failed its verification",
+			errors.New("archive verification failed"),
 			notifiers,
 		)
 		return
 	}
 
-	for _, entry := range entries {
+	// The folder could not be restored from the storages which did not store its backup
+	if failed := getFailedStorages(ctx); len(failed) > 0 {
+
+		names := []string{}
+		for name := range failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
 
-		fullPath := filepath.Join(folderPath, entry.Name())
-		os.RemoveAll(fullPath) // No need to check errors here. It is a fault tolerant operation
+		log.Printf("Folder 📁 %s has not been removed, the backup failed on storages %s\n", folder.Name, strings.Join(names, ", "))
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Folder 📁 %s has not been removed", folder.Name),
+			fmt.Sprintf("The backup failed on storages %s", strings.Join(names, ", ")),
+			errors.New("backup not stored on every storage"),
+			notifiers,
+		)
+		return
 	}
 
-	log.Printf("Folder 📁 %s has been removed\n", folderPath)
+	// We clear the content of every path without removing the paths themselves
+	// The database dumps are not part of the folder, they are deleted at the end of the run
+	for _, src := range folder.Sources() {
+
+		if src.Unfiltered {
+			continue
+		}
+
+		entries, err := os.ReadDir(src.Path)
+		if err != nil {
+			log.Printf("Unable to read folder 📁 %s: %v\n", src.Path, err)
+			NotifyError(
+				ctx,
+				folder.Name,
+				fmt.Sprintf("Unable to read folder 📁 %s", folder.Name),
+				"",
+				err,
+				notifiers,
+			)
+			return
+		}
+
+		for _, entry := range entries {
+
+			fullPath := filepath.Join(src.Path, entry.Name())
+			os.RemoveAll(fullPath) // No need to check errors here. It is a fault tolerant operation
+		}
+
+		log.Printf("Folder 📁 %s has been removed\n", src.Path)
+	}
 
 	c.success(ctx, folder, notifiers)
 }
 
 func (c *cleaner) success(ctx context.Context, folder config.Folder, notifiers map[string]alerting.Provider) {
 
+	getHookRun(ctx).complete()
+
 	NotifySuccess(
 		ctx,
 		folder.Name,
@@ -0,0 +1,269 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/storing"
+)
+
+// DrillReport sums up a restore drill
+type DrillReport struct {
+	Storage    string
+	Key        string // Archive or snapshot restored
+	Compare    string // MANIFEST or LIVE
+	Files      int    // Files restored
+	Size       int64  // Bytes restored
+	Checked    int    // Files compared
+	Skipped    int    // Files modified or deleted since the backup, left out of the LIVE comparison
+	Restore    time.Duration
+	Comparison time.Duration
+}
+
+// details returns the report as notification details
+func (r DrillReport) details() string {
+	return fmt.Sprintf("Archive: %s\nStorage: %s\nCompared with: %s\nFiles: %d (%d bytes)\nChecked: %d\nSkipped: %d\nRestore: %s\nComparison: %s",
+		r.Key, r.Storage, r.Compare, r.Files, r.Size, r.Checked, r.Skipped, r.Restore.Round(time.Millisecond), r.Comparison.Round(time.Millisecond))
+}
+
+// Drill restores an archive of the given folder inside a temporary directory, compares the restored files
+// with the manifest of the archive or with the files of the folder, then deletes them.
+// The archive, the storage and the comparison are picked by the drill settings of the folder.
+// The result is notified along with the timings.
+func (e *Engine) Drill(folderName string) (DrillReport, error) {
+
+	folder, ok := e.getFolder(folderName)
+	if !ok {
+		return DrillReport{}, fmt.Errorf("folder %s is not valid or have not been declared", folderName)
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, ProcessTimeout)
+	defer cancel()
+
+	notifiers := e.getFolderNotifiers(folder)
+	report := DrillReport{Storage: folder.DrillStorage(), Compare: folder.DrillComparison()}
+
+	err := e.drill(ctx, folder, &report)
+	if err != nil {
+		log.Printf("Restore drill of folder %s failed: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Restore drill 🧯 of folder %s failed ❌", folder.Name),
+			report.details(),
+			err,
+			notifiers,
+		)
+		return report, err
+	}
+
+	NotifySuccess(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Restore drill 🧯 of folder %s passed ✅", folder.Name),
+		report.details(),
+		notifiers,
+	)
+
+	return report, nil
+}
+
+// drill runs the restore drill of the folder and fills the report
+func (e *Engine) drill(ctx context.Context, folder config.Folder, report *DrillReport) error {
+
+	stor, ok := e.storages[report.Storage]
+	if !ok {
+		return fmt.Errorf("storage %s is not valid or have not been declared", report.Storage)
+	}
+
+	archive, err := pickArchive(ctx, folder, stor, folder.Drill.PickMode())
+	if err != nil {
+		return fmt.Errorf("unable to pick the archive to restore: %w", err)
+	}
+	report.Key = archive.Key
+
+	sandbox, err := os.MkdirTemp("", "harpo-drill-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := os.RemoveAll(sandbox)
+		if err != nil {
+			log.Printf("Unable to remove drill directory %s: %v\n", sandbox, err)
+		}
+	}()
+
+	// The restore is not notified, only the drill result is
+	start := time.Now()
+	encryption := e.getEncryption(folder, report.Storage)
+	err = restore(ctx, folder, report.Storage, stor, encryption, archive.Key, sandbox, nil)
+	report.Restore = time.Since(start)
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	defer func() { report.Comparison = time.Since(start) }()
+
+	restored, err := restoredFiles(sandbox)
+	if err != nil {
+		return fmt.Errorf("unable to read the restored files: %w", err)
+	}
+	report.Files = len(restored)
+	for _, sum := range restored {
+		report.Size += sum.size
+	}
+
+	if report.Compare == config.LiveComparison {
+		report.Checked, report.Skipped, err = compareLive(folder, archive.Time, restored)
+		return err
+	}
+
+	manifest, err := loadManifest(ctx, stor, archive.Key)
+	if err == nil && manifest == nil {
+		err = fmt.Errorf("archive %s has no manifest", archive.Key)
+	}
+	if err == nil {
+		err = manifest.decryptFiles(filesEncryption(folder, encryption))
+	}
+	if err != nil {
+		return err
+	}
+	report.Checked, err = compareManifest(manifest, restored)
+
+	return err
+}
+
+// pickArchive returns the newest archive or snapshot of the folder stored on the storage, or a random one.
+// Latest aliases are never picked, their archive is.
+func pickArchive(ctx context.Context, folder config.Folder, stor storing.Provider, pick string) (Archive, error) {
+
+	archives, err := listArchives(ctx, folder, stor)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	candidates := []Archive{}
+	for _, a := range archives {
+		if !a.Latest {
+			candidates = append(candidates, a)
+		}
+	}
+	if len(candidates) == 0 {
+		return Archive{}, fmt.Errorf("no archive found")
+	}
+
+	if pick == config.RandomDrill {
+		return candidates[rand.Intn(len(candidates))], nil
+	}
+	return candidates[0], nil
+}
+
+// restoredFiles returns the size and the checksums of the regular files restored inside dir, by name inside the archive
+func restoredFiles(dir string) (map[string]fileSum, error) {
+
+	files := map[string]fileSum{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sum, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = sum
+
+		return nil
+	})
+
+	return files, err
+}
+
+// compareManifest compares the restored files with the manifest of the archive and returns the number of files compared.
+// Incremental and differential archives only describe their own files, so the files of their chain are not compared.
+func compareManifest(m *Manifest, restored map[string]fileSum) (int, error) {
+
+	errs := []error{}
+	described := map[string]bool{}
+	checked := 0
+	for _, f := range m.Files {
+
+		described[f.Path] = true
+		if f.SHA256 == "" {
+			continue
+		}
+
+		checked++
+		sum, ok := restored[f.Path]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("file %s has not been restored", f.Path))
+		case sum.size != f.Size || sum.sha256 != f.SHA256:
+			errs = append(errs, fmt.Errorf("file %s does not match the manifest", f.Path))
+		}
+	}
+
+	if !partialKind(m.Kind) {
+		for name := range restored {
+			if !described[name] {
+				errs = append(errs, fmt.Errorf("file %s is not in the manifest", name))
+			}
+		}
+	}
+
+	return checked, errors.Join(errs...)
+}
+
+// compareLive compares the restored files with the files of the folder and returns the number of files compared and skipped.
+// Files deleted or modified since the backup are skipped.
+func compareLive(folder config.Folder, backupTime time.Time, restored map[string]fileSum) (int, int, error) {
+
+	errs := []error{}
+	checked, skipped := 0, 0
+	targets := folder.OriginalTargets()
+	for name, sum := range restored {
+
+		livePath, err := archiving.ExtractPath(name, "", targets)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		info, err := os.Lstat(livePath)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && info.ModTime().After(backupTime)) {
+			skipped++
+			continue
+		}
+
+		var live fileSum
+		if err == nil {
+			live, err = hashFile(livePath)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("file %s: %w", name, err))
+			continue
+		}
+
+		checked++
+		if live.size != sum.size || live.sha256 != sum.sha256 {
+			errs = append(errs, fmt.Errorf("file %s does not match the folder", name))
+		}
+	}
+
+	return checked, skipped, errors.Join(errs...)
+}
@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/config"
+)
+
+func TestDrill(t *testing.T) {
+
+	for _, archiver := range []string{"ZIP", "TAR"} {
+
+		// Archive times are rounded to the second, files must be older to be compared with the live folder
+		e, folder := testEngine(t, archiver)
+		file := filepath.Join(folder.Path, "texts", "file1.txt")
+		old := time.Now().Add(-24 * time.Hour)
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatalf("%s: error changing file times: %s", archiver, err.Error())
+		}
+		folder.Drill = config.Drill{Schedule: "0 4 * * 6"}
+		e.folders[0] = folder
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+		// The restored files match the manifest and the sandbox is deleted
+		report, err := e.Drill(folder.Name)
+		if err != nil || report.Files != 1 || report.Checked != 1 || report.Compare != config.ManifestComparison || report.Storage != "local" {
+			t.Fatalf("%s: unexpected manifest drill: %+v %v", archiver, report, err)
+		}
+		if strings.HasPrefix(filepath.Base(report.Key), "dummies"+harpoExt) {
+			t.Fatalf("%s: latest alias picked: %s", archiver, report.Key)
+		}
+		if matches, _ := filepath.Glob(filepath.Join(os.TempDir(), "harpo-drill-*")); len(matches) != 0 {
+			t.Fatalf("%s: drill directories left: %v", archiver, matches)
+		}
+
+		// The live folder matches until a file changes without being backed up
+		folder.Drill.Compare = config.LiveComparison
+		folder.Drill.Pick = config.RandomDrill
+		e.folders[0] = folder
+		report, err = e.Drill(folder.Name)
+		if err != nil || report.Checked != 1 || report.Skipped != 0 {
+			t.Fatalf("%s: unexpected live drill: %+v %v", archiver, report, err)
+		}
+
+		if err := os.WriteFile(file, []byte("Hello Drill!"), os.ModePerm); err != nil {
+			t.Fatalf("%s: error writing file: %s", archiver, err.Error())
+		}
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatalf("%s: error changing file times: %s", archiver, err.Error())
+		}
+		_, err = e.Drill(folder.Name)
+		if err == nil || !strings.Contains(err.Error(), "does not match the folder") {
+			t.Fatalf("%s: changed file not detected: %v", archiver, err)
+		}
+
+		// Files modified since the backup are skipped
+		if err := os.Chtimes(file, time.Now(), time.Now()); err != nil {
+			t.Fatalf("%s: error changing file times: %s", archiver, err.Error())
+		}
+		report, err = e.Drill(folder.Name)
+		if err != nil || report.Checked != 0 || report.Skipped != 1 {
+			t.Fatalf("%s: unexpected drill of a modified file: %+v %v", archiver, report, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/dumping"
+)
+
+var (
+	dumpTimeout = time.Duration(60 * time.Minute) // TODO: Calculate the timeout based on the databases size
+)
+
+// dumpDatabases lists the databases of the folder and returns the folder streaming their dumps into the archive,
+// each one as <server kind>/<database><ext>. The dumps run while the archive is written, so they never touch the disk.
+func dumpDatabases(ctx context.Context, folder config.Folder, notifiers map[string]alerting.Provider) (config.Folder, error) {
+
+	dumps := folder.DatabaseDumps()
+	if len(dumps) == 0 {
+		return folder, nil
+	}
+
+	folder.DumpStreams = map[string]map[string]archiving.StreamFunc{}
+	for _, dump := range dumps {
+
+		streams, err := serverStreams(ctx, folder, dump, notifiers)
+		if err != nil {
+			return folder, dumpError(ctx, folder, fmt.Sprintf("Unable to dump %s databases of folder %s", dump.Name, folder.Name), err, notifiers)
+		}
+		folder.DumpStreams[dump.Name] = streams
+	}
+
+	return folder, nil
+}
+
+// serverStreams lists the databases of the server and returns the functions dumping them, by file name.
+// Each dump has its own timeout. Once every database of the server is dumped, the dumps are notified with their size.
+func serverStreams(ctx context.Context, folder config.Folder, dump config.DatabaseDump, notifiers map[string]alerting.Provider) (map[string]archiving.StreamFunc, error) {
+
+	p, err := dumping.GetProvider(dump.Entity, dump.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	lCtx, cancel := context.WithTimeout(ctx, dumpTimeout)
+	defer cancel()
+
+	databases, err := p.Databases(lCtx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list databases: %w", err)
+	}
+	if len(databases) == 0 {
+		return nil, fmt.Errorf("no database found")
+	}
+
+	server := &serverDump{sizes: map[string]int64{}, left: len(databases)}
+	streams := map[string]archiving.StreamFunc{}
+	for _, db := range databases {
+
+		db := db
+		streams[db+p.Ext()] = func(dst io.Writer) error {
+
+			dCtx, cancel := context.WithTimeout(ctx, dumpTimeout)
+			defer cancel()
+
+			w := &dumpWriter{dst: dst}
+			err := p.Dump(dCtx, db, w)
+
+			// The archive stopped reading the dump, its own failure is notified
+			if w.err != nil {
+				return w.err
+			}
+			if err != nil {
+				return dumpError(ctx, folder, fmt.Sprintf("Unable to dump %s database %s of folder %s", dump.Name, db, folder.Name), err, notifiers)
+			}
+
+			if details, ok := server.dumped(db, w.size); ok {
+				NotifyInfo(
+					ctx,
+					folder.Name,
+					fmt.Sprintf("Databases dumped 🛢️ ✅ from %s", dump.Name),
+					details,
+					notifiers,
+				)
+			}
+			return nil
+		}
+	}
+
+	return streams, nil
+}
+
+// serverDump records the size of the dumps of a server while they are archived
+type serverDump struct {
+	mu    sync.Mutex
+	sizes map[string]int64
+	left  int
+}
+
+// dumped records the dump of the database. Once all the databases are dumped, it returns their sizes.
+func (s *serverDump) dumped(database string, size int64) (string, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sizes[database]; !ok {
+		s.left--
+	}
+	s.sizes[database] = size
+	if s.left > 0 {
+		return "", false
+	}
+
+	databases := []string{}
+	for db := range s.sizes {
+		databases = append(databases, db)
+	}
+	sort.Strings(databases)
+
+	lines := []string{}
+	for _, db := range databases {
+		lines = append(lines, fmt.Sprintf("%s (%d bytes)", db, s.sizes[db]))
+	}
+
+	return fmt.Sprintf("Databases: %s", strings.Join(lines, ", ")), true
+}
+
+// dumpWriter counts the bytes of a dump written to the archive, and records the error of the archive
+type dumpWriter struct {
+	dst  io.Writer
+	size int64
+	err  error
+}
+
+func (w *dumpWriter) Write(p []byte) (int, error) {
+
+	n, err := w.dst.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+// restoreDumps restores the databases from the dumps extracted inside the directory of each server, named after their database
+func restoreDumps(ctx context.Context, folder config.Folder, dir string, notifiers map[string]alerting.Provider) error {
+
+	rCtx, cancel := context.WithTimeout(ctx, dumpTimeout)
+	defer cancel()
+
+	for _, dump := range folder.DatabaseDumps() {
+
+		p, err := dumping.GetProvider(dump.Entity, dump.Config)
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to get %s dump provider of folder %s", dump.Name, folder.Name), err, notifiers)
+		}
+
+		entries, err := os.ReadDir(filepath.Join(dir, dump.Name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to read %s dumps of folder %s", dump.Name, folder.Name), err, notifiers)
+		}
+
+		restored := []string{}
+		for _, entry := range entries {
+
+			if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), p.Ext()) {
+				continue
+			}
+
+			db := strings.TrimSuffix(entry.Name(), p.Ext())
+			err := restoreDatabase(rCtx, p, db, filepath.Join(dir, dump.Name, entry.Name()))
+			if err != nil {
+				return restoreError(ctx, folder, fmt.Sprintf("Unable to restore %s database %s of folder %s", dump.Name, db, folder.Name), err, notifiers)
+			}
+			restored = append(restored, db)
+		}
+
+		NotifyInfo(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Databases restored 🛢️ ✅ to %s", dump.Name),
+			fmt.Sprintf("Databases: %s", strings.Join(restored, ", ")),
+			notifiers,
+		)
+	}
+
+	return nil
+}
+
+// restoreDatabase restores the database from the dump file
+func restoreDatabase(ctx context.Context, p dumping.Provider, database, filePath string) error {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return p.Restore(ctx, database, file)
+}
+
+// dumpError logs and notifies a dump failure and returns it
+func dumpError(ctx context.Context, folder config.Folder, text string, err error, notifiers map[string]alerting.Provider) error {
+
+	log.Printf("%s: %v\n", text, err)
+	NotifyError(
+		ctx,
+		folder.Name,
+		text,
+		"",
+		err,
+		notifiers,
+	)
+
+	return fmt.Errorf("%s: %w", text, err)
+}
@@ -0,0 +1,120 @@
+//go:build unix
+
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Polo44444/harpo/config"
+)
+
+// fakeDatabases writes fake PostgreSQL and MySQL clients inside a directory and returns it along with the file recording the restores
+func fakeDatabases(t *testing.T) (string, string) {
+
+	bin := t.TempDir()
+	restored := filepath.Join(t.TempDir(), "restored")
+	for name, script := range map[string]string{
+		"pg_dump":    `for arg in "$@"; do [ "$prev" = "--dbname" ] && db="$arg"; prev="$arg"; done; [ "$db" = "broken" ] && exit 1; echo "dump of $db"`,
+		"pg_restore": `cat >> "` + restored + `"`,
+		"mysqldump":  `for arg in "$@"; do db="$arg"; done; echo "mysql dump of $db"`,
+		"mysql":      `cat >> "` + restored + `"`,
+	} {
+		err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755)
+		if err != nil {
+			t.Fatalf("Error creating fake %s: %s", name, err.Error())
+		}
+	}
+
+	return bin, restored
+}
+
+func TestDatabaseDumps(t *testing.T) {
+
+	e, folder := testEngine(t, "TAR")
+	bin, restored := fakeDatabases(t)
+
+	// The dumps are archived with the paths, whatever the filter
+	folder.Postgres = config.Postgres{Host: "db.local", Databases: []string{"app", "shop"}, BinDir: bin}
+	folder.MySQL = config.MySQL{Host: "db.local", Databases: []string{"crm"}, BinDir: bin}
+	folder.Exclude = []string{"*.dump"}
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+	target := filepath.Join(t.TempDir(), "restored")
+	err := e.Restore(folder.Name, "local", "", target)
+	if err != nil {
+		t.Fatalf("Error restoring archive: %s", err.Error())
+	}
+	for name, content := range map[string]string{
+		"postgres/app.dump":   "dump of app\n",
+		"postgres/shop.dump":  "dump of shop\n",
+		"mysql/crm.sql":       "mysql dump of crm\n",
+		"src/texts/file1.txt": "Hello World!",
+	} {
+		data, err := os.ReadFile(filepath.Join(target, name))
+		if err != nil || string(data) != content {
+			t.Fatalf("Unexpected restored file %s: %q %v", name, string(data), err)
+		}
+	}
+
+	// Restored in place, the databases are restored from their dumps
+	err = e.Restore(folder.Name, "local", "", "")
+	if err != nil {
+		t.Fatalf("Error restoring in place: %s", err.Error())
+	}
+	data, err := os.ReadFile(restored)
+	if err != nil || string(data) != "dump of app\ndump of shop\nmysql dump of crm\n" {
+		t.Fatalf("Unexpected databases restored: %q %v", string(data), err)
+	}
+
+	// A failing dump aborts the backup
+	folder.Postgres.Databases = []string{"app", "broken"}
+	folder.Destination = "backup/broken"
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+	archives, err := e.List(folder.Name, "local")
+	if err != nil || len(archives) != 0 {
+		t.Fatalf("Backup not aborted: %d archives %v", len(archives), err)
+	}
+}
+
+func TestStreamedDatabaseDumps(t *testing.T) {
+
+	e, folder := testEngine(t, "ZIP")
+	bin, _ := fakeDatabases(t)
+
+	// The dumps are piped from the dump programs into the archive uploaded while it is written
+	folder.Streaming = true
+	folder.Postgres = config.Postgres{Host: "db.local", Databases: []string{"app"}, BinDir: bin}
+	folder.MySQL = config.MySQL{Host: "db.local", Databases: []string{"crm"}, BinDir: bin}
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+	target := filepath.Join(t.TempDir(), "restored")
+	err := e.Restore(folder.Name, "local", "", target)
+	if err != nil {
+		t.Fatalf("Error restoring archive: %s", err.Error())
+	}
+	for name, content := range map[string]string{
+		"postgres/app.dump":   "dump of app\n",
+		"mysql/crm.sql":       "mysql dump of crm\n",
+		"src/texts/file1.txt": "Hello World!",
+	} {
+		data, err := os.ReadFile(filepath.Join(target, name))
+		if err != nil || string(data) != content {
+			t.Fatalf("Unexpected restored file %s: %q %v", name, string(data), err)
+		}
+	}
+
+	// A failing dump aborts the streamed backup
+	folder.Postgres.Databases = []string{"app", "broken"}
+	folder.Destination = "backup/broken"
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+	archives, err := e.List(folder.Name, "local")
+	if err != nil || len(archives) != 0 {
+		t.Fatalf("Streamed backup not aborted: %d archives %v", len(archives), err)
+	}
+}
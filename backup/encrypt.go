@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/encrypting"
+	"github.com/Polo44444/harpo/storing"
+	"github.com/google/uuid"
+)
+
+var (
+	encryptTimeout = time.Duration(30 * time.Minute) // TODO: Calculate the timeout based on the archive size
+)
+
+type encrypter struct {
+	next        processor
+	encryptions map[string]config.Encryption // Encryption of the storages overriding the folder one
+}
+
+func NewEncrypter(encryptions map[string]config.Encryption) *encrypter {
+	return &encrypter{encryptions: encryptions}
+}
+
+func (e *encrypter) setNext(p processor) processor {
+	e.next = p
+	return p
+}
+
+// encrypt encrypts the archive file for the given encryption and returns the encrypted file name
+func (e *encrypter) encrypt(ctx context.Context, archiveFile string, encryption config.Encryption) (string, error) {
+
+	recipients, err := encrypting.NewRecipients(encryption.Recipients, encryption.Passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(archiveFile)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	fileName := uuid.Must(uuid.NewRandom()).String() + archiveExt(archiveFile) + encrypting.Ext
+	dst, err := os.Create(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	eCtx, cancel := context.WithTimeout(ctx, encryptTimeout) // TODO: Calculate the timeout based on the archive size
+	defer cancel()
+	err = encrypting.Encrypt(eCtx, src, dst, recipients...)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fileName) // No need to check errors here. The file is incomplete anyway
+		return "", err
+	}
+
+	return fileName, nil
+}
+
+func (e *encrypter) process(ctx context.Context, folder config.Folder, storages map[string]storing.Provider, notifiers map[string]alerting.Provider) {
+
+	archiveFile, ok := ctx.Value(ArchiveCtxKey).(string)
+	if !ok {
+		log.Printf("Unable to get archive file from context\n")
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to get archive file of folder 📁 %s from context", folder.Name),
+			"",
+			nil,
+			notifiers,
+		)
+		return
+	}
+
+	// We group the storages by encryption, so the archive is encrypted once per encryption
+	groups := map[string][]string{}
+	encryptions := map[string]config.Encryption{}
+	for name := range storages {
+
+		encryption := folder.Encryption.Merge(e.encryptions[name])
+		if !encryption.Enabled() {
+			continue
+		}
+
+		groups[encryption.Key()] = append(groups[encryption.Key()], name)
+		encryptions[encryption.Key()] = encryption
+	}
+
+	storageArchives := map[string]string{}
+	nextStorages := map[string]storing.Provider{}
+	for name, storage := range storages {
+		nextStorages[name] = storage
+	}
+
+	for key, names := range groups {
+
+		sort.Strings(names)
+		fileName, err := e.encrypt(ctx, archiveFile, encryptions[key])
+		if err != nil {
+
+			// The plain archive must not be uploaded instead, so these storages are skipped
+			log.Printf("Unable to encrypt archive of folder %s: %v\n", folder.Path, err)
+			NotifyError(
+				ctx,
+				folder.Name,
+				fmt.Sprintf("Unable to encrypt archive of folder 📁 %s for storages %s", folder.Name, strings.Join(names, ", ")),
+				"",
+				err,
+				notifiers,
+			)
+			for _, name := range names {
+				delete(nextStorages, name)
+			}
+			continue
+		}
+
+		for _, name := range names {
+			storageArchives[name] = fileName
+		}
+
+		NotifyInfo(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Archive of folder 📁 %s encrypted 🔐 ✅ for storages %s", folder.Name, strings.Join(names, ", ")),
+			"",
+			notifiers,
+		)
+	}
+
+	newCtx := context.WithValue(ctx, StorageArchivesCtxKey, storageArchives)
+
+	if e.next != nil {
+		e.next.process(newCtx, folder, nextStorages, notifiers)
+	}
+}
@@ -0,0 +1,276 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/config"
+)
+
+// hookOutputSize is the size of the end of the output of the hooks kept for the notifications
+const hookOutputSize = 4096
+
+// Status of the run given to the hooks
+const (
+	hookSuccess = "success"
+	hookFailure = "failure"
+)
+
+// errArchiveNotReached is given to the post_archive hooks when the chain stopped before the end of its archive step
+var errArchiveNotReached = errors.New("archive step not reached")
+
+// hookRun runs the hooks of a run of a folder and tracks whether the run failed
+type hookRun struct {
+	folder      config.Folder
+	notifiers   map[string]alerting.Provider
+	postArchive sync.Once
+
+	mu        sync.Mutex
+	err       error // First error notified during the run
+	archived  bool  // True once the post_archive hooks ran
+	completed bool  // True once the whole chain ran
+}
+
+func newHookRun(folder config.Folder, notifiers map[string]alerting.Provider) *hookRun {
+	return &hookRun{folder: folder, notifiers: notifiers}
+}
+
+// getHookRun returns the hooks run of the context. It is nil outside of a backup run.
+func getHookRun(ctx context.Context) *hookRun {
+	r, _ := ctx.Value(HooksCtxKey).(*hookRun)
+	return r
+}
+
+// fail records the error notified during the run. Only the first one is given to the hooks.
+func (r *hookRun) fail(text string, err error) {
+
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", text, err)
+	} else {
+		r.err = errors.New(text)
+	}
+}
+
+// complete marks the run as gone through the whole chain
+func (r *hookRun) complete() {
+
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.completed = true
+}
+
+// status returns the status and the first error of the run so far
+func (r *hookRun) status() (string, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return hookFailure, r.err
+	}
+
+	return hookSuccess, nil
+}
+
+// preArchive runs the pre_archive hooks. Their failure aborts the run.
+func (r *hookRun) preArchive(ctx context.Context) error {
+	return r.run(ctx, config.PreArchiveHook, r.folder.Hooks.PreArchive, "", "", nil)
+}
+
+// afterArchive runs the post_archive hooks once the archive step ended, whatever its result.
+// Only the first call runs them, so the services stopped before the archive are always restarted.
+func (r *hookRun) afterArchive(ctx context.Context, archiveFile string, err error) {
+
+	if r == nil {
+		return
+	}
+
+	r.postArchive.Do(func() {
+
+		r.mu.Lock()
+		r.archived = true
+		r.mu.Unlock()
+
+		status := hookSuccess
+		if err != nil {
+			status = hookFailure
+		}
+		r.run(ctx, config.PostArchiveHook, r.folder.Hooks.PostArchive, archiveFile, status, err)
+	})
+}
+
+// afterUpload runs the post_upload hooks once the archive is stored on the storages
+func (r *hookRun) afterUpload(ctx context.Context, archiveFile string) {
+
+	if r == nil {
+		return
+	}
+
+	status, err := r.status()
+	r.run(ctx, config.PostUploadHook, r.folder.Hooks.PostUpload, archiveFile, status, err)
+}
+
+// finish ends the run. When the chain stopped before the end of its archive step, the post_archive hooks run
+// with the archive step not reached. Then the on_success or the on_failure hooks run.
+// A run which did not go through the whole chain failed.
+func (r *hookRun) finish(ctx context.Context) {
+
+	r.mu.Lock()
+	archived := r.archived
+	r.mu.Unlock()
+	if !archived {
+		r.afterArchive(ctx, "", errArchiveNotReached)
+	}
+
+	r.mu.Lock()
+	if !r.completed && r.err == nil {
+		r.err = errors.New("backup did not complete")
+	}
+	r.mu.Unlock()
+
+	status, err := r.status()
+	if status == hookSuccess {
+		r.run(ctx, config.OnSuccessHook, r.folder.Hooks.OnSuccess, "", status, nil)
+	} else {
+		r.run(ctx, config.OnFailureHook, r.folder.Hooks.OnFailure, "", status, err)
+	}
+}
+
+// run runs the hooks of the step one after the other and stops at the first failure.
+// The output of each hook is notified along with its result.
+func (r *hookRun) run(ctx context.Context, step string, hooks []config.Hook, archiveFile, status string, runErr error) error {
+
+	for _, hook := range hooks {
+
+		env := hookEnv(ctx, r.folder, step, archiveFile, status, runErr)
+		output, err := runHook(ctx, hook, env)
+		details := fmt.Sprintf("Command: %s", hook.Command)
+		if output != "" {
+			details += fmt.Sprintf("\nOutput:\n%s", output)
+		}
+
+		if err != nil {
+			log.Printf("Hook %s of folder %s failed: %v\n", step, r.folder.Name, err)
+			NotifyError(
+				ctx,
+				r.folder.Name,
+				fmt.Sprintf("Hook %s of folder %s failed ❌", step, r.folder.Name),
+				details,
+				err,
+				r.notifiers,
+			)
+			return err
+		}
+
+		NotifyInfo(
+			ctx,
+			r.folder.Name,
+			fmt.Sprintf("Hook %s of folder %s succeeded 🪝 ✅", step, r.folder.Name),
+			details,
+			r.notifiers,
+		)
+	}
+
+	return nil
+}
+
+// hookEnv returns the environment variables describing the run to the hooks
+func hookEnv(ctx context.Context, folder config.Folder, step, archiveFile, status string, err error) []string {
+
+	runID, _ := ctx.Value(RunIDCtxKey).(string)
+	paths := []string{}
+	for _, src := range folder.Sources() {
+		paths = append(paths, src.Path)
+	}
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+
+	return []string{
+		"HARPO_FOLDER=" + folder.Name,
+		"HARPO_RUN_ID=" + runID,
+		"HARPO_HOOK=" + step,
+		"HARPO_PATHS=" + strings.Join(paths, string(os.PathListSeparator)),
+		"HARPO_ARCHIVE=" + archiveFile,
+		"HARPO_STATUS=" + status,
+		"HARPO_ERROR=" + errText,
+	}
+}
+
+// runHook runs the command of the hook with the environment added to the process one and returns the end of its output
+func runHook(ctx context.Context, hook config.Hook, env []string) (string, error) {
+
+	hCtx, cancel := context.WithTimeout(ctx, hook.TimeoutDuration())
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(hCtx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(hCtx, "sh", "-c", hook.Command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	// The children of the shell are killed along with it. Those out of its group may still hold the output open.
+	killProcessGroup(cmd)
+	cmd.WaitDelay = 5 * time.Second
+
+	output := &tailWriter{max: hookOutputSize}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Run()
+	if hCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", hook.TimeoutDuration())
+	}
+
+	return strings.TrimSpace(output.String()), err
+}
+
+// tailWriter keeps the last max bytes written
+type tailWriter struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append([]byte{}, t.buf[len(t.buf)-t.max:]...)
+		t.truncated = true
+	}
+
+	return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+
+	if t.truncated {
+		return "..." + string(t.buf)
+	}
+	return string(t.buf)
+}
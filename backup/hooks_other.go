@@ -0,0 +1,8 @@
+//go:build !unix
+
+package backup
+
+import "os/exec"
+
+// killProcessGroup does nothing, only the command is killed on timeout on this platform
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,136 @@
+//go:build unix
+
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/config"
+)
+
+// recordingNotifier keeps the messages sent
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []alerting.Message
+}
+
+func (r *recordingNotifier) Send(ctx context.Context, m *alerting.Message) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, *m)
+
+	return nil
+}
+
+func (r *recordingNotifier) Close(ctx context.Context) error {
+	return nil
+}
+
+// sent returns whether a message whose subject and details contain the texts has been sent
+func (r *recordingNotifier) sent(subject, details string) bool {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.messages {
+		if strings.Contains(m.Subject, subject) && strings.Contains(m.Details, details) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestHooks(t *testing.T) {
+
+	e, folder := testEngine(t, "TAR")
+	notifier := &recordingNotifier{}
+	e.notifiers = map[string]alerting.Provider{"recorder": notifier}
+	folder.Notifiers = []string{"recorder"}
+
+	// Each hook appends its step and the status of the run
+	logFile := filepath.Join(t.TempDir(), "hooks.log")
+	record := config.Hook{Command: `echo "$HARPO_HOOK $HARPO_STATUS $HARPO_FOLDER" >> ` + logFile}
+	folder.Hooks = config.Hooks{
+		PreArchive:  []config.Hook{record, {Command: "echo flushing cache; echo warning >&2"}},
+		PostArchive: []config.Hook{record, {Command: `test -f "$HARPO_ARCHIVE"`}},
+		PostUpload:  []config.Hook{record},
+		OnSuccess:   []config.Hook{record},
+		OnFailure:   []config.Hook{record},
+	}
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+	WaitNotifications()
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Error reading hooks log: %s", err.Error())
+	}
+	want := "pre_archive  Dummies\npost_archive success Dummies\npost_upload success Dummies\non_success success Dummies\n"
+	if string(data) != want {
+		t.Fatalf("Unexpected hooks run:\n%s", string(data))
+	}
+
+	// The output of the hooks is attached to the notifications
+	if !notifier.sent("Hook pre_archive of folder Dummies succeeded", "Output:\nflushing cache\nwarning") {
+		t.Fatalf("Output of hook not notified")
+	}
+
+	// A failing pre_archive hook aborts the backup, the other hooks are told why
+	os.Remove(logFile)
+	folder.Hooks.PreArchive = []config.Hook{{Command: "echo container not found; exit 3"}, record}
+	folder.Hooks.OnFailure = []config.Hook{{Command: `echo "$HARPO_HOOK $HARPO_ERROR" >> ` + logFile}}
+	folder.Destination = "backup/aborted"
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+	WaitNotifications()
+
+	data, _ = os.ReadFile(logFile)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "post_archive failure Dummies" || !strings.HasPrefix(lines[1], "on_failure Hook pre_archive of folder Dummies failed") {
+		t.Fatalf("Unexpected hooks run after a failing pre_archive hook:\n%s", string(data))
+	}
+	archives, err := e.List(folder.Name, "local")
+	if err != nil || len(archives) != 0 {
+		t.Fatalf("Backup not aborted: %d archives %v", len(archives), err)
+	}
+	if !notifier.sent("Hook pre_archive of folder Dummies failed", "container not found") {
+		t.Fatalf("Output of failing hook not notified")
+	}
+
+	// A chain stopping before the end of its archive step runs the post_archive hooks when the run finishes
+	os.Remove(logFile)
+	folder.Hooks.PostArchive = []config.Hook{{Command: `echo "$HARPO_HOOK $HARPO_STATUS $HARPO_ERROR" >> ` + logFile}}
+	run := newHookRun(folder, map[string]alerting.Provider{})
+	run.finish(context.Background())
+
+	data, _ = os.ReadFile(logFile)
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "post_archive failure archive step not reached" || !strings.HasPrefix(lines[1], "on_failure backup did not complete") {
+		t.Fatalf("Unexpected hooks run after a chain stopped before its archive step:\n%s", string(data))
+	}
+
+	// Once the archive step ran them, the post_archive hooks do not run again
+	os.Remove(logFile)
+	run = newHookRun(folder, map[string]alerting.Provider{})
+	run.afterArchive(context.Background(), "", nil)
+	run.complete()
+	run.finish(context.Background())
+
+	data, _ = os.ReadFile(logFile)
+	if string(data) != "post_archive success \non_success success Dummies\n" {
+		t.Fatalf("Unexpected hooks run after the archive step:\n%s", string(data))
+	}
+
+	// Hooks are killed after their timeout
+	_, err = runHook(context.Background(), config.Hook{Command: "sleep 10", Timeout: "100ms"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Unexpected error of hook out of time: %v", err)
+	}
+}
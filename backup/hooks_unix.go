@@ -0,0 +1,17 @@
+//go:build unix
+
+package backup
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup makes the command run inside its own process group, killed as a whole on timeout
+func killProcessGroup(cmd *exec.Cmd) {
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,223 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/config"
+)
+
+// Kinds of archives. The incremental and differential archives are marked in their extension, e.g. ".harpo.incr.zip"
+const (
+	FullKind         = "full"
+	IncrementalKind  = "incremental"
+	DifferentialKind = "differential"
+)
+
+var kindExts = map[string]string{
+	IncrementalKind:  ".incr",
+	DifferentialKind: ".diff",
+}
+
+// kindExt returns the extension marking the kind of archive. Full archives have none.
+func kindExt(kind string) string {
+	return kindExts[kind]
+}
+
+// archiveKind returns the kind of archive from its extension
+func archiveKind(ext string) string {
+
+	for kind, kExt := range kindExts {
+		if strings.HasPrefix(ext, kExt+".") {
+			return kind
+		}
+	}
+
+	return FullKind
+}
+
+// partialKind returns whether the archives of the kind need their chain to be restored
+func partialKind(kind string) bool {
+	return kind == IncrementalKind || kind == DifferentialKind
+}
+
+// keyKind returns the kind of the archive stored under the key
+func keyKind(key string) string {
+
+	i := strings.LastIndex(key, harpoExt+".")
+	if i < 0 {
+		return FullKind
+	}
+
+	return archiveKind(key[i+len(harpoExt):])
+}
+
+// chainState is the local state of the backup chain of a folder
+type chainState struct {
+	Runs int              `json:"runs"` // Archives of the chain, full one included
+	Full *archiving.Index `json:"full"` // Files of the last full archive
+	Last *archiving.Index `json:"last"` // Files of the last archive
+}
+
+// pendingChain holds the state of the chain once the archive of the run is uploaded
+type pendingChain struct {
+	path  string
+	state chainState
+}
+
+// loadChainState reads the chain state of the folder. Without state, the zero state is returned.
+func loadChainState(folder config.Folder) (chainState, error) {
+
+	state := chainState{}
+	data, err := os.ReadFile(folder.Incremental.IndexPath(folder.Name))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// nextArchive returns the kind of the next archive of the folder and sets the indexes of its filter.
+// The returned chain must be saved once the archive is uploaded. It is nil when incremental backups are disabled.
+// A full archive starts a new chain every N runs, or when the state of the chain can not be read.
+func nextArchive(folder config.Folder, filter *archiving.Filter) (string, *pendingChain) {
+
+	if !folder.Incremental.Enabled() {
+		return FullKind, nil
+	}
+
+	index := archiving.NewIndex()
+	filter.Index = index
+	next := &pendingChain{
+		path:  folder.Incremental.IndexPath(folder.Name),
+		state: chainState{Runs: 1, Full: index, Last: index},
+	}
+
+	state, err := loadChainState(folder)
+	if err != nil {
+		log.Printf("Unable to read file index of folder %s, a full backup is done: %v\n", folder.Name, err)
+		return FullKind, next
+	}
+	if state.Full == nil || state.Last == nil || state.Runs <= 0 || state.Runs >= folder.Incremental.GetFullEvery() {
+		return FullKind, next
+	}
+
+	next.state = chainState{Runs: state.Runs + 1, Full: state.Full, Last: index}
+	if folder.Incremental.Differential() {
+		filter.Base = state.Full
+		return DifferentialKind, next
+	}
+
+	filter.Base = state.Last
+	return IncrementalKind, next
+}
+
+// save writes the chain state, replacing the previous one at once
+func (c *pendingChain) save() error {
+
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+// commitChain saves the chain state of the run held by the context, after a successful upload
+func commitChain(ctx context.Context, folder config.Folder, notifiers map[string]alerting.Provider) {
+
+	chain, ok := ctx.Value(ChainCtxKey).(*pendingChain)
+	if !ok || chain == nil {
+		return
+	}
+
+	err := chain.save()
+	if err != nil {
+		log.Printf("Unable to save file index of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to save file index of folder 📁 %s, the next backup will archive the same changes", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+	}
+}
+
+// resetChain makes the next archive of the folder a full one, after an archive the context holds was not stored on every storage.
+// The storages holding it would replay it before the next archive, which is based on the previous one:
+// the files it added and the next archives deleted would not be recorded as deleted, and would come back on restore.
+func resetChain(ctx context.Context, folder config.Folder, notifiers map[string]alerting.Provider) {
+
+	chain, ok := ctx.Value(ChainCtxKey).(*pendingChain)
+	if !ok || chain == nil {
+		return
+	}
+
+	reset := &pendingChain{path: chain.path}
+	err := reset.save()
+	if err != nil {
+		log.Printf("Unable to reset file index of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to reset file index of folder 📁 %s, restores of the next archives may bring back deleted files", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+	}
+}
+
+// archiveChain returns the indexes of the archives needed to restore the archive at i, oldest first.
+// The archives are sorted newest first. An incremental archive needs all the previous ones up to the full one,
+// a differential archive only needs the full one. When the full archive is missing, the archives found are returned with an error.
+func archiveChain(archives []Archive, i int) ([]int, error) {
+
+	chain := []int{i}
+	skip := archives[i].Kind == DifferentialKind
+	var err error
+	for j := i + 1; partialKind(archives[chain[len(chain)-1]].Kind); j++ {
+
+		if j >= len(archives) {
+			err = fmt.Errorf("no full archive found before archive %s", archives[i].Key)
+			break
+		}
+
+		a := archives[j]
+		if a.Latest || (skip && partialKind(a.Kind)) {
+			continue
+		}
+		chain = append(chain, j)
+		skip = a.Kind == DifferentialKind
+	}
+
+	for l, r := 0, len(chain)-1; l < r; l, r = l+1, r-1 {
+		chain[l], chain[r] = chain[r], chain[l]
+	}
+
+	return chain, err
+}
@@ -0,0 +1,231 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/storing"
+	storing_local "github.com/Polo44444/harpo/storing/local"
+)
+
+func TestIncrementalBackupAndRestore(t *testing.T) {
+
+	for _, test := range []struct {
+		mode      string
+		streaming bool
+		kinds     []string
+	}{
+		{config.IncrementalMode, false, []string{FullKind, IncrementalKind, IncrementalKind, FullKind}},
+		{config.DifferentialMode, true, []string{FullKind, DifferentialKind, DifferentialKind, FullKind}},
+	} {
+
+		e, folder := testEngine(t, "ZIP")
+		folder.Streaming = test.streaming
+		folder.Incremental = config.Incremental{Mode: test.mode, FullEvery: 3, IndexDir: t.TempDir()}
+		e.folders[0] = folder
+
+		// Each run changes the folder before its backup
+		changes := []map[string]string{
+			{},
+			{"texts/file1.txt": "Hello World! changed", "file2.txt": "Hello World! 2"},
+			{"file2.txt": "", "new/file3.txt": "Hello World! 3"},
+			{},
+		}
+		for _, change := range changes {
+
+			for name, content := range change {
+
+				filePath := filepath.Join(folder.Path, name)
+				if content == "" {
+					os.Remove(filePath)
+					continue
+				}
+				os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+				if err := os.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
+					t.Fatalf("%s: error writing file: %s", test.mode, err.Error())
+				}
+			}
+			e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+		}
+
+		archives, err := e.List(folder.Name, "local")
+		if err != nil {
+			t.Fatalf("%s: error listing archives: %s", test.mode, err.Error())
+		}
+
+		// The latest alias only holds full archives
+		versioned := []Archive{}
+		for _, a := range archives {
+			if a.Latest {
+				if a.Kind != FullKind {
+					t.Fatalf("%s: latest alias is %s", test.mode, a.Kind)
+				}
+				continue
+			}
+			versioned = append(versioned, a)
+		}
+		if len(versioned) != len(test.kinds) {
+			t.Fatalf("%s: listed %d archives, want %d", test.mode, len(versioned), len(test.kinds))
+		}
+		for i, a := range versioned {
+			if kind := test.kinds[len(test.kinds)-1-i]; a.Kind != kind {
+				t.Fatalf("%s: archive %s is %s, want %s", test.mode, a.Key, a.Kind, kind)
+			}
+		}
+
+		// Each run is rebuilt from its chain
+		for run, files := range map[int]map[string]string{
+			2: {"texts/file1.txt": "Hello World! changed", "file2.txt": "Hello World! 2", "new/file3.txt": ""},
+			3: {"texts/file1.txt": "Hello World! changed", "file2.txt": "", "new/file3.txt": "Hello World! 3"},
+		} {
+
+			target := filepath.Join(t.TempDir(), "restored")
+			err = e.Restore(folder.Name, "local", versioned[len(versioned)-run].Key, target)
+			if err != nil {
+				t.Fatalf("%s: error restoring run %d: %s", test.mode, run, err.Error())
+			}
+
+			for name, content := range files {
+
+				data, err := os.ReadFile(filepath.Join(target, "src", name))
+				if content == "" && !os.IsNotExist(err) {
+					t.Fatalf("%s: deleted file %s restored in run %d", test.mode, name, run)
+				}
+				if content != "" && (err != nil || string(data) != content) {
+					t.Fatalf("%s: file %s of run %d restored as %q: %v", test.mode, name, run, string(data), err)
+				}
+			}
+		}
+
+		// Without key, the newest archive is restored
+		target := filepath.Join(t.TempDir(), "restored")
+		err = e.Restore(folder.Name, "local", "", target)
+		if err != nil {
+			t.Fatalf("%s: error restoring newest archive: %s", test.mode, err.Error())
+		}
+		if _, err := os.Stat(filepath.Join(target, "src", "new", "file3.txt")); err != nil {
+			t.Fatalf("%s: newest archive not restored: %v", test.mode, err)
+		}
+	}
+}
+
+func TestArchiveChain(t *testing.T) {
+
+	// Newest first
+	archives := []Archive{
+		{Key: "latest", Kind: FullKind, Latest: true},
+		{Key: "i3", Kind: IncrementalKind},
+		{Key: "d2", Kind: DifferentialKind},
+		{Key: "i1", Kind: IncrementalKind},
+		{Key: "f", Kind: FullKind},
+		{Key: "i0", Kind: IncrementalKind},
+	}
+
+	chainKeys := func(i int) string {
+		chain, err := archiveChain(archives, i)
+		keys := []string{}
+		for _, j := range chain {
+			keys = append(keys, archives[j].Key)
+		}
+		if err != nil {
+			keys = append(keys, "error")
+		}
+		return strings.Join(keys, ",")
+	}
+
+	for i, expected := range map[int]string{1: "f,d2,i3", 2: "f,d2", 3: "f,i1", 4: "f", 5: "i0,error"} {
+		if keys := chainKeys(i); keys != expected {
+			t.Fatalf("Chain of %s is %s, want %s", archives[i].Key, keys, expected)
+		}
+	}
+
+	// Retention keeps the chains of the kept archives
+	keep, _ := applyRetention(archives, config.Retention{KeepLast: 1})
+	keys := []string{}
+	for _, a := range keep {
+		keys = append(keys, a.Key)
+	}
+	if strings.Join(keys, ",") != "latest,i3,d2,f" {
+		t.Fatalf("Kept archives %v", keys)
+	}
+}
+
+// flakyProvider fails the uploads while failing is set
+type flakyProvider struct {
+	storing.Provider
+	failing bool
+}
+
+func (f *flakyProvider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
+
+	if f.failing {
+		io.CopyN(io.Discard, data, 64)
+		return errors.New("connection reset")
+	}
+	return f.Provider.UploadWithReader(ctx, filePath, data, contentType)
+}
+
+func TestIncrementalStorageFailure(t *testing.T) {
+
+	for _, streaming := range []bool{false, true} {
+
+		e, folder := testEngine(t, "ZIP")
+		other, err := storing.GetProvider(storing.LocalProvider, storing_local.BuildLocalConfig(t.TempDir(), false, 0600, 0700))
+		if err != nil {
+			t.Fatalf("Error creating local provider: %s", err.Error())
+		}
+		flaky := &flakyProvider{Provider: other}
+		e.storages["flaky"] = flaky
+		folder.Storages = []string{"local", "flaky"}
+		folder.Streaming = streaming
+		folder.Incremental = config.Incremental{Mode: config.IncrementalMode, FullEvery: 5, IndexDir: t.TempDir()}
+		e.folders[0] = folder
+		added := filepath.Join(folder.Path, "file2.txt")
+
+		// A file is added by an archive which fails on one storage, then deleted
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+		os.WriteFile(added, []byte("Hello World! 2"), os.ModePerm)
+		flaky.failing = true
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+		flaky.failing = false
+		os.Remove(added)
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+		// The archive following the one stored on some storages only starts a new chain
+		archives, err := e.List(folder.Name, "local")
+		if err != nil {
+			t.Fatalf("streaming %v: error listing archives: %s", streaming, err.Error())
+		}
+		kinds := []string{}
+		for _, a := range archives {
+			if !a.Latest {
+				kinds = append(kinds, a.Kind)
+			}
+		}
+		if strings.Join(kinds, ",") != "full,incremental,full" {
+			t.Fatalf("streaming %v: unexpected archives %v", streaming, kinds)
+		}
+
+		// The deleted file does not come back
+		for _, storage := range []string{"local", "flaky"} {
+
+			target := filepath.Join(t.TempDir(), "restored")
+			err = e.Restore(folder.Name, storage, "", target)
+			if err != nil {
+				t.Fatalf("streaming %v, %s: error restoring: %s", streaming, storage, err.Error())
+			}
+			if _, err := os.Stat(filepath.Join(target, "src", "file2.txt")); !os.IsNotExist(err) {
+				t.Fatalf("streaming %v, %s: deleted file restored: %v", streaming, storage, err)
+			}
+			if _, err := os.Stat(filepath.Join(target, "src", "texts", "file1.txt")); err != nil {
+				t.Fatalf("streaming %v, %s: file not restored: %s", streaming, storage, err.Error())
+			}
+		}
+	}
+}
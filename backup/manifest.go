@@ -0,0 +1,315 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/encrypting"
+	"github.com/Polo44444/harpo/models"
+	"github.com/Polo44444/harpo/storing"
+)
+
+// manifestExt is the extension of the manifest stored next to each archive, e.g. ".harpo.zip.manifest.json"
+const manifestExt = ".manifest.json"
+
+const manifestVersion = 1
+
+// ManifestFile describes a file written to an archive
+type ManifestFile struct {
+	Path    string      `json:"path"` // Name inside the archive
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	SHA256  string      `json:"sha256,omitempty"` // Empty for links and special files
+}
+
+// Manifest describes what went into an archive and the archive itself. It is stored next to the archive on each storage.
+// The files of encrypted archives are encrypted like the archive, the rest of the manifest stays readable.
+// The files of password protected 7z archives are encrypted with the password, as the archives hide their names.
+type Manifest struct {
+	Version        int            `json:"version"`
+	Folder         string         `json:"folder"`
+	RunID          string         `json:"run_id"`
+	Host           string         `json:"host"`
+	StartTime      time.Time      `json:"start_time"`
+	EndTime        time.Time      `json:"end_time"`
+	Kind           string         `json:"kind"`
+	Archiver       string         `json:"archiver"`
+	Compression    string         `json:"compression"`
+	Size           int64          `json:"size"` // Total size of the files
+	FileCount      int            `json:"file_count"`
+	Files          []ManifestFile `json:"files,omitempty"`
+	EncryptedFiles []byte         `json:"encrypted_files,omitempty"` // Files of encrypted archives, encrypted with age
+	ArchiveSize    int64          `json:"archive_size"`
+	ArchiveSHA256  string         `json:"archive_sha256"` // SHA-256 of the stored archive, encrypted or not
+}
+
+// newManifest returns the manifest of the files written to the archive of the run. The archive itself is described per storage.
+func newManifest(ctx context.Context, folder config.Folder, kind string, files *archiving.Index) *Manifest {
+
+	m := &Manifest{
+		Version:     manifestVersion,
+		Folder:      folder.Name,
+		EndTime:     time.Now(),
+		Kind:        kind,
+		Archiver:    strings.ToUpper(folder.Archiver),
+		Compression: folder.CompressionAlgorithm(),
+		Files:       []ManifestFile{},
+	}
+	m.RunID, _ = ctx.Value(RunIDCtxKey).(string)
+	m.StartTime, _ = ctx.Value(StartTimeCtxKey).(time.Time)
+	m.Host, _ = os.Hostname()
+
+	for name, entry := range files.Files {
+
+		if entry.Dir {
+			continue
+		}
+		m.Files = append(m.Files, ManifestFile{
+			Path:    name,
+			Size:    entry.Size,
+			Mode:    entry.Mode,
+			ModTime: entry.ModTime,
+			SHA256:  entry.Hash,
+		})
+		m.Size += entry.Size
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	m.FileCount = len(m.Files)
+
+	return m
+}
+
+// manifestKey returns the key of the manifest of the archive stored under archiveKey
+func manifestKey(archiveKey string) string {
+	return archiveKey + manifestExt
+}
+
+// isManifestKey returns whether the key is the key of a manifest
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, manifestExt)
+}
+
+// hashWriter computes the SHA-256, the MD5 and the size of the data written.
+// The MD5 is only compared with the checksums known by some storages.
+type hashWriter struct {
+	hash hash.Hash
+	md5  hash.Hash
+	size int64
+}
+
+func newHashWriter() *hashWriter {
+	return &hashWriter{hash: sha256.New(), md5: md5.New()}
+}
+
+func (h *hashWriter) Write(p []byte) (int, error) {
+
+	h.hash.Write(p)
+	h.md5.Write(p)
+	h.size += int64(len(p))
+
+	return len(p), nil
+}
+
+// sum returns the hex SHA-256 of the data written
+func (h *hashWriter) sum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// fileSum returns the size and the checksums of the data written
+func (h *hashWriter) fileSum() fileSum {
+	return fileSum{size: h.size, sha256: h.sum(), md5: hex.EncodeToString(h.md5.Sum(nil))}
+}
+
+// fileSum is the size and the checksums of a file
+type fileSum struct {
+	size   int64
+	sha256 string
+	md5    string
+}
+
+// hashFile returns the size and the checksums of the file
+func hashFile(filePath string) (fileSum, error) {
+
+	h := newHashWriter()
+	_, err := archiving.HashFile(filePath, h)
+	if err != nil {
+		return fileSum{}, err
+	}
+
+	return h.fileSum(), nil
+}
+
+// filesEncryption returns the encryption of the files of the manifest of an archive encrypted with encryption.
+// Without encryption, the password of the 7z archives is used so the manifest does not reveal what they hide.
+func filesEncryption(folder config.Folder, encryption config.Encryption) config.Encryption {
+
+	if encryption.Enabled() || folder.Compression.Password == "" {
+		return encryption
+	}
+
+	return config.Encryption{Passphrase: folder.Compression.Password}
+}
+
+// forArchive returns the manifest of the stored archive, its files encrypted like the archive
+func (m *Manifest) forArchive(sum fileSum, encryption config.Encryption) (*Manifest, error) {
+
+	stored := *m
+	stored.ArchiveSize = sum.size
+	stored.ArchiveSHA256 = sum.sha256
+	if !encryption.Enabled() {
+		return &stored, nil
+	}
+
+	recipients, err := encrypting.NewRecipients(encryption.Recipients, encryption.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(m.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	err = encrypting.Encrypt(context.Background(), bytes.NewReader(data), buf, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt manifest files: %w", err)
+	}
+	stored.Files = nil
+	stored.EncryptedFiles = buf.Bytes()
+
+	return &stored, nil
+}
+
+// decryptFiles decrypts the files of the manifest of an encrypted archive
+func (m *Manifest) decryptFiles(encryption config.Encryption) error {
+
+	if len(m.EncryptedFiles) == 0 {
+		return nil
+	}
+
+	identities, err := encrypting.NewIdentities(encryption.Identities, encryption.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	err = encrypting.Decrypt(context.Background(), bytes.NewReader(m.EncryptedFiles), buf, identities...)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt manifest files: %w", err)
+	}
+
+	err = json.Unmarshal(buf.Bytes(), &m.Files)
+	if err != nil {
+		return err
+	}
+	m.EncryptedFiles = nil
+
+	return nil
+}
+
+// uploadManifest uploads the manifest next to each of the archive keys
+func uploadManifest(ctx context.Context, stor storing.Provider, m *Manifest, archiveKeys ...string) error {
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range archiveKeys {
+
+		err = stor.UploadWithReader(ctx, manifestKey(key), bytes.NewReader(data), "application/json")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadManifest downloads the manifest of the archive stored under archiveKey. It is nil when the archive has none.
+func loadManifest(ctx context.Context, stor storing.Provider, archiveKey string) (*Manifest, error) {
+
+	key := manifestKey(archiveKey)
+	found := false
+	err := stor.List(ctx, key, func(page []models.FileInfo) error {
+		for _, file := range page {
+			found = found || file.Key == key
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+
+	buf := &bytes.Buffer{}
+	err = stor.DownloadWithWriter(ctx, key, buf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download manifest %s: %w", key, err)
+	}
+
+	m := &Manifest{}
+	err = json.Unmarshal(buf.Bytes(), m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest %s: %w", key, err)
+	}
+
+	return m, nil
+}
+
+// checkArchive checks the size and the SHA-256 of the archive against its manifest
+func (m *Manifest) checkArchive(size int64, sum string) error {
+
+	if m.ArchiveSize != size {
+		return fmt.Errorf("archive size is %d bytes, manifest expects %d bytes", size, m.ArchiveSize)
+	}
+	if m.ArchiveSHA256 != sum {
+		return fmt.Errorf("archive SHA-256 is %s, manifest expects %s", sum, m.ArchiveSHA256)
+	}
+
+	return nil
+}
+
+// checkFiles checks the files extracted inside dst against the SHA-256 of the manifest.
+// Files are resolved the same way as the extracted ones. All the mismatches are returned together.
+func (m *Manifest) checkFiles(dst string, targets map[string]string) error {
+
+	errs := []error{}
+	for _, f := range m.Files {
+
+		if f.SHA256 == "" {
+			continue
+		}
+
+		filePath, err := archiving.ExtractPath(f.Path, dst, targets)
+		if err == nil {
+			var sum fileSum
+			sum, err = hashFile(filePath)
+			if err == nil && sum.sha256 != f.SHA256 {
+				err = errors.New("content does not match the manifest")
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("file %s: %w", f.Path, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/Polo44444/harpo/config"
+)
+
+func TestManifest(t *testing.T) {
+
+	sum := sha256.Sum256([]byte("Hello World!"))
+	fileSHA256 := hex.EncodeToString(sum[:])
+
+	for _, test := range []struct {
+		name       string
+		streaming  bool
+		encryption config.Encryption
+	}{
+		{"plain", false, config.Encryption{}},
+		{"streaming", true, config.Encryption{}},
+		{"encrypted", false, config.Encryption{Passphrase: "my-passphrase"}},
+	} {
+
+		e, folder := testEngine(t, "ZIP")
+		folder.Streaming = test.streaming
+		folder.Encryption = test.encryption
+		e.folders[0] = folder
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+		// The versioned archive and the latest alias have their manifest
+		archives, err := e.List(folder.Name, "local")
+		if err != nil || len(archives) != 2 {
+			t.Fatalf("%s: unexpected archives: %+v %v", test.name, archives, err)
+		}
+		for _, a := range archives {
+			if a.Manifest != manifestKey(a.Key) {
+				t.Fatalf("%s: archive %s has no manifest", test.name, a.Key)
+			}
+		}
+
+		// The files of encrypted archives are only readable with the encryption
+		stor := e.storages["local"]
+		stored, err := loadManifest(context.Background(), stor, archives[1].Key)
+		if err != nil || stored == nil || test.encryption.Enabled() != (len(stored.Files) == 0 && len(stored.EncryptedFiles) > 0) {
+			t.Fatalf("%s: unexpected stored manifest: %+v %v", test.name, stored, err)
+		}
+
+		m, err := e.Manifest(folder.Name, "local", "")
+		if err != nil {
+			t.Fatalf("%s: error reading manifest: %s", test.name, err.Error())
+		}
+		if m.Folder != folder.Name || m.Kind != FullKind || m.Archiver != "ZIP" || m.Compression != config.DeflateAlgorithm ||
+			m.FileCount != 1 || m.Size != 12 || m.Files[0].Path != "src/texts/file1.txt" || m.Files[0].SHA256 != fileSHA256 {
+			t.Fatalf("%s: unexpected manifest: %+v", test.name, m)
+		}
+
+		_, err = e.Verify(folder.Name, "local", "")
+		if err != nil {
+			t.Fatalf("%s: error verifying archive: %s", test.name, err.Error())
+		}
+
+		// A corrupted archive is neither verified nor restored
+		key := archives[0].Key
+		if archives[0].Latest {
+			key = archives[1].Key
+		}
+		err = stor.UploadWithReader(context.Background(), key, bytes.NewReader([]byte("corrupted")), "")
+		if err != nil {
+			t.Fatalf("%s: error corrupting archive: %s", test.name, err.Error())
+		}
+		if _, err = e.Verify(folder.Name, "local", key); err == nil {
+			t.Fatalf("%s: corrupted archive verified", test.name)
+		}
+		if err = e.Restore(folder.Name, "local", key, filepath.Join(t.TempDir(), "restored")); err == nil {
+			t.Fatalf("%s: corrupted archive restored", test.name)
+		}
+	}
+}
+
+func TestManifestSevenZipPassword(t *testing.T) {
+
+	e, folder := testEngine(t, "SEVENZIP")
+	folder.Compression.Password = "my-password"
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+	archives, err := e.List(folder.Name, "local")
+	if err != nil || len(archives) != 2 {
+		t.Fatalf("Unexpected archives: %+v %v", archives, err)
+	}
+
+	// The archive hides its file names, so does its manifest
+	stored, err := loadManifest(context.Background(), e.storages["local"], archives[1].Key)
+	if err != nil || stored == nil || len(stored.Files) != 0 || len(stored.EncryptedFiles) == 0 {
+		t.Fatalf("Unexpected stored manifest: %+v %v", stored, err)
+	}
+
+	m, err := e.Manifest(folder.Name, "local", "")
+	if err != nil {
+		t.Fatalf("Error reading manifest: %s", err.Error())
+	}
+	if m.FileCount != 1 || len(m.Files) != 1 || m.Files[0].Path != "src/texts/file1.txt" {
+		t.Fatalf("Unexpected manifest: %+v", m)
+	}
+
+	// The restored files are checked against the decrypted manifest
+	err = e.Restore(folder.Name, "local", "", filepath.Join(t.TempDir(), "restored"))
+	if err != nil {
+		t.Fatalf("Error restoring: %s", err.Error())
+	}
+}
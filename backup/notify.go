@@ -2,19 +2,42 @@ package backup
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/Polo44444/harpo/alerting"
 )
 
+var (
+	notifyTimeout = time.Duration(30 * time.Second)
+
+	// notifyWg tracks the messages being sent by the notifiers
+	notifyWg sync.WaitGroup
+)
+
 func notify(ctx context.Context, m *alerting.Message, notifiers map[string]alerting.Provider) {
 
 	for _, notifier := range notifiers {
 
 		mClone := *m
-		go notifier.Send(ctx, &mClone)
+		notifyWg.Add(1)
+		go func(notifier alerting.Provider) {
+			defer notifyWg.Done()
+
+			// Messages must still be sent when the process context ends
+			nCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+			defer cancel()
+			notifier.Send(nCtx, &mClone)
+		}(notifier)
 	}
 }
 
+// WaitNotifications blocks until all the pending messages have been sent.
+// Short lived commands should call it before exiting.
+func WaitNotifications() {
+	notifyWg.Wait()
+}
+
 func NotifyInfo(ctx context.Context, folderName, text, details string, notifiers map[string]alerting.Provider) {
 
 	m := &alerting.Message{
@@ -44,6 +67,9 @@ func NotifySuccess(ctx context.Context, folderName, text, details string, notifi
 // NotifyError sends an error message to all notifiers
 func NotifyError(ctx context.Context, folderName, text, details string, err error, notifiers map[string]alerting.Provider) {
 
+	// The hooks of the run are told the run failed
+	getHookRun(ctx).fail(text, err)
+
 	m := &alerting.Message{
 		Entity:  "Harpo Backup",
 		Subject: text,
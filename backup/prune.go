@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/storing"
+)
+
+var (
+	pruneTimeout = time.Duration(10 * time.Minute)
+)
+
+type pruner struct {
+	next processor
+}
+
+func NewPruner() *pruner {
+	return &pruner{}
+}
+
+func (p *pruner) setNext(next processor) processor {
+	p.next = next
+	return next
+}
+
+func (p *pruner) prune(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	folder *config.Folder,
+	notifiers map[string]alerting.Provider,
+	storName string,
+	stor storing.Provider) {
+
+	defer wg.Done()
+
+	pCtx, cancel := context.WithTimeout(ctx, pruneTimeout)
+	defer cancel()
+
+	// List the folder archives on the storage
+	archives, err := listArchives(pCtx, *folder, stor)
+	if err != nil {
+		log.Printf("Unable to list archives of folder %s on storage %s: %v\n", folder.Name, storName, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to list archives of folder 📁 %s on storage %s", folder.Name, storName),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	_, remove := applyRetention(archives, folder.Retention)
+	if len(remove) == 0 {
+		return
+	}
+
+	keys := make([]string, len(remove))
+	for i, a := range remove {
+		keys[i] = a.Key
+	}
+	details := strings.Join(keys, "\n")
+
+	// The manifests are deleted along with their archives
+	deleted := append([]string{}, keys...)
+	for _, a := range remove {
+		if a.Manifest != "" {
+			deleted = append(deleted, a.Manifest)
+		}
+	}
+
+	if folder.Retention.DryRun {
+		log.Printf("Retention of folder %s would delete %d archive(s) on storage %s:\n%s\n", folder.Name, len(keys), storName, details)
+		NotifyInfo(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Retention 🧹 dry run: %d archive(s) would be deleted on storage %s", len(keys), storName),
+			details,
+			notifiers,
+		)
+		return
+	}
+
+	err = stor.DeleteMany(pCtx, deleted)
+	if err != nil {
+		log.Printf("Unable to delete old archives of folder %s on storage %s: %v\n", folder.Name, storName, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to delete old archives of folder 📁 %s on storage %s", folder.Name, storName),
+			details,
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	NotifyInfo(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Retention 🧹 deleted %d old archive(s) on storage %s", len(keys), storName),
+		details,
+		notifiers,
+	)
+
+	// The chunks of the deleted snapshots are deleted once no other snapshot references them
+	if folder.IsRepository() {
+
+		stats, err := pruneRepository(pCtx, *folder, stor)
+		if err != nil {
+			log.Printf("Unable to prune repository of folder %s on storage %s: %v\n", folder.Name, storName, err)
+			NotifyError(
+				ctx,
+				folder.Name,
+				fmt.Sprintf("Unable to prune repository of folder 📁 %s on storage %s", folder.Name, storName),
+				"",
+				err,
+				notifiers,
+			)
+			return
+		}
+
+		NotifyInfo(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Retention 🧹 deleted %d unreferenced chunk(s) on storage %s", stats.Chunks, storName),
+			fmt.Sprintf("Size: %d bytes", stats.Size),
+			notifiers,
+		)
+	}
+}
+
+// getFailedStorages returns the storages which failed to store the archive of the run
+func getFailedStorages(ctx context.Context) map[string]bool {
+	failed, _ := ctx.Value(FailedStoragesCtxKey).(map[string]bool)
+	return failed
+}
+
+func (p *pruner) process(ctx context.Context, folder config.Folder, storages map[string]storing.Provider, notifiers map[string]alerting.Provider) {
+
+	if folder.Retention.Enabled() {
+
+		// A storage which did not receive the new archive keeps its older ones
+		failed := getFailedStorages(ctx)
+		var wg sync.WaitGroup
+		for name, storage := range storages {
+
+			if failed[name] {
+				log.Printf("Retention of folder %s skipped on storage %s, the archive of the run is not stored there\n", folder.Name, name)
+				continue
+			}
+
+			wg.Add(1)
+			go p.prune(ctx, &wg, &folder, notifiers, name, storage)
+		}
+
+		wg.Wait()
+	}
+
+	if p.next != nil {
+		p.next.process(ctx, folder, storages, notifiers)
+	}
+}
@@ -0,0 +1,344 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/repository"
+	"github.com/Polo44444/harpo/storing"
+)
+
+// SnapshotKind is the kind of the snapshots of the folders backed up inside a repository
+const SnapshotKind = "snapshot"
+
+type repositoryBackup struct {
+	next processor
+}
+
+// NewRepositoryBackup returns the processor storing the folder inside the repository of each storage
+func NewRepositoryBackup() *repositoryBackup {
+	return &repositoryBackup{}
+}
+
+func (r *repositoryBackup) setNext(p processor) processor {
+	r.next = p
+	return p
+}
+
+// backup stores a snapshot of the folder inside the repository of the storage and returns whether it succeeded
+func (r *repositoryBackup) backup(
+	ctx context.Context,
+	folder *config.Folder,
+	filter *archiving.Filter,
+	params repository.ChunkerParams,
+	notifiers map[string]alerting.Provider,
+	storName string,
+	stor storing.Provider) bool {
+
+	bCtx, cancel := context.WithTimeout(ctx, uploadTimeout) // TODO: Calculate the timeout based on the folder size
+	defer cancel()
+
+	repo, err := repository.Init(bCtx, stor, getDestPrefix(*folder), params)
+	if err != nil {
+		log.Printf("Unable to open repository of folder %s on storage %s: %v\n", folder.Name, storName, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to open repository of folder 📁 %s on storage %s", folder.Name, storName),
+			"",
+			err,
+			notifiers,
+		)
+		return false
+	}
+	defer repo.Close()
+
+	info, stats, err := repo.Backup(bCtx, folder.Sources(), filter, folder.IgnoreArchiveErrors)
+	if err != nil {
+		log.Printf("Unable to store snapshot of folder %s on storage %s: %v\n", folder.Name, storName, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to store snapshot of folder 📁 %s on storage %s", folder.Name, storName),
+			"",
+			err,
+			notifiers,
+		)
+		return false
+	}
+
+	NotifyInfo(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Snapshot stored 📤 ✅ on storage %s", storName),
+		fmt.Sprintf("Snapshot: %s\nFiles: %d (%d bytes)\nNew chunks: %d of %d (%d bytes stored)", info.Key, stats.Files, stats.Size, stats.NewChunks, stats.Chunks, stats.StoredSize),
+		notifiers,
+	)
+
+	return true
+}
+
+func (r *repositoryBackup) process(ctx context.Context, folder config.Folder, storages map[string]storing.Provider, notifiers map[string]alerting.Provider) {
+
+	filter, err := folder.ArchiveFilter()
+	if err != nil {
+		log.Printf("Unable to get archive filter of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to get archive filter of folder %s", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	params, err := folder.Repository.ChunkerParams()
+	if err != nil {
+		log.Printf("Unable to get repository settings of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to get repository settings of folder %s", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	// ─── Start Backup Process ────────────────────────────────────────────
+	NotifyInfo(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Backup 💾 process of folder %s started🌴", folder.Name),
+		"Snapshot inside repository",
+		notifiers,
+	)
+
+	// Each storage has its own repository. Files are read once per storage.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	for name, storage := range storages {
+
+		wg.Add(1)
+		go func(name string, storage storing.Provider) {
+			defer wg.Done()
+			if !r.backup(ctx, &folder, filter, params, notifiers, name, storage) {
+				mu.Lock()
+				defer mu.Unlock()
+				failed[name] = true
+			}
+		}(name, storage)
+	}
+
+	wg.Wait()
+
+	// The storages without the snapshot keep their older ones, and the folder is not removed
+	ctx = context.WithValue(ctx, FailedStoragesCtxKey, failed)
+
+	// The snapshot is written while being stored
+	var snapshotErr error
+	if len(failed) == len(storages) {
+		snapshotErr = errors.New("no snapshot stored")
+	}
+	getHookRun(ctx).afterArchive(ctx, "", snapshotErr)
+	if snapshotErr == nil {
+		getHookRun(ctx).afterUpload(ctx, "")
+	}
+
+	if r.next != nil {
+		r.next.process(ctx, folder, storages, notifiers)
+	}
+}
+
+// listSnapshots returns the snapshots of the repository of the folder stored on the storage, newest first.
+// A repository which has not been initialized yet has no snapshot.
+func listSnapshots(ctx context.Context, folder config.Folder, stor storing.Provider) ([]Archive, error) {
+
+	repo, err := repository.Open(ctx, stor, getDestPrefix(folder))
+	if errors.Is(err, repository.ErrNotInitialized) {
+		return []Archive{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+
+	snapshots, err := repo.Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	archives := []Archive{}
+	for _, s := range snapshots {
+		archives = append(archives, Archive{
+			Folder:  folder.Name,
+			Key:     s.Key,
+			Size:    s.Size,
+			ModTime: s.ModTime,
+			Time:    s.Time,
+			Kind:    SnapshotKind,
+		})
+	}
+
+	return archives, nil
+}
+
+// pruneRepository deletes the chunks of the repository of the folder referenced by no snapshot anymore
+func pruneRepository(ctx context.Context, folder config.Folder, stor storing.Provider) (repository.PruneStats, error) {
+
+	repo, err := repository.Open(ctx, stor, getDestPrefix(folder))
+	if err != nil {
+		return repository.PruneStats{}, err
+	}
+	defer repo.Close()
+
+	return repo.Prune(ctx)
+}
+
+// restoreSnapshot restores the snapshot of the folder stored under the key inside target.
+// Without key, the newest snapshot is restored. Without target, each path is restored in place
+// and the databases are restored from their dumps, extracted inside dumpDir.
+func restoreSnapshot(
+	ctx context.Context,
+	folder config.Folder,
+	storName string,
+	stor storing.Provider,
+	key string,
+	target string,
+	dumpDir string,
+	notifiers map[string]alerting.Provider) error {
+
+	repo, err := repository.Open(ctx, stor, getDestPrefix(folder))
+	if err != nil {
+		return restoreError(ctx, folder, fmt.Sprintf("Unable to open repository of folder %s on storage %s", folder.Name, storName), err, notifiers)
+	}
+	defer repo.Close()
+
+	if key == "" {
+		snapshots, err := repo.Snapshots(ctx)
+		if err == nil && len(snapshots) == 0 {
+			err = fmt.Errorf("no snapshot found")
+		}
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to find the snapshot to restore folder %s from storage %s", folder.Name, storName), err, notifiers)
+		}
+		key = snapshots[0].Key
+	}
+
+	// ─── Start Restore Process ───────────────────────────────────────────
+	targetLabel := target
+	if target == "" {
+		targetLabel = originalLocations
+	}
+	NotifyInfo(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Restore ♻️ process of folder %s from storage %s started 🌴", folder.Name, storName),
+		fmt.Sprintf("Snapshot: %s\nTarget: %s", key, targetLabel),
+		notifiers,
+	)
+
+	// Without target, the paths are restored at their original locations
+	var targets map[string]string
+	if target == "" {
+		targets = restoreTargets(folder, dumpDir)
+	} else {
+		err := os.MkdirAll(target, os.ModePerm)
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to create restore target %s", target), err, notifiers)
+		}
+	}
+
+	dCtx, cancel := context.WithTimeout(ctx, downloadTimeout) // TODO: Calculate the timeout based on the snapshot size
+	defer cancel()
+	err = repo.Restore(dCtx, key, target, targets)
+	if err != nil {
+		return restoreError(ctx, folder, fmt.Sprintf("Unable to restore snapshot of folder %s from storage %s", folder.Name, storName), err, notifiers)
+	}
+
+	if dumpDir != "" {
+
+		err = restoreDumps(ctx, folder, dumpDir, notifiers)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ─── End Restore Process ─────────────────────────────────────────────
+	absTarget, _ := filepath.Abs(target)
+	if target == "" {
+		absTarget = originalLocations
+	}
+	NotifySuccess(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Folder 📁 %s has been successfully restored ♻️ ✅ 🚀 🎉", folder.Name),
+		fmt.Sprintf("Target: %s", absTarget),
+		notifiers,
+	)
+
+	return nil
+}
+
+// getRepository returns the repository of the given folder stored on the given storage
+func (e *Engine) getRepository(ctx context.Context, folderName, storageName string) (*repository.Repository, error) {
+
+	folder, ok := e.getFolder(folderName)
+	if !ok {
+		return nil, fmt.Errorf("folder %s is not valid or have not been declared", folderName)
+	}
+	if !folder.IsRepository() {
+		return nil, fmt.Errorf("folder %s is not backed up inside a repository", folderName)
+	}
+
+	storage, ok := e.storages[storageName]
+	if !ok {
+		return nil, fmt.Errorf("storage %s is not valid or have not been declared", storageName)
+	}
+
+	return repository.Open(ctx, storage, getDestPrefix(folder))
+}
+
+// Prune deletes the chunks of the repository of the given folder on the given storage which are referenced by no snapshot.
+// It must not run while the folder is being backed up.
+func (e *Engine) Prune(folderName, storageName string) (repository.PruneStats, error) {
+
+	ctx, cancel := context.WithTimeout(e.ctx, ProcessTimeout)
+	defer cancel()
+
+	repo, err := e.getRepository(ctx, folderName, storageName)
+	if err != nil {
+		return repository.PruneStats{}, err
+	}
+	defer repo.Close()
+
+	return repo.Prune(ctx)
+}
+
+// Check checks that the chunks of the snapshots of the given folder on the given storage are stored.
+// With readData, the chunks are also downloaded and checked against their hash.
+func (e *Engine) Check(folderName, storageName string, readData bool) (repository.CheckStats, error) {
+
+	ctx, cancel := context.WithTimeout(e.ctx, ProcessTimeout)
+	defer cancel()
+
+	repo, err := e.getRepository(ctx, folderName, storageName)
+	if err != nil {
+		return repository.CheckStats{}, err
+	}
+	defer repo.Close()
+
+	return repo.Check(ctx, readData)
+}
@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Polo44444/harpo/config"
+)
+
+func TestRepositoryBackupAndRestore(t *testing.T) {
+
+	e, folder := testEngine(t, "")
+	folder.Format = config.RepositoryFormat
+	folder.Repository = config.Repository{ChunkSize: "4KiB"}
+	folder.Retention = config.Retention{KeepLast: 2}
+	e.folders[0] = folder
+
+	// Each run changes the file before its backup
+	file := filepath.Join(folder.Path, "texts", "file1.txt")
+	for _, content := range []string{"Hello World! 1", "Hello World! 2", "Hello World! 3"} {
+
+		if err := os.WriteFile(file, []byte(content), os.ModePerm); err != nil {
+			t.Fatalf("Error writing file: %s", err.Error())
+		}
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+	}
+
+	// Retention keeps the last 2 snapshots and deletes the chunks of the first one
+	snapshots, err := e.List(folder.Name, "local")
+	if err != nil || len(snapshots) != 2 {
+		t.Fatalf("Unexpected snapshots: %+v %v", snapshots, err)
+	}
+	for _, s := range snapshots {
+		if s.Kind != SnapshotKind || s.Latest {
+			t.Fatalf("Unexpected snapshot: %+v", s)
+		}
+	}
+
+	stats, err := e.Check(folder.Name, "local", true)
+	if err != nil || stats.Snapshots != 2 || stats.Unreferenced != 0 || stats.Read != stats.Chunks {
+		t.Fatalf("Unexpected check: %+v %v", stats, err)
+	}
+	pStats, err := e.Prune(folder.Name, "local")
+	if err != nil || pStats.Chunks != 0 {
+		t.Fatalf("Unexpected prune: %+v %v", pStats, err)
+	}
+
+	// Without key, the newest snapshot is restored
+	for key, content := range map[string]string{"": "Hello World! 3", snapshots[1].Key: "Hello World! 2"} {
+
+		target := t.TempDir()
+		err = e.Restore(folder.Name, "local", key, target)
+		if err != nil {
+			t.Fatalf("Error restoring snapshot %q: %s", key, err.Error())
+		}
+		data, err := os.ReadFile(filepath.Join(target, "src", "texts", "file1.txt"))
+		if err != nil || string(data) != content {
+			t.Fatalf("Snapshot %q restored %q: %v", key, string(data), err)
+		}
+	}
+
+	// Without target, the folder is restored in place
+	os.RemoveAll(folder.Path)
+	err = e.Restore(folder.Name, "local", "", "")
+	if err != nil {
+		t.Fatalf("Error restoring in place: %s", err.Error())
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || string(data) != "Hello World! 3" {
+		t.Fatalf("File restored in place is %q: %v", string(data), err)
+	}
+}
+
+func TestRepositoryBackupFailure(t *testing.T) {
+
+	// A folder is only removed once its snapshot is stored on every storage
+	for name, storages := range map[string][]string{"one failing": {"local", "broken"}, "all failing": {"broken"}} {
+
+		e, folder := testEngine(t, "")
+		folder.Format = config.RepositoryFormat
+		folder.Remove = true
+		folder.Storages = storages
+		e.folders[0] = folder
+		e.storages["broken"] = &brokenProvider{Provider: e.storages["local"]}
+
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+		data, err := os.ReadFile(filepath.Join(folder.Path, "texts", "file1.txt"))
+		if err != nil || string(data) != "Hello World!" {
+			t.Fatalf("%s: folder has been removed: %q %v", name, string(data), err)
+		}
+	}
+}
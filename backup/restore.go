@@ -0,0 +1,445 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/encrypting"
+	"github.com/Polo44444/harpo/storing"
+)
+
+var (
+	downloadTimeout = time.Duration(90 * time.Minute) // TODO: Calculate the timeout based on the archive size
+	extractTimeout  = time.Duration(30 * time.Minute) // TODO: Calculate the timeout based on the archive size
+)
+
+// originalLocations is the restore target shown when the paths are restored in place
+const originalLocations = "original locations"
+
+// Restore downloads the archive of the given folder from the given storage and extracts it inside target.
+// The archive holds the folder paths themselves, so their content is restored inside target/<path name>.
+// When target is empty, each path is restored in place, at its original location, and the databases dumped with the folder
+// are restored from their dumps. Otherwise, the dumps are only extracted inside target/<server kind>.
+// `key` is the path of the archive to restore. When empty, the latest archive alias is restored.
+// For folders backed up inside a repository, `key` is the snapshot to restore. When empty, the newest snapshot is restored.
+func (e *Engine) Restore(folderName, storageName, key, target string) error {
+
+	folder, ok := e.getFolder(folderName)
+	if !ok {
+		return fmt.Errorf("folder %s is not valid or have not been declared", folderName)
+	}
+
+	storage, ok := e.storages[storageName]
+	if !ok {
+		return fmt.Errorf("storage %s is not valid or have not been declared", storageName)
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, ProcessTimeout)
+	defer cancel()
+
+	return restore(ctx, folder, storageName, storage, e.getEncryption(folder, storageName), key, target, e.getFolderNotifiers(folder))
+}
+
+// restore runs the restore pipeline of the given folder: download, decryption, archiver selection and extraction.
+// Archives ending with the age extension are decrypted with the identities or the passphrase of the encryption.
+// Incremental and differential archives are restored after the archives of their chain, from the full one.
+func restore(
+	ctx context.Context,
+	folder config.Folder,
+	storName string,
+	stor storing.Provider,
+	encryption config.Encryption,
+	key string,
+	target string,
+	notifiers map[string]alerting.Provider) error {
+
+	// Restored in place, the dumps are extracted inside a temporary directory, then restored to their servers
+	dumpDir := ""
+	if target == "" && len(folder.DatabaseDumps()) > 0 {
+
+		var err error
+		dumpDir, err = os.MkdirTemp("", "harpo-dump-*")
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to create dump directory of folder %s", folder.Name), err, notifiers)
+		}
+		defer func() {
+			err := os.RemoveAll(dumpDir)
+			if err != nil {
+				log.Printf("Unable to remove dump directory %s: %v\n", dumpDir, err)
+			}
+		}()
+	}
+
+	// Folders backed up inside a repository restore a snapshot
+	if folder.IsRepository() {
+		return restoreSnapshot(ctx, folder, storName, stor, key, target, dumpDir, notifiers)
+	}
+
+	// Without key, we restore the latest archive alias.
+	// With incremental backups, the newest archive is restored instead, along with its chain.
+	keys := []string{key}
+	if key == "" && !folder.Incremental.Enabled() {
+
+		if folder.DisableLatest {
+			return restoreError(ctx, folder, fmt.Sprintf("Folder %s has no latest archive alias, the archive key must be provided", folder.Name), nil, notifiers)
+		}
+
+		p, _, err := getArchiverProvider(folder)
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to get archiver provider of folder %s", folder.Name), err, notifiers)
+		}
+		ext := p.Ext()
+		if encryption.Enabled() {
+			ext += encrypting.Ext
+		}
+		keys = []string{getLatestFilePath(folder, ext)}
+	} else if key == "" || partialKind(keyKind(key)) {
+
+		var err error
+		keys, err = restoreChain(ctx, folder, stor, key)
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to find the archives to restore folder %s from storage %s", folder.Name, storName), err, notifiers)
+		}
+	}
+
+	// ─── Start Restore Process ───────────────────────────────────────────
+	targetLabel := target
+	if target == "" {
+		targetLabel = originalLocations
+	}
+	NotifyInfo(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Restore ♻️ process of folder %s from storage %s started 🌴", folder.Name, storName),
+		fmt.Sprintf("Archive: %s\nTarget: %s", strings.Join(keys, ", "), targetLabel),
+		notifiers,
+	)
+
+	// Without target, the paths are restored at their original locations
+	var targets map[string]string
+	if target == "" {
+		targets = restoreTargets(folder, dumpDir)
+	} else {
+		err := os.MkdirAll(target, os.ModePerm)
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to create restore target %s", target), err, notifiers)
+		}
+	}
+
+	for _, srcFilePath := range keys {
+
+		err := restoreArchive(ctx, folder, storName, stor, encryption, srcFilePath, target, targets, notifiers)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dumpDir != "" {
+
+		err := restoreDumps(ctx, folder, dumpDir, notifiers)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ─── End Restore Process ─────────────────────────────────────────────
+	absTarget, _ := filepath.Abs(target)
+	if target == "" {
+		absTarget = originalLocations
+	}
+	NotifySuccess(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Folder 📁 %s has been successfully restored ♻️ ✅ 🚀 🎉", folder.Name),
+		fmt.Sprintf("Target: %s", absTarget),
+		notifiers,
+	)
+
+	return nil
+}
+
+// restoreTargets maps the names inside the archive to the locations they are restored at: the original paths of the folder,
+// and the directory of each database server inside dumpDir
+func restoreTargets(folder config.Folder, dumpDir string) map[string]string {
+
+	targets := folder.OriginalTargets()
+	for _, dump := range folder.DatabaseDumps() {
+		targets[dump.Name] = filepath.Join(dumpDir, dump.Name)
+	}
+
+	return targets
+}
+
+// restoreChain returns the keys of the archives to restore, oldest first, to rebuild the folder as it was
+// when the archive of the key was made. Without key, the newest archive is restored.
+func restoreChain(ctx context.Context, folder config.Folder, stor storing.Provider, key string) ([]string, error) {
+
+	lCtx, cancel := context.WithTimeout(ctx, pruneTimeout)
+	defer cancel()
+
+	archives, err := listArchives(lCtx, folder, stor)
+	if err != nil {
+		return nil, err
+	}
+
+	i := -1
+	for j, a := range archives {
+		if !a.Latest && (key == "" || a.Key == key) {
+			i = j
+			break
+		}
+	}
+	if i < 0 && key == "" {
+		return nil, fmt.Errorf("no archive found")
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("archive %s not found", key)
+	}
+
+	chain, err := archiveChain(archives, i)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, j := range chain {
+		keys = append(keys, archives[j].Key)
+	}
+
+	return keys, nil
+}
+
+// restoreArchive downloads, decrypts and extracts one archive of the folder.
+// When the archive has a manifest, the archive is checked against it before its extraction, and the extracted files after.
+// The files deleted since the base of an incremental or differential archive are removed after its extraction.
+func restoreArchive(
+	ctx context.Context,
+	folder config.Folder,
+	storName string,
+	stor storing.Provider,
+	encryption config.Encryption,
+	srcFilePath string,
+	target string,
+	targets map[string]string,
+	notifiers map[string]alerting.Provider) error {
+
+	// Download the archive inside a temporary file.
+	// Some archive formats (zip) need to seek inside the archive to extract it.
+	file, err := os.CreateTemp("", "harpo-restore-*")
+	if err != nil {
+		return restoreError(ctx, folder, fmt.Sprintf("Unable to create archive file of folder %s", folder.Name), err, notifiers)
+	}
+	defer func() {
+		file.Close()
+		err := os.Remove(file.Name())
+		if err != nil {
+			log.Printf("Unable to remove archive file %s: %v\n", file.Name(), err)
+		}
+	}()
+
+	dCtx, cancel := context.WithTimeout(ctx, downloadTimeout) // TODO: Calculate the timeout based on the archive size
+	defer cancel()
+	sum := newHashWriter()
+	err = stor.DownloadWithWriter(dCtx, srcFilePath, io.MultiWriter(file, sum))
+	if err != nil {
+		return restoreError(ctx, folder, fmt.Sprintf("Unable to download archive of folder %s from storage %s", folder.Name, storName), err, notifiers)
+	}
+
+	// Archives stored before the manifests were introduced have none
+	manifest, err := loadManifest(dCtx, stor, srcFilePath)
+	if err == nil && manifest != nil {
+		err = manifest.checkArchive(sum.size, sum.sum())
+	}
+	if err != nil {
+		return restoreError(ctx, folder, fmt.Sprintf("Unable to check archive %s against its manifest", srcFilePath), err, notifiers)
+	}
+
+	NotifyInfo(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Archive downloaded 📥 ✅ from storage %s", storName),
+		fmt.Sprintf("Archive: %s", srcFilePath),
+		notifiers,
+	)
+
+	// Decrypt the archive inside another temporary file
+	archivePath, archiveFile := srcFilePath, file
+	if strings.HasSuffix(strings.ToLower(archivePath), encrypting.Ext) {
+
+		archivePath = archivePath[:len(archivePath)-len(encrypting.Ext)]
+		decrypted, err := decryptArchive(ctx, file, encryption)
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to decrypt archive of folder %s", folder.Name), err, notifiers)
+		}
+		defer func() {
+			decrypted.Close()
+			err := os.Remove(decrypted.Name())
+			if err != nil {
+				log.Printf("Unable to remove decrypted archive file %s: %v\n", decrypted.Name(), err)
+			}
+		}()
+		archiveFile = decrypted
+
+		NotifyInfo(
+			ctx,
+			folder.Name,
+			"Archive decrypted 🔓 ✅",
+			"",
+			notifiers,
+		)
+	}
+
+	extractor, err := getExtractor(folder, archivePath)
+	if err != nil {
+		return restoreError(ctx, folder, fmt.Sprintf("Unable to get extractor of archive %s", srcFilePath), err, notifiers)
+	}
+
+	_, err = archiveFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return restoreError(ctx, folder, fmt.Sprintf("Unable to read archive of folder %s", folder.Name), err, notifiers)
+	}
+
+	// The deleted names of the incremental and differential archives are extracted apart
+	extractTargets, deletedFile := targets, ""
+	if partialKind(keyKind(srcFilePath)) {
+
+		deletedFile = file.Name() + archiving.DeletedFileName
+		defer os.Remove(deletedFile) // No need to check errors here. The file may not exist
+		extractTargets = map[string]string{archiving.DeletedFileName: deletedFile}
+		for name, dir := range targets {
+			extractTargets[name] = dir
+		}
+	}
+
+	eCtx, cancel := context.WithTimeout(ctx, extractTimeout) // TODO: Calculate the timeout based on the archive size
+	defer cancel()
+	err = extractor.Extract(eCtx, archiveFile, target, extractTargets, folder.IgnoreArchiveErrors)
+	if err != nil {
+		return restoreError(ctx, folder, fmt.Sprintf("Unable to extract archive of folder %s", folder.Name), err, notifiers)
+	}
+
+	if manifest != nil {
+
+		err = manifest.decryptFiles(filesEncryption(folder, encryption))
+		if err == nil {
+			err = manifest.checkFiles(target, targets)
+		}
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to check the files restored from archive %s against its manifest", srcFilePath), err, notifiers)
+		}
+	}
+
+	if deletedFile != "" {
+
+		err = removeDeleted(deletedFile, target, targets)
+		if err != nil {
+			return restoreError(ctx, folder, fmt.Sprintf("Unable to remove the files deleted before archive %s", srcFilePath), err, notifiers)
+		}
+	}
+
+	return nil
+}
+
+// getExtractor picks the archiver of the archive of the folder from its extension
+func getExtractor(folder config.Folder, archivePath string) (archiving.Provider, error) {
+
+	// Latest aliases only keep the last extension of the archives (e.g. ".gz"). They are extracted by the archiver of the folder
+	extractor, err := archiving.GetProviderFromExt(archivePath)
+	if err != nil {
+		p, _, pErr := getArchiverProvider(folder)
+		if pErr != nil || !strings.HasSuffix(strings.ToLower(archivePath), latestExt(p.Ext())) {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	// The password of the 7z archives is only known by the folder archiver
+	if folder.Compression.Password != "" && strings.HasSuffix(strings.ToLower(archivePath), extractor.Ext()) {
+		if p, _, err := getArchiverProvider(folder); err == nil && p.Ext() == extractor.Ext() {
+			extractor = p
+		}
+	}
+
+	return extractor, nil
+}
+
+// removeDeleted removes the names listed by the deleted file of an incremental or differential archive
+func removeDeleted(deletedFile string, target string, targets map[string]string) error {
+
+	data, err := os.ReadFile(deletedFile)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	err = json.Unmarshal(data, &names)
+	if err != nil {
+		return err
+	}
+
+	return archiving.RemoveDeleted(names, target, targets)
+}
+
+// decryptArchive decrypts the downloaded archive inside a new temporary file, ready to be read
+func decryptArchive(ctx context.Context, encrypted *os.File, encryption config.Encryption) (*os.File, error) {
+
+	identities, err := encrypting.NewIdentities(encryption.Identities, encryption.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = encrypted.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp("", "harpo-restore-*")
+	if err != nil {
+		return nil, err
+	}
+
+	dCtx, cancel := context.WithTimeout(ctx, encryptTimeout) // TODO: Calculate the timeout based on the archive size
+	defer cancel()
+	err = encrypting.Decrypt(dCtx, encrypted, file, identities...)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name()) // No need to check errors here. The file is incomplete anyway
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// restoreError logs and notifies a restore failure and returns it
+func restoreError(ctx context.Context, folder config.Folder, text string, err error, notifiers map[string]alerting.Provider) error {
+
+	if err != nil {
+		log.Printf("%s: %v\n", text, err)
+	} else {
+		log.Println(text)
+	}
+	NotifyError(
+		ctx,
+		folder.Name,
+		text,
+		"",
+		err,
+		notifiers,
+	)
+
+	if err == nil {
+		return fmt.Errorf("%s", text)
+	}
+
+	return fmt.Errorf("%s: %w", text, err)
+}
@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/Polo44444/harpo/config"
+)
+
+// retentionBucket returns the period key of the given archive for a retention rule
+type retentionBucket func(a Archive) string
+
+// applyRetention splits the archives, sorted newest first, into the kept and the removed ones.
+// Latest aliases are never removed. The archives needed to restore the kept incremental or differential ones are kept too.
+func applyRetention(archives []Archive, r config.Retention) (keep []Archive, remove []Archive) {
+
+	kept := make([]bool, len(archives))
+
+	// Keep last N archives
+	n := 0
+	for i, a := range archives {
+		if a.Latest {
+			kept[i] = true
+			continue
+		}
+		if n < r.KeepLast {
+			kept[i] = true
+			n++
+		}
+	}
+
+	// Keep the newest archive of each period
+	rules := []struct {
+		count  int
+		bucket retentionBucket
+	}{
+		{r.KeepDaily, func(a Archive) string { return a.Time.UTC().Format("2006-01-02") }},
+		{r.KeepWeekly, func(a Archive) string {
+			year, week := a.Time.UTC().ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{r.KeepMonthly, func(a Archive) string { return a.Time.UTC().Format("2006-01") }},
+		{r.KeepYearly, func(a Archive) string { return a.Time.UTC().Format("2006") }},
+	}
+
+	for _, rule := range rules {
+
+		seen := map[string]bool{}
+		for i, a := range archives {
+
+			if len(seen) >= rule.count {
+				break
+			}
+			if a.Latest {
+				continue
+			}
+
+			bucket := rule.bucket(a)
+			if seen[bucket] {
+				continue
+			}
+			seen[bucket] = true
+			kept[i] = true
+		}
+	}
+
+	for i, a := range archives {
+		if !kept[i] || a.Latest || !partialKind(a.Kind) {
+			continue
+		}
+		chain, _ := archiveChain(archives, i)
+		for _, j := range chain {
+			kept[j] = true
+		}
+	}
+
+	for i, a := range archives {
+		if kept[i] {
+			keep = append(keep, a)
+		} else {
+			remove = append(remove, a)
+		}
+	}
+
+	return keep, remove
+}
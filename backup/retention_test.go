@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/config"
+)
+
+// testArchives returns one archive per day during the given number of days, newest first
+func testArchives(days int) []Archive {
+
+	start := time.Date(2024, 12, 31, 1, 0, 0, 0, time.UTC)
+	archives := []Archive{{Key: "latest", Time: start, Latest: true}}
+	for i := 0; i < days; i++ {
+		t := start.AddDate(0, 0, -i)
+		archives = append(archives, Archive{Key: t.Format("2006-01-02"), Time: t})
+	}
+
+	return archives
+}
+
+func TestApplyRetention(t *testing.T) {
+
+	archives := testArchives(400)
+
+	tests := []struct {
+		name      string
+		retention config.Retention
+		keep      []string
+	}{
+		{"keep last", config.Retention{KeepLast: 2}, []string{"latest", "2024-12-31", "2024-12-30"}},
+		{"keep daily", config.Retention{KeepDaily: 3}, []string{"latest", "2024-12-31", "2024-12-30", "2024-12-29"}},
+		{"keep weekly", config.Retention{KeepWeekly: 2}, []string{"latest", "2024-12-31", "2024-12-29"}},
+		{"keep monthly", config.Retention{KeepMonthly: 3}, []string{"latest", "2024-12-31", "2024-11-30", "2024-10-31"}},
+		{"keep yearly", config.Retention{KeepYearly: 5}, []string{"latest", "2024-12-31", "2023-12-31"}},
+		{"combined", config.Retention{KeepLast: 1, KeepMonthly: 2}, []string{"latest", "2024-12-31", "2024-11-30"}},
+	}
+
+	for _, test := range tests {
+
+		keep, remove := applyRetention(archives, test.retention)
+		if len(keep)+len(remove) != len(archives) {
+			t.Fatalf("%s: %d kept and %d removed archives, want %d in total", test.name, len(keep), len(remove), len(archives))
+		}
+
+		if len(keep) != len(test.keep) {
+			t.Fatalf("%s: kept %d archives, want %d", test.name, len(keep), len(test.keep))
+		}
+		for i, a := range keep {
+			if a.Key != test.keep[i] {
+				t.Fatalf("%s: kept archive %d is %s, want %s", test.name, i, a.Key, test.keep[i])
+			}
+		}
+	}
+}
+
+func TestRetentionAfterFailedUpload(t *testing.T) {
+
+	for _, streaming := range []bool{false, true} {
+
+		e, folder := testEngine(t, "ZIP")
+		folder.Streaming = streaming
+		folder.Verify = "DEEP"
+		folder.Retention = config.Retention{KeepLast: 1}
+		e.folders[0] = folder
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+		// The corrupted archive of the second run fails its verification, the sound archive of the first run is kept
+		local := e.storages["local"]
+		e.storages["local"] = &corruptingProvider{Provider: local}
+		e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+		e.storages["local"] = local
+
+		archives, err := e.List(folder.Name, "local")
+		if err != nil {
+			t.Fatalf("streaming %v: error listing archives: %s", streaming, err.Error())
+		}
+		versioned := 0
+		for _, a := range archives {
+			if !a.Latest {
+				versioned++
+			}
+		}
+		if versioned != 2 {
+			t.Fatalf("streaming %v: %d archives left, want 2", streaming, versioned)
+		}
+	}
+}
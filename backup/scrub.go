@@ -0,0 +1,367 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/encrypting"
+	"github.com/Polo44444/harpo/models"
+	"github.com/Polo44444/harpo/repository"
+	"github.com/Polo44444/harpo/storing"
+)
+
+// ScrubStats sums up a verify job
+type ScrubStats struct {
+	Archives        int   // Archives downloaded
+	Size            int64 // Bytes of the archives downloaded
+	Failed          int   // Archives corrupted, unreadable or failing to extract
+	Missing         int   // Archives whose manifest or chain is left without them
+	WithoutManifest int   // Archives only test-extracted
+	NotExtracted    int   // Encrypted archives without identity, only checked against their manifest
+	Snapshots       int   // Snapshots of repositories checked with their data
+	Chunks          int   // Chunks of repositories downloaded
+}
+
+// SetVerifyJobs sets the jobs checking the archives stored on the storages
+func (e *Engine) SetVerifyJobs(jobs []config.VerifyJob) {
+	e.verifyJobs = jobs
+}
+
+// getJobNotifiers returns the registered notifiers used by the given verify job
+func (e *Engine) getJobNotifiers(job config.VerifyJob) map[string]alerting.Provider {
+
+	jobNotifiers := map[string]alerting.Provider{}
+	for _, notifierName := range job.Notifiers {
+		if notifier, ok := e.notifiers[notifierName]; ok {
+			jobNotifiers[notifierName] = notifier
+		}
+	}
+
+	return jobNotifiers
+}
+
+// Scrub downloads every archive of the folders of the job stored on its storages, checks it against its manifest
+// and test-extracts it without writing its files. The snapshots of repositories are checked with their data.
+// The latest aliases are copies of the newest archives, they are not downloaded again.
+// ZIP and 7z archives need random access to be extracted, so each of them is spooled to a temporary file while it is checked.
+// Each problem is notified as an error, then the job ends with a summary. All the problems are returned together.
+func (e *Engine) Scrub(job config.VerifyJob) (ScrubStats, error) {
+
+	// Each archive and each repository has its own timeout, a job checking many of them is not cut short
+	ctx := e.ctx
+
+	notifiers := e.getJobNotifiers(job)
+	stats := ScrubStats{}
+	errs := []error{}
+
+	// ─── Start Verify Process ────────────────────────────────────────────
+	NotifyInfo(
+		ctx,
+		job.Name,
+		fmt.Sprintf("Verify 🔍 job %s started 🌴", job.Name),
+		fmt.Sprintf("Storages: %s", strings.Join(job.Storages, ", ")),
+		notifiers,
+	)
+
+	for _, storName := range job.Storages {
+
+		stor, ok := e.storages[storName]
+		if !ok {
+			errs = append(errs, fmt.Errorf("storage %s is not valid or have not been declared", storName))
+			continue
+		}
+
+		for _, folder := range e.folders {
+
+			if !jobFolder(job, folder, storName) {
+				continue
+			}
+
+			err := e.scrubFolder(ctx, folder, storName, stor, &stats, notifiers)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	// ─── End Verify Process ──────────────────────────────────────────────
+	summary := fmt.Sprintf("Archives: %d (%d bytes)\nFailed: %d\nMissing: %d\nWithout manifest: %d\nNot extracted: %d\nSnapshots: %d (%d chunks)",
+		stats.Archives, stats.Size, stats.Failed, stats.Missing, stats.WithoutManifest, stats.NotExtracted, stats.Snapshots, stats.Chunks)
+	err := errors.Join(errs...)
+	if err != nil {
+		log.Printf("Verify job %s found problems: %v\n", job.Name, err)
+		NotifyError(
+			ctx,
+			job.Name,
+			fmt.Sprintf("Verify 🔍 job %s found problems ❌", job.Name),
+			summary,
+			err,
+			notifiers,
+		)
+		return stats, err
+	}
+
+	NotifySuccess(
+		ctx,
+		job.Name,
+		fmt.Sprintf("Verify 🔍 job %s completed ✅", job.Name),
+		summary,
+		notifiers,
+	)
+
+	return stats, nil
+}
+
+// jobFolder returns whether the job checks the archives of the folder stored on the storage
+func jobFolder(job config.VerifyJob, folder config.Folder, storName string) bool {
+
+	stored := false
+	for _, name := range folder.Storages {
+		stored = stored || name == storName
+	}
+	if !stored || len(job.Folders) == 0 {
+		return stored
+	}
+
+	for _, name := range job.Folders {
+		if name == folder.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scrubFolder checks the archives or the repository of the folder stored on the storage
+func (e *Engine) scrubFolder(
+	ctx context.Context,
+	folder config.Folder,
+	storName string,
+	stor storing.Provider,
+	stats *ScrubStats,
+	notifiers map[string]alerting.Provider) error {
+
+	if folder.IsRepository() {
+		return scrubRepository(ctx, folder, storName, stor, stats, notifiers)
+	}
+
+	lCtx, cancel := context.WithTimeout(ctx, pruneTimeout)
+	defer cancel()
+
+	archives, err := listArchives(lCtx, folder, stor)
+	if err != nil {
+		return scrubError(ctx, folder, fmt.Sprintf("Unable to list archives of folder %s on storage %s", folder.Name, storName), err, notifiers)
+	}
+
+	errs := []error{}
+
+	// Manifests are deleted along with their archives, so a manifest alone means its archive is missing
+	missing, err := orphanManifests(lCtx, folder, stor, archives)
+	if err != nil {
+		errs = append(errs, scrubError(ctx, folder, fmt.Sprintf("Unable to list manifests of folder %s on storage %s", folder.Name, storName), err, notifiers))
+	}
+	for _, key := range missing {
+		stats.Missing++
+		errs = append(errs, scrubError(ctx, folder, fmt.Sprintf("Archive of folder %s is missing from storage %s", folder.Name, storName), fmt.Errorf("archive %s is missing, its manifest is left", key), notifiers))
+	}
+
+	encryption := e.getEncryption(folder, storName)
+	for i, archive := range archives {
+
+		if archive.Latest {
+			continue
+		}
+
+		// Incremental and differential archives can not be restored without their full archive
+		if _, err := archiveChain(archives, i); err != nil {
+			stats.Missing++
+			errs = append(errs, scrubError(ctx, folder, fmt.Sprintf("Chain of archive %s is broken on storage %s", archive.Key, storName), err, notifiers))
+		}
+
+		stats.Archives++
+		stats.Size += archive.Size
+		result, err := scrubArchive(ctx, folder, stor, encryption, archive.Key)
+		if err != nil {
+			stats.Failed++
+			errs = append(errs, scrubError(ctx, folder, fmt.Sprintf("Archive %s of folder %s is corrupted on storage %s", archive.Key, folder.Name, storName), err, notifiers))
+			continue
+		}
+		if !result.manifest {
+			stats.WithoutManifest++
+		}
+		if !result.extracted {
+			stats.NotExtracted++
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// orphanManifests returns the keys of the archives of the folder whose manifest is stored without them
+func orphanManifests(ctx context.Context, folder config.Folder, stor storing.Provider, archives []Archive) ([]string, error) {
+
+	stored := map[string]bool{}
+	for _, archive := range archives {
+		stored[archive.Key] = true
+	}
+
+	missing := []string{}
+	err := stor.List(ctx, getDestPrefix(folder), func(page []models.FileInfo) error {
+		for _, file := range page {
+			key := strings.TrimSuffix(file.Key, manifestExt)
+			if isManifestKey(file.Key) && !stored[key] {
+				missing = append(missing, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return missing, nil
+}
+
+// scrubResult tells how far an archive has been checked
+type scrubResult struct {
+	manifest  bool // Checked against its manifest
+	extracted bool // Test-extracted
+}
+
+// scrubArchive downloads the archive stored under the key once, hashing it and test-extracting it on the fly.
+// Encrypted archives are decrypted on the fly too. Without identity, they are only checked against their manifest.
+func scrubArchive(ctx context.Context, folder config.Folder, stor storing.Provider, encryption config.Encryption, key string) (scrubResult, error) {
+
+	result := scrubResult{}
+
+	dCtx, cancel := context.WithTimeout(ctx, downloadTimeout) // TODO: Calculate the timeout based on the archive size
+	defer cancel()
+
+	manifest, err := loadManifest(dCtx, stor, key)
+	if err != nil {
+		return result, err
+	}
+	result.manifest = manifest != nil
+
+	archivePath := key
+	encrypted := strings.HasSuffix(strings.ToLower(archivePath), encrypting.Ext)
+	if encrypted {
+		archivePath = archivePath[:len(archivePath)-len(encrypting.Ext)]
+	}
+	extractor, err := getExtractor(folder, archivePath)
+	if err != nil {
+		return result, fmt.Errorf("unable to get extractor of archive %s: %w", key, err)
+	}
+	result.extracted = !encrypted || len(encryption.Identities) > 0 || encryption.Passphrase != ""
+
+	// The archive is extracted while being downloaded. What the extractor leaves is still hashed.
+	pr, pw := io.Pipe()
+	extracted := make(chan error, 1)
+	go func() {
+
+		var err error
+		if result.extracted {
+			err = testExtract(dCtx, pr, extractor, encrypted, encryption)
+		}
+		extracted <- err
+		io.Copy(io.Discard, pr)
+		pr.Close()
+	}()
+
+	sum := newHashWriter()
+	err = stor.DownloadWithWriter(dCtx, key, io.MultiWriter(sum, pw))
+	pw.CloseWithError(err)
+	extractErr := <-extracted
+	if err != nil {
+		return result, fmt.Errorf("unable to download archive %s: %w", key, err)
+	}
+
+	if manifest != nil {
+		err = manifest.checkArchive(sum.size, sum.sum())
+		if err != nil {
+			return result, err
+		}
+	}
+	if extractErr != nil {
+		return result, fmt.Errorf("unable to extract archive %s: %w", key, extractErr)
+	}
+
+	return result, nil
+}
+
+// testExtract extracts the archive read from src without writing its files
+func testExtract(ctx context.Context, src io.Reader, extractor archiving.Provider, encrypted bool, encryption config.Encryption) error {
+
+	if encrypted {
+
+		identities, err := encrypting.NewIdentities(encryption.Identities, encryption.Passphrase)
+		if err != nil {
+			return err
+		}
+
+		// Closing the reader stops the decryption when the extraction ends early
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func(src io.Reader) {
+			pw.CloseWithError(encrypting.Decrypt(ctx, src, pw, identities...))
+		}(src)
+		src = pr
+	}
+
+	return extractor.Extract(ctx, src, archiving.NullSink, nil, false)
+}
+
+// scrubRepository checks the snapshots of the repository of the folder stored on the storage with their data
+func scrubRepository(
+	ctx context.Context,
+	folder config.Folder,
+	storName string,
+	stor storing.Provider,
+	stats *ScrubStats,
+	notifiers map[string]alerting.Provider) error {
+
+	rCtx, cancel := context.WithTimeout(ctx, ProcessTimeout) // TODO: Calculate the timeout based on the repository size
+	defer cancel()
+
+	repo, err := repository.Open(rCtx, stor, getDestPrefix(folder))
+	if errors.Is(err, repository.ErrNotInitialized) {
+		return nil
+	}
+	if err != nil {
+		return scrubError(ctx, folder, fmt.Sprintf("Unable to open repository of folder %s on storage %s", folder.Name, storName), err, notifiers)
+	}
+	defer repo.Close()
+
+	cStats, err := repo.Check(rCtx, true)
+	stats.Snapshots += cStats.Snapshots
+	stats.Chunks += cStats.Read
+	if err != nil {
+		stats.Failed++
+		return scrubError(ctx, folder, fmt.Sprintf("Repository of folder %s is corrupted on storage %s", folder.Name, storName), err, notifiers)
+	}
+
+	return nil
+}
+
+// scrubError logs and notifies a problem found by a verify job and returns it
+func scrubError(ctx context.Context, folder config.Folder, text string, err error, notifiers map[string]alerting.Provider) error {
+
+	log.Printf("%s: %v\n", text, err)
+	NotifyError(
+		ctx,
+		folder.Name,
+		text,
+		"",
+		err,
+		notifiers,
+	)
+
+	return fmt.Errorf("%s: %w", text, err)
+}
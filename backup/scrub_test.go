@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/Polo44444/harpo/config"
+)
+
+func TestScrub(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, archiver := range []string{"ZIP", "TAR", "SEVENZIP"} {
+
+		e, folder := testEngine(t, archiver)
+		job := config.VerifyJob{Name: "weekly", Schedule: "0 3 * * 0", Storages: []string{"local"}}
+		for i := 0; i < 2; i++ {
+			e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+		}
+
+		// Two versioned archives, the latest alias is not downloaded again
+		stats, err := e.Scrub(job)
+		if err != nil || stats.Archives != 2 || stats.Failed != 0 || stats.WithoutManifest != 0 || stats.NotExtracted != 0 {
+			t.Fatalf("%s: unexpected scrub of sound archives: %+v %v", archiver, stats, err)
+		}
+
+		archives, err := listArchives(ctx, folder, e.storages["local"])
+		if err != nil {
+			t.Fatalf("%s: error listing archives: %s", archiver, err.Error())
+		}
+		var versioned []string
+		for _, a := range archives {
+			if !a.Latest {
+				versioned = append(versioned, a.Key)
+			}
+		}
+
+		// A corrupted archive is reported
+		stor := e.storages["local"]
+		buf := &bytes.Buffer{}
+		if err := stor.DownloadWithWriter(ctx, versioned[0], buf); err != nil {
+			t.Fatalf("%s: error downloading archive: %s", archiver, err.Error())
+		}
+		data := buf.Bytes()
+		data[len(data)/2] ^= 0xff
+		if err := stor.UploadWithReader(ctx, versioned[0], bytes.NewReader(data), ""); err != nil {
+			t.Fatalf("%s: error uploading archive: %s", archiver, err.Error())
+		}
+
+		// An archive deleted without its manifest is missing
+		if err := stor.Delete(ctx, versioned[1]); err != nil {
+			t.Fatalf("%s: error deleting archive: %s", archiver, err.Error())
+		}
+
+		stats, err = e.Scrub(job)
+		if err == nil || stats.Archives != 1 || stats.Failed != 1 || stats.Missing != 1 {
+			t.Fatalf("%s: unexpected scrub of damaged archives: %+v %v", archiver, stats, err)
+		}
+
+		// Folders left out of the job are not checked
+		job.Folders = []string{"Others"}
+		stats, err = e.Scrub(job)
+		if err != nil || stats.Archives != 0 {
+			t.Fatalf("%s: unexpected scrub without folder: %+v %v", archiver, stats, err)
+		}
+	}
+}
+
+func TestScrubEncrypted(t *testing.T) {
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Error generating identity: %s", err.Error())
+	}
+
+	e, folder := testEngine(t, "TAR")
+	folder.Encryption = config.Encryption{Passphrase: "secret"}
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+	// The passphrase decrypts the archives on the fly
+	job := config.VerifyJob{Name: "weekly", Schedule: "0 3 * * 0", Storages: []string{"local"}}
+	stats, err := e.Scrub(job)
+	if err != nil || stats.Archives != 1 || stats.NotExtracted != 0 {
+		t.Fatalf("Unexpected scrub with passphrase: %+v %v", stats, err)
+	}
+
+	// Without identity, the archives are only checked against their manifest
+	folder.Encryption = config.Encryption{Recipients: []string{id.Recipient().String()}}
+	e.folders[0] = folder
+	e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+	stats, err = e.Scrub(job)
+	if err != nil || stats.Archives != 2 || stats.NotExtracted != 2 {
+		t.Fatalf("Unexpected scrub without identity: %+v %v", stats, err)
+	}
+}
@@ -0,0 +1,414 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/encrypting"
+	"github.com/Polo44444/harpo/storing"
+)
+
+var (
+	errAllStreamsFailed = errors.New("all the storages failed to receive the archive")
+
+	// errUploadEnded is seen by the archive writer when an upload stops reading before the end of the archive
+	errUploadEnded = errors.New("upload ended before the end of the archive")
+)
+
+// streamer archives the folder straight into the uploads of all the storages, without temporary file.
+// It replaces the archiver, encrypter and uploader processes when the folder streaming is enabled.
+type streamer struct {
+	next        processor
+	encryptions map[string]config.Encryption // Encryption of the storages overriding the folder one
+}
+
+func NewStreamer(encryptions map[string]config.Encryption) *streamer {
+	return &streamer{encryptions: encryptions}
+}
+
+func (s *streamer) setNext(p processor) processor {
+	s.next = p
+	return p
+}
+
+// storageStream receives the archive of one storage and feeds its uploads, one pipe per uploaded key.
+type storageStream struct {
+	storName string
+	stor     storing.Provider
+	keys     []string
+	pipes    []*io.PipeWriter
+	w        io.Writer      // Entry point of the archive data: the encrypter or the pipes
+	enc      io.WriteCloser // Encrypter of the archive. Nil without encryption
+	sum      *hashWriter    // Size and SHA-256 of the uploaded archive, recorded by its manifest
+	err      error          // First error of the stream. A failed stream receives no more data
+	errs     []error        // Upload error of each key
+}
+
+// fail stops the stream and aborts all its uploads
+func (ss *storageStream) fail(err error) {
+
+	if ss.err != nil {
+		return
+	}
+
+	ss.err = err
+	for _, pw := range ss.pipes {
+		pw.CloseWithError(err)
+	}
+}
+
+// close ends the stream after the whole archive has been written
+func (ss *storageStream) close() {
+
+	if ss.err != nil {
+		return
+	}
+
+	// Closing the encrypter writes its last chunk
+	if ss.enc != nil {
+		if err := ss.enc.Close(); err != nil {
+			ss.fail(err)
+			return
+		}
+	}
+
+	for _, pw := range ss.pipes {
+		pw.Close()
+	}
+}
+
+// fanoutWriter writes the archive to all the storage streams.
+// Pipes have no buffer, so the archive is produced at the pace of the slowest storage.
+// A stream failing does not stop the others, the write fails only when all of them have failed.
+type fanoutWriter struct {
+	streams []*storageStream
+}
+
+func (f *fanoutWriter) Write(p []byte) (int, error) {
+
+	alive := 0
+	for _, ss := range f.streams {
+
+		if ss.err != nil {
+			continue
+		}
+
+		_, err := ss.w.Write(p)
+		if err != nil {
+			ss.fail(err)
+			continue
+		}
+		alive++
+	}
+
+	if alive == 0 {
+		return 0, errAllStreamsFailed
+	}
+
+	return len(p), nil
+}
+
+// startStream starts the uploads of the storage, reading the archive from pipes
+func (s *streamer) startStream(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	folder *config.Folder,
+	storName string,
+	stor storing.Provider,
+	destFilePath string,
+	ext string,
+	contentType string) *storageStream {
+
+	ss := &storageStream{
+		storName: storName,
+		stor:     stor,
+		sum:      newHashWriter(),
+	}
+
+	encryption := folder.Encryption.Merge(s.encryptions[storName])
+	if encryption.Enabled() {
+		destFilePath += encrypting.Ext
+		ext += encrypting.Ext
+		contentType = "application/octet-stream"
+	}
+
+	// The versioned archive and the latest alias are uploaded at the same time.
+	// Incremental and differential archives can not be restored alone, the alias keeps the last full archive.
+	ss.keys = []string{destFilePath}
+	if !folder.DisableLatest && archiveKind(ext) == FullKind {
+		ss.keys = append(ss.keys, getLatestFilePath(*folder, ext))
+	}
+	ss.errs = make([]error, len(ss.keys))
+
+	writers := []io.Writer{}
+	for i, key := range ss.keys {
+
+		pr, pw := io.Pipe()
+		ss.pipes = append(ss.pipes, pw)
+		writers = append(writers, pw)
+
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+
+			err := stor.UploadWithReader(ctx, key, pr, contentType)
+			ss.errs[i] = err
+
+			// Unblock the writer when the upload ends early. After a complete read, the error is never seen.
+			if err == nil {
+				err = errUploadEnded
+			}
+			pr.CloseWithError(err)
+		}(i, key)
+	}
+	ss.w = io.MultiWriter(append(writers, ss.sum)...)
+
+	// The encrypter writes the age header right away, so it is created once the uploads read the pipes
+	if encryption.Enabled() {
+
+		recipients, err := encrypting.NewRecipients(encryption.Recipients, encryption.Passphrase)
+		if err == nil {
+			ss.enc, err = encrypting.NewWriter(ss.w, recipients...)
+		}
+		if err != nil {
+			ss.fail(fmt.Errorf("unable to encrypt archive: %w", err))
+			return ss
+		}
+		ss.w = ss.enc
+	}
+
+	return ss
+}
+
+func (s *streamer) process(ctx context.Context, folder config.Folder, storages map[string]storing.Provider, notifiers map[string]alerting.Provider) {
+
+	p, contentType, err := getArchiverProvider(folder)
+	if err != nil {
+		log.Printf("Unable to get archiver provider of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to get archiver provider of folder %s", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	filter, err := folder.ArchiveFilter()
+	if err != nil {
+		log.Printf("Unable to get archive filter of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to get archive filter of folder %s", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	// Incremental and differential archives only hold the changes since their base
+	kind, chain := nextArchive(folder, filter)
+	filter.Manifest = archiving.NewIndex()
+
+	// We compute the archive versioned path. Its extension marks the kind of archive
+	runID, _ := ctx.Value(RunIDCtxKey).(string)
+	startTime, ok := ctx.Value(StartTimeCtxKey).(time.Time)
+	if !ok {
+		startTime = time.Now()
+	}
+	ext := kindExt(kind) + p.Ext()
+	destFilePath, err := getVersionedFilePath(folder, runID, startTime, ext)
+	if err != nil {
+		log.Printf("Unable to build archive path of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to build archive path of folder 📁 %s", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	// ─── Start Streaming Process ─────────────────────────────────────────
+	NotifyInfo(
+		ctx,
+		folder.Name,
+		fmt.Sprintf("Backup 💾 process of folder %s started🌴", folder.Name),
+		fmt.Sprintf("Streaming the %s archive to the storages 📤", kind),
+		notifiers,
+	)
+
+	sCtx, cancel := context.WithTimeout(ctx, uploadTimeout) // TODO: Calculate the timeout based on the folder size
+	defer cancel()
+
+	var wg sync.WaitGroup
+	fanout := &fanoutWriter{}
+	for name, storage := range storages {
+		fanout.streams = append(fanout.streams, s.startStream(sCtx, &wg, &folder, name, storage, destFilePath, ext, contentType))
+	}
+
+	// The archive error aborts all the uploads, so no partial archive is stored
+	err = p.Archive(sCtx, folder.Sources(), fanout, filter, folder.IgnoreArchiveErrors)
+	for _, ss := range fanout.streams {
+		if err != nil {
+			ss.fail(err)
+		} else {
+			ss.close()
+		}
+	}
+	wg.Wait()
+	getHookRun(ctx).afterArchive(ctx, "", err)
+
+	// When all the storages failed, their own errors are reported below
+	if err != nil && !errors.Is(err, errAllStreamsFailed) {
+		log.Printf("Unable to archive folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to archive folder %s", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
+	if err == nil {
+		NotifyInfo(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Archival of folder 📁 %s completed 🗜️ ✅", folder.Name),
+			"",
+			notifiers,
+		)
+	}
+
+	// ─── Uploads Results ─────────────────────────────────────────────────
+	var manifest *Manifest
+	if err == nil {
+		manifest = newManifest(ctx, folder, kind, filter.Manifest)
+	}
+	failedStorages := map[string]bool{}
+	unverified := false
+	for _, ss := range fanout.streams {
+
+		// The upload error explains better than the pipe error why the stream failed
+		failed := errors.Join(ss.errs...)
+		if failed == nil {
+			failed = ss.err
+		}
+
+		if failed != nil {
+			log.Printf("Unable to upload archive to storage %s: %v\n", ss.storName, failed)
+			NotifyError(
+				ctx,
+				folder.Name,
+				fmt.Sprintf("Unable to upload archive to storage %s", ss.storName),
+				"",
+				failed,
+				notifiers,
+			)
+			failedStorages[ss.storName] = true
+			continue
+		}
+
+		details := fmt.Sprintf("Archive: %s", ss.keys[0])
+		if len(ss.keys) > 1 {
+			details += fmt.Sprintf("\nLatest: %s", ss.keys[1])
+		}
+
+		// Check the stored archives against the streamed one. A mismatch fails the upload.
+		if mode := folder.VerifyMode(); mode != config.NoVerification {
+
+			var err error
+			for _, key := range ss.keys {
+				if err = verifyUpload(ctx, ss.stor, key, ss.sum.fileSum(), mode); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				log.Printf("Archive uploaded to storage %s does not match the streamed archive: %v\n", ss.storName, err)
+				NotifyError(
+					ctx,
+					folder.Name,
+					fmt.Sprintf("Archive uploaded to storage %s does not match the streamed archive", ss.storName),
+					details,
+					err,
+					notifiers,
+				)
+				unverified = true
+				failedStorages[ss.storName] = true
+				continue
+			}
+			details += fmt.Sprintf("\nVerified: %s", mode)
+		}
+
+		// Upload the manifest next to the archive and its latest alias
+		if manifest != nil {
+
+			m, err := manifest.forArchive(ss.sum.fileSum(), filesEncryption(folder, folder.Encryption.Merge(s.encryptions[ss.storName])))
+			if err == nil {
+				err = uploadManifest(ctx, ss.stor, m, ss.keys...)
+			}
+			if err != nil {
+				log.Printf("Unable to upload archive manifest to storage %s: %v\n", ss.storName, err)
+				NotifyError(
+					ctx,
+					folder.Name,
+					fmt.Sprintf("Unable to upload archive manifest to storage %s", ss.storName),
+					details,
+					err,
+					notifiers,
+				)
+				failedStorages[ss.storName] = true
+				continue
+			}
+			details += fmt.Sprintf("\nSHA-256: %s", m.ArchiveSHA256)
+		}
+
+		NotifyInfo(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Archive uploaded 📤 ✅ to storage %s", ss.storName),
+			details,
+			notifiers,
+		)
+	}
+
+	// Without any uploaded archive, the folder must not be cleaned
+	if len(failedStorages) == len(fanout.streams) {
+		return
+	}
+	getHookRun(ctx).afterUpload(ctx, "")
+	ctx = context.WithValue(ctx, FailedStoragesCtxKey, failedStorages)
+
+	// The next incremental archive is based on this one once it is stored everywhere.
+	// Otherwise the next archive starts a new chain, as some storages hold this one.
+	ctx = context.WithValue(ctx, ChainCtxKey, chain)
+	if err == nil && len(failedStorages) == 0 {
+		commitChain(ctx, folder, notifiers)
+	} else {
+		resetChain(ctx, folder, notifiers)
+	}
+	if unverified {
+		ctx = context.WithValue(ctx, UnverifiedCtxKey, true)
+	}
+
+	if s.next != nil {
+		s.next.process(ctx, folder, storages, notifiers)
+	}
+}
@@ -3,14 +3,16 @@ package backup
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Polo44444/harpo/alerting"
 	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/encrypting"
 	"github.com/Polo44444/harpo/storing"
 )
 
@@ -19,11 +21,12 @@ var (
 )
 
 type uploader struct {
-	next processor
+	next        processor
+	encryptions map[string]config.Encryption // Encryption of the storages overriding the folder one. Used for the manifest files
 }
 
-func NewUploader() *uploader {
-	return &uploader{}
+func NewUploader(encryptions map[string]config.Encryption) *uploader {
+	return &uploader{encryptions: encryptions}
 }
 
 func (u *uploader) setNext(p processor) processor {
@@ -31,17 +34,21 @@ func (u *uploader) setNext(p processor) processor {
 	return p
 }
 
+// upload uploads the archive to the storage and returns whether it succeeded.
+// An archive failing its verification also marks the run as unverified.
 func (u *uploader) upload(
 	ctx context.Context,
-	wg *sync.WaitGroup,
 	archiveFile string,
+	destFilePath string,
+	ext string,
 	contentType string,
+	sum fileSum,
+	manifest *Manifest,
 	folder *config.Folder,
 	notifiers map[string]alerting.Provider,
 	storName string,
-	stor storing.Provider) {
-
-	defer wg.Done()
+	stor storing.Provider,
+	unverified *atomic.Bool) bool {
 
 	// open file
 	file, err := os.Open(archiveFile)
@@ -55,7 +62,7 @@ func (u *uploader) upload(
 			err,
 			notifiers,
 		)
-		return
+		return false
 	}
 	defer func() {
 		file.Close()
@@ -65,13 +72,7 @@ func (u *uploader) upload(
 	uCtx, cancel := context.WithTimeout(ctx, uploadTimeout) // TODO: Calculate the timeout based on the folder size
 	defer cancel()
 
-	// Upload the archive file
-	destFilePath := getDestFilePath(
-		folder.Name,
-		folder.Destination,
-		filepath.Ext(file.Name()),
-	)
-
+	// Upload the archive file under its versioned path
 	err = stor.UploadWithReader(uCtx, destFilePath, file, contentType)
 	if err != nil {
 		log.Printf("Unable to upload archive to storage %s: %v\n", storName, err)
@@ -83,16 +84,98 @@ func (u *uploader) upload(
 			err,
 			notifiers,
 		)
-		return
+		return false
+	}
+	details := fmt.Sprintf("Archive: %s", destFilePath)
+	keys := []string{destFilePath}
+
+	// Upload the archive file under the latest alias, so consumers of the previous layout keep working.
+	// Incremental and differential archives can not be restored alone, the alias keeps the last full archive.
+	if !folder.DisableLatest && archiveKind(ext) == FullKind {
+
+		latestFilePath := getLatestFilePath(*folder, ext)
+		_, err = file.Seek(0, io.SeekStart)
+		if err == nil {
+			err = stor.UploadWithReader(uCtx, latestFilePath, file, contentType)
+		}
+		if err != nil {
+			log.Printf("Unable to upload latest archive alias to storage %s: %v\n", storName, err)
+			NotifyError(
+				ctx,
+				folder.Name,
+				fmt.Sprintf("Unable to upload latest archive alias to storage %s", storName),
+				details,
+				err,
+				notifiers,
+			)
+			return false
+		}
+		details += fmt.Sprintf("\nLatest: %s", latestFilePath)
+		keys = append(keys, latestFilePath)
+	}
+
+	// Check the stored archives against the local one. A mismatch fails the upload.
+	if mode := folder.VerifyMode(); mode != config.NoVerification {
+
+		for _, key := range keys {
+
+			err = verifyUpload(uCtx, stor, key, sum, mode)
+			if err != nil {
+				log.Printf("Archive uploaded to storage %s does not match the local archive: %v\n", storName, err)
+				NotifyError(
+					ctx,
+					folder.Name,
+					fmt.Sprintf("Archive uploaded to storage %s does not match the local archive", storName),
+					details,
+					err,
+					notifiers,
+				)
+				unverified.Store(true)
+				return false
+			}
+		}
+		details += fmt.Sprintf("\nVerified: %s", mode)
+	}
+
+	// Upload the manifest next to the archive and its latest alias
+	if manifest != nil {
+
+		err = uploadManifest(uCtx, stor, manifest, keys...)
+		if err != nil {
+			log.Printf("Unable to upload archive manifest to storage %s: %v\n", storName, err)
+			NotifyError(
+				ctx,
+				folder.Name,
+				fmt.Sprintf("Unable to upload archive manifest to storage %s", storName),
+				details,
+				err,
+				notifiers,
+			)
+			return false
+		}
+		details += fmt.Sprintf("\nSHA-256: %s", manifest.ArchiveSHA256)
 	}
 
 	NotifyInfo(
 		ctx,
 		folder.Name,
 		fmt.Sprintf("Archive uploaded 📤 ✅ to storage %s", storName),
-		"",
+		details,
 		notifiers,
 	)
+
+	return true
+}
+
+// storageManifest returns the manifest of the archive uploaded to the storage, its files encrypted like the archive
+func (u *uploader) storageManifest(manifest *Manifest, sum fileSum, encrypted bool, folder *config.Folder, storName string) (*Manifest, error) {
+
+	encryption := config.Encryption{}
+	if encrypted {
+		encryption = folder.Encryption.Merge(u.encryptions[storName])
+	}
+
+	return manifest.forArchive(sum, filesEncryption(*folder, encryption))
 }
 
 func (u *uploader) process(ctx context.Context, folder config.Folder, storages map[string]storing.Provider, notifiers map[string]alerting.Provider) {
@@ -117,6 +200,27 @@ func (u *uploader) process(ctx context.Context, folder config.Folder, storages m
 		contentType = "application/octet-stream"
 	}
 
+	// We compute the archive versioned path
+	runID, _ := ctx.Value(RunIDCtxKey).(string)
+	startTime, ok := ctx.Value(StartTimeCtxKey).(time.Time)
+	if !ok {
+		startTime = time.Now()
+	}
+	ext := archiveExt(archiveFile)
+	destFilePath, err := getVersionedFilePath(folder, runID, startTime, ext)
+	if err != nil {
+		log.Printf("Unable to build archive path of folder %s: %v\n", folder.Name, err)
+		NotifyError(
+			ctx,
+			folder.Name,
+			fmt.Sprintf("Unable to build archive path of folder 📁 %s", folder.Name),
+			"",
+			err,
+			notifiers,
+		)
+		return
+	}
+
 	// ─── Start Upload Process ────────────────────────────────────────────
 	NotifyInfo(
 		ctx,
@@ -130,14 +234,88 @@ func (u *uploader) process(ctx context.Context, folder config.Folder, storages m
 	// Therefore, we will create multiple readers for each storage provider.
 	// We will use the same archive file for each storage provider.
 
+	// Storages with encryption upload their encrypted archive
+	storageArchives, _ := ctx.Value(StorageArchivesCtxKey).(map[string]string)
+
+	// The manifest of each storage describes the archive it receives.
+	// Archive files shared by several storages are hashed once, their hash error is shared too.
+	manifest, _ := ctx.Value(ManifestCtxKey).(*Manifest)
+	type hashed struct {
+		sum fileSum
+		err error
+	}
+	sums := map[string]hashed{}
+
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	fail := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed[name] = true
+	}
+	var unverified atomic.Bool
 	for name, storage := range storages {
 
+		storArchiveFile, storDestFilePath, storExt, storContentType := archiveFile, destFilePath, ext, contentType
+		encryptedFile, encrypted := storageArchives[name]
+		if encrypted {
+			storArchiveFile = encryptedFile
+			storDestFilePath += encrypting.Ext
+			storExt += encrypting.Ext
+			storContentType = "application/octet-stream"
+		}
+
+		h, ok := sums[storArchiveFile]
+		if !ok {
+			h.sum, h.err = hashFile(storArchiveFile)
+			sums[storArchiveFile] = h
+		}
+		sum, err := h.sum, h.err
+		var storManifest *Manifest
+		if err == nil && manifest != nil {
+			storManifest, err = u.storageManifest(manifest, sum, encrypted, &folder, name)
+		}
+		if err != nil {
+			log.Printf("Unable to build archive manifest of storage %s: %v\n", name, err)
+			NotifyError(
+				ctx,
+				folder.Name,
+				fmt.Sprintf("Unable to build archive manifest of storage %s", name),
+				"",
+				err,
+				notifiers,
+			)
+			fail(name)
+			continue
+		}
+
 		wg.Add(1)
-		go u.upload(ctx, &wg, archiveFile, contentType, &folder, notifiers, name, storage)
+		go func(name string, storage storing.Provider) {
+			defer wg.Done()
+
+			if !u.upload(ctx, storArchiveFile, storDestFilePath, storExt, storContentType, sum, storManifest, &folder, notifiers, name, storage, &unverified) {
+				fail(name)
+			}
+		}(name, storage)
 	}
 
 	wg.Wait()
+	ctx = context.WithValue(ctx, FailedStoragesCtxKey, failed)
+	if len(failed) < len(storages) {
+		getHookRun(ctx).afterUpload(ctx, archiveFile)
+	}
+
+	// The next incremental archive is based on this one once it is stored everywhere.
+	// Otherwise the next archive starts a new chain, as some storages may hold this one.
+	if len(storages) > 0 && len(failed) == 0 {
+		commitChain(ctx, folder, notifiers)
+	} else if len(storages) > 0 {
+		resetChain(ctx, folder, notifiers)
+	}
+	if unverified.Load() {
+		ctx = context.WithValue(ctx, UnverifiedCtxKey, true)
+	}
 
 	if u.next != nil {
 		u.next.process(ctx, folder, storages, notifiers)
@@ -0,0 +1,64 @@
+//go:build unix
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/alerting"
+	"github.com/Polo44444/harpo/storing"
+	storing_local "github.com/Polo44444/harpo/storing/local"
+)
+
+func TestUploadHashFailure(t *testing.T) {
+
+	_, folder := testEngine(t, "ZIP")
+	dir := t.TempDir()
+
+	// The plain storages share an archive which cannot be hashed, the encrypted ones an archive which can
+	encryptedFile := filepath.Join(dir, "dummies.harpo.zip.age")
+	err := os.WriteFile(encryptedFile, []byte("encrypted"), 0600)
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err.Error())
+	}
+	storages := map[string]storing.Provider{}
+	storageArchives := map[string]string{}
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("storage%d", i)
+		storage, err := storing.GetProvider(storing.LocalProvider, storing_local.BuildLocalConfig(filepath.Join(dir, name), false, 0600, 0700))
+		if err != nil {
+			t.Fatalf("Error creating local provider: %s", err.Error())
+		}
+		storages[name] = storage
+		if i%2 == 1 {
+			storageArchives[name] = encryptedFile
+		}
+	}
+
+	// The storages are visited in a random order, a few runs cover the orders mixing both kinds
+	for run := 0; run < 5; run++ {
+
+		notifier := &recordingNotifier{}
+		notifiers := map[string]alerting.Provider{"recorder": notifier}
+		ctx := context.WithValue(context.Background(), ArchiveCtxKey, filepath.Join(dir, "missing.harpo.zip"))
+		ctx = context.WithValue(ctx, StorageArchivesCtxKey, storageArchives)
+		ctx = context.WithValue(ctx, StartTimeCtxKey, time.Now().Add(time.Duration(run)*time.Second))
+
+		NewUploader(nil).process(ctx, folder, storages, notifiers)
+		WaitNotifications()
+
+		// Each plain storage reports the hash error, the encrypted ones do not inherit it
+		for name := range storages {
+			_, encrypted := storageArchives[name]
+			reported := notifier.sent("Unable to build archive manifest of storage "+name, "")
+			if reported == encrypted {
+				t.Fatalf("Run %d: storage %s reported a hash error: %v, want %v", run, name, reported, !encrypted)
+			}
+		}
+	}
+}
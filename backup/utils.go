@@ -3,14 +3,62 @@ package backup
 import (
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/encrypting"
 	"github.com/gosimple/slug"
 )
 
-func getDestFilePath(folderName, destFolderPath, ext string) string {
+// archiveExt returns the full extension of the local archive file (e.g. ".tar.gz").
+// Local archives are named after an UUID, so everything after the first dot is the extension.
+func archiveExt(archiveFile string) string {
+
+	base := filepath.Base(archiveFile)
+	if i := strings.Index(base, "."); i >= 0 {
+		return base[i:]
+	}
+
+	return ""
+}
+
+func getDestFilePath(destFolderPath, name, ext string) string {
 	return strings.ReplaceAll(
-		filepath.Join(destFolderPath, slug.Make(folderName)+".harpo"+ext),
+		filepath.Join(destFolderPath, name+".harpo"+ext),
 		"\\",
 		"/",
 	)
 }
+
+// latestExt returns the extension of the latest alias of the archives with the given extension.
+// The alias keeps the layout of the first releases, which only kept the last extension of the archive (e.g. ".gz" for ".tar.gz").
+// The encryption extension follows it.
+func latestExt(ext string) string {
+
+	encrypted := strings.HasSuffix(strings.ToLower(ext), encrypting.Ext)
+	if encrypted {
+		ext = ext[:len(ext)-len(encrypting.Ext)]
+	}
+	ext = filepath.Ext(ext)
+	if encrypted {
+		ext += encrypting.Ext
+	}
+
+	return ext
+}
+
+// getLatestFilePath returns the path of the "latest" alias of the folder archives with the given extension
+func getLatestFilePath(folder config.Folder, ext string) string {
+	return getDestFilePath(folder.Destination, slug.Make(folder.Name), latestExt(ext))
+}
+
+// getVersionedFilePath returns the path of the folder archive produced by the given run
+func getVersionedFilePath(folder config.Folder, runID string, start time.Time, ext string) (string, error) {
+
+	name, err := folder.RenderName(runID, start)
+	if err != nil {
+		return "", err
+	}
+
+	return getDestFilePath(folder.Destination, name, ext), nil
+}
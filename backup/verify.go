@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Polo44444/harpo/config"
+	"github.com/Polo44444/harpo/models"
+	"github.com/Polo44444/harpo/storing"
+)
+
+// manifestArchive returns the folder, the storage and the key of the archive whose manifest is read.
+// Without key, the newest archive of the folder is selected.
+func (e *Engine) manifestArchive(ctx context.Context, folderName, storageName, key string) (config.Folder, storing.Provider, string, error) {
+
+	folder, ok := e.getFolder(folderName)
+	if !ok {
+		return folder, nil, "", fmt.Errorf("folder %s is not valid or have not been declared", folderName)
+	}
+	if folder.IsRepository() {
+		return folder, nil, "", fmt.Errorf("folder %s is backed up inside a repository, its snapshots have no manifest", folderName)
+	}
+
+	storage, ok := e.storages[storageName]
+	if !ok {
+		return folder, nil, "", fmt.Errorf("storage %s is not valid or have not been declared", storageName)
+	}
+
+	if key != "" {
+		return folder, storage, key, nil
+	}
+
+	archives, err := listArchives(ctx, folder, storage)
+	if err != nil {
+		return folder, nil, "", err
+	}
+	for _, a := range archives {
+		if !a.Latest {
+			return folder, storage, a.Key, nil
+		}
+	}
+
+	return folder, nil, "", fmt.Errorf("no archive found")
+}
+
+// Manifest returns the manifest of the archive of the given folder stored on the given storage under the key.
+// Without key, the manifest of the newest archive is returned. The files of encrypted archives are decrypted
+// with the identities or the passphrase of the encryption. When they can not be, the manifest is returned without them, along with the error.
+func (e *Engine) Manifest(folderName, storageName, key string) (*Manifest, error) {
+
+	ctx, cancel := context.WithTimeout(e.ctx, pruneTimeout)
+	defer cancel()
+
+	folder, storage, key, err := e.manifestArchive(ctx, folderName, storageName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := loadManifest(ctx, storage, key)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("archive %s has no manifest", key)
+	}
+
+	return m, m.decryptFiles(filesEncryption(folder, e.getEncryption(folder, storageName)))
+}
+
+// Verify downloads the archive of the given folder stored on the given storage under the key
+// and checks its size and its SHA-256 against its manifest. Without key, the newest archive is verified.
+// Encrypted archives are verified as stored, without being decrypted.
+func (e *Engine) Verify(folderName, storageName, key string) (*Manifest, error) {
+
+	ctx, cancel := context.WithTimeout(e.ctx, downloadTimeout)
+	defer cancel()
+
+	_, storage, key, err := e.manifestArchive(ctx, folderName, storageName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyArchive(ctx, storage, key)
+}
+
+// verifyArchive downloads the archive stored under the key and checks it against its manifest
+func verifyArchive(ctx context.Context, stor storing.Provider, key string) (*Manifest, error) {
+
+	m, err := loadManifest(ctx, stor, key)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("archive %s has no manifest", key)
+	}
+
+	sum := newHashWriter()
+	err = stor.DownloadWithWriter(ctx, key, sum)
+	if err != nil {
+		return m, fmt.Errorf("unable to download archive %s: %w", key, err)
+	}
+
+	err = m.checkArchive(sum.size, sum.sum())
+	if err != nil {
+		return m, fmt.Errorf("archive %s is corrupted: %w", key, err)
+	}
+
+	return m, nil
+}
+
+// verifyUpload checks the archive uploaded under the key against the local one.
+// The quick mode compares its size and the checksums known by the storage, the deep mode downloads and hashes it.
+func verifyUpload(ctx context.Context, stor storing.Provider, key string, sum fileSum, mode string) error {
+
+	info, err := stor.Info(ctx, key)
+	if err != nil {
+		return fmt.Errorf("unable to get info of archive %s: %w", key, err)
+	}
+	if info.Size != sum.size {
+		return fmt.Errorf("archive %s is %d bytes on the storage, %d bytes locally", key, info.Size, sum.size)
+	}
+	if remote := info.Metadata[models.SHA256Metadata]; remote != "" && remote != sum.sha256 {
+		return fmt.Errorf("archive %s SHA-256 is %s on the storage, %s locally", key, remote, sum.sha256)
+	}
+	if remote := info.Metadata[models.MD5Metadata]; remote != "" && remote != sum.md5 {
+		return fmt.Errorf("archive %s MD5 is %s on the storage, %s locally", key, remote, sum.md5)
+	}
+
+	if mode != config.DeepVerification {
+		return nil
+	}
+
+	h := newHashWriter()
+	err = stor.DownloadWithWriter(ctx, key, h)
+	if err != nil {
+		return fmt.Errorf("unable to download archive %s: %w", key, err)
+	}
+	if h.size != sum.size || h.sum() != sum.sha256 {
+		return fmt.Errorf("archive %s downloaded from the storage does not match the local archive", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/Polo44444/harpo/models"
+	"github.com/Polo44444/harpo/storing"
+)
+
+// corruptingProvider flips the last byte of the stored archives.
+// With checksum, it reports the SHA-256 of what it stored like S3 does.
+type corruptingProvider struct {
+	storing.Provider
+	checksum bool
+}
+
+func (c *corruptingProvider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
+
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	if !isManifestKey(filePath) && len(content) > 0 {
+		content[len(content)-1] ^= 0xff
+	}
+
+	return c.Provider.UploadWithReader(ctx, filePath, bytes.NewReader(content), contentType)
+}
+
+func (c *corruptingProvider) Info(ctx context.Context, filePath string) (*models.FileInfo, error) {
+
+	info, err := c.Provider.Info(ctx, filePath)
+	if err != nil || !c.checksum {
+		return info, err
+	}
+
+	h := newHashWriter()
+	err = c.Provider.DownloadWithWriter(ctx, filePath, h)
+	if err != nil {
+		return nil, err
+	}
+	info.Metadata[models.SHA256Metadata] = h.sum()
+
+	return info, nil
+}
+
+func TestVerifyUpload(t *testing.T) {
+
+	for _, streaming := range []bool{false, true} {
+
+		// Sound archives pass both modes and the paths are cleared
+		for _, mode := range []string{"QUICK", "DEEP"} {
+
+			e, folder := testEngine(t, "ZIP")
+			folder.Streaming = streaming
+			folder.Verify = mode
+			folder.Remove = true
+			e.folders[0] = folder
+			e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+			entries, err := os.ReadDir(folder.Path)
+			if err != nil || len(entries) != 0 {
+				t.Fatalf("streaming %v, %s: path has not been cleared: %v", streaming, mode, err)
+			}
+		}
+
+		for _, test := range []struct {
+			mode     string
+			checksum bool
+			detected bool
+		}{
+			{"", false, false},
+			{"QUICK", false, false}, // Same size and no checksum to compare
+			{"QUICK", true, true},
+			{"DEEP", false, true},
+		} {
+
+			e, folder := testEngine(t, "ZIP")
+			folder.Streaming = streaming
+			folder.Verify = test.mode
+			folder.Remove = true
+			e.folders[0] = folder
+			e.storages["local"] = &corruptingProvider{Provider: e.storages["local"], checksum: test.checksum}
+			e.ProcessFolder(folder, e.getFolderStorages(folder), e.getFolderNotifiers(folder))
+
+			// A detected mismatch keeps the content of the paths
+			entries, err := os.ReadDir(folder.Path)
+			if err != nil {
+				t.Fatalf("Error reading path: %s", err.Error())
+			}
+			if kept := len(entries) != 0; kept != test.detected {
+				t.Fatalf("streaming %v, %s, checksum %v: content kept %v, want %v", streaming, test.mode, test.checksum, kept, test.detected)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Polo44444/harpo/backup"
+	"github.com/Polo44444/harpo/config"
+)
+
+// check checks the integrity of the repository of a folder
+func check(settings *config.Settings, args []string) error {
+
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	folderName := fs.String("folder", "", "name of the folder backed up inside a repository")
+	storageName := fs.String("storage", "", "name of the storage holding the repository")
+	readData := fs.Bool("read-data", false, "download every chunk and check it against its hash")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-c config] check -folder name -storage name [-read-data]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if strings.TrimSpace(*folderName) == "" || strings.TrimSpace(*storageName) == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	storages := settings.GetStorageProviders()
+	defer func() {
+		for _, storage := range storages {
+			storage.Close(context.Background())
+		}
+	}()
+
+	bck := backup.NewEngine(settings.Folders, storages, nil)
+	stats, err := bck.Check(*folderName, *storageName, *readData)
+	if err != nil {
+		return fmt.Errorf("repository of folder %s is not valid: %w", *folderName, err)
+	}
+
+	log.Printf("Repository of folder %s is valid: %d snapshot(s), %d chunk(s), %d chunk(s) read, %d unreferenced chunk(s)\n",
+		*folderName, stats.Snapshots, stats.Chunks, stats.Read, stats.Unreferenced)
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"archive/zip"
+	"fmt"
+	"strings"
+
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/models"
+)
+
+// Compression algorithms
+const (
+	DeflateAlgorithm = "DEFLATE" // ZIP
+	StoreAlgorithm   = "STORE"   // ZIP, without compression
+	GzAlgorithm      = "GZ"      // TAR
+	ZstdAlgorithm    = "ZSTD"    // TAR
+	XzAlgorithm      = "XZ"      // TAR
+	Bz2Algorithm     = "BZ2"     // TAR
+	Lz4Algorithm     = "LZ4"     // TAR
+	BrotliAlgorithm  = "BROTLI"  // TAR
+	Lzma2Algorithm   = "LZMA2"   // SEVENZIP
+)
+
+// tarAlgorithms holds the compression of the TAR archives of each algorithm name
+var tarAlgorithms = map[string]archiving.CompressionType{
+	"":              archiving.GzCompressionType,
+	GzAlgorithm:     archiving.GzCompressionType,
+	"GZIP":          archiving.GzCompressionType,
+	ZstdAlgorithm:   archiving.ZstdCompressionType,
+	"ZST":           archiving.ZstdCompressionType,
+	XzAlgorithm:     archiving.XzCompressionType,
+	Bz2Algorithm:    archiving.Bz2CompressionType,
+	"BZIP2":         archiving.Bz2CompressionType,
+	Lz4Algorithm:    archiving.Lz4CompressionType,
+	BrotliAlgorithm: archiving.BrotliCompressionType,
+	"BR":            archiving.BrotliCompressionType,
+}
+
+// multithreadedCompressions holds the compressions of the TAR archives which can use several goroutines
+var multithreadedCompressions = map[archiving.CompressionType]bool{
+	archiving.GzCompressionType:   true,
+	archiving.ZstdCompressionType: true,
+}
+
+// Compression describes how the archives of a folder are compressed
+type Compression struct {
+	Algorithm     string `json:"algorithm" yaml:"algorithm"`         // ZIP: DEFLATE | STORE. TAR: GZ | ZSTD | XZ | BZ2 | LZ4 | BROTLI. SEVENZIP: LZMA2. Default is DEFLATE for ZIP, GZ for TAR and LZMA2 for SEVENZIP
+	Level         *int   `json:"level" yaml:"level"`                 // Level of the algorithm. Default is 9 for GZ and the algorithm default for the others
+	Multithreaded *bool  `json:"multithreaded" yaml:"multithreaded"` // TAR GZ and ZSTD only. Compress with several goroutines. Default is true for them
+	Password      string `json:"password" yaml:"password"`           // SEVENZIP only. Encrypts the content and the file names of the archives with AES-256
+}
+
+// CompressionAlgorithm returns the compression algorithm of the archives of the folder, default included
+func (f *Folder) CompressionAlgorithm() string {
+
+	algorithm := strings.ToUpper(strings.TrimSpace(f.Compression.Algorithm))
+	if algorithm != "" {
+		return algorithm
+	}
+
+	switch strings.ToUpper(f.Archiver) {
+	case string(archiving.SevenZipProvider):
+		return Lzma2Algorithm
+	case string(archiving.TarProvider):
+		return GzAlgorithm
+	default:
+		return DeflateAlgorithm
+	}
+}
+
+// ArchiverConfig returns the archiver of the folder and its configuration, built from the compression settings
+func (f *Folder) ArchiverConfig() (models.ProviderEntity, models.ProviderConfig, error) {
+
+	c := f.Compression
+	algorithm := strings.ToUpper(c.Algorithm)
+
+	if c.Password != "" && strings.ToUpper(f.Archiver) != string(archiving.SevenZipProvider) {
+		return "", nil, fmt.Errorf("password is only supported by SEVENZIP archives")
+	}
+
+	switch strings.ToUpper(f.Archiver) {
+	case string(archiving.SevenZipProvider):
+
+		if algorithm != "" && algorithm != Lzma2Algorithm {
+			return "", nil, fmt.Errorf("compression algorithm %s is not supported by SEVENZIP archives", c.Algorithm)
+		}
+		if c.Multithreaded != nil && *c.Multithreaded {
+			return "", nil, fmt.Errorf("multithreaded compression is not supported by SEVENZIP archives")
+		}
+
+		level := archiving.DefaultLevel
+		if c.Level != nil {
+			level = *c.Level
+		}
+
+		return archiving.SevenZipProvider, archiving.BuildSevenZipConfig(level, c.Password), nil
+	case string(archiving.TarProvider):
+
+		compression, ok := tarAlgorithms[algorithm]
+		if !ok {
+			return "", nil, fmt.Errorf("compression algorithm %s is not supported by TAR archives", c.Algorithm)
+		}
+
+		level := archiving.DefaultLevel
+		if compression == archiving.GzCompressionType {
+			level = 9
+		}
+		if c.Level != nil {
+			level = *c.Level
+		}
+
+		// The other compressions are single threaded, so they are never reported as multithreaded
+		multithreaded := multithreadedCompressions[compression]
+		if c.Multithreaded != nil {
+			if *c.Multithreaded && !multithreaded {
+				return "", nil, fmt.Errorf("multithreaded compression is not supported by %s", c.Algorithm)
+			}
+			multithreaded = *c.Multithreaded
+		}
+
+		return archiving.TarProvider, archiving.BuildTarConfig(level, compression, multithreaded), nil
+	default:
+
+		level := archiving.DefaultLevel
+		if c.Level != nil {
+			level = *c.Level
+		}
+		if c.Multithreaded != nil && *c.Multithreaded {
+			return "", nil, fmt.Errorf("multithreaded compression is not supported by ZIP archives")
+		}
+
+		switch algorithm {
+		case "", DeflateAlgorithm:
+			return archiving.ZipProvider, archiving.BuildZipConfig(zip.Deflate, level), nil
+		case StoreAlgorithm:
+			return archiving.ZipProvider, archiving.BuildZipConfig(zip.Store, level), nil
+		default:
+			return "", nil, fmt.Errorf("compression algorithm %s is not supported by ZIP archives", c.Algorithm)
+		}
+	}
+}
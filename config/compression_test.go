@@ -0,0 +1,96 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/Polo44444/harpo/archiving"
+)
+
+func TestArchiverConfig(t *testing.T) {
+
+	level := func(l int) *int { return &l }
+	enabled := true
+
+	cases := []struct {
+		name        string
+		archiver    string
+		compression Compression
+		valid       bool
+	}{
+		{"zip default", "ZIP", Compression{}, true},
+		{"zip deflate level", "zip", Compression{Algorithm: "deflate", Level: level(9)}, true},
+		{"zip store", "ZIP", Compression{Algorithm: "STORE"}, true},
+		{"zip store level", "ZIP", Compression{Algorithm: "STORE", Level: level(5)}, false},
+		{"zip deflate level out of range", "ZIP", Compression{Level: level(10)}, false},
+		{"zip multithreaded", "ZIP", Compression{Multithreaded: &enabled}, false},
+		{"zip unknown algorithm", "ZIP", Compression{Algorithm: "GZ"}, false},
+		{"tar default", "TAR", Compression{}, true},
+		{"tar gz fast", "TAR", Compression{Algorithm: "gzip", Level: level(1)}, true},
+		{"tar gz level out of range", "TAR", Compression{Level: level(12)}, false},
+		{"tar unknown algorithm", "TAR", Compression{Algorithm: "STORE"}, false},
+		{"tar zstd", "TAR", Compression{Algorithm: "zstd", Level: level(19), Multithreaded: &enabled}, true},
+		{"tar zstd level out of range", "TAR", Compression{Algorithm: "ZSTD", Level: level(30)}, false},
+		{"tar xz", "TAR", Compression{Algorithm: "XZ"}, true},
+		{"tar xz level", "TAR", Compression{Algorithm: "XZ", Level: level(6)}, false},
+		{"tar xz multithreaded", "TAR", Compression{Algorithm: "XZ", Multithreaded: &enabled}, false},
+		{"tar bz2 multithreaded", "TAR", Compression{Algorithm: "BZ2", Multithreaded: &enabled}, false},
+		{"tar lz4 multithreaded", "TAR", Compression{Algorithm: "LZ4", Multithreaded: &enabled}, false},
+		{"tar brotli multithreaded", "TAR", Compression{Algorithm: "BROTLI", Multithreaded: &enabled}, false},
+		{"tar bzip2", "TAR", Compression{Algorithm: "bzip2", Level: level(9)}, true},
+		{"tar lz4", "TAR", Compression{Algorithm: "LZ4", Level: level(1)}, true},
+		{"tar brotli", "TAR", Compression{Algorithm: "BROTLI", Level: level(11)}, true},
+		{"tar password", "TAR", Compression{Password: "secret"}, false},
+		{"7z default", "SEVENZIP", Compression{}, true},
+		{"7z lzma2 password", "sevenzip", Compression{Algorithm: "lzma2", Level: level(9), Password: "secret"}, true},
+		{"7z level out of range", "SEVENZIP", Compression{Level: level(0)}, false},
+		{"7z unknown algorithm", "SEVENZIP", Compression{Algorithm: "DEFLATE"}, false},
+		{"7z multithreaded", "SEVENZIP", Compression{Multithreaded: &enabled}, false},
+	}
+
+	for _, c := range cases {
+
+		f := Folder{Archiver: c.archiver, Compression: c.compression}
+		entity, conf, err := f.ArchiverConfig()
+		if err == nil {
+			_, err = archiving.GetProvider(entity, conf)
+		}
+
+		if c.valid && err != nil {
+			t.Fatalf("%s: unexpected error: %s", c.name, err.Error())
+		}
+		if !c.valid && err == nil {
+			t.Fatalf("%s: invalid compression accepted", c.name)
+		}
+	}
+}
+
+func TestArchiverConfigMultithreaded(t *testing.T) {
+
+	disabled := false
+	cases := []struct {
+		algorithm     string
+		multithreaded *bool
+		want          bool
+	}{
+		{"", nil, true},
+		{"ZSTD", nil, true},
+		{"ZSTD", &disabled, false},
+		{"XZ", nil, false},
+		{"BZ2", nil, false},
+		{"LZ4", nil, false},
+		{"BROTLI", nil, false},
+		{"BROTLI", &disabled, false},
+	}
+
+	for _, c := range cases {
+
+		f := Folder{Archiver: "TAR", Compression: Compression{Algorithm: c.algorithm, Multithreaded: c.multithreaded}}
+		_, conf, err := f.ArchiverConfig()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", c.algorithm, err.Error())
+		}
+		if conf["multithreaded"] != c.want {
+			t.Fatalf("%s: multithreaded is %v, want %v", c.algorithm, conf["multithreaded"], c.want)
+		}
+	}
+}
@@ -23,6 +23,7 @@ type Settings struct {
 	Folders   []Folder            `json:"folders" yaml:"folders"`
 	Storages  map[string]Storage  `json:"storages" yaml:"storages"`
 	Notifiers map[string]Notifier `json:"notifiers" yaml:"notifiers"`
+	Verify    []VerifyJob         `json:"verify" yaml:"verify"`
 }
 
 func Load(path string) (*Settings, error) {
@@ -70,6 +71,15 @@ func (s *Settings) Validate() error {
 		}
 	}
 
+	// Validate verify jobs
+	for _, job := range s.Verify {
+
+		err := job.Validate(s.Folders, s.Storages, s.Notifiers)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -91,6 +101,36 @@ func (s *Settings) GetStorageProviders() map[string]storing.Provider {
 			if err != nil {
 				continue
 			}
+		case string(storing.LocalProvider):
+
+			p, err = storing.GetProvider(storing.LocalProvider, storage.Settings)
+			if err != nil {
+				continue
+			}
+		case string(storing.SFTPProvider):
+
+			p, err = storing.GetProvider(storing.SFTPProvider, storage.Settings)
+			if err != nil {
+				continue
+			}
+		case string(storing.WebDAVProvider):
+
+			p, err = storing.GetProvider(storing.WebDAVProvider, storage.Settings)
+			if err != nil {
+				continue
+			}
+		case string(storing.AzureProvider):
+
+			p, err = storing.GetProvider(storing.AzureProvider, storage.Settings)
+			if err != nil {
+				continue
+			}
+		case string(storing.GCSProvider):
+
+			p, err = storing.GetProvider(storing.GCSProvider, storage.Settings)
+			if err != nil {
+				continue
+			}
 		default:
 			continue
 		}
@@ -101,6 +141,19 @@ func (s *Settings) GetStorageProviders() map[string]storing.Provider {
 	return providers
 }
 
+// GetStorageEncryptions returns the encryption settings of the storages which override the folders one
+func (s *Settings) GetStorageEncryptions() map[string]Encryption {
+
+	encryptions := map[string]Encryption{}
+	for name, storage := range s.Storages {
+		if storage.Encryption.Enabled() {
+			encryptions[name] = storage.Encryption
+		}
+	}
+
+	return encryptions
+}
+
 // GetNotifierProviders returns the notifier providers
 func (s *Settings) GetNotifierProviders() map[string]alerting.Provider {
 
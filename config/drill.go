@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Archives picked by the restore drills
+const (
+	LatestDrill = "LATEST" // The newest archive. Default
+	RandomDrill = "RANDOM" // Any archive still stored
+)
+
+// Comparisons of the files restored by the drills
+const (
+	ManifestComparison = "MANIFEST" // Against the manifest of the archive. Default for archives
+	LiveComparison     = "LIVE"     // Against the files of the folder not modified since the backup. Default for repositories
+)
+
+// Drill periodically restores an archive of the folder inside a temporary directory and compares the restored files
+type Drill struct {
+	Schedule string `json:"schedule" yaml:"schedule"` // Cron expression. Disabled when empty
+	Storage  string `json:"storage" yaml:"storage"`   // Storage to restore from. Default is the first storage of the folder
+	Pick     string `json:"pick" yaml:"pick"`         // LATEST | RANDOM. Default is LATEST
+	Compare  string `json:"compare" yaml:"compare"`   // MANIFEST | LIVE. Default is MANIFEST, LIVE for repositories
+}
+
+// Enabled returns true when the drill is scheduled
+func (d *Drill) Enabled() bool {
+	return strings.TrimSpace(d.Schedule) != ""
+}
+
+// PickMode returns the archive picked by the drill
+func (d *Drill) PickMode() string {
+
+	pick := strings.ToUpper(strings.TrimSpace(d.Pick))
+	if pick == "" {
+		return LatestDrill
+	}
+	return pick
+}
+
+// DrillStorage returns the storage the drill of the folder restores from
+func (f *Folder) DrillStorage() string {
+
+	if f.Drill.Storage != "" || len(f.Storages) == 0 {
+		return f.Drill.Storage
+	}
+	return f.Storages[0]
+}
+
+// DrillComparison returns what the files restored by the drill of the folder are compared with
+func (f *Folder) DrillComparison() string {
+
+	compare := strings.ToUpper(strings.TrimSpace(f.Drill.Compare))
+	if compare != "" {
+		return compare
+	}
+	if f.IsRepository() {
+		return LiveComparison
+	}
+	return ManifestComparison
+}
+
+// validateDrill checks the drill of the folder
+func (f *Folder) validateDrill() error {
+
+	if !f.Drill.Enabled() {
+		return nil
+	}
+
+	switch f.Drill.PickMode() {
+	case LatestDrill, RandomDrill:
+	default:
+		return fmt.Errorf("pick %s is not supported", f.Drill.Pick)
+	}
+
+	switch f.DrillComparison() {
+	case LiveComparison:
+		if len(f.DatabaseDumps()) > 0 {
+			return fmt.Errorf("database dumps can not be compared with the live databases, compare with MANIFEST")
+		}
+	case ManifestComparison:
+		if f.IsRepository() {
+			return fmt.Errorf("snapshots of repositories have no manifest, compare with LIVE")
+		}
+	default:
+		return fmt.Errorf("compare %s is not supported", f.Drill.Compare)
+	}
+
+	storage := f.DrillStorage()
+	for _, name := range f.Storages {
+		if name == storage {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("storage %s is not a storage of the folder", storage)
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestDrill(t *testing.T) {
+
+	f := Folder{Storages: []string{"s3", "local"}, Drill: Drill{Schedule: "0 4 * * 6", Pick: "random"}}
+	if err := f.validateDrill(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if f.DrillStorage() != "s3" || f.Drill.PickMode() != RandomDrill || f.DrillComparison() != ManifestComparison {
+		t.Fatalf("Unexpected drill defaults: %s %s %s", f.DrillStorage(), f.Drill.PickMode(), f.DrillComparison())
+	}
+
+	// Snapshots have no manifest
+	f.Format = RepositoryFormat
+	if f.DrillComparison() != LiveComparison {
+		t.Fatalf("Repository drill compares with %s", f.DrillComparison())
+	}
+
+	for _, invalid := range []Folder{
+		{Storages: []string{"s3"}, Drill: Drill{Schedule: "0 4 * * 6", Pick: "OLDEST"}},
+		{Storages: []string{"s3"}, Drill: Drill{Schedule: "0 4 * * 6", Compare: "INDEX"}},
+		{Storages: []string{"s3"}, Drill: Drill{Schedule: "0 4 * * 6", Storage: "gcs"}},
+		{Storages: []string{"s3"}, Format: RepositoryFormat, Drill: Drill{Schedule: "0 4 * * 6", Compare: ManifestComparison}},
+	} {
+		if err := invalid.validateDrill(); err == nil {
+			t.Fatalf("Invalid drill accepted: %+v", invalid.Drill)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"github.com/Polo44444/harpo/dumping"
+	"github.com/Polo44444/harpo/models"
+)
+
+// DatabaseDump is a database server whose dumps are archived along with the paths of a folder.
+// Each database is dumped inside a file of the directory named after the server kind inside the archive.
+type DatabaseDump struct {
+	Name   string // Name of the directory of the dumps inside the archive
+	Entity models.ProviderEntity
+	Config models.ProviderConfig
+}
+
+// DatabaseDumps returns the database servers dumped by the folder
+func (f *Folder) DatabaseDumps() []DatabaseDump {
+
+	dumps := []DatabaseDump{}
+	if f.Postgres.Enabled() {
+		dumps = append(dumps, DatabaseDump{Name: PostgresDumpName, Entity: dumping.PostgresProvider, Config: f.Postgres.ProviderConfig()})
+	}
+	if f.MySQL.Enabled() {
+		dumps = append(dumps, DatabaseDump{Name: MySQLDumpName, Entity: dumping.MySQLProvider, Config: f.MySQL.ProviderConfig()})
+	}
+
+	return dumps
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Polo44444/harpo/encrypting"
+)
+
+// Encryption describes how the archives are encrypted with age before being uploaded.
+// Only public keys or a passphrase are needed to encrypt. Identities are only read when restoring.
+type Encryption struct {
+	Recipients []string `json:"recipients" yaml:"recipients"` // age X25519 public keys (age1...)
+	Passphrase string   `json:"passphrase" yaml:"passphrase"` // scrypt passphrase. Can not be used with recipients
+	Identities []string `json:"identities" yaml:"identities"` // Files holding the age private keys. Only used by restore
+}
+
+// Enabled returns true when the archives are encrypted
+func (e *Encryption) Enabled() bool {
+	return len(e.Recipients) > 0 || e.Passphrase != ""
+}
+
+// Key returns a key identifying the encryption settings. Archives with the same key are encrypted once.
+func (e *Encryption) Key() string {
+	return strings.Join(e.Recipients, ",") + "|" + e.Passphrase
+}
+
+// Validate checks if the encryption settings are valid
+func (e *Encryption) Validate() error {
+
+	if !e.Enabled() {
+		return nil
+	}
+
+	_, err := encrypting.NewRecipients(e.Recipients, e.Passphrase)
+	if err != nil {
+		return fmt.Errorf("invalid encryption: %w", err)
+	}
+
+	return nil
+}
+
+// Merge returns the encryption of the storage when set, otherwise the encryption of the folder
+func (e *Encryption) Merge(storage Encryption) Encryption {
+
+	if storage.Enabled() {
+		return storage
+	}
+
+	return *e
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Polo44444/harpo/archiving"
+)
+
+// sizeUnits holds the multiplier of each size unit, longest first
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseSize parses a size such as 512, 100MB or 2GiB. Empty is 0
+func parseSize(s string) (int64, error) {
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.multiplier
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// parseAge parses a duration such as 12h, 30d or 2w. Empty is 0
+func parseAge(s string) (time.Duration, error) {
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if value, ok := strings.CutSuffix(s, suffix); ok {
+
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d, nil
+}
+
+// ArchiveFilter returns the filter selecting the files of the folder archives
+func (f *Folder) ArchiveFilter() (*archiving.Filter, error) {
+
+	filter := &archiving.Filter{
+		Include: f.Include,
+		Exclude: f.Exclude,
+	}
+
+	var err error
+	if filter.MaxSize, err = parseSize(f.MaxFileSize); err != nil {
+		return nil, fmt.Errorf("max_file_size: %w", err)
+	}
+	if filter.MaxAge, err = parseAge(f.MaxFileAge); err != nil {
+		return nil, fmt.Errorf("max_file_age: %w", err)
+	}
+	if filter.MinAge, err = parseAge(f.MinFileAge); err != nil {
+		return nil, fmt.Errorf("min_file_age: %w", err)
+	}
+	if filter.MaxAge > 0 && filter.MinAge >= filter.MaxAge {
+		return nil, fmt.Errorf("min_file_age must be lower than max_file_age")
+	}
+
+	if err = archiving.ValidatePatterns(f.Include); err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+	if err = archiving.ValidatePatterns(f.Exclude); err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchiveFilter(t *testing.T) {
+
+	f := Folder{
+		Include:     []string{"src/", "*.go"},
+		Exclude:     []string{"node_modules/", "!keep/"},
+		MaxFileSize: "1.5MiB",
+		MaxFileAge:  "30d",
+		MinFileAge:  "1h",
+	}
+
+	filter, err := f.ArchiveFilter()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if filter.MaxSize != 3<<19 || filter.MaxAge != 30*24*time.Hour || filter.MinAge != time.Hour {
+		t.Fatalf("Unexpected filter: %+v", filter)
+	}
+
+	for _, invalid := range []Folder{
+		{MaxFileSize: "big"},
+		{MaxFileSize: "-1MB"},
+		{MaxFileAge: "1y"},
+		{MaxFileAge: "1h", MinFileAge: "2h"},
+		{Exclude: []string{"/"}},
+	} {
+		if _, err := invalid.ArchiveFilter(); err == nil {
+			t.Fatalf("Invalid filter accepted: %+v", invalid)
+		}
+	}
+
+	sizes := map[string]int64{"": 0, "512": 512, "10kb": 10000, "2K": 2048, "1GiB": 1 << 30}
+	for s, expected := range sizes {
+		size, err := parseSize(s)
+		if err != nil || size != expected {
+			t.Fatalf("Size %q: got %d %v, want %d", s, size, err, expected)
+		}
+	}
+}
@@ -3,22 +3,145 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/Polo44444/harpo/archiving"
+	"github.com/gosimple/slug"
 )
 
+// Source is a path of a folder, archived under its name
+type Source struct {
+	Path string `json:"path" yaml:"path"`
+	Name string `json:"name" yaml:"name"` // Name inside the archive. Default is the base name of the path
+}
+
 type Folder struct {
-	Name                string `json:"name" yaml:"name"`
-	Path                string `json:"path" yaml:"path"`
-	Remove              bool   `json:"remove" yaml:"remove"`
-	IgnoreArchiveErrors bool   `json:"ignore_archive_errors" yaml:"ignore_archive_errors"`
-	Destination         string `json:"destination" yaml:"destination"`
-	Schedule            string `json:"schedule" yaml:"schedule"`
-	Archiver            string `json:"archiver" yaml:"archiver"`
-	// TODO: Give the ability to add compression level
-	Storages  []string `json:"storages" yaml:"storages"`
-	Notifiers []string `json:"notifiers" yaml:"notifiers"`
+	Name                string      `json:"name" yaml:"name"`
+	Path                string      `json:"path" yaml:"path"`
+	Paths               []Source    `json:"paths" yaml:"paths"` // Several paths archived together, each under its name. Used instead of path
+	Remove              bool        `json:"remove" yaml:"remove"`
+	IgnoreArchiveErrors bool        `json:"ignore_archive_errors" yaml:"ignore_archive_errors"`
+	Destination         string      `json:"destination" yaml:"destination"`
+	NameTemplate        string      `json:"name_template" yaml:"name_template"`
+	DisableLatest       bool        `json:"disable_latest" yaml:"disable_latest"`
+	Streaming           bool        `json:"streaming" yaml:"streaming"` // When true, the archive is uploaded while being written, without temporary file
+	Format              string      `json:"format" yaml:"format"`       // ARCHIVE | REPOSITORY. Default is ARCHIVE
+	Repository          Repository  `json:"repository" yaml:"repository"`
+	Verify              string      `json:"verify" yaml:"verify"` // QUICK | DEEP. Uploaded archives are checked against the local archive. Disabled when empty
+	Drill               Drill       `json:"drill" yaml:"drill"`
+	Hooks               Hooks       `json:"hooks" yaml:"hooks"`
+	Postgres            Postgres    `json:"postgres" yaml:"postgres"` // PostgreSQL databases dumped and archived along with the paths
+	MySQL               MySQL       `json:"mysql" yaml:"mysql"`       // MySQL or MariaDB databases dumped and archived along with the paths
+	Schedule            string      `json:"schedule" yaml:"schedule"`
+	Include             []string    `json:"include" yaml:"include"`             // Gitignore patterns. When set, only the matching files are archived
+	Exclude             []string    `json:"exclude" yaml:"exclude"`             // Gitignore patterns of the files left out. The .harpoignore files are honored too
+	MaxFileSize         string      `json:"max_file_size" yaml:"max_file_size"` // Files larger than this size are left out, e.g. 100MB
+	MaxFileAge          string      `json:"max_file_age" yaml:"max_file_age"`   // Files modified before this duration are left out, e.g. 30d
+	MinFileAge          string      `json:"min_file_age" yaml:"min_file_age"`   // Files modified within this duration are left out, e.g. 1h
+	Archiver            string      `json:"archiver" yaml:"archiver"`
+	Compression         Compression `json:"compression" yaml:"compression"`
+	Retention           Retention   `json:"retention" yaml:"retention"`
+	Incremental         Incremental `json:"incremental" yaml:"incremental"`
+	Encryption          Encryption  `json:"encryption" yaml:"encryption"`
+	Storages            []string    `json:"storages" yaml:"storages"`
+	Notifiers           []string    `json:"notifiers" yaml:"notifiers"`
+
+	DumpStreams map[string]map[string]archiving.StreamFunc `json:"-" yaml:"-"` // Database dumps of the run by server, streamed into the archive. Only set while the folder is backed up
+}
+
+// Sources returns the paths to archive with their names inside the archive.
+// Folders only dumping databases have none. While the folder is backed up, the dumps of the run follow the paths.
+func (f *Folder) Sources() []archiving.Source {
+
+	sources := []archiving.Source{}
+	if len(f.Paths) > 0 {
+		for _, p := range f.Paths {
+			sources = append(sources, archiving.Source{Path: p.Path, Name: p.Name})
+		}
+	} else if f.Path != "" || len(f.DatabaseDumps()) == 0 {
+		sources = append(sources, archiving.Source{Path: f.Path})
+	}
+
+	// The dumps are archived whole, whatever the filter of the folder
+	for _, dump := range f.DatabaseDumps() {
+		if streams, ok := f.DumpStreams[dump.Name]; ok {
+			sources = append(sources, archiving.Source{Name: dump.Name, Unfiltered: true, Streams: streams})
+		}
+	}
+
+	return sources
+}
+
+// OriginalTargets maps the names of the sources inside the archive to their paths, to restore them where they were archived
+func (f *Folder) OriginalTargets() map[string]string {
+
+	targets := map[string]string{}
+	for _, src := range f.Sources() {
+		targets[src.ArchiveName()] = src.Path
+	}
+
+	return targets
+}
+
+// sourcePaths returns the paths of the folder
+func (f *Folder) sourcePaths() []string {
+
+	paths := []string{}
+	for _, src := range f.Sources() {
+		paths = append(paths, src.Path)
+	}
+
+	return paths
+}
+
+// validateSources checks the paths of the folder and their names inside the archive
+func (f *Folder) validateSources() error {
+
+	if strings.TrimSpace(f.Path) != "" && len(f.Paths) > 0 {
+		return fmt.Errorf("path and paths can not be used together")
+	}
+
+	names := map[string]string{}
+	for _, src := range f.Sources() {
+
+		if strings.TrimSpace(src.Path) == "" {
+			return fmt.Errorf("path is empty")
+		}
+
+		fileInfo, err := os.Stat(src.Path)
+		if err != nil {
+			return fmt.Errorf("unable to check folder path %s existence: %w", src.Path, err)
+		}
+		if !fileInfo.IsDir() {
+			return fmt.Errorf("path %s is not a folder", src.Path)
+		}
+
+		name := src.ArchiveName()
+		if name == "." || name == "/" || name == ".." || path.IsAbs(name) || strings.HasPrefix(name, "../") || name == archiving.RulesFileName || name == archiving.DeletedFileName {
+			return fmt.Errorf("name %s of path %s is not valid inside the archive", name, src.Path)
+		}
+
+		// A name inside another one would mix their files
+		for other, otherPath := range names {
+			if name == other || strings.HasPrefix(name, other+"/") || strings.HasPrefix(other, name+"/") {
+				return fmt.Errorf("paths %s and %s have conflicting names %s and %s inside the archive", otherPath, src.Path, other, name)
+			}
+		}
+		names[name] = src.Path
+	}
+
+	// The dumps are archived under their own name
+	for _, dump := range f.DatabaseDumps() {
+		for other, otherPath := range names {
+			if dump.Name == other || strings.HasPrefix(other, dump.Name+"/") {
+				return fmt.Errorf("path %s has name %s inside the archive, reserved for the %s dumps", otherPath, other, dump.Name)
+			}
+		}
+	}
+
+	return nil
 }
 
 // Validate checks if the folder is valid
@@ -26,46 +149,149 @@ func (f *Folder) Validate(storages map[string]Storage, notifiers map[string]Noti
 
 	// Check name
 	if strings.TrimSpace(f.Name) == "" {
-		return fmt.Errorf("name of path %s is not valid", f.Path)
+		return fmt.Errorf("name of folder with path %s is not valid", strings.Join(f.sourcePaths(), ", "))
+	}
+
+	// Check paths
+	err := f.validateSources()
+	if err != nil {
+		return fmt.Errorf("paths of folder %s are not valid: %w", f.Name, err)
 	}
 
-	// Check path
-	fileInfo, err := os.Stat(f.Path)
+	// Check database dumps
+	err = f.Postgres.Validate()
 	if err != nil {
-		return fmt.Errorf("unable to check folder path %s existence: %w", f.Path, err)
+		return fmt.Errorf("postgres settings of folder %s are not valid: %w", f.Name, err)
 	}
-	if !fileInfo.IsDir() {
-		return fmt.Errorf("path %s is not a folder", f.Path)
+	err = f.MySQL.Validate()
+	if err != nil {
+		return fmt.Errorf("mysql settings of folder %s are not valid: %w", f.Name, err)
 	}
 
 	// Check destination
 	if strings.TrimSpace(f.Destination) == "" {
-		return fmt.Errorf("destination of path %s is not valid", f.Path)
+		return fmt.Errorf("destination of folder %s is not valid", f.Name)
+	}
+
+	// Check name template. Two runs one second apart must not overwrite each other, nor the latest alias
+	start := time.Now()
+	name, err := f.RenderName("00000000-0000-0000-0000-000000000000", start)
+	if err != nil {
+		return fmt.Errorf("name template of folder %s is not valid: %w", f.Name, err)
+	}
+	next, err := f.RenderName("11111111-1111-1111-1111-111111111111", start.Add(time.Second))
+	if err != nil {
+		return fmt.Errorf("name template of folder %s is not valid: %w", f.Name, err)
+	}
+	if name == next || name == slug.Make(f.Name) || next == slug.Make(f.Name) {
+		return fmt.Errorf("name template of folder %s must produce a distinct name for each run", f.Name)
+	}
+
+	// Check retention
+	err = f.Retention.Validate()
+	if err != nil {
+		return fmt.Errorf("retention of folder %s is not valid: %w", f.Name, err)
+	}
+	if f.Retention.Enabled() {
+		_, err = f.NamePattern()
+		if err != nil {
+			return fmt.Errorf("name template of folder %s can not be used with retention: %w", f.Name, err)
+		}
+	}
+
+	// Check incremental backups
+	err = f.Incremental.Validate()
+	if err != nil {
+		return fmt.Errorf("incremental settings of folder %s are not valid: %w", f.Name, err)
+	}
+	if f.Incremental.Enabled() {
+		if f.Remove {
+			return fmt.Errorf("incremental backups of folder %s can not be used with remove", f.Name)
+		}
+		_, err = f.NamePattern()
+		if err != nil {
+			return fmt.Errorf("name template of folder %s can not be used with incremental backups: %w", f.Name, err)
+		}
+	}
+
+	// Check encryption
+	err = f.Encryption.Validate()
+	if err != nil {
+		return fmt.Errorf("encryption of folder %s is not valid: %w", f.Name, err)
+	}
+
+	// Check format
+	err = f.validateFormat()
+	if err != nil {
+		return fmt.Errorf("format of folder %s is not valid: %w", f.Name, err)
+	}
+
+	// Check verification
+	err = f.validateVerify()
+	if err != nil {
+		return fmt.Errorf("verify of folder %s is not valid: %w", f.Name, err)
+	}
+
+	// Check restore drill
+	err = f.validateDrill()
+	if err != nil {
+		return fmt.Errorf("drill of folder %s is not valid: %w", f.Name, err)
+	}
+
+	// Check hooks
+	err = f.Hooks.Validate()
+	if err != nil {
+		return fmt.Errorf("hooks of folder %s are not valid: %w", f.Name, err)
 	}
 
 	// Check schedule
 	if strings.TrimSpace(f.Schedule) == "" {
-		return fmt.Errorf("schedule of path %s is not valid", f.Path)
+		return fmt.Errorf("schedule of folder %s is not valid", f.Name)
 	}
 
-	// Check archiver
-	if strings.TrimSpace(f.Archiver) == "" ||
-		(strings.ToUpper(f.Archiver) != string(archiving.ZipProvider) &&
-			strings.ToUpper(f.Archiver) != string(archiving.TarProvider)) {
-		return fmt.Errorf("archiver of path %s is not valid", f.Path)
+	// Check archiver and compression. Repositories store chunks instead of archives
+	if !f.IsRepository() {
+
+		// Check archiver
+		if strings.TrimSpace(f.Archiver) == "" ||
+			(strings.ToUpper(f.Archiver) != string(archiving.ZipProvider) &&
+				strings.ToUpper(f.Archiver) != string(archiving.TarProvider) &&
+				strings.ToUpper(f.Archiver) != string(archiving.SevenZipProvider)) {
+			return fmt.Errorf("archiver of folder %s is not valid", f.Name)
+		}
+
+		// The header offsets of 7z archives are written once they are complete, so they can not be streamed
+		if f.Streaming && strings.ToUpper(f.Archiver) == string(archiving.SevenZipProvider) {
+			return fmt.Errorf("SEVENZIP archiver of folder %s can not be used with streaming", f.Name)
+		}
+
+		// Check compression
+		entity, archiverConfig, err := f.ArchiverConfig()
+		if err == nil {
+			_, err = archiving.GetProvider(entity, archiverConfig)
+		}
+		if err != nil {
+			return fmt.Errorf("compression of folder %s is not valid: %w", f.Name, err)
+		}
+	}
+
+	// Check filter
+	_, err = f.ArchiveFilter()
+	if err != nil {
+		return fmt.Errorf("filter of folder %s is not valid: %w", f.Name, err)
 	}
 
 	// Check storages
 	for _, storage := range f.Storages {
 		if _, ok := storages[storage]; !ok {
-			return fmt.Errorf("storage %s of path %s is not valid or have not been declared", storage, f.Path)
+			return fmt.Errorf("storage %s of folder %s is not valid or have not been declared", storage, f.Name)
 		}
 	}
 
 	// Check notifiers
 	for _, notifier := range f.Notifiers {
 		if _, ok := notifiers[notifier]; !ok {
-			return fmt.Errorf("notifier %s of path %s is not valid or have not been declared", notifier, f.Path)
+			return fmt.Errorf("notifier %s of folder %s is not valid or have not been declared", notifier, f.Name)
 		}
 	}
 
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFolderSources(t *testing.T) {
+
+	dir := t.TempDir()
+	for _, name := range []string{"nginx", "www", "other/www"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), os.ModePerm); err != nil {
+			t.Fatalf("Error creating directory: %s", err.Error())
+		}
+	}
+	nginx, www, otherWww := filepath.Join(dir, "nginx"), filepath.Join(dir, "www"), filepath.Join(dir, "other/www")
+
+	// The single path is used when paths are not set
+	f := Folder{Path: nginx}
+	targets := f.OriginalTargets()
+	if len(targets) != 1 || targets["nginx"] != nginx {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+
+	f = Folder{Paths: []Source{{Path: nginx}, {Path: www, Name: "sites/www"}}}
+	if err := f.validateSources(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	targets = f.OriginalTargets()
+	if len(targets) != 2 || targets["nginx"] != nginx || targets["sites/www"] != www {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+
+	for _, invalid := range []Folder{
+		{},
+		{Path: nginx, Paths: []Source{{Path: www}}},
+		{Paths: []Source{{Path: filepath.Join(dir, "missing")}}},
+		{Paths: []Source{{Path: www}, {Path: otherWww}}},
+		{Paths: []Source{{Path: www, Name: "data"}, {Path: nginx, Name: "data/nginx"}}},
+		{Paths: []Source{{Path: www, Name: "../www"}}},
+		{Paths: []Source{{Path: www, Name: "/www"}}},
+	} {
+		if err := invalid.validateSources(); err == nil {
+			t.Fatalf("Invalid paths accepted: %+v", invalid)
+		}
+	}
+}
+
+func TestFolderStreaming(t *testing.T) {
+
+	storages := map[string]Storage{"local": {Type: "LOCAL"}}
+	for archiver, valid := range map[string]bool{"ZIP": true, "TAR": true, "SEVENZIP": false} {
+
+		f := Folder{
+			Name:        "dummies",
+			Path:        t.TempDir(),
+			Destination: "backup",
+			Schedule:    "@daily",
+			Archiver:    archiver,
+			Storages:    []string{"local"},
+			Streaming:   true,
+		}
+		err := f.Validate(storages, nil)
+		if valid && err != nil {
+			t.Fatalf("%s: unexpected error: %s", archiver, err.Error())
+		}
+		if !valid && (err == nil || !strings.Contains(err.Error(), "streaming")) {
+			t.Fatalf("%s: streaming accepted, error %v", archiver, err)
+		}
+	}
+}
+
+func TestFolderNameTemplate(t *testing.T) {
+
+	storages := map[string]Storage{"local": {Type: "LOCAL"}}
+	for tmpl, valid := range map[string]bool{
+		"":                               true,
+		"{{.Slug}}-{{.Time}}":            true,
+		"{{.Slug}}-{{.ShortRunID}}":      true,
+		"{{.Date}}/{{.Slug}}-{{.RunID}}": true,
+		"{{.Slug}}":                      false,
+		"backup":                         false,
+		"{{.Slug}}-{{.Date}}":            false,
+	} {
+
+		f := Folder{
+			Name:         "dummies",
+			Path:         t.TempDir(),
+			Destination:  "backup",
+			Schedule:     "@daily",
+			Archiver:     "TAR",
+			Storages:     []string{"local"},
+			NameTemplate: tmpl,
+		}
+		err := f.Validate(storages, nil)
+		if valid && err != nil {
+			t.Fatalf("%q: unexpected error: %s", tmpl, err.Error())
+		}
+		if !valid && (err == nil || !strings.Contains(err.Error(), "distinct name")) {
+			t.Fatalf("%q: template accepted, error %v", tmpl, err)
+		}
+	}
+}
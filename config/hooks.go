@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Steps of the backup of a folder running hooks
+const (
+	PreArchiveHook  = "pre_archive"  // Before the archive is written. A failure aborts the backup
+	PostArchiveHook = "post_archive" // Once the archive is written, even when it failed
+	PostUploadHook  = "post_upload"  // Once the archive is stored on the storages
+	OnSuccessHook   = "on_success"   // At the end of a backup without error
+	OnFailureHook   = "on_failure"   // At the end of a backup with errors
+)
+
+// DefaultHookTimeout is the timeout of the hooks without their own
+const DefaultHookTimeout = 5 * time.Minute
+
+// Hook is a shell command run at a step of the backup
+type Hook struct {
+	Command string `json:"command" yaml:"command"` // Run by sh -c, or cmd /C on Windows
+	Timeout string `json:"timeout" yaml:"timeout"` // e.g. 30s or 10m. Default is 5m
+}
+
+// TimeoutDuration returns the time the command is given before being killed
+func (h *Hook) TimeoutDuration() time.Duration {
+
+	d, err := parseAge(h.Timeout)
+	if err != nil || d == 0 {
+		return DefaultHookTimeout
+	}
+	return d
+}
+
+// Hooks are the commands run at the steps of the backup of a folder, one after the other
+type Hooks struct {
+	PreArchive  []Hook `json:"pre_archive" yaml:"pre_archive"`
+	PostArchive []Hook `json:"post_archive" yaml:"post_archive"`
+	PostUpload  []Hook `json:"post_upload" yaml:"post_upload"`
+	OnSuccess   []Hook `json:"on_success" yaml:"on_success"`
+	OnFailure   []Hook `json:"on_failure" yaml:"on_failure"`
+}
+
+// Validate checks if the hooks are valid
+func (h *Hooks) Validate() error {
+
+	for step, hooks := range map[string][]Hook{
+		PreArchiveHook:  h.PreArchive,
+		PostArchiveHook: h.PostArchive,
+		PostUploadHook:  h.PostUpload,
+		OnSuccessHook:   h.OnSuccess,
+		OnFailureHook:   h.OnFailure,
+	} {
+		for _, hook := range hooks {
+
+			if strings.TrimSpace(hook.Command) == "" {
+				return fmt.Errorf("command of %s hook is empty", step)
+			}
+			if _, err := parseAge(hook.Timeout); err != nil {
+				return fmt.Errorf("timeout of %s hook is not valid: %w", step, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+
+	h := Hooks{
+		PreArchive: []Hook{{Command: "docker stop db", Timeout: "2m"}},
+		OnFailure:  []Hook{{Command: "echo failed"}},
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if h.PreArchive[0].TimeoutDuration() != 2*time.Minute || h.OnFailure[0].TimeoutDuration() != DefaultHookTimeout {
+		t.Fatalf("Unexpected timeouts: %s %s", h.PreArchive[0].TimeoutDuration(), h.OnFailure[0].TimeoutDuration())
+	}
+
+	for _, invalid := range []Hooks{
+		{PostArchive: []Hook{{Command: " "}}},
+		{PostUpload: []Hook{{Command: "true", Timeout: "soon"}}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Invalid hooks accepted: %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gosimple/slug"
+)
+
+// Backup modes
+const (
+	FullMode         = ""             // Each archive holds the whole folder
+	IncrementalMode  = "INCREMENTAL"  // Each archive holds the changes since the previous one
+	DifferentialMode = "DIFFERENTIAL" // Each archive holds the changes since the last full one
+)
+
+const (
+	// DefaultFullEvery is the default count of runs of a backup chain, full one included
+	DefaultFullEvery = 7
+
+	// DefaultIndexDir is the default directory of the file indexes of the folders
+	DefaultIndexDir = ".harpo"
+)
+
+// Incremental describes the incremental or differential backups of a folder.
+// The files of each run are recorded inside a local index, to archive only the changes of the next runs.
+type Incremental struct {
+	Mode      string `json:"mode" yaml:"mode"`             // INCREMENTAL | DIFFERENTIAL. Disabled when empty
+	FullEvery int    `json:"full_every" yaml:"full_every"` // A full backup is forced every N runs. Default is 7
+	IndexDir  string `json:"index_dir" yaml:"index_dir"`   // Directory of the local indexes. Default is .harpo
+}
+
+// Enabled returns true when the archives only hold the changed files
+func (i *Incremental) Enabled() bool {
+	return strings.TrimSpace(i.Mode) != FullMode
+}
+
+// Differential returns true when the archives hold the changes since the last full backup
+func (i *Incremental) Differential() bool {
+	return strings.ToUpper(strings.TrimSpace(i.Mode)) == DifferentialMode
+}
+
+// GetFullEvery returns the count of runs of a backup chain, full one included
+func (i *Incremental) GetFullEvery() int {
+
+	if i.FullEvery <= 0 {
+		return DefaultFullEvery
+	}
+	return i.FullEvery
+}
+
+// IndexPath returns the path of the local index of the folder
+func (i *Incremental) IndexPath(folderName string) string {
+
+	dir := i.IndexDir
+	if strings.TrimSpace(dir) == "" {
+		dir = DefaultIndexDir
+	}
+
+	return filepath.Join(dir, slug.Make(folderName)+".index.json")
+}
+
+// Validate checks if the incremental settings are valid
+func (i *Incremental) Validate() error {
+
+	switch strings.ToUpper(strings.TrimSpace(i.Mode)) {
+	case FullMode, IncrementalMode, DifferentialMode:
+	default:
+		return fmt.Errorf("mode %s is not supported", i.Mode)
+	}
+
+	if i.FullEvery < 0 {
+		return fmt.Errorf("full_every can not be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIncremental(t *testing.T) {
+
+	i := Incremental{Mode: "differential"}
+	if err := i.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !i.Enabled() || !i.Differential() || i.GetFullEvery() != DefaultFullEvery {
+		t.Fatalf("Unexpected settings: %+v", i)
+	}
+	if path := i.IndexPath("My Folder"); path != filepath.Join(DefaultIndexDir, "my-folder.index.json") {
+		t.Fatalf("Unexpected index path %s", path)
+	}
+
+	if (&Incremental{}).Enabled() {
+		t.Fatalf("Incremental backups enabled without mode")
+	}
+
+	for _, invalid := range []Incremental{{Mode: "SNAPSHOT"}, {Mode: IncrementalMode, FullEvery: -1}} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Invalid settings accepted: %+v", invalid)
+		}
+	}
+}
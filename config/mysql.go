@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Polo44444/harpo/dumping"
+	"github.com/Polo44444/harpo/models"
+)
+
+// MySQLDumpName is the name of the MySQL and MariaDB dumps inside the archives
+const MySQLDumpName = "mysql"
+
+// MySQL describes the MySQL or MariaDB databases dumped along with the paths of a folder.
+// The credentials come from the settings or from an option file.
+type MySQL struct {
+	Host       string   `json:"host" yaml:"host"`
+	Port       int      `json:"port" yaml:"port"`
+	Socket     string   `json:"socket" yaml:"socket"` // Unix socket, used instead of host and port
+	User       string   `json:"user" yaml:"user"`
+	Password   string   `json:"password" yaml:"password"`
+	OptionFile string   `json:"option_file" yaml:"option_file"` // Option file with a [client] section, e.g. /etc/harpo/my.cnf. Used instead of password
+	Databases  []string `json:"databases" yaml:"databases"`     // Databases to dump. Empty dumps all the databases of the server but the system ones
+	Flavor     string   `json:"flavor" yaml:"flavor"`           // MYSQL (mysqldump) | MARIADB (mariadb-dump). Default is MYSQL
+	Options    []string `json:"options" yaml:"options"`         // Extra arguments of the dump program, e.g. --ignore-table=app.logs
+	BinDir     string   `json:"bin_dir" yaml:"bin_dir"`         // Directory of the dump program and of mysql. Default is the PATH
+}
+
+// Enabled returns true when the folder dumps MySQL databases
+func (m *MySQL) Enabled() bool {
+	return strings.TrimSpace(m.Host) != "" || strings.TrimSpace(m.Socket) != "" || strings.TrimSpace(m.User) != "" ||
+		strings.TrimSpace(m.OptionFile) != "" || len(m.Databases) > 0
+}
+
+// ProviderConfig returns the config of the dump provider
+func (m *MySQL) ProviderConfig() models.ProviderConfig {
+
+	databases := []string{}
+	if m.Databases != nil {
+		databases = m.Databases
+	}
+	options := []string{}
+	if m.Options != nil {
+		options = m.Options
+	}
+
+	return dumping.BuildMySQLConfig(m.Host, m.Port, m.Socket, m.User, m.Password, m.OptionFile, databases, m.Flavor, options, m.BinDir)
+}
+
+// Validate checks if the MySQL settings are valid
+func (m *MySQL) Validate() error {
+
+	if !m.Enabled() {
+		return nil
+	}
+
+	if m.Port < 0 || m.Port > 65535 {
+		return fmt.Errorf("port %d is not valid", m.Port)
+	}
+	for _, db := range m.Databases {
+		if strings.TrimSpace(db) == "" || strings.ContainsAny(db, `/\`) {
+			return fmt.Errorf("database name %q is not valid", db)
+		}
+	}
+	if m.OptionFile != "" {
+		_, err := os.Stat(m.OptionFile)
+		if err != nil {
+			return fmt.Errorf("unable to check option file %s existence: %w", m.OptionFile, err)
+		}
+	}
+
+	_, err := dumping.GetProvider(dumping.MySQLProvider, m.ProviderConfig())
+	return err
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Polo44444/harpo/archiving"
+)
+
+func TestMySQL(t *testing.T) {
+
+	optionFile := filepath.Join(t.TempDir(), "my.cnf")
+	if err := os.WriteFile(optionFile, []byte("[client]\nuser=backup\n"), 0o600); err != nil {
+		t.Fatalf("Error creating option file: %s", err.Error())
+	}
+
+	// Both servers are dumped, each under its name
+	f := Folder{
+		Postgres: Postgres{Host: "pg.local"},
+		MySQL:    MySQL{OptionFile: optionFile, Flavor: "mariadb"},
+		DumpStreams: map[string]map[string]archiving.StreamFunc{
+			PostgresDumpName: {"app.dump": nil},
+			MySQLDumpName:    {"app.sql": nil},
+		},
+	}
+	if err := f.MySQL.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	sources := f.Sources()
+	if len(sources) != 2 || sources[0].ArchiveName() != PostgresDumpName || sources[1].ArchiveName() != MySQLDumpName {
+		t.Fatalf("Unexpected sources: %v", sources)
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "mysql"), os.ModePerm); err != nil {
+		t.Fatalf("Error creating directory: %s", err.Error())
+	}
+	invalid := Folder{Path: filepath.Join(dir, "mysql"), MySQL: MySQL{Host: "db.local"}}
+	if err := invalid.validateSources(); err == nil {
+		t.Fatalf("Path named after the dumps accepted")
+	}
+
+	for _, invalid := range []MySQL{
+		{Host: "db.local", Flavor: "PERCONA"},
+		{Host: "db.local", Password: "secret", OptionFile: optionFile},
+		{OptionFile: filepath.Join(dir, "missing.cnf")},
+		{Databases: []string{""}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Invalid mysql settings accepted: %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gosimple/slug"
+)
+
+const (
+	// DefaultNameTemplate is the default template of the versioned archives names
+	DefaultNameTemplate = "{{.Slug}}-{{.Timestamp}}-{{.ShortRunID}}"
+
+	// TimestampLayout is the layout of the timestamp available in names templates
+	TimestampLayout = "20060102T150405Z"
+)
+
+// NameTemplateData holds the values available inside a folder name template
+type NameTemplateData struct {
+	Name       string // Folder name
+	Slug       string // Slug of the folder name
+	Timestamp  string // Run start time in UTC. Format 20060102T150405Z
+	Date       string // Run start date in UTC. Format 2006-01-02
+	Time       string // Run start time in UTC. Format 150405
+	RunID      string // Unique ID of the run
+	ShortRunID string // First 8 characters of the run ID
+}
+
+// NewNameTemplateData builds the template data of the given folder run
+func NewNameTemplateData(folderName, runID string, start time.Time) NameTemplateData {
+
+	start = start.UTC()
+	shortRunID := runID
+	if len(shortRunID) > 8 {
+		shortRunID = shortRunID[:8]
+	}
+
+	return NameTemplateData{
+		Name:       folderName,
+		Slug:       slug.Make(folderName),
+		Timestamp:  start.Format(TimestampLayout),
+		Date:       start.Format("2006-01-02"),
+		Time:       start.Format("150405"),
+		RunID:      runID,
+		ShortRunID: shortRunID,
+	}
+}
+
+// RenderName renders the name of the archive of the given run, without the extension.
+func (f *Folder) RenderName(runID string, start time.Time) (string, error) {
+
+	nameTemplate := f.NameTemplate
+	if strings.TrimSpace(nameTemplate) == "" {
+		nameTemplate = DefaultNameTemplate
+	}
+
+	tmpl, err := template.New(f.Name).Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = tmpl.Execute(buf, NewNameTemplateData(f.Name, runID, start))
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.Trim(strings.TrimSpace(buf.String()), "/")
+	if name == "" {
+		return "", fmt.Errorf("name template %s renders an empty name", nameTemplate)
+	}
+
+	return name, nil
+}
+
+// Name pattern markers. They are rendered in place of the run values then replaced by regular expressions.
+var namePatternMarkers = []struct {
+	marker  string
+	group   string
+	pattern string
+}{
+	{"\x00Timestamp\x00", "timestamp", `\d{8}T\d{6}Z`},
+	{"\x00Date\x00", "date", `\d{4}-\d{2}-\d{2}`},
+	{"\x00Time\x00", "time", `\d{6}`},
+	{"\x00RunID\x00", "", `[0-9a-fA-F-]+`},
+	{"\x00ShortRunID\x00", "", `[0-9a-fA-F-]+`},
+}
+
+// NamePattern matches the names rendered by a folder name template
+type NamePattern struct {
+	re *regexp.Regexp
+}
+
+// NamePattern returns the pattern matching all the names the folder name template can render.
+func (f *Folder) NamePattern() (*NamePattern, error) {
+
+	nameTemplate := f.NameTemplate
+	if strings.TrimSpace(nameTemplate) == "" {
+		nameTemplate = DefaultNameTemplate
+	}
+
+	tmpl, err := template.New(f.Name).Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	// We render the template with markers in place of the run values
+	buf := bytes.NewBuffer(nil)
+	err = tmpl.Execute(buf, NameTemplateData{
+		Name:       f.Name,
+		Slug:       slug.Make(f.Name),
+		Timestamp:  namePatternMarkers[0].marker,
+		Date:       namePatternMarkers[1].marker,
+		Time:       namePatternMarkers[2].marker,
+		RunID:      namePatternMarkers[3].marker,
+		ShortRunID: namePatternMarkers[4].marker,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Then we replace the markers with their regular expression.
+	// Only the first occurrence of a value is captured.
+	expr := regexp.QuoteMeta(strings.Trim(strings.TrimSpace(buf.String()), "/"))
+	for _, m := range namePatternMarkers {
+
+		first := true
+		for strings.Contains(expr, m.marker) {
+
+			pattern := "(?:" + m.pattern + ")"
+			if first && m.group != "" {
+				pattern = "(?P<" + m.group + ">" + m.pattern + ")"
+			}
+			expr = strings.Replace(expr, m.marker, pattern, 1)
+			first = false
+		}
+	}
+
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, err
+	}
+
+	return &NamePattern{re: re}, nil
+}
+
+// Match reports whether name has been rendered by the folder name template.
+// When the template holds the run start time, it is returned. Otherwise the returned time is zero.
+func (p *NamePattern) Match(name string) (bool, time.Time) {
+
+	matches := p.re.FindStringSubmatch(name)
+	if matches == nil {
+		return false, time.Time{}
+	}
+
+	group := func(name string) string {
+		i := p.re.SubexpIndex(name)
+		if i < 0 {
+			return ""
+		}
+		return matches[i]
+	}
+
+	if ts := group("timestamp"); ts != "" {
+		t, err := time.Parse(TimestampLayout, ts)
+		if err == nil {
+			return true, t
+		}
+	}
+
+	if date := group("date"); date != "" {
+		t, err := time.Parse("2006-01-02 150405", date+" "+group("time"))
+		if err != nil {
+			t, err = time.Parse("2006-01-02", date)
+		}
+		if err == nil {
+			return true, t
+		}
+	}
+
+	return true, time.Time{}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderName(t *testing.T) {
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	runID := "1a2b3c4d-0000-0000-0000-000000000000"
+
+	tests := []struct {
+		template string
+		expected string
+		wantErr  bool
+	}{
+		{"", "my-folder-20240102T030405Z-1a2b3c4d", false},
+		{"{{.Date}}/{{.Slug}}-{{.RunID}}", "2024-01-02/my-folder-" + runID, false},
+		{"{{.Unknown}}", "", true},
+		{"  ", "my-folder-20240102T030405Z-1a2b3c4d", false},
+		{"{{if false}}x{{end}}", "", true},
+	}
+
+	for _, test := range tests {
+
+		f := Folder{Name: "My Folder", NameTemplate: test.template}
+		name, err := f.RenderName(runID, start)
+		if test.wantErr {
+			if err == nil {
+				t.Fatalf("Expected an error for template %q, got name %q", test.template, name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Error rendering template %q: %s", test.template, err.Error())
+		}
+		if name != test.expected {
+			t.Fatalf("Template %q rendered %q, want %q", test.template, name, test.expected)
+		}
+	}
+}
+
+func TestNamePattern(t *testing.T) {
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	runID := "1a2b3c4d-0000-0000-0000-000000000000"
+
+	templates := []string{
+		"",
+		"{{.Date}}/{{.Slug}}-{{.RunID}}",
+		"{{.Name}}.{{.Date}}.{{.Time}}.{{.ShortRunID}}",
+		"{{.Slug}}-{{.Timestamp}}-{{.Timestamp}}",
+	}
+
+	for _, tmpl := range templates {
+
+		f := Folder{Name: "My Folder", NameTemplate: tmpl}
+		name, err := f.RenderName(runID, start)
+		if err != nil {
+			t.Fatalf("Error rendering template %q: %s", tmpl, err.Error())
+		}
+
+		pattern, err := f.NamePattern()
+		if err != nil {
+			t.Fatalf("Error building pattern of template %q: %s", tmpl, err.Error())
+		}
+
+		ok, ts := pattern.Match(name)
+		if !ok {
+			t.Fatalf("Pattern of template %q does not match %q", tmpl, name)
+		}
+		if !ts.Equal(start) && !ts.Equal(start.Truncate(24*time.Hour)) {
+			t.Fatalf("Pattern of template %q extracted time %s from %q", tmpl, ts, name)
+		}
+
+		// Names of other folders must not match
+		if ok, _ := pattern.Match("other-folder-20240102T030405Z-1a2b3c4d"); ok {
+			t.Fatalf("Pattern of template %q matches another folder name", tmpl)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Polo44444/harpo/dumping"
+	"github.com/Polo44444/harpo/models"
+)
+
+// PostgresDumpName is the name of the PostgreSQL dumps inside the archives
+const PostgresDumpName = "postgres"
+
+// Postgres describes the PostgreSQL databases dumped with pg_dump along with the paths of a folder.
+// The connection settings left empty are taken from the libpq environment variables.
+type Postgres struct {
+	Host      string   `json:"host" yaml:"host"` // Host name or socket directory
+	Port      int      `json:"port" yaml:"port"`
+	User      string   `json:"user" yaml:"user"`
+	Password  string   `json:"password" yaml:"password"`
+	SSLMode   string   `json:"ssl_mode" yaml:"ssl_mode"`   // disable | require | verify-ca | verify-full...
+	Databases []string `json:"databases" yaml:"databases"` // Databases to dump. Empty dumps all the databases of the server
+	Format    string   `json:"format" yaml:"format"`       // CUSTOM | PLAIN. Default is CUSTOM
+	Options   []string `json:"options" yaml:"options"`     // Extra arguments of pg_dump, e.g. --exclude-table=logs
+	BinDir    string   `json:"bin_dir" yaml:"bin_dir"`     // Directory of pg_dump, pg_restore and psql. Default is the PATH
+}
+
+// Enabled returns true when the folder dumps PostgreSQL databases
+func (p *Postgres) Enabled() bool {
+	return strings.TrimSpace(p.Host) != "" || strings.TrimSpace(p.User) != "" || len(p.Databases) > 0
+}
+
+// ProviderConfig returns the config of the dump provider
+func (p *Postgres) ProviderConfig() models.ProviderConfig {
+
+	databases := []string{}
+	if p.Databases != nil {
+		databases = p.Databases
+	}
+	options := []string{}
+	if p.Options != nil {
+		options = p.Options
+	}
+
+	return dumping.BuildPostgresConfig(p.Host, p.Port, p.User, p.Password, p.SSLMode, databases, p.Format, options, p.BinDir)
+}
+
+// Validate checks if the PostgreSQL settings are valid
+func (p *Postgres) Validate() error {
+
+	if !p.Enabled() {
+		return nil
+	}
+
+	if p.Port < 0 || p.Port > 65535 {
+		return fmt.Errorf("port %d is not valid", p.Port)
+	}
+	for _, db := range p.Databases {
+		if strings.TrimSpace(db) == "" || strings.ContainsAny(db, `/\`) {
+			return fmt.Errorf("database name %q is not valid", db)
+		}
+	}
+
+	_, err := dumping.GetProvider(dumping.PostgresProvider, p.ProviderConfig())
+	return err
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Polo44444/harpo/archiving"
+)
+
+func TestPostgres(t *testing.T) {
+
+	// A folder can only dump databases
+	f := Folder{Postgres: Postgres{Host: "db.local", Databases: []string{"app"}}}
+	if err := f.validateSources(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := f.Postgres.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(f.Sources()) != 0 {
+		t.Fatalf("Unexpected sources: %v", f.Sources())
+	}
+
+	// While backed up, the dumps follow the paths, streamed and unfiltered
+	f.DumpStreams = map[string]map[string]archiving.StreamFunc{PostgresDumpName: {"app.dump": nil}}
+	sources := f.Sources()
+	if len(sources) != 1 || sources[0].ArchiveName() != PostgresDumpName || len(sources[0].Streams) != 1 || !sources[0].Unfiltered {
+		t.Fatalf("Unexpected sources: %v", sources)
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "postgres"), os.ModePerm); err != nil {
+		t.Fatalf("Error creating directory: %s", err.Error())
+	}
+
+	// The name of the dumps is reserved inside the archive
+	for _, invalid := range []Folder{
+		{Path: filepath.Join(dir, "postgres"), Postgres: Postgres{Host: "db.local"}},
+		{Paths: []Source{{Path: dir, Name: "postgres/data"}}, Postgres: Postgres{User: "backup"}},
+	} {
+		if err := invalid.validateSources(); err == nil {
+			t.Fatalf("Invalid paths accepted: %+v", invalid)
+		}
+	}
+
+	for _, invalid := range []Postgres{
+		{Host: "db.local", Format: "DIRECTORY"},
+		{Host: "db.local", Port: 70000},
+		{Databases: []string{"../app"}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Invalid postgres settings accepted: %+v", invalid)
+		}
+	}
+
+	// Dumps can not be compared with the live databases
+	f = Folder{Storages: []string{"s3"}, Postgres: Postgres{Host: "db.local"}, Drill: Drill{Schedule: "0 4 * * 6", Compare: LiveComparison}}
+	if err := f.validateDrill(); err == nil {
+		t.Fatalf("LIVE drill of dumps accepted")
+	}
+}
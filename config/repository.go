@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Polo44444/harpo/repository"
+)
+
+// Backup formats
+const (
+	ArchiveFormat    = "ARCHIVE"    // Each run uploads a whole archive. Default
+	RepositoryFormat = "REPOSITORY" // Each run stores the new chunks of the files and a snapshot inside a deduplicated repository
+)
+
+// Repository describes the deduplicated repository of a folder, stored under its destination
+type Repository struct {
+	ChunkSize string `json:"chunk_size" yaml:"chunk_size"` // Average size of the chunks, rounded down to a power of two, e.g. 512KiB. Default is 1MiB
+}
+
+// ChunkerParams returns the chunker params of new repositories. Existing repositories keep their own.
+func (r *Repository) ChunkerParams() (repository.ChunkerParams, error) {
+
+	size, err := parseSize(r.ChunkSize)
+	if err != nil {
+		return repository.ChunkerParams{}, err
+	}
+
+	return repository.NewChunkerParams(int(size))
+}
+
+// Validate checks if the repository settings are valid
+func (r *Repository) Validate() error {
+
+	_, err := r.ChunkerParams()
+	if err != nil {
+		return fmt.Errorf("chunk_size is not valid: %w", err)
+	}
+
+	return nil
+}
+
+// IsRepository returns true when the folder is backed up inside a deduplicated repository
+func (f *Folder) IsRepository() bool {
+	return strings.ToUpper(strings.TrimSpace(f.Format)) == RepositoryFormat
+}
+
+// validateFormat checks the format of the folder and the settings it can not be combined with
+func (f *Folder) validateFormat() error {
+
+	switch strings.ToUpper(strings.TrimSpace(f.Format)) {
+	case "", ArchiveFormat:
+		return nil
+	case RepositoryFormat:
+	default:
+		return fmt.Errorf("format %s is not supported", f.Format)
+	}
+
+	if f.Streaming {
+		return fmt.Errorf("repository format can not be used with streaming")
+	}
+	if f.Incremental.Enabled() {
+		return fmt.Errorf("repository format can not be used with incremental backups, snapshots are already deduplicated")
+	}
+	if f.Encryption.Enabled() {
+		return fmt.Errorf("repository format does not support encryption yet")
+	}
+
+	return f.Repository.Validate()
+}
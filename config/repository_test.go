@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestRepositoryFormat(t *testing.T) {
+
+	f := Folder{Format: "repository", Repository: Repository{ChunkSize: "100KB"}}
+	if err := f.validateFormat(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	params, err := f.Repository.ChunkerParams()
+	if err != nil || !f.IsRepository() || params.AvgSize != 64<<10 {
+		t.Fatalf("Unexpected params: %+v %v", params, err)
+	}
+
+	for _, invalid := range []Folder{
+		{Format: "CHUNKS"},
+		{Format: RepositoryFormat, Streaming: true},
+		{Format: RepositoryFormat, Incremental: Incremental{Mode: IncrementalMode}},
+		{Format: RepositoryFormat, Repository: Repository{ChunkSize: "100B"}},
+	} {
+		if err := invalid.validateFormat(); err == nil {
+			t.Fatalf("Invalid format accepted: %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package config
+
+import "fmt"
+
+// Retention describes which remote archives of a folder are kept.
+// An archive is kept when at least one of the rules selects it. Other archives are deleted.
+type Retention struct {
+	KeepLast    int  `json:"keep_last" yaml:"keep_last"`       // Keep the last N archives
+	KeepDaily   int  `json:"keep_daily" yaml:"keep_daily"`     // Keep the last archive of the last N days
+	KeepWeekly  int  `json:"keep_weekly" yaml:"keep_weekly"`   // Keep the last archive of the last N weeks
+	KeepMonthly int  `json:"keep_monthly" yaml:"keep_monthly"` // Keep the last archive of the last N months
+	KeepYearly  int  `json:"keep_yearly" yaml:"keep_yearly"`   // Keep the last archive of the last N years
+	DryRun      bool `json:"dry_run" yaml:"dry_run"`           // When true, only report the archives that would be deleted
+}
+
+// Enabled returns true when at least one retention rule is set
+func (r *Retention) Enabled() bool {
+	return r.KeepLast > 0 || r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0 || r.KeepYearly > 0
+}
+
+// Validate checks if the retention policy is valid
+func (r *Retention) Validate() error {
+
+	if r.KeepLast < 0 || r.KeepDaily < 0 || r.KeepWeekly < 0 || r.KeepMonthly < 0 || r.KeepYearly < 0 {
+		return fmt.Errorf("retention counts can not be negative")
+	}
+
+	return nil
+}
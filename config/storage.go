@@ -11,8 +11,9 @@ import (
 )
 
 type Storage struct {
-	Type     string                `json:"type" yaml:"type"`
-	Settings models.ProviderConfig `json:"settings" yaml:"settings"`
+	Type       string                `json:"type" yaml:"type"`
+	Settings   models.ProviderConfig `json:"settings" yaml:"settings"`
+	Encryption Encryption            `json:"encryption" yaml:"encryption"` // Overrides the encryption of the folders uploading to this storage
 }
 
 // Validate checks if the storage is valid
@@ -20,22 +21,35 @@ func (s *Storage) Validate(name string, folder string) error {
 
 	typeUpper := strings.ToUpper(s.Type)
 
+	// Check encryption
+	err := s.Encryption.Validate()
+	if err != nil {
+		return fmt.Errorf("encryption of storage %s is not valid: %w", name, err)
+	}
+
 	// Get provider
-	var (
-		p   storing.Provider
-		err error
-	)
+	var p storing.Provider
 	switch typeUpper {
 	case string(storing.S3Provider):
 		p, err = storing.GetProvider(storing.S3Provider, s.Settings)
+	case string(storing.LocalProvider):
+		p, err = storing.GetProvider(storing.LocalProvider, s.Settings)
+	case string(storing.SFTPProvider):
+		p, err = storing.GetProvider(storing.SFTPProvider, s.Settings)
+	case string(storing.WebDAVProvider):
+		p, err = storing.GetProvider(storing.WebDAVProvider, s.Settings)
+	case string(storing.AzureProvider):
+		p, err = storing.GetProvider(storing.AzureProvider, s.Settings)
+	case string(storing.GCSProvider):
+		p, err = storing.GetProvider(storing.GCSProvider, s.Settings)
 	default:
 		return fmt.Errorf("type %s of storage %s is not valid", s.Type, name)
 	}
-	defer p.Close(context.Background())
 
 	if err != nil {
 		return fmt.Errorf("unable to get provider for storage %s: %w", name, err)
 	}
+	defer p.Close(context.Background())
 
 	// Test provider
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second) // The test should not take more than 30 seconds
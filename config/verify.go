@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Verification modes of the uploaded archives
+const (
+	NoVerification    = ""      // Uploads are trusted once the storage accepted them
+	QuickVerification = "QUICK" // The size and, when the storage knows it, the checksum of the stored archive are compared with the local archive
+	DeepVerification  = "DEEP"  // The stored archive is downloaded again and hashed
+)
+
+// VerifyMode returns the verification mode of the uploaded archives of the folder
+func (f *Folder) VerifyMode() string {
+	return strings.ToUpper(strings.TrimSpace(f.Verify))
+}
+
+// validateVerify checks the verification mode of the folder
+func (f *Folder) validateVerify() error {
+
+	switch f.VerifyMode() {
+	case NoVerification, QuickVerification, DeepVerification:
+	default:
+		return fmt.Errorf("mode %s is not supported", f.Verify)
+	}
+
+	if f.VerifyMode() != NoVerification && f.IsRepository() {
+		return fmt.Errorf("repository format can not be used with verify, the chunks are checked by their hash")
+	}
+
+	return nil
+}
+
+// VerifyJob periodically downloads the archives stored on storages, checks them against their manifest
+// and test-extracts them. Snapshots of repositories are checked with their data.
+type VerifyJob struct {
+	Name      string   `json:"name" yaml:"name"`
+	Schedule  string   `json:"schedule" yaml:"schedule"`
+	Storages  []string `json:"storages" yaml:"storages"`
+	Folders   []string `json:"folders" yaml:"folders"` // Folders to check. Default is every folder stored on the storages
+	Notifiers []string `json:"notifiers" yaml:"notifiers"`
+}
+
+// Validate checks if the verify job is valid
+func (j *VerifyJob) Validate(folders []Folder, storages map[string]Storage, notifiers map[string]Notifier) error {
+
+	if strings.TrimSpace(j.Name) == "" {
+		return fmt.Errorf("name of verify job is not valid")
+	}
+	if strings.TrimSpace(j.Schedule) == "" {
+		return fmt.Errorf("schedule of verify job %s is not valid", j.Name)
+	}
+
+	if len(j.Storages) == 0 {
+		return fmt.Errorf("storages of verify job %s are not valid", j.Name)
+	}
+	for _, storage := range j.Storages {
+		if _, ok := storages[storage]; !ok {
+			return fmt.Errorf("storage %s of verify job %s is not valid or have not been declared", storage, j.Name)
+		}
+	}
+
+	for _, name := range j.Folders {
+		found := false
+		for _, folder := range folders {
+			found = found || folder.Name == name
+		}
+		if !found {
+			return fmt.Errorf("folder %s of verify job %s is not valid or have not been declared", name, j.Name)
+		}
+	}
+
+	for _, notifier := range j.Notifiers {
+		if _, ok := notifiers[notifier]; !ok {
+			return fmt.Errorf("notifier %s of verify job %s is not valid or have not been declared", notifier, j.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+
+	for _, valid := range []Folder{{}, {Verify: "quick"}, {Verify: " DEEP "}} {
+		if err := valid.validateVerify(); err != nil {
+			t.Fatalf("Valid verify %q rejected: %s", valid.Verify, err.Error())
+		}
+	}
+	if f := (Folder{Verify: "deep"}); f.VerifyMode() != DeepVerification {
+		t.Fatalf("Unexpected mode %q", f.VerifyMode())
+	}
+
+	for _, invalid := range []Folder{
+		{Verify: "FULL"},
+		{Verify: QuickVerification, Format: RepositoryFormat},
+	} {
+		if err := invalid.validateVerify(); err == nil {
+			t.Fatalf("Invalid verify accepted: %+v", invalid)
+		}
+	}
+}
+
+func TestVerifyJob(t *testing.T) {
+
+	folders := []Folder{{Name: "user1"}}
+	storages := map[string]Storage{"s3": {}}
+	notifiers := map[string]Notifier{"slack": {}}
+
+	job := VerifyJob{Name: "weekly", Schedule: "0 3 * * 0", Storages: []string{"s3"}, Folders: []string{"user1"}, Notifiers: []string{"slack"}}
+	if err := job.Validate(folders, storages, notifiers); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	for _, invalid := range []VerifyJob{
+		{Schedule: "0 3 * * 0", Storages: []string{"s3"}},
+		{Name: "weekly", Storages: []string{"s3"}},
+		{Name: "weekly", Schedule: "0 3 * * 0"},
+		{Name: "weekly", Schedule: "0 3 * * 0", Storages: []string{"gcs"}},
+		{Name: "weekly", Schedule: "0 3 * * 0", Storages: []string{"s3"}, Folders: []string{"user2"}},
+		{Name: "weekly", Schedule: "0 3 * * 0", Storages: []string{"s3"}, Notifiers: []string{"discord"}},
+	} {
+		if err := invalid.Validate(folders, storages, notifiers); err == nil {
+			t.Fatalf("Invalid verify job accepted: %+v", invalid)
+		}
+	}
+}
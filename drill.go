@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Polo44444/harpo/backup"
+	"github.com/Polo44444/harpo/config"
+)
+
+// drill restores an archive of a folder inside a temporary directory and compares the restored files
+func drill(settings *config.Settings, args []string) error {
+
+	fs := flag.NewFlagSet("drill", flag.ExitOnError)
+	folderName := fs.String("folder", "", "name of the folder to drill")
+	storageName := fs.String("storage", "", "name of the storage to restore from. Default is the drill storage of the folder")
+	pick := fs.String("pick", "", "archive to restore: LATEST or RANDOM. Default is the drill pick of the folder")
+	compare := fs.String("compare", "", "compare the restored files with the MANIFEST of the archive or the LIVE folder. Default is the drill comparison of the folder")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-c config] drill -folder name [-storage name] [-pick LATEST|RANDOM] [-compare MANIFEST|LIVE]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if strings.TrimSpace(*folderName) == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// The flags override the drill settings of the folder
+	for i := range settings.Folders {
+		if settings.Folders[i].Name != *folderName {
+			continue
+		}
+		if *storageName != "" {
+			settings.Folders[i].Drill.Storage = *storageName
+		}
+		if *pick != "" {
+			settings.Folders[i].Drill.Pick = *pick
+		}
+		if *compare != "" {
+			settings.Folders[i].Drill.Compare = *compare
+		}
+	}
+
+	storages := settings.GetStorageProviders()
+	notifiers := settings.GetNotifierProviders()
+	defer func() {
+		for _, storage := range storages {
+			storage.Close(context.Background())
+		}
+
+		for _, notifier := range notifiers {
+			notifier.Close(context.Background())
+		}
+	}()
+
+	bck := backup.NewEngine(settings.Folders, storages, notifiers)
+	bck.SetStorageEncryptions(settings.GetStorageEncryptions())
+	report, err := bck.Drill(*folderName)
+
+	// Make sure notifications are sent before exiting
+	backup.WaitNotifications()
+	if err != nil {
+		return fmt.Errorf("restore drill of folder %s failed: %w", *folderName, err)
+	}
+
+	log.Printf("Restore drill of folder %s passed: %s from %s, %d file(s) restored in %s, %d compared with %s in %s\n",
+		*folderName, report.Key, report.Storage, report.Files, report.Restore, report.Checked, report.Compare, report.Comparison)
+
+	return nil
+}
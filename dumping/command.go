@@ -0,0 +1,90 @@
+package dumping
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// stderrSize is the size of the end of the error output kept inside the errors
+const stderrSize = 2048
+
+// command describes a client program of a database server
+type command struct {
+	binDir string   // Directory of the program. Default is the PATH
+	env    []string // Added to the environment of the process
+}
+
+// run runs the program with the args, reading stdin and writing stdout. Both can be nil.
+// The end of the error output is returned inside the error.
+func (c command) run(ctx context.Context, program string, args []string, stdin io.Reader, stdout io.Writer) error {
+
+	if c.binDir != "" {
+		program = filepath.Join(c.binDir, program)
+	}
+
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Env = append(os.Environ(), c.env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	stderr := &tailWriter{max: stderrSize}
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return fmt.Errorf("%s failed: %w: %s", filepath.Base(program), err, msg)
+		}
+		return fmt.Errorf("%s failed: %w", filepath.Base(program), err)
+	}
+
+	return nil
+}
+
+// output runs the program with the args and returns its output
+func (c command) output(ctx context.Context, program string, args ...string) (string, error) {
+
+	stdout := &bytes.Buffer{}
+	err := c.run(ctx, program, args, nil, stdout)
+
+	return stdout.String(), err
+}
+
+// tailWriter keeps the last max bytes written
+type tailWriter struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+
+	if len(p) >= t.max {
+		t.truncated = t.truncated || len(t.buf) > 0 || len(p) > t.max
+		t.buf = append(t.buf[:0], p[len(p)-t.max:]...)
+		return len(p), nil
+	}
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+		t.truncated = true
+	}
+
+	return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+
+	if t.truncated {
+		return "..." + string(t.buf)
+	}
+	return string(t.buf)
+}
@@ -0,0 +1,75 @@
+//go:build unix
+
+package dumping
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCommandStderr(t *testing.T) {
+
+	// Only the end of a long error output is kept inside the error
+	bin := t.TempDir()
+	fakeBinary(t, bin, "noisy", `
+i=0
+while [ $i -lt 1000 ]; do
+	echo "warning: line $i"
+	i=$((i+1))
+done >&2
+echo "fatal: the end" >&2
+exit 2
+`)
+
+	err := command{binDir: bin}.run(context.Background(), "noisy", nil, nil, nil)
+	if err == nil {
+		t.Fatalf("Failing command succeeded")
+	}
+	msg := err.Error()
+	if !strings.HasSuffix(msg, "fatal: the end") || !strings.Contains(msg, ": ...") || strings.Contains(msg, "line 0\n") {
+		t.Fatalf("Unexpected error: %s", msg)
+	}
+	if len(msg) > stderrSize+len("noisy failed: exit status 2: ...") {
+		t.Fatalf("Error of %d bytes keeps more than the end of the output", len(msg))
+	}
+
+	// A short error output is kept whole
+	fakeBinary(t, bin, "quiet", `echo "fatal: denied" >&2; exit 1`)
+	err = command{binDir: bin}.run(context.Background(), "quiet", nil, nil, nil)
+	if err == nil || err.Error() != "quiet failed: exit status 1: fatal: denied" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+
+	for _, writes := range [][]string{
+		{"abcdef"},
+		{"ab", "cd", "ef"},
+		{"abc", "defgh", "", "i"},
+	} {
+		w := &tailWriter{max: 4}
+		for _, p := range writes {
+			n, err := w.Write([]byte(p))
+			if n != len(p) || err != nil {
+				t.Fatalf("%v: wrote %d bytes of %d: %v", writes, n, len(p), err)
+			}
+			if len(w.buf) > w.max {
+				t.Fatalf("%v: kept %d bytes", writes, len(w.buf))
+			}
+		}
+		all := strings.Join(writes, "")
+		if w.String() != "..."+all[len(all)-4:] {
+			t.Fatalf("%v: kept %q", writes, w.String())
+		}
+	}
+
+	// Nothing is truncated while the output fits
+	w := &tailWriter{max: 4}
+	w.Write([]byte("ab"))
+	w.Write([]byte("cd"))
+	if w.String() != "abcd" {
+		t.Fatalf("Kept %q", w.String())
+	}
+}
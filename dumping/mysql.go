@@ -0,0 +1,187 @@
+package dumping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Polo44444/harpo/models"
+)
+
+// Flavors of the MySQL servers, picking the dump program
+const (
+	MySQLFlavor   = "MYSQL"   // Dumped with mysqldump. Default
+	MariaDBFlavor = "MARIADB" // Dumped with mariadb-dump
+)
+
+// mysqlSystemDatabases are left out when all the databases of the server are dumped.
+// Restoring a dump of mysql would drop the users and the grants of the server, it is only dumped when listed in the databases.
+var mysqlSystemDatabases = map[string]bool{
+	"information_schema": true,
+	"mysql":              true,
+	"performance_schema": true,
+	"sys":                true,
+}
+
+type mysqlProvider struct {
+	command
+	dumpProgram string
+	host        string
+	port        int
+	socket      string
+	user        string
+	password    string
+	optionFile  string   // Option file read by the clients before their arguments
+	databases   []string // Databases to dump. Empty dumps all the databases of the server
+	options     []string // Extra arguments of the dump program
+}
+
+func BuildMySQLConfig(host string, port int, socket, user, password, optionFile string, databases []string, flavor string, options []string, binDir string) models.ProviderConfig {
+	return models.ProviderConfig{
+		"host":        host,
+		"port":        port,
+		"socket":      socket,
+		"user":        user,
+		"password":    password,
+		"option_file": optionFile,
+		"databases":   databases,
+		"flavor":      flavor,
+		"options":     options,
+		"bin_dir":     binDir,
+	}
+}
+
+func newMySQLProvider(config models.ProviderConfig) (*mysqlProvider, error) {
+
+	prvd := &mysqlProvider{
+		host:       config["host"].(string),
+		port:       config["port"].(int),
+		socket:     config["socket"].(string),
+		user:       config["user"].(string),
+		password:   config["password"].(string),
+		optionFile: config["option_file"].(string),
+		databases:  config["databases"].([]string),
+		options:    config["options"].([]string),
+	}
+	prvd.binDir = config["bin_dir"].(string)
+
+	switch strings.ToUpper(config["flavor"].(string)) {
+	case "", MySQLFlavor:
+		prvd.dumpProgram = "mysqldump"
+	case MariaDBFlavor:
+		prvd.dumpProgram = "mariadb-dump"
+	default:
+		return nil, fmt.Errorf("invalid MySQL flavor: %s. Must be MYSQL or MARIADB", config["flavor"])
+	}
+
+	// The clients read a single extra option file
+	if prvd.password != "" && prvd.optionFile != "" {
+		return nil, fmt.Errorf("password and option file can not be used together, set the password inside the option file")
+	}
+
+	return prvd, nil
+}
+
+// withConnection calls fn with the connection arguments of the clients.
+// The password is given through a temporary option file, so it never shows in the process list.
+func (p *mysqlProvider) withConnection(fn func(args []string) error) error {
+
+	args := []string{}
+	optionFile := p.optionFile
+	if p.password != "" {
+
+		file, err := os.CreateTemp("", "harpo-mysql-*.cnf")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(file.Name()) // No need to check errors here. The file is in the temporary directory
+
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p.password)
+		_, err = fmt.Fprintf(file, "[client]\npassword=\"%s\"\n", escaped)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		optionFile = file.Name()
+	}
+
+	// The option file must be the first argument
+	if optionFile != "" {
+		args = append(args, "--defaults-extra-file="+optionFile)
+	}
+	if p.host != "" {
+		args = append(args, "--host="+p.host)
+	}
+	if p.port != 0 {
+		args = append(args, "--port="+strconv.Itoa(p.port))
+	}
+	if p.socket != "" {
+		args = append(args, "--socket="+p.socket)
+	}
+	if p.user != "" {
+		args = append(args, "--user="+p.user)
+	}
+
+	return fn(args)
+}
+
+// Databases returns the configured databases, or all the databases of the server but the system ones
+func (p *mysqlProvider) Databases(ctx context.Context) ([]string, error) {
+
+	if len(p.databases) > 0 {
+		return p.databases, nil
+	}
+
+	var out string
+	err := p.withConnection(func(args []string) error {
+
+		var err error
+		out, err = p.output(ctx, "mysql", append(args, "--batch", "--skip-column-names", "--execute=SHOW DATABASES")...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	databases := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if name := strings.TrimSpace(line); name != "" && !mysqlSystemDatabases[strings.ToLower(name)] {
+			databases = append(databases, name)
+		}
+	}
+
+	return databases, nil
+}
+
+// Dump writes the dump of the database to the dst. It is made inside a single transaction, without locking the InnoDB tables.
+// The dump drops and recreates the database, along with its routines, triggers and events.
+// The output of the dump program is written to the dst while it runs, the backups pipe it into the archive.
+func (p *mysqlProvider) Dump(ctx context.Context, database string, dst io.Writer) error {
+
+	return p.withConnection(func(args []string) error {
+
+		args = append(args, "--single-transaction", "--routines", "--triggers", "--events", "--add-drop-database")
+		args = append(args, p.options...)
+		args = append(args, "--databases", database)
+
+		return p.run(ctx, p.dumpProgram, args, nil, dst)
+	})
+}
+
+// Restore replaces the database by piping its dump into the mysql client. The dump selects the database itself.
+func (p *mysqlProvider) Restore(ctx context.Context, database string, src io.Reader) error {
+
+	return p.withConnection(func(args []string) error {
+		return p.run(ctx, "mysql", args, src, io.Discard)
+	})
+}
+
+// Ext returns the extension of the dumps
+func (p *mysqlProvider) Ext() string {
+	return ".sql"
+}
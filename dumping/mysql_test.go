@@ -0,0 +1,167 @@
+//go:build unix
+
+package dumping
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
+)
+
+// fakeMySQL puts stub MySQL clients on the PATH. The dump programs print their arguments and their option file,
+// mysql records its stdin and its arguments inside the returned directory.
+// The dump of the "streamed" database only ends once its first line has been read, through the "read" file of the directory.
+func fakeMySQL(t *testing.T) string {
+
+	bin, records := t.TempDir(), t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_RECORDS", records)
+
+	dump := `
+for arg in "$@"; do
+	case "$arg" in
+		--defaults-extra-file=*) cnf="${arg#*=}" ;;
+		--ignore-table=*) echo "mysqldump: Couldn't find table" >&2; exit 2 ;;
+	esac
+	db="$arg"
+done
+if [ "$db" = "streamed" ]; then
+	echo "-- first"
+	i=0
+	while [ ! -f "$FAKE_RECORDS/read" ]; do
+		i=$((i+1)); [ $i -gt 100 ] && { echo "mysqldump: output not read" >&2; exit 3; }
+		sleep 0.1
+	done
+	echo "-- second"
+	exit 0
+fi
+echo "-- $(basename "$0") $*"
+[ -n "$cnf" ] && cat "$cnf"
+true
+`
+	fakeBinary(t, bin, "mysqldump", dump)
+	fakeBinary(t, bin, "mariadb-dump", dump)
+	fakeBinary(t, bin, "mysql", `
+case "$*" in
+	*--execute*) printf 'app\ninformation_schema\nmysql\nperformance_schema\nshop\nsys\n' ;;
+	*) cat > "$FAKE_RECORDS/mysql"; echo "$*" > "$FAKE_RECORDS/mysql.args" ;;
+esac
+`)
+
+	return records
+}
+
+// readSignal creates the file once it received its first bytes
+type readSignal struct {
+	buf  bytes.Buffer
+	path string
+}
+
+func (w *readSignal) Write(p []byte) (int, error) {
+
+	if w.buf.Len() == 0 {
+		os.WriteFile(w.path, nil, 0o600) // No need to check errors here. The fake dump fails without the file
+	}
+	return w.buf.Write(p)
+}
+
+func TestMySQL(t *testing.T) {
+
+	records := fakeMySQL(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	p, err := GetProvider(MySQLProvider, BuildMySQLConfig("db.local", 3307, "", "backup", `se"cret`, "", []string{}, "", []string{}, ""))
+	if err != nil {
+		t.Fatalf("Error creating MySQL provider: %s", err.Error())
+	}
+
+	// Without databases, all the databases of the server but the system ones are dumped
+	databases, err := p.Databases(ctx)
+	if err != nil {
+		t.Fatalf("Error listing databases: %s", err.Error())
+	}
+	if strings.Join(databases, ",") != "app,shop" {
+		t.Fatalf("Unexpected databases: %v", databases)
+	}
+
+	// The mysql database is dumped when listed
+	listed, _ := GetProvider(MySQLProvider, BuildMySQLConfig("db.local", 0, "", "", "", "", []string{"mysql"}, "", []string{}, ""))
+	databases, err = listed.Databases(ctx)
+	if err != nil || strings.Join(databases, ",") != "mysql" {
+		t.Fatalf("Unexpected listed databases: %v %v", databases, err)
+	}
+
+	// The password is given through a temporary option file, removed afterwards
+	buf := &bytes.Buffer{}
+	err = p.Dump(ctx, "app", buf)
+	if err != nil {
+		t.Fatalf("Error dumping database: %s", err.Error())
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "-- mysqldump --defaults-extra-file=") ||
+		!strings.HasSuffix(lines[0], " --host=db.local --port=3307 --user=backup --single-transaction --routines --triggers --events --add-drop-database --databases app") ||
+		lines[1] != "[client]" || lines[2] != `password="se\"cret"` {
+		t.Fatalf("Unexpected dump:\n%s", buf.String())
+	}
+	cnf := strings.TrimPrefix(strings.Fields(lines[0])[2], "--defaults-extra-file=")
+	if _, err := os.Stat(cnf); !os.IsNotExist(err) {
+		t.Fatalf("Option file %s left: %v", cnf, err)
+	}
+
+	// The dump reaches the dst while mysqldump runs, so the backups pipe it into the archive
+	w := &readSignal{path: filepath.Join(records, "read")}
+	err = p.Dump(ctx, "streamed", w)
+	if err != nil || w.buf.String() != "-- first\n-- second\n" {
+		t.Fatalf("Dump not streamed: %q %v", w.buf.String(), err)
+	}
+
+	// Dumps are restored by the mysql client
+	err = p.Restore(ctx, "app", strings.NewReader("CREATE DATABASE app;"))
+	if err != nil {
+		t.Fatalf("Error restoring database: %s", err.Error())
+	}
+	restored, _ := os.ReadFile(filepath.Join(records, "mysql"))
+	args, _ := os.ReadFile(filepath.Join(records, "mysql.args"))
+	if string(restored) != "CREATE DATABASE app;" || !strings.Contains(string(args), "--host=db.local --port=3307 --user=backup") {
+		t.Fatalf("Unexpected restore: %s with %s", string(restored), string(args))
+	}
+
+	// MariaDB servers are dumped with mariadb-dump, the credentials can come from an option file
+	optionFile := filepath.Join(t.TempDir(), "my.cnf")
+	if err := os.WriteFile(optionFile, []byte("[client]\nuser=backup\n"), 0o600); err != nil {
+		t.Fatalf("Error creating option file: %s", err.Error())
+	}
+	p, err = GetProvider(MySQLProvider, BuildMySQLConfig("", 0, "/run/mysqld/mysqld.sock", "", "", optionFile, []string{"app"}, "mariadb", []string{}, ""))
+	if err != nil {
+		t.Fatalf("Error creating MySQL provider: %s", err.Error())
+	}
+	buf.Reset()
+	err = p.Dump(ctx, "app", buf)
+	want := "-- mariadb-dump --defaults-extra-file=" + optionFile + " --socket=/run/mysqld/mysqld.sock --single-transaction --routines --triggers --events --add-drop-database --databases app\n[client]\nuser=backup\n"
+	if err != nil || buf.String() != want {
+		t.Fatalf("Unexpected MariaDB dump:\n%s\nwant:\n%s\n%v", buf.String(), want, err)
+	}
+
+	// The error output of the clients is kept
+	p, _ = GetProvider(MySQLProvider, BuildMySQLConfig("db.local", 0, "", "", "", "", []string{}, "", []string{"--ignore-table=app.logs"}, ""))
+	err = p.Dump(ctx, "app", &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "Couldn't find table") {
+		t.Fatalf("Unexpected error of failing dump: %v", err)
+	}
+
+	for _, config := range []models.ProviderConfig{
+		BuildMySQLConfig("db.local", 0, "", "", "", "", []string{}, "PERCONA", []string{}, ""),
+		BuildMySQLConfig("db.local", 0, "", "", "secret", optionFile, []string{}, "", []string{}, ""),
+	} {
+		if _, err := GetProvider(MySQLProvider, config); err == nil {
+			t.Fatalf("Invalid config accepted: %v", config)
+		}
+	}
+}
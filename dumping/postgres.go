@@ -0,0 +1,132 @@
+package dumping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Polo44444/harpo/models"
+)
+
+// Formats of the PostgreSQL dumps
+const (
+	PostgresCustomFormat = "CUSTOM" // Compressed archive restored with pg_restore. Default
+	PostgresPlainFormat  = "PLAIN"  // SQL script restored with psql
+)
+
+// postgresMaintenanceDB is the database the clients connect to when they handle other databases
+const postgresMaintenanceDB = "postgres"
+
+type postgresProvider struct {
+	command
+	databases []string // Databases to dump. Empty dumps all the databases of the server
+	format    string   // CUSTOM or PLAIN
+	options   []string // Extra arguments of pg_dump
+}
+
+func BuildPostgresConfig(host string, port int, user, password, sslMode string, databases []string, format string, options []string, binDir string) models.ProviderConfig {
+	return models.ProviderConfig{
+		"host":      host,
+		"port":      port,
+		"user":      user,
+		"password":  password,
+		"ssl_mode":  sslMode,
+		"databases": databases,
+		"format":    format,
+		"options":   options,
+		"bin_dir":   binDir,
+	}
+}
+
+func newPostgresProvider(config models.ProviderConfig) (*postgresProvider, error) {
+
+	prvd := &postgresProvider{
+		databases: config["databases"].([]string),
+		format:    strings.ToUpper(config["format"].(string)),
+		options:   config["options"].([]string),
+	}
+	prvd.binDir = config["bin_dir"].(string)
+
+	if prvd.format == "" {
+		prvd.format = PostgresCustomFormat
+	}
+	if prvd.format != PostgresCustomFormat && prvd.format != PostgresPlainFormat {
+		return nil, fmt.Errorf("invalid PostgreSQL dump format: %s. Must be CUSTOM or PLAIN", prvd.format)
+	}
+
+	// The connection settings are given through the libpq variables, so the password never shows in the process list
+	for name, value := range map[string]string{
+		"PGHOST":     config["host"].(string),
+		"PGUSER":     config["user"].(string),
+		"PGPASSWORD": config["password"].(string),
+		"PGSSLMODE":  config["ssl_mode"].(string),
+	} {
+		if value != "" {
+			prvd.env = append(prvd.env, name+"="+value)
+		}
+	}
+	if port := config["port"].(int); port != 0 {
+		prvd.env = append(prvd.env, "PGPORT="+strconv.Itoa(port))
+	}
+
+	return prvd, nil
+}
+
+// Databases returns the configured databases, or all the databases of the server accepting connections
+func (p *postgresProvider) Databases(ctx context.Context) ([]string, error) {
+
+	if len(p.databases) > 0 {
+		return p.databases, nil
+	}
+
+	out, err := p.output(ctx, "psql", "--no-psqlrc", "--tuples-only", "--no-align", "--dbname", postgresMaintenanceDB,
+		"--command", "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname")
+	if err != nil {
+		return nil, err
+	}
+
+	databases := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			databases = append(databases, name)
+		}
+	}
+
+	return databases, nil
+}
+
+// Dump writes the dump of the database made by pg_dump to the dst.
+// Plain dumps recreate the database, custom dumps are recreated by pg_restore.
+func (p *postgresProvider) Dump(ctx context.Context, database string, dst io.Writer) error {
+
+	args := []string{"--dbname", database}
+	if p.format == PostgresPlainFormat {
+		args = append(args, "--format=plain", "--create", "--clean", "--if-exists")
+	} else {
+		args = append(args, "--format=custom")
+	}
+	args = append(args, p.options...)
+
+	return p.run(ctx, "pg_dump", args, nil, dst)
+}
+
+// Restore drops and recreates the database from its dump, with pg_restore for the custom dumps and psql for the plain ones
+func (p *postgresProvider) Restore(ctx context.Context, database string, src io.Reader) error {
+
+	if p.format == PostgresPlainFormat {
+		return p.run(ctx, "psql", []string{"--no-psqlrc", "--quiet", "--set", "ON_ERROR_STOP=1", "--dbname", postgresMaintenanceDB}, src, io.Discard)
+	}
+
+	return p.run(ctx, "pg_restore", []string{"--clean", "--if-exists", "--create", "--exit-on-error", "--dbname", postgresMaintenanceDB}, src, nil)
+}
+
+// Ext returns the extension of the dumps
+func (p *postgresProvider) Ext() string {
+
+	if p.format == PostgresPlainFormat {
+		return ".sql"
+	}
+	return ".dump"
+}
@@ -0,0 +1,125 @@
+//go:build unix
+
+package dumping
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBinary writes an executable shell script named after the program inside dir
+func fakeBinary(t *testing.T, dir, name, script string) {
+
+	err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755)
+	if err != nil {
+		t.Fatalf("Error creating fake %s: %s", name, err.Error())
+	}
+}
+
+// fakePostgres puts fake PostgreSQL clients on the PATH. They record their stdin inside the returned directory.
+func fakePostgres(t *testing.T) string {
+
+	bin, records := t.TempDir(), t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_RECORDS", records)
+
+	fakeBinary(t, bin, "pg_dump", `
+for arg in "$@"; do
+	[ "$prev" = "--dbname" ] && db="$arg"
+	prev="$arg"
+done
+[ "$db" = "broken" ] && { echo "pg_dump: error: connection to server failed" >&2; exit 1; }
+echo "dump of $db by $PGUSER@$PGHOST:$PGPORT with $PGPASSWORD: $*"
+`)
+	fakeBinary(t, bin, "psql", `
+case "$*" in
+	*--command*) printf 'app\nshop\n' ;;
+	*) cat > "$FAKE_RECORDS/psql" ;;
+esac
+`)
+	fakeBinary(t, bin, "pg_restore", `cat > "$FAKE_RECORDS/pg_restore"; echo "$*" > "$FAKE_RECORDS/pg_restore.args"`)
+
+	return records
+}
+
+func TestPostgres(t *testing.T) {
+
+	records := fakePostgres(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	p, err := GetProvider(PostgresProvider, BuildPostgresConfig("db.local", 5433, "backup", "secret", "", []string{}, "", []string{"--no-owner"}, ""))
+	if err != nil {
+		t.Fatalf("Error creating PostgreSQL provider: %s", err.Error())
+	}
+	if p.Ext() != ".dump" {
+		t.Fatalf("Unexpected extension of custom dumps: %s", p.Ext())
+	}
+
+	// Without databases, all the databases of the server are dumped
+	databases, err := p.Databases(ctx)
+	if err != nil {
+		t.Fatalf("Error listing databases: %s", err.Error())
+	}
+	if strings.Join(databases, ",") != "app,shop" {
+		t.Fatalf("Unexpected databases: %v", databases)
+	}
+
+	buf := &bytes.Buffer{}
+	err = p.Dump(ctx, "app", buf)
+	if err != nil {
+		t.Fatalf("Error dumping database: %s", err.Error())
+	}
+	want := "dump of app by backup@db.local:5433 with secret: --dbname app --format=custom --no-owner\n"
+	if buf.String() != want {
+		t.Fatalf("Unexpected dump:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	// Custom dumps are restored by pg_restore
+	err = p.Restore(ctx, "app", strings.NewReader(want))
+	if err != nil {
+		t.Fatalf("Error restoring database: %s", err.Error())
+	}
+	restored, _ := os.ReadFile(filepath.Join(records, "pg_restore"))
+	args, _ := os.ReadFile(filepath.Join(records, "pg_restore.args"))
+	if string(restored) != want || !strings.Contains(string(args), "--clean --if-exists --create") {
+		t.Fatalf("Unexpected restore: %s with %s", string(restored), string(args))
+	}
+
+	// The error output of the clients is kept
+	err = p.Dump(ctx, "broken", &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "connection to server failed") {
+		t.Fatalf("Unexpected error of failing dump: %v", err)
+	}
+
+	// Plain dumps are SQL scripts recreating the database, restored by psql
+	p, err = GetProvider(PostgresProvider, BuildPostgresConfig("", 0, "", "", "", []string{"app"}, "plain", []string{}, ""))
+	if err != nil {
+		t.Fatalf("Error creating PostgreSQL provider: %s", err.Error())
+	}
+	databases, err = p.Databases(ctx)
+	if err != nil || strings.Join(databases, ",") != "app" {
+		t.Fatalf("Unexpected databases: %v %v", databases, err)
+	}
+	buf.Reset()
+	err = p.Dump(ctx, "app", buf)
+	if err != nil || p.Ext() != ".sql" || !strings.Contains(buf.String(), "--format=plain --create --clean --if-exists") {
+		t.Fatalf("Unexpected plain dump: %s %v", buf.String(), err)
+	}
+	err = p.Restore(ctx, "app", strings.NewReader("CREATE DATABASE app;"))
+	restored, _ = os.ReadFile(filepath.Join(records, "psql"))
+	if err != nil || string(restored) != "CREATE DATABASE app;" {
+		t.Fatalf("Unexpected plain restore: %s %v", string(restored), err)
+	}
+
+	// Unknown formats are rejected
+	_, err = GetProvider(PostgresProvider, BuildPostgresConfig("", 0, "", "", "", []string{}, "DIRECTORY", []string{}, ""))
+	if err == nil {
+		t.Fatalf("Invalid format accepted")
+	}
+}
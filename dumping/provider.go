@@ -0,0 +1,47 @@
+package dumping
+
+import (
+	"context"
+	"io"
+
+	"github.com/Polo44444/harpo/models"
+)
+
+const (
+	PostgresProvider models.ProviderEntity = "POSTGRES"
+	MySQLProvider    models.ProviderEntity = "MYSQL"
+)
+
+// Provider interface
+type Provider interface {
+
+	// Databases returns the databases to dump: the configured ones, or all the databases of the server.
+	Databases(ctx context.Context) ([]string, error)
+
+	// Dump writes the dump of the database to the dst.
+	Dump(ctx context.Context, database string, dst io.Writer) error
+
+	// Restore restores the database from its dump read from the src. The database is replaced.
+	Restore(ctx context.Context, database string, src io.Reader) error
+
+	// Ext returns the extension of the dumps with the dot.
+	Ext() string
+}
+
+// GetProvider returns a provider based on the entity and the config
+func GetProvider(entity models.ProviderEntity, config models.ProviderConfig) (Provider, error) {
+
+	var err error = nil
+	var prvd Provider = nil
+
+	switch entity {
+	case PostgresProvider:
+		prvd, err = newPostgresProvider(config)
+	case MySQLProvider:
+		prvd, err = newMySQLProvider(config)
+	default:
+		err = models.ErrProviderNotSupported
+	}
+
+	return prvd, err
+}
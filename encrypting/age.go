@@ -0,0 +1,121 @@
+package encrypting
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/Polo44444/harpo/internal/ioctx"
+)
+
+// Ext is the extension appended to the encrypted archives
+const Ext = ".age"
+
+// NewRecipients returns the age recipients of the given X25519 public keys, or the scrypt recipient of the passphrase.
+// age does not allow to mix a passphrase with public keys.
+func NewRecipients(publicKeys []string, passphrase string) ([]age.Recipient, error) {
+
+	if len(publicKeys) > 0 && passphrase != "" {
+		return nil, fmt.Errorf("recipients and passphrase can not be used together")
+	}
+
+	if passphrase != "" {
+
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{r}, nil
+	}
+
+	recipients := []age.Recipient{}
+	for _, key := range publicKeys {
+
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %s: %w", key, err)
+		}
+		recipients = append(recipients, r)
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("at least one recipient or a passphrase is required")
+	}
+
+	return recipients, nil
+}
+
+// NewIdentities returns the age identities stored inside the given files and the scrypt identity of the passphrase.
+// Identity files hold one private key per line, as written by age-keygen.
+func NewIdentities(identityFiles []string, passphrase string) ([]age.Identity, error) {
+
+	identities := []age.Identity{}
+	for _, identityFile := range identityFiles {
+
+		file, err := os.Open(identityFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open identity file: %w", err)
+		}
+
+		ids, err := age.ParseIdentities(bufio.NewReader(file))
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse identity file %s: %w", identityFile, err)
+		}
+		identities = append(identities, ids...)
+	}
+
+	if passphrase != "" {
+
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("at least one identity or a passphrase is required")
+	}
+
+	return identities, nil
+}
+
+// NewWriter returns a writer encrypting the data written to it for the recipients and writing the result to dst.
+// The writer must be closed to write the last chunk.
+func NewWriter(dst io.Writer, recipients ...age.Recipient) (io.WriteCloser, error) {
+	return age.Encrypt(dst, recipients...)
+}
+
+// Encrypt encrypts src for the recipients and writes the result to dst.
+func Encrypt(ctx context.Context, src io.Reader, dst io.Writer, recipients ...age.Recipient) error {
+
+	w, err := age.Encrypt(dst, recipients...)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, ioctx.NewReader(ctx, src))
+	if err != nil {
+		return err
+	}
+
+	// Close writes the last chunk
+	return w.Close()
+}
+
+// Decrypt decrypts src with the first matching identity and writes the result to dst.
+func Decrypt(ctx context.Context, src io.Reader, dst io.Writer, identities ...age.Identity) error {
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, ioctx.NewReader(ctx, r))
+	return err
+}
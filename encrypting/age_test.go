@@ -0,0 +1,78 @@
+package encrypting
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Error generating identity: %s", err.Error())
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	err = os.WriteFile(identityFile, []byte("# created: now\n"+id.String()+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing identity: %s", err.Error())
+	}
+
+	cases := map[string]struct {
+		publicKeys    []string
+		passphrase    string
+		identityFiles []string
+	}{
+		"x25519":     {publicKeys: []string{id.Recipient().String()}, identityFiles: []string{identityFile}},
+		"passphrase": {passphrase: "correct horse battery staple"},
+	}
+
+	content := bytes.Repeat([]byte("Hi dear! It's Harpo!"), 10000)
+	for name, c := range cases {
+
+		recipients, err := NewRecipients(c.publicKeys, c.passphrase)
+		if err != nil {
+			t.Fatalf("%s: error creating recipients: %s", name, err.Error())
+		}
+
+		encrypted := bytes.NewBuffer(nil)
+		err = Encrypt(context.Background(), bytes.NewReader(content), encrypted, recipients...)
+		if err != nil {
+			t.Fatalf("%s: error encrypting: %s", name, err.Error())
+		}
+		if bytes.Contains(encrypted.Bytes(), content[:20]) {
+			t.Fatalf("%s: encrypted data holds the plaintext", name)
+		}
+
+		identities, err := NewIdentities(c.identityFiles, c.passphrase)
+		if err != nil {
+			t.Fatalf("%s: error creating identities: %s", name, err.Error())
+		}
+
+		decrypted := bytes.NewBuffer(nil)
+		err = Decrypt(context.Background(), bytes.NewReader(encrypted.Bytes()), decrypted, identities...)
+		if err != nil {
+			t.Fatalf("%s: error decrypting: %s", name, err.Error())
+		}
+		if !bytes.Equal(decrypted.Bytes(), content) {
+			t.Fatalf("%s: decrypted content is not the same", name)
+		}
+
+		// A wrong passphrase can not decrypt
+		wrong, _ := NewIdentities(nil, "wrong")
+		err = Decrypt(context.Background(), bytes.NewReader(encrypted.Bytes()), bytes.NewBuffer(nil), wrong...)
+		if err == nil {
+			t.Fatalf("%s: decrypted with a wrong passphrase", name)
+		}
+	}
+
+	// Passphrase and recipients can not be mixed
+	_, err = NewRecipients([]string{id.Recipient().String()}, "secret")
+	if err == nil {
+		t.Fatalf("Recipients created with both public keys and passphrase")
+	}
+}
@@ -0,0 +1,26 @@
+package ioctx
+
+import (
+	"context"
+	"io"
+)
+
+// reader stops reading as soon as its context is done
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// NewReader returns a reader which reads from r until ctx is done
+func NewReader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r}
+}
+
+func (c *reader) Read(p []byte) (int, error) {
+
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.r.Read(p)
+}
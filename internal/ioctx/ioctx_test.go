@@ -0,0 +1,35 @@
+package ioctx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewReader(ctx, strings.NewReader("data"))
+	buf := make([]byte, 2)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != "da" {
+		t.Fatalf("Unexpected read: %q, %v", buf[:n], err)
+	}
+
+	// Reading stops once the context is done
+	cancel()
+	n, err = r.Read(buf)
+	if n != 0 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Reading has not been stopped: %d bytes, %v", n, err)
+	}
+
+	// The end of the data is passed through
+	data, err := io.ReadAll(NewReader(context.Background(), strings.NewReader("data")))
+	if err != nil || string(data) != "data" {
+		t.Fatalf("Unexpected data: %q, %v", data, err)
+	}
+}
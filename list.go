@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Polo44444/harpo/backup"
+	"github.com/Polo44444/harpo/config"
+)
+
+// list prints the archives of the folders stored on their storages
+func list(settings *config.Settings, args []string) error {
+
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	folderName := fs.String("folder", "", "name of the folder to list. Default is all the folders")
+	storageName := fs.String("storage", "", "name of the storage to list. Default is all the folder storages")
+	key := fs.String("key", "", "path of an archive of the folder. Its files are listed from its manifest")
+	asJSON := fs.Bool("json", false, "print the archives as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-c config] list [-folder name] [-storage name] [-key path] [-json]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	storages := settings.GetStorageProviders()
+	defer func() {
+		for _, storage := range storages {
+			storage.Close(context.Background())
+		}
+	}()
+
+	bck := backup.NewEngine(settings.Folders, storages, nil)
+	if *key != "" {
+		return listFiles(bck, *folderName, *storageName, *key, *asJSON)
+	}
+
+	archives, err := bck.List(*folderName, *storageName)
+	if err != nil && archives == nil {
+		return err
+	}
+	if err != nil {
+		log.Println(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(archives)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FOLDER\tSTORAGE\tTIME\tKIND\tSIZE\tMANIFEST\tKEY")
+	for _, a := range archives {
+
+		key := a.Key
+		if a.Latest {
+			key += " (latest)"
+		}
+		manifest := "no"
+		if a.Manifest != "" {
+			manifest = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", a.Folder, a.Storage, a.Time.Local().Format(time.DateTime), a.Kind, formatSize(a.Size), manifest, key)
+	}
+
+	return w.Flush()
+}
+
+// listFiles prints the files of an archive recorded by its manifest
+func listFiles(bck *backup.Engine, folderName, storageName, key string, asJSON bool) error {
+
+	if folderName == "" || storageName == "" {
+		return errors.New("the folder and the storage of the archive must be provided")
+	}
+
+	m, err := bck.Manifest(folderName, storageName, key)
+	if m == nil {
+		return err
+	}
+	if err != nil {
+		log.Println(err)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	}
+
+	fmt.Printf("Run %s on %s, %s to %s\n", m.RunID, m.Host, m.StartTime.Local().Format(time.DateTime), m.EndTime.Local().Format(time.DateTime))
+	fmt.Printf("%s %s archive, %d file(s), %s, SHA-256 %s\n\n", m.Archiver, m.Compression, m.FileCount, formatSize(m.Size), m.ArchiveSHA256)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODE\tSIZE\tMODIFIED\tSHA-256\tPATH")
+	for _, f := range m.Files {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.Mode, formatSize(f.Size), f.ModTime.Local().Format(time.DateTime), f.SHA256, f.Path)
+	}
+
+	return w.Flush()
+}
+
+// formatSize returns a human readable size
+func formatSize(size int64) string {
+
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -16,19 +17,66 @@ import (
 
 func main() {
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
-
 	// Read config file path  from flags
 	var configFilePath *string = flag.String("c", config.DefaultConfigPath, "path to the config file")
+	flag.Usage = usage
 	flag.Parse()
 
 	// Load config
 	settings, err := config.Load(*configFilePath)
 	utils.LogFatalIfErr(err)
 
+	// Run command. The commands return their error once their providers are closed
+	switch flag.Arg(0) {
+	case "", "run":
+		err = run(settings)
+	case "restore":
+		err = restore(settings, flag.Args()[1:])
+	case "list":
+		err = list(settings, flag.Args()[1:])
+	case "prune":
+		err = prune(settings, flag.Args()[1:])
+	case "check":
+		err = check(settings, flag.Args()[1:])
+	case "verify":
+		err = verify(settings, flag.Args()[1:])
+	case "drill":
+		err = drill(settings, flag.Args()[1:])
+	default:
+		err = fmt.Errorf("unknown command %s. Run %s -h to get the list of commands", flag.Arg(0), os.Args[0])
+	}
+	utils.LogFatalIfErr(err)
+}
+
+func usage() {
+
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [-c config] [command] [arguments]
+
+Commands:
+  run       Start the backup engine (default)
+  restore   Download and extract the archive of a folder
+  list      List the archives of the folders on their storages
+  prune     Delete the unreferenced chunks of the repository of a folder
+  check     Check the integrity of the repository of a folder
+  verify    Download an archive and check it against its manifest
+  drill     Restore an archive inside a temporary directory and compare the restored files
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// run starts the backup engine and blocks until the program is stopped
+func run(settings *config.Settings) error {
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
 	// Validate config
-	utils.LogFatalIfErr(settings.Validate())
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
 
 	log.Println("Config is valid")
 
@@ -36,25 +84,32 @@ func main() {
 	storages := settings.GetStorageProviders()
 	notifiers := settings.GetNotifierProviders()
 
+	// ─── Graceful Shutdown ──────────────────────────────────────────────
+	// Providers are closed once the backup engine is stopped, or when it can not start
+	defer func() {
+		for _, storage := range storages {
+			storage.Close(context.Background())
+		}
+
+		for _, notifier := range notifiers {
+			notifier.Close(context.Background())
+		}
+	}()
+
 	// Start backup engine
 	bck := backup.NewEngine(settings.Folders, storages, notifiers)
-	utils.LogFatalIfErr(bck.BuildJobs())
+	bck.SetStorageEncryptions(settings.GetStorageEncryptions())
+	bck.SetVerifyJobs(settings.Verify)
+	err = bck.BuildJobs()
+	if err != nil {
+		return err
+	}
 	bck.Start()
 
 	log.Printf("%s backup engine started\n", constants.AppName)
 
 	<-sigs
-	// ─── Graceful Shutdown ──────────────────────────────────────────────
-
-	// Stop providers
-	for _, storage := range storages {
-		storage.Close(context.Background())
-	}
-
-	for _, notifier := range notifiers {
-		notifier.Close(context.Background())
-	}
 
 	// Stop backup engine
-	bck.Stop()
+	return bck.Stop()
 }
@@ -1,5 +1,24 @@
 package models
 
+import "time"
+
+// Metadata keys of the checksums of the stored content, as lowercase hex, set by the providers which know them.
+// They are only set when they describe the whole content, e.g. not for the ETag of multipart uploads.
+const (
+	MD5Metadata    = "md5"
+	SHA256Metadata = "sha256"
+)
+
 type FileInfo struct {
-	Size int64
+	Key      string
+	Size     int64
+	ModTime  time.Time
+	Metadata map[string]string // Provider specific metadata (content type, etag, storage class...)
 }
+
+// ListPageSize is the number of files handed at once to a ListFunc by the providers which do not page natively.
+const ListPageSize = 1000
+
+// ListFunc receives the files listed by a provider, one page at a time.
+// Returning an error stops the listing, and the error is returned by List.
+type ListFunc func(page []FileInfo) error
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Polo44444/harpo/backup"
+	"github.com/Polo44444/harpo/config"
+)
+
+// prune deletes the chunks of the repository of a folder referenced by no snapshot
+func prune(settings *config.Settings, args []string) error {
+
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	folderName := fs.String("folder", "", "name of the folder backed up inside a repository")
+	storageName := fs.String("storage", "", "name of the storage holding the repository")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-c config] prune -folder name -storage name\n\nIt must not run while the folder is being backed up.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if strings.TrimSpace(*folderName) == "" || strings.TrimSpace(*storageName) == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	storages := settings.GetStorageProviders()
+	defer func() {
+		for _, storage := range storages {
+			storage.Close(context.Background())
+		}
+	}()
+
+	bck := backup.NewEngine(settings.Folders, storages, nil)
+	stats, err := bck.Prune(*folderName, *storageName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Repository of folder %s pruned: %d chunk(s) deleted, %s freed\n", *folderName, stats.Chunks, formatSize(stats.Size))
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	// DefaultChunkSize is the default average size of the chunks
+	DefaultChunkSize = 1 << 20
+
+	// MinChunkSize is the lowest average size of the chunks
+	MinChunkSize = 1 << 10
+)
+
+// ChunkerParams holds the sizes of the content-defined chunks.
+// They are stored inside the repository, so the same content is always split the same way.
+type ChunkerParams struct {
+	MinSize int `json:"min_size"`
+	AvgSize int `json:"avg_size"`
+	MaxSize int `json:"max_size"`
+}
+
+// NewChunkerParams returns the chunker params of the given average size, rounded down to a power of two.
+// Chunks are at least a quarter and at most eight times the average size.
+func NewChunkerParams(avgSize int) (ChunkerParams, error) {
+
+	if avgSize == 0 {
+		avgSize = DefaultChunkSize
+	}
+	if avgSize < MinChunkSize {
+		return ChunkerParams{}, fmt.Errorf("chunk size must be at least %d bytes", MinChunkSize)
+	}
+
+	avgSize = 1 << (bits.Len(uint(avgSize)) - 1)
+
+	return ChunkerParams{
+		MinSize: avgSize / 4,
+		AvgSize: avgSize,
+		MaxSize: avgSize * 8,
+	}, nil
+}
+
+// gear holds the random values of the rolling hash, one per byte value.
+// They are generated from a fixed seed, so the cut points never change.
+var gear = func() [256]uint64 {
+
+	var table [256]uint64
+	seed := uint64(0x6861727030) // "harpo"
+	for i := range table {
+
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks, with the FastCDC gear rolling hash.
+// An insertion inside a file only changes the chunks around it, the others are found again in the repository.
+type Chunker struct {
+	r      io.Reader
+	params ChunkerParams
+	maskS  uint64 // Mask of the cut points below the average size, harder to match
+	maskL  uint64 // Mask of the cut points above the average size, easier to match
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+// NewChunker returns a chunker reading r
+func NewChunker(r io.Reader, params ChunkerParams) *Chunker {
+
+	// The masks use the high bits, which depend on the last 64 bytes read
+	avgBits := bits.Len(uint(params.AvgSize)) - 1
+	mask := func(n int) uint64 {
+		return ((uint64(1) << n) - 1) << (64 - n)
+	}
+
+	return &Chunker{
+		r:      r,
+		params: params,
+		maskS:  mask(avgBits + 1),
+		maskL:  mask(avgBits - 1),
+		buf:    make([]byte, params.MaxSize*2),
+	}
+}
+
+// Next returns the next chunk. The slice is only valid until the next call.
+// At the end of the stream, io.EOF is returned.
+func (c *Chunker) Next() ([]byte, error) {
+
+	// Keep at least one maximum chunk inside the buffer
+	if c.end-c.start < c.params.MaxSize && !c.eof {
+
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+
+		for c.end < len(c.buf) && !c.eof {
+
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+
+	return chunk, nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *Chunker) cut(data []byte) int {
+
+	n := len(data)
+	if n <= c.params.MinSize {
+		return n
+	}
+	if n > c.params.MaxSize {
+		n = c.params.MaxSize
+	}
+
+	normal := c.params.AvgSize
+	if normal > n {
+		normal = n
+	}
+
+	fp := uint64(0)
+	i := c.params.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
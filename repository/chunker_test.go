@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// testChunks splits the data and returns the hashes of the chunks
+func testChunks(t *testing.T, data []byte, params ChunkerParams) [][32]byte {
+
+	chunker := NewChunker(bytes.NewReader(data), params)
+	hashes := [][32]byte{}
+	total := 0
+	for {
+
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error chunking: %s", err.Error())
+		}
+
+		// Only the last chunk can be smaller than the minimum
+		if len(chunk) > params.MaxSize || (len(chunk) < params.MinSize && total+len(chunk) != len(data)) {
+			t.Fatalf("Chunk of %d bytes out of bounds %+v", len(chunk), params)
+		}
+		total += len(chunk)
+		hashes = append(hashes, sha256.Sum256(chunk))
+	}
+
+	if total != len(data) {
+		t.Fatalf("Chunks hold %d bytes, want %d", total, len(data))
+	}
+
+	return hashes
+}
+
+func TestChunker(t *testing.T) {
+
+	params, err := NewChunkerParams(4096)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if params.MinSize != 1024 || params.AvgSize != 4096 || params.MaxSize != 32768 {
+		t.Fatalf("Unexpected params: %+v", params)
+	}
+	if _, err := NewChunkerParams(100); err == nil {
+		t.Fatalf("Too small chunk size accepted")
+	}
+
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := testChunks(t, data, params)
+	if len(chunks) < 100 || len(chunks) > 600 {
+		t.Fatalf("%d chunks for 1 MiB, want about 256", len(chunks))
+	}
+
+	// An insertion only changes the chunks around it
+	inserted := append(append(append([]byte{}, data[:500000]...), []byte("inserted bytes")...), data[500000:]...)
+	known := map[[32]byte]bool{}
+	for _, h := range chunks {
+		known[h] = true
+	}
+	changed := 0
+	for _, h := range testChunks(t, inserted, params) {
+		if !known[h] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 3 {
+		t.Fatalf("%d chunks changed after an insertion", changed)
+	}
+
+	// Data without cut point is split at the maximum size
+	zeros := testChunks(t, make([]byte, 100000), params)
+	if len(zeros) != 4 {
+		t.Fatalf("%d chunks of zeros, want 4", len(zeros))
+	}
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
+	"github.com/google/uuid"
+)
+
+// Backups hold a shared lock while they store chunks and prunes an exclusive one,
+// so a prune never deletes the chunks a running backup relies on.
+const (
+	sharedLock    = "shared"
+	exclusiveLock = "exclusive"
+	lockRefresh   = 5 * time.Minute
+	staleLockAge  = 30 * time.Minute // Locks not refreshed for this long were left by stopped processes
+	unlockTimeout = time.Minute
+)
+
+// ErrLocked is returned when a backup or a prune conflicts with the lock of another one
+var ErrLocked = errors.New("repository is locked")
+
+// lock is a lock of the repository, stored under locks/<kind>-<id> and refreshed while held
+type lock struct {
+	r      *Repository
+	key    string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// lock locks the repository. An exclusive lock conflicts with any other lock, a shared one with exclusive locks only.
+func (r *Repository) lock(ctx context.Context, exclusive bool) (*lock, error) {
+
+	kind := sharedLock
+	if exclusive {
+		kind = exclusiveLock
+	}
+	l := &lock{r: r, key: r.prefix + locksDir + kind + "-" + uuid.Must(uuid.NewRandom()).String()}
+
+	err := l.write(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock repository: %w", err)
+	}
+
+	// Both sides write their lock before looking for the other one, so at least one of them sees the conflict
+	conflict := ""
+	err = r.stor.List(ctx, r.prefix+locksDir, func(page []models.FileInfo) error {
+		for _, file := range page {
+
+			if file.Key == l.key || time.Since(file.ModTime) > staleLockAge {
+				continue
+			}
+			if exclusive || strings.HasPrefix(path.Base(file.Key), exclusiveLock+"-") {
+				conflict = path.Base(file.Key)
+			}
+		}
+		return nil
+	})
+	if err == nil && conflict != "" {
+		err = fmt.Errorf("%w by %s", ErrLocked, conflict)
+	}
+	if err != nil {
+		l.delete()
+		return nil, err
+	}
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+
+		ticker := time.NewTicker(lockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-refreshCtx.Done():
+				return
+			case <-ticker.C:
+				l.write(refreshCtx) // No need to check errors here. The next refresh tries again
+			}
+		}
+	}()
+
+	return l, nil
+}
+
+// write stores the lock, which also refreshes its modification time
+func (l *lock) write(ctx context.Context) error {
+
+	host, _ := os.Hostname()
+	content := fmt.Sprintf("host: %s\npid: %d\ntime: %s\n", host, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+
+	return l.r.stor.UploadWithReader(ctx, l.key, strings.NewReader(content), "text/plain")
+}
+
+// delete removes the lock from the storage, even when the context of the locked operation is done
+func (l *lock) delete() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+	defer cancel()
+
+	return l.r.stor.Delete(ctx, l.key)
+}
+
+// unlock stops refreshing the lock and removes it
+func (l *lock) unlock() error {
+
+	l.cancel()
+	<-l.done
+
+	return l.delete()
+}
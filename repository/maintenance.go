@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// PruneStats counts what a prune deleted
+type PruneStats struct {
+	Chunks int   // Unreferenced chunks deleted
+	Size   int64 // Stored size of the deleted chunks
+}
+
+// CheckStats counts what a check found
+type CheckStats struct {
+	Snapshots    int
+	Chunks       int // Chunks referenced by the snapshots
+	Unreferenced int // Stored chunks referenced by no snapshot, deleted by the next prune
+	Read         int // Chunks downloaded and checked against their hash
+}
+
+// referencedChunks returns the chunks referenced by all the snapshots
+func (r *Repository) referencedChunks(ctx context.Context) (map[string]bool, []SnapshotInfo, error) {
+
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	referenced := map[string]bool{}
+	for _, info := range snapshots {
+
+		snapshot, err := r.LoadSnapshot(ctx, info.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, node := range snapshot.Nodes {
+			for _, id := range node.Chunks {
+				referenced[id] = true
+			}
+		}
+	}
+
+	return referenced, snapshots, nil
+}
+
+// Prune deletes the chunks referenced by no snapshot, left by forgotten snapshots or interrupted backups.
+// It locks the repository, so it fails with ErrLocked while a backup is storing chunks in it.
+func (r *Repository) Prune(ctx context.Context) (PruneStats, error) {
+
+	stats := PruneStats{}
+	l, err := r.lock(ctx, true)
+	if err != nil {
+		return stats, err
+	}
+	defer l.unlock()
+
+	referenced, _, err := r.referencedChunks(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	stored, err := r.listChunks(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	keys := []string{}
+	for id, size := range stored {
+		if !referenced[id] {
+			keys = append(keys, r.chunkKey(id))
+			stats.Chunks++
+			stats.Size += size
+		}
+	}
+	if len(keys) == 0 {
+		return stats, nil
+	}
+	sort.Strings(keys)
+
+	// The chunks must be listed again by the next backup, even when only some of them were deleted
+	err = r.stor.DeleteMany(ctx, keys)
+	r.mu.Lock()
+	r.chunks = nil
+	r.mu.Unlock()
+	if err != nil {
+		return PruneStats{}, err
+	}
+
+	return stats, nil
+}
+
+// Check checks that the chunks referenced by the snapshots are stored.
+// With readData, the chunks are also downloaded and checked against their hash.
+// All the problems found are returned together.
+func (r *Repository) Check(ctx context.Context, readData bool) (CheckStats, error) {
+
+	stats := CheckStats{}
+	referenced, snapshots, err := r.referencedChunks(ctx)
+	if err != nil {
+		return stats, err
+	}
+	stats.Snapshots = len(snapshots)
+	stats.Chunks = len(referenced)
+
+	stored, err := r.listChunks(ctx)
+	if err != nil {
+		return stats, err
+	}
+	for id := range stored {
+		if !referenced[id] {
+			stats.Unreferenced++
+		}
+	}
+
+	ids := make([]string, 0, len(referenced))
+	for id := range referenced {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	errs := []error{}
+	for _, id := range ids {
+
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+
+		if _, ok := stored[id]; !ok {
+			errs = append(errs, fmt.Errorf("chunk %s is missing", id))
+			continue
+		}
+		if !readData {
+			continue
+		}
+
+		_, err := r.loadChunk(ctx, id)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stats.Read++
+	}
+
+	return stats, errors.Join(errs...)
+}
@@ -0,0 +1,249 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Polo44444/harpo/models"
+	"github.com/Polo44444/harpo/storing"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Layout of the repository, under its prefix:
+//
+//	config.json                 Version and chunker params
+//	data/<2 hex>/<sha256>       Chunks compressed with zstd, named after the SHA-256 of their content
+//	snapshots/<time>-<id>.json  Files of each backup, referencing their chunks
+//	locks/<kind>-<id>           Locks of the running backups (shared) and prunes (exclusive)
+const (
+	configFile    = "config.json"
+	dataDir       = "data/"
+	snapshotsDir  = "snapshots/"
+	locksDir      = "locks/"
+	snapshotExt   = ".json"
+	formatVersion = 1
+)
+
+// ErrNotInitialized is returned when the repository has no config yet
+var ErrNotInitialized = errors.New("repository is not initialized")
+
+// Config is the config of the repository, written by its first backup
+type Config struct {
+	Version int           `json:"version"`
+	Chunker ChunkerParams `json:"chunker"`
+}
+
+// Repository is a deduplicated repository of content-defined chunks, stored inside a storage
+type Repository struct {
+	stor   storing.Provider
+	prefix string
+	config Config
+
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	mu      sync.Mutex
+	chunks  map[string]bool // Chunks known to be stored. Nil until listed
+}
+
+// newRepository returns the repository of the storage stored under prefix
+func newRepository(stor storing.Provider, prefix string) (*Repository, error) {
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{
+		stor:    stor,
+		prefix:  prefix,
+		encoder: encoder,
+		decoder: decoder,
+	}, nil
+}
+
+// Open opens the repository stored under prefix. ErrNotInitialized is returned when it does not exist.
+func Open(ctx context.Context, stor storing.Provider, prefix string) (*Repository, error) {
+
+	r, err := newRepository(stor, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	err = stor.List(ctx, r.prefix+configFile, func(page []models.FileInfo) error {
+		for _, file := range page {
+			found = found || file.Key == r.prefix+configFile
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotInitialized
+	}
+
+	buf := &bytes.Buffer{}
+	err = stor.DownloadWithWriter(ctx, r.prefix+configFile, buf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read repository config: %w", err)
+	}
+	err = json.Unmarshal(buf.Bytes(), &r.config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read repository config: %w", err)
+	}
+	if r.config.Version != formatVersion {
+		return nil, fmt.Errorf("repository version %d is not supported", r.config.Version)
+	}
+
+	return r, nil
+}
+
+// Init opens the repository stored under prefix, or creates it with the chunker params.
+// The params of an existing repository are kept.
+func Init(ctx context.Context, stor storing.Provider, prefix string, params ChunkerParams) (*Repository, error) {
+
+	r, err := Open(ctx, stor, prefix)
+	if !errors.Is(err, ErrNotInitialized) {
+		return r, err
+	}
+
+	r, err = newRepository(stor, prefix)
+	if err != nil {
+		return nil, err
+	}
+	r.config = Config{Version: formatVersion, Chunker: params}
+
+	data, err := json.MarshalIndent(r.config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = stor.UploadWithReader(ctx, r.prefix+configFile, bytes.NewReader(data), "application/json")
+	if err != nil {
+		return nil, fmt.Errorf("unable to write repository config: %w", err)
+	}
+
+	return r, nil
+}
+
+// Config returns the config of the repository
+func (r *Repository) Config() Config {
+	return r.config
+}
+
+// chunkKey returns the key of the chunk
+func (r *Repository) chunkKey(id string) string {
+	return r.prefix + dataDir + id[:2] + "/" + id
+}
+
+// listChunks returns the stored chunks and their sizes
+func (r *Repository) listChunks(ctx context.Context) (map[string]int64, error) {
+
+	chunks := map[string]int64{}
+	err := r.stor.List(ctx, r.prefix+dataDir, func(page []models.FileInfo) error {
+		for _, file := range page {
+			id := path.Base(file.Key)
+			if len(id) == sha256.Size*2 {
+				chunks[id] = file.Size
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+// hasChunk returns whether the chunk is stored. Stored chunks are listed once.
+func (r *Repository) hasChunk(ctx context.Context, id string) (bool, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.chunks == nil {
+
+		chunks, err := r.listChunks(ctx)
+		if err != nil {
+			return false, err
+		}
+		r.chunks = map[string]bool{}
+		for id := range chunks {
+			r.chunks[id] = true
+		}
+	}
+
+	return r.chunks[id], nil
+}
+
+// saveChunk stores the chunk when it is not stored yet.
+// It returns the chunk ID and the size added to the repository.
+func (r *Repository) saveChunk(ctx context.Context, data []byte) (string, int64, error) {
+
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+
+	ok, err := r.hasChunk(ctx, id)
+	if err != nil || ok {
+		return id, 0, err
+	}
+
+	compressed := r.encoder.EncodeAll(data, nil)
+	err = r.stor.UploadWithReader(ctx, r.chunkKey(id), bytes.NewReader(compressed), "application/octet-stream")
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to upload chunk %s: %w", id, err)
+	}
+
+	r.mu.Lock()
+	r.chunks[id] = true
+	r.mu.Unlock()
+
+	return id, int64(len(compressed)), nil
+}
+
+// loadChunk downloads the chunk and checks its content against its ID
+func (r *Repository) loadChunk(ctx context.Context, id string) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+	err := r.stor.DownloadWithWriter(ctx, r.chunkKey(id), buf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download chunk %s: %w", id, err)
+	}
+
+	data, err := r.decoder.DecodeAll(buf.Bytes(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s is corrupted: %w", id, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("chunk %s is corrupted: content does not match its hash", id)
+	}
+
+	return data, nil
+}
+
+// Close releases the resources of the repository
+func (r *Repository) Close() {
+
+	r.encoder.Close()
+	r.decoder.Close()
+}
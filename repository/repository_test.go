@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/storing"
+	storing_local "github.com/Polo44444/harpo/storing/local"
+)
+
+func TestRepository(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	err := os.MkdirAll(filepath.Join(src, "texts"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err.Error())
+	}
+	big := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(big)
+	for name, content := range map[string][]byte{
+		"big.bin":         big,
+		"copy.bin":        big,
+		"texts/file1.txt": []byte("Hello World!"),
+		"empty.txt":       nil,
+	} {
+		if err := os.WriteFile(filepath.Join(src, name), content, 0640); err != nil {
+			t.Fatalf("Error creating file: %s", err.Error())
+		}
+	}
+
+	stor, err := storing.GetProvider(storing.LocalProvider, storing_local.BuildLocalConfig(filepath.Join(dir, "storage"), false, 0600, 0700))
+	if err != nil {
+		t.Fatalf("Error creating local provider: %s", err.Error())
+	}
+
+	if _, err := Open(ctx, stor, "repo"); err != ErrNotInitialized {
+		t.Fatalf("Missing repository opened: %v", err)
+	}
+
+	params, _ := NewChunkerParams(4096)
+	r, err := Init(ctx, stor, "repo", params)
+	if err != nil {
+		t.Fatalf("Error initializing repository: %s", err.Error())
+	}
+	defer r.Close()
+
+	// Identical files are stored once
+	srcs := []archiving.Source{{Path: src}}
+	first, stats, err := r.Backup(ctx, srcs, nil, false)
+	if err != nil {
+		t.Fatalf("Error backing up: %s", err.Error())
+	}
+	if stats.Files != 4 || stats.NewChunks == 0 || stats.NewChunks > stats.Chunks/2+1 {
+		t.Fatalf("Unexpected first backup stats: %+v", stats)
+	}
+
+	// A small change only stores a few chunks
+	changed := append([]byte("prefix"), big...)
+	if err := os.WriteFile(filepath.Join(src, "big.bin"), changed, 0640); err != nil {
+		t.Fatalf("Error writing file: %s", err.Error())
+	}
+	os.Remove(filepath.Join(src, "copy.bin"))
+
+	// The repository keeps its params when initialized again
+	other, _ := NewChunkerParams(1 << 20)
+	r2, err := Init(ctx, stor, "repo", other)
+	if err != nil || r2.Config().Chunker != params {
+		t.Fatalf("Repository params changed: %+v %v", r2.Config(), err)
+	}
+	r2.Close()
+
+	second, stats, err := r.Backup(ctx, srcs, nil, false)
+	if err != nil {
+		t.Fatalf("Error backing up: %s", err.Error())
+	}
+	if stats.NewChunks == 0 || stats.NewChunks > 3 {
+		t.Fatalf("%d new chunks after a small change", stats.NewChunks)
+	}
+
+	snapshots, err := r.Snapshots(ctx)
+	if err != nil || len(snapshots) != 2 || snapshots[0].Key != second.Key || snapshots[1].Key != first.Key {
+		t.Fatalf("Unexpected snapshots: %+v %v", snapshots, err)
+	}
+
+	// Each snapshot restores its own files
+	target := filepath.Join(dir, "restored")
+	err = r.Restore(ctx, first.Key, target, nil)
+	if err != nil {
+		t.Fatalf("Error restoring: %s", err.Error())
+	}
+	for name, content := range map[string][]byte{"src/big.bin": big, "src/copy.bin": big, "src/texts/file1.txt": []byte("Hello World!"), "src/empty.txt": {}} {
+		data, err := os.ReadFile(filepath.Join(target, name))
+		if err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("File %s not restored: %v", name, err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(target, "src/texts/file1.txt"))
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("Mode of file not restored: %v %v", info.Mode(), err)
+	}
+
+	err = r.Restore(ctx, second.Key, "", map[string]string{"src": filepath.Join(dir, "second")})
+	if err != nil {
+		t.Fatalf("Error restoring: %s", err.Error())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "second", "big.bin"))
+	if err != nil || !bytes.Equal(data, changed) {
+		t.Fatalf("Changed file not restored: %v", err)
+	}
+
+	// Forgetting the first snapshot leaves its own chunks unreferenced
+	cStats, err := r.Check(ctx, true)
+	if err != nil || cStats.Snapshots != 2 || cStats.Unreferenced != 0 || cStats.Read != cStats.Chunks {
+		t.Fatalf("Unexpected check: %+v %v", cStats, err)
+	}
+	err = r.Forget(ctx, []string{first.Key})
+	if err != nil {
+		t.Fatalf("Error forgetting snapshot: %s", err.Error())
+	}
+	cStats, _ = r.Check(ctx, false)
+	if cStats.Unreferenced == 0 {
+		t.Fatalf("No unreferenced chunk after forget: %+v", cStats)
+	}
+	pStats, err := r.Prune(ctx)
+	if err != nil || pStats.Chunks != cStats.Unreferenced {
+		t.Fatalf("Unexpected prune: %+v %v", pStats, err)
+	}
+	err = r.Restore(ctx, second.Key, filepath.Join(dir, "pruned"), nil)
+	if err != nil {
+		t.Fatalf("Error restoring after prune: %s", err.Error())
+	}
+
+	// Check finds the missing and the corrupted chunks
+	chunks, err := r.listChunks(ctx)
+	if err != nil || len(chunks) < 2 {
+		t.Fatalf("Unexpected chunks: %v", err)
+	}
+	ids := []string{}
+	for id := range chunks {
+		ids = append(ids, id)
+	}
+	stor.Delete(ctx, r.chunkKey(ids[0]))
+	stor.UploadWithReader(ctx, r.chunkKey(ids[1]), bytes.NewReader(r.encoder.EncodeAll([]byte("corrupted"), nil)), "")
+
+	_, err = r.Check(ctx, false)
+	if err == nil || !strings.Contains(err.Error(), ids[0]+" is missing") || strings.Contains(err.Error(), ids[1]) {
+		t.Fatalf("Unexpected check error: %v", err)
+	}
+	_, err = r.Check(ctx, true)
+	if err == nil || !strings.Contains(err.Error(), ids[1]+" is corrupted") {
+		t.Fatalf("Unexpected check error with data: %v", err)
+	}
+}
+
+func TestRepositoryLock(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	dir := t.TempDir()
+	stor, err := storing.GetProvider(storing.LocalProvider, storing_local.BuildLocalConfig(dir, false, 0600, 0700))
+	if err != nil {
+		t.Fatalf("Error creating local provider: %s", err.Error())
+	}
+
+	params, _ := NewChunkerParams(4096)
+	r, err := Init(ctx, stor, "repo", params)
+	if err != nil {
+		t.Fatalf("Error initializing repository: %s", err.Error())
+	}
+	defer r.Close()
+
+	// Backups share the repository, a prune can not run at the same time
+	backup, err := r.lock(ctx, false)
+	if err != nil {
+		t.Fatalf("Error locking repository: %s", err.Error())
+	}
+	other, err := r.lock(ctx, false)
+	if err != nil {
+		t.Fatalf("Shared lock refused: %s", err.Error())
+	}
+	if _, err := r.Prune(ctx); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Prune ran during a backup: %v", err)
+	}
+	other.unlock()
+
+	// A lock left by a stopped process is ignored once stale
+	old := time.Now().Add(-staleLockAge - time.Minute)
+	err = os.Chtimes(filepath.Join(dir, filepath.FromSlash(backup.key)), old, old)
+	if err != nil {
+		t.Fatalf("Error aging lock: %s", err.Error())
+	}
+	backup.cancel()
+	<-backup.done
+	if _, err := r.Prune(ctx); err != nil {
+		t.Fatalf("Prune refused by a stale lock: %s", err.Error())
+	}
+	backup.delete()
+
+	// A backup can not run during a prune, and the locks are removed once released
+	prune, err := r.lock(ctx, true)
+	if err != nil {
+		t.Fatalf("Error locking repository: %s", err.Error())
+	}
+	if _, _, err := r.Backup(ctx, []archiving.Source{{Path: t.TempDir()}}, nil, false); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Backup ran during a prune: %v", err)
+	}
+	prune.unlock()
+
+	entries, err := os.ReadDir(filepath.Join(dir, "repo", "locks"))
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Locks left behind: %v %v", entries, err)
+	}
+}
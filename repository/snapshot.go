@@ -0,0 +1,333 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Polo44444/harpo/archiving"
+	"github.com/Polo44444/harpo/models"
+	"github.com/google/uuid"
+)
+
+// Types of the snapshot nodes
+const (
+	DirNode     = "dir"
+	FileNode    = "file"
+	SymlinkNode = "symlink"
+)
+
+// snapshotTimeLayout is the layout of the snapshot time inside its key, sorted like the time
+const snapshotTimeLayout = "20060102T150405Z"
+
+// Node is a file or a directory of a snapshot, named as inside the archives
+type Node struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Mode       fs.FileMode `json:"mode"`
+	ModTime    time.Time   `json:"mod_time"`
+	Size       int64       `json:"size,omitempty"`
+	LinkTarget string      `json:"link_target,omitempty"`
+	Chunks     []string    `json:"chunks,omitempty"` // IDs of the chunks of the file content, in order
+}
+
+// Snapshot lists the files of a backup and their chunks
+type Snapshot struct {
+	ID    string    `json:"id"`
+	Time  time.Time `json:"time"`
+	Nodes []Node    `json:"nodes"`
+}
+
+// SnapshotInfo describes a snapshot stored inside the repository
+type SnapshotInfo struct {
+	Key     string
+	ID      string
+	Time    time.Time
+	Size    int64 // Size of the snapshot object, not of its files
+	ModTime time.Time
+}
+
+// BackupStats counts what a backup stored
+type BackupStats struct {
+	Files      int
+	Size       int64 // Size of the files of the snapshot
+	Chunks     int   // Chunks of the files of the snapshot
+	NewChunks  int   // Chunks added to the repository
+	StoredSize int64 // Compressed size of the added chunks
+}
+
+// Backup stores the files of the sources which go through the filter, then the snapshot referencing them.
+// Only the chunks missing from the repository are uploaded.
+// With ignoreErrors, files which can not be read are left out of the snapshot.
+func (r *Repository) Backup(ctx context.Context, srcs []archiving.Source, filter *archiving.Filter, ignoreErrors bool) (SnapshotInfo, BackupStats, error) {
+
+	stats := BackupStats{}
+	files, err := archiving.FilesFromDisk(srcs, filter, ignoreErrors)
+	if err != nil {
+		return SnapshotInfo{}, stats, err
+	}
+
+	l, err := r.lock(ctx, false)
+	if err != nil {
+		return SnapshotInfo{}, stats, err
+	}
+	defer l.unlock()
+
+	snapshot := Snapshot{
+		ID:   uuid.Must(uuid.NewRandom()).String(),
+		Time: time.Now().UTC(),
+	}
+	for _, f := range files {
+
+		if ctx.Err() != nil {
+			return SnapshotInfo{}, stats, ctx.Err()
+		}
+
+		node := Node{
+			Name:    f.NameInArchive,
+			Mode:    f.Mode(),
+			ModTime: f.ModTime().UTC(),
+		}
+
+		switch {
+		case f.IsDir():
+			node.Type = DirNode
+		case f.LinkTarget != "":
+			node.Type = SymlinkNode
+			node.LinkTarget = f.LinkTarget
+		default:
+			node.Type = FileNode
+			err = r.saveFile(ctx, f.Open, &node, &stats)
+			if err != nil && ignoreErrors {
+				log.Printf("Unable to store file %s, it is left out: %v\n", f.NameInArchive, err)
+				continue
+			}
+			if err != nil {
+				return SnapshotInfo{}, stats, fmt.Errorf("unable to store file %s: %w", f.NameInArchive, err)
+			}
+			stats.Files++
+			stats.Size += node.Size
+		}
+
+		snapshot.Nodes = append(snapshot.Nodes, node)
+	}
+
+	// The snapshot is stored last, once all its chunks are stored
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return SnapshotInfo{}, stats, err
+	}
+
+	info := SnapshotInfo{
+		Key:  r.prefix + snapshotsDir + snapshot.Time.Format(snapshotTimeLayout) + "-" + snapshot.ID[:8] + snapshotExt,
+		ID:   snapshot.ID,
+		Time: snapshot.Time,
+		Size: int64(len(data)),
+	}
+	err = r.stor.UploadWithReader(ctx, info.Key, bytes.NewReader(data), "application/json")
+	if err != nil {
+		return SnapshotInfo{}, stats, fmt.Errorf("unable to upload snapshot: %w", err)
+	}
+
+	return info, stats, nil
+}
+
+// saveFile splits the file into chunks and stores the missing ones
+func (r *Repository) saveFile(ctx context.Context, open func() (io.ReadCloser, error), node *Node, stats *BackupStats) error {
+
+	file, err := open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	chunker := NewChunker(file, r.config.Chunker)
+	for {
+
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		id, stored, err := r.saveChunk(ctx, chunk)
+		if err != nil {
+			return err
+		}
+
+		node.Chunks = append(node.Chunks, id)
+		node.Size += int64(len(chunk))
+		stats.Chunks++
+		if stored > 0 {
+			stats.NewChunks++
+			stats.StoredSize += stored
+		}
+	}
+}
+
+// Snapshots returns the snapshots of the repository, newest first
+func (r *Repository) Snapshots(ctx context.Context) ([]SnapshotInfo, error) {
+
+	snapshots := []SnapshotInfo{}
+	err := r.stor.List(ctx, r.prefix+snapshotsDir, func(page []models.FileInfo) error {
+		for _, file := range page {
+
+			// Snapshots are named <time>-<short id>.json
+			name := strings.TrimSuffix(path.Base(file.Key), snapshotExt)
+			i := strings.Index(name, "-")
+			if i < 0 || !strings.HasSuffix(file.Key, snapshotExt) {
+				continue
+			}
+			t, err := time.Parse(snapshotTimeLayout, name[:i])
+			if err != nil {
+				continue
+			}
+
+			snapshots = append(snapshots, SnapshotInfo{
+				Key:     file.Key,
+				ID:      name[i+1:],
+				Time:    t,
+				Size:    file.Size,
+				ModTime: file.ModTime,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if snapshots[i].Time.Equal(snapshots[j].Time) {
+			return snapshots[i].ModTime.After(snapshots[j].ModTime)
+		}
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+
+	return snapshots, nil
+}
+
+// LoadSnapshot downloads the snapshot stored under the key
+func (r *Repository) LoadSnapshot(ctx context.Context, key string) (*Snapshot, error) {
+
+	buf := &bytes.Buffer{}
+	err := r.stor.DownloadWithWriter(ctx, key, buf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download snapshot %s: %w", key, err)
+	}
+
+	snapshot := &Snapshot{}
+	err = json.Unmarshal(buf.Bytes(), snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read snapshot %s: %w", key, err)
+	}
+
+	return snapshot, nil
+}
+
+// Restore writes the files of the snapshot stored under the key inside dst.
+// `targets` maps top level names of the snapshot to the directories where they are restored instead of dst, as Extract does.
+// Chunks are checked against their hash before being written.
+func (r *Repository) Restore(ctx context.Context, key string, dst string, targets map[string]string) error {
+
+	snapshot, err := r.LoadSnapshot(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range snapshot.Nodes {
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// The rules of the snapshot are not part of the restored files
+		if node.Name == archiving.RulesFileName {
+			continue
+		}
+
+		filePath, err := archiving.ExtractPath(node.Name, dst, targets)
+		if err != nil {
+			return err
+		}
+
+		err = r.restoreNode(ctx, node, filePath)
+		if err != nil {
+			return fmt.Errorf("unable to restore %s: %w", node.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// restoreNode writes the node at filePath
+func (r *Repository) restoreNode(ctx context.Context, node Node, filePath string) error {
+
+	switch node.Type {
+	case DirNode:
+		return os.MkdirAll(filePath, os.ModePerm)
+	case SymlinkNode:
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return os.Symlink(node.LinkTarget, filePath)
+	}
+
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, node.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, id := range node.Chunks {
+
+		data, err := r.loadChunk(ctx, id)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(filePath, node.ModTime, node.ModTime)
+}
+
+// Forget deletes the snapshots stored under the keys. Their chunks are deleted by Prune.
+func (r *Repository) Forget(ctx context.Context, keys []string) error {
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, r.prefix+snapshotsDir) {
+			return fmt.Errorf("%s is not a snapshot of the repository", key)
+		}
+	}
+
+	return r.stor.DeleteMany(ctx, keys)
+}
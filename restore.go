@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Polo44444/harpo/backup"
+	"github.com/Polo44444/harpo/config"
+)
+
+// restore downloads and extracts the archive of a folder from a storage
+func restore(settings *config.Settings, args []string) error {
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	folderName := fs.String("folder", "", "name of the folder to restore")
+	storageName := fs.String("storage", "", "name of the storage to download the archive from")
+	key := fs.String("key", "", "path of the archive or of the snapshot to restore. Default is the latest archive alias of the folder, or its newest snapshot")
+	target := fs.String("target", "", "directory where the archive will be extracted")
+	original := fs.Bool("original", false, "restore each path of the folder at its original location instead of inside a target")
+	identity := fs.String("identity", "", "age identity file used to decrypt the archive. Default is the identities of the encryption settings")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-c config] restore -folder name -storage name [-key path] [-identity file] (-target dir | -original)\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// Exactly one of target and original must be given
+	if strings.TrimSpace(*folderName) == "" || strings.TrimSpace(*storageName) == "" || (strings.TrimSpace(*target) == "") != *original {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// The identity given on the command line replaces the configured ones
+	if *identity != "" {
+
+		for i := range settings.Folders {
+			settings.Folders[i].Encryption.Identities = []string{*identity}
+		}
+		for name, storage := range settings.Storages {
+			storage.Encryption.Identities = []string{*identity}
+			settings.Storages[name] = storage
+		}
+	}
+
+	// ─── Load Providers ──────────────────────────────────────────────────
+	storages := settings.GetStorageProviders()
+	notifiers := settings.GetNotifierProviders()
+	defer func() {
+		for _, storage := range storages {
+			storage.Close(context.Background())
+		}
+
+		for _, notifier := range notifiers {
+			notifier.Close(context.Background())
+		}
+	}()
+
+	bck := backup.NewEngine(settings.Folders, storages, notifiers)
+	bck.SetStorageEncryptions(settings.GetStorageEncryptions())
+	err := bck.Restore(*folderName, *storageName, *key, *target)
+
+	// Make sure notifications are sent before exiting
+	backup.WaitNotifications()
+	if err != nil {
+		return err
+	}
+
+	if *original {
+		log.Printf("Folder %s restored at its original locations\n", *folderName)
+		return nil
+	}
+	log.Printf("Folder %s restored inside %s\n", *folderName, *target)
+
+	return nil
+}
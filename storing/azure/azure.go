@@ -0,0 +1,278 @@
+package storing_azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Polo44444/harpo/models"
+	storing_probe "github.com/Polo44444/harpo/storing/probe"
+)
+
+const (
+	defaultBlockSize   = 8 // MiB
+	defaultConcurrency = 4
+	mib                = 1024 * 1024
+)
+
+type azureProvider struct {
+	c             *container.Client
+	accountName   string
+	accountKey    string           // Shared key authentication
+	sasToken      string           // SAS token authentication. Used when no account key is set
+	containerName string           // Container where the blobs are stored
+	endpoint      string           // Blob service endpoint. Default is https://<account_name>.blob.core.windows.net
+	blockSize     int64            // Size of the uploaded blocks in bytes
+	concurrency   int              // Number of blocks uploaded in parallel
+	accessTier    *blob.AccessTier // Access tier of the uploaded blobs. Default is the account tier
+}
+
+func BuildAzureConfig(accountName, accountKey, sasToken, containerName, endpoint string, blockSize, concurrency int, accessTier string) models.ProviderConfig {
+
+	return models.ProviderConfig{
+		"account_name": accountName,
+		"account_key":  accountKey,
+		"sas_token":    sasToken,
+		"container":    containerName,
+		"endpoint":     endpoint,
+		"block_size":   blockSize,
+		"concurrency":  concurrency,
+		"access_tier":  accessTier,
+	}
+}
+
+func NewAzureProvider(config models.ProviderConfig) (*azureProvider, error) {
+
+	prvd := &azureProvider{
+		blockSize:   defaultBlockSize * mib,
+		concurrency: defaultConcurrency,
+	}
+	prvd.accountName, _ = config["account_name"].(string)
+	prvd.accountKey, _ = config["account_key"].(string)
+	prvd.sasToken, _ = config["sas_token"].(string)
+	prvd.containerName, _ = config["container"].(string)
+	prvd.endpoint, _ = config["endpoint"].(string)
+
+	if blockSize, ok := config["block_size"].(int); ok && blockSize > 0 {
+		prvd.blockSize = int64(blockSize) * mib
+	}
+	if concurrency, ok := config["concurrency"].(int); ok && concurrency > 0 {
+		prvd.concurrency = concurrency
+	}
+
+	if tier, _ := config["access_tier"].(string); tier != "" {
+
+		accessTier, err := parseAccessTier(tier)
+		if err != nil {
+			return nil, err
+		}
+		prvd.accessTier = &accessTier
+	}
+
+	if strings.TrimSpace(prvd.accountName) == "" {
+		return nil, fmt.Errorf("account_name is required")
+	}
+	if strings.TrimSpace(prvd.containerName) == "" {
+		return nil, fmt.Errorf("container is required")
+	}
+	if prvd.endpoint == "" {
+		prvd.endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", prvd.accountName)
+	}
+
+	containerURL := strings.TrimSuffix(prvd.endpoint, "/") + "/" + prvd.containerName
+
+	var err error
+	switch {
+	case prvd.accountKey != "":
+
+		cred, credErr := container.NewSharedKeyCredential(prvd.accountName, prvd.accountKey)
+		if credErr != nil {
+			return nil, fmt.Errorf("invalid account key: %w", credErr)
+		}
+		prvd.c, err = container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+	case prvd.sasToken != "":
+		prvd.c, err = container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(prvd.sasToken, "?"), nil)
+	default:
+		return nil, fmt.Errorf("account_key or sas_token is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create azure client, %w", err)
+	}
+
+	return prvd, nil
+}
+
+// parseAccessTier returns the access tier matching the name, case insensitive
+func parseAccessTier(name string) (blob.AccessTier, error) {
+
+	for _, tier := range blob.PossibleAccessTierValues() {
+		if strings.EqualFold(string(tier), name) {
+			return tier, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid access tier %s", name)
+}
+
+// blobName returns the name of the blob at filePath
+func blobName(filePath string) string {
+	return strings.TrimPrefix(strings.ReplaceAll(filePath, "\\", "/"), "/")
+}
+
+func (a *azureProvider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	_, err := a.c.NewBlockBlobClient(blobName(filePath)).UploadStream(ctx, data, &blockblob.UploadStreamOptions{
+		BlockSize:   a.blockSize,
+		Concurrency: a.concurrency,
+		AccessTier:  a.accessTier,
+		HTTPHeaders: &blob.HTTPHeaders{
+			BlobContentType: to.Ptr(contentType),
+		},
+	})
+
+	return err
+}
+
+func (a *azureProvider) DownloadWithWriter(ctx context.Context, filePath string, writer io.Writer) error {
+
+	resp, err := a.c.NewBlobClient(blobName(filePath)).DownloadStream(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(writer, resp.Body)
+	return err
+}
+
+func (a *azureProvider) Info(ctx context.Context, filePath string) (*models.FileInfo, error) {
+
+	props, err := a.c.NewBlobClient(blobName(filePath)).GetProperties(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{
+		"content_type": deref(props.ContentType),
+		"etag":         strings.Trim(string(deref(props.ETag)), `"`),
+		"access_tier":  deref(props.AccessTier),
+	}
+	if len(props.ContentMD5) > 0 {
+		metadata[models.MD5Metadata] = fmt.Sprintf("%x", props.ContentMD5)
+	}
+	for k, v := range props.Metadata {
+		metadata[k] = deref(v)
+	}
+
+	return &models.FileInfo{
+		Key:      filePath,
+		Size:     deref(props.ContentLength),
+		ModTime:  deref(props.LastModified),
+		Metadata: metadata,
+	}, nil
+}
+
+func (a *azureProvider) List(ctx context.Context, prefix string, fn models.ListFunc) error {
+
+	pager := a.c.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  to.Ptr(blobName(prefix)),
+		Include: container.ListBlobsInclude{Metadata: true},
+	})
+
+	for pager.More() {
+
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		files := make([]models.FileInfo, 0, len(page.Segment.BlobItems))
+		for _, item := range page.Segment.BlobItems {
+
+			metadata := map[string]string{}
+			if item.Properties != nil {
+				metadata["content_type"] = deref(item.Properties.ContentType)
+				metadata["etag"] = strings.Trim(string(deref(item.Properties.ETag)), `"`)
+				if item.Properties.AccessTier != nil {
+					metadata["access_tier"] = string(*item.Properties.AccessTier)
+				}
+			}
+			for k, v := range item.Metadata {
+				metadata[k] = deref(v)
+			}
+
+			file := models.FileInfo{
+				Key:      deref(item.Name),
+				Metadata: metadata,
+			}
+			if item.Properties != nil {
+				file.Size = deref(item.Properties.ContentLength)
+				file.ModTime = deref(item.Properties.LastModified)
+			}
+
+			files = append(files, file)
+		}
+
+		if err := fn(files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the blob. Deleting a blob which does not exist is not an error.
+func (a *azureProvider) Delete(ctx context.Context, filePath string) error {
+
+	_, err := a.c.NewBlobClient(blobName(filePath)).Delete(ctx, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+func (a *azureProvider) DeleteMany(ctx context.Context, filePaths []string) error {
+
+	errs := []error{}
+	for _, fp := range filePaths {
+		if err := a.Delete(ctx, fp); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Test tests the connection to the container.
+func (a *azureProvider) Test(ctx context.Context, folder string) error {
+	return storing_probe.Run(ctx, a, folder)
+}
+
+// Close closes the provider.
+// Azure provider does not need to be closed.
+func (a *azureProvider) Close(ctx context.Context) error {
+	return nil
+}
+
+// deref returns the value pointed by p, or the zero value when p is nil
+func deref[T any](p *T) T {
+
+	if p == nil {
+		var zero T
+		return zero
+	}
+
+	return *p
+}
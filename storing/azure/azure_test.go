@@ -0,0 +1,119 @@
+package storing_azure
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Polo44444/harpo/models"
+)
+
+// Well known Azurite development account
+const (
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+func TestNewAzureProvider(t *testing.T) {
+
+	p, err := NewAzureProvider(BuildAzureConfig(azuriteAccountName, azuriteAccountKey, "", "harpo", "", 4, 2, "cool"))
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err.Error())
+	}
+	if p.endpoint != "https://devstoreaccount1.blob.core.windows.net" {
+		t.Fatalf("Unexpected default endpoint %s", p.endpoint)
+	}
+	if p.blockSize != 4*mib || p.concurrency != 2 || p.accessTier == nil || *p.accessTier != blob.AccessTierCool {
+		t.Fatalf("Unexpected upload settings: %d %d %v", p.blockSize, p.concurrency, p.accessTier)
+	}
+
+	// SAS token authentication
+	_, err = NewAzureProvider(BuildAzureConfig(azuriteAccountName, "", "?sv=2022-11-02&sig=abc", "harpo", "", 0, 0, ""))
+	if err != nil {
+		t.Fatalf("Error creating provider with SAS token: %s", err.Error())
+	}
+
+	// Invalid configurations
+	invalid := map[string][]string{
+		"no credentials": {azuriteAccountName, "", "", "harpo", ""},
+		"no container":   {azuriteAccountName, azuriteAccountKey, "", "", ""},
+		"invalid tier":   {azuriteAccountName, azuriteAccountKey, "", "harpo", "Lukewarm"},
+	}
+	for name, v := range invalid {
+		_, err := NewAzureProvider(BuildAzureConfig(v[0], v[1], v[2], v[3], "", 0, 0, v[4]))
+		if err == nil {
+			t.Fatalf("%s: provider created", name)
+		}
+	}
+}
+
+// TestAzurite runs against the Azurite emulator, e.g.
+// HARPO_AZURITE_ENDPOINT=http://127.0.0.1:10000/devstoreaccount1 go test ./storing/azure/
+func TestAzurite(t *testing.T) {
+
+	endpoint := os.Getenv("HARPO_AZURITE_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("HARPO_AZURITE_ENDPOINT is not set")
+	}
+
+	p, err := NewAzureProvider(BuildAzureConfig(azuriteAccountName, azuriteAccountKey, "", "harpo", endpoint, 1, 2, ""))
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err.Error())
+	}
+	defer p.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err = p.c.Create(ctx, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		t.Fatalf("Error creating container: %s", err.Error())
+	}
+
+	err = p.Test(ctx, "backup")
+	if err != nil {
+		t.Fatalf("Error testing provider: %s", err.Error())
+	}
+
+	// Upload a multi blocks archive
+	data := bytes.Repeat([]byte("harpo"), 1024*1024)
+	err = p.UploadWithReader(ctx, "backup/user1/a.harpo.zip", bytes.NewReader(data), "application/zip")
+	if err != nil {
+		t.Fatalf("Error uploading: %s", err.Error())
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = p.DownloadWithWriter(ctx, "backup/user1/a.harpo.zip", buf)
+	if err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("Downloaded %d bytes, error %v", buf.Len(), err)
+	}
+
+	files, err := listAll(ctx, p, "backup/user1/")
+	if err != nil {
+		t.Fatalf("Error listing: %s", err.Error())
+	}
+	if len(files) != 1 || files[0].Size != int64(len(data)) || files[0].Metadata["content_type"] != "application/zip" {
+		t.Fatalf("Unexpected listed files: %+v", files)
+	}
+
+	err = p.DeleteMany(ctx, []string{"backup/user1/a.harpo.zip", "backup/missing.zip"})
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err.Error())
+	}
+}
+
+// listAll returns all the files listed under prefix
+func listAll(ctx context.Context, p *azureProvider, prefix string) ([]models.FileInfo, error) {
+
+	files := []models.FileInfo{}
+	err := p.List(ctx, prefix, func(page []models.FileInfo) error {
+		files = append(files, page...)
+		return nil
+	})
+
+	return files, err
+}
@@ -0,0 +1,248 @@
+package storing_gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Polo44444/harpo/models"
+	storing_probe "github.com/Polo44444/harpo/storing/probe"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const (
+	defaultChunkSize = 16 // MiB
+	mib              = 1024 * 1024
+)
+
+type gcsProvider struct {
+	c               *storage.Client
+	bucket          string
+	credentialsFile string            // Path of the service account JSON key
+	credentialsJSON string            // Content of the service account JSON key. Used instead of credentialsFile when set
+	endpoint        string            // Custom endpoint, e.g. fake-gcs-server. Credentials are optional with a custom endpoint
+	storageClass    string            // Storage class of the uploaded objects. Default is the bucket class
+	chunkSize       int               // Size of the resumable upload chunks in bytes
+	metadata        map[string]string // Metadata added to the uploaded objects
+}
+
+func BuildGCSConfig(bucket, credentialsFile, credentialsJSON, endpoint, storageClass string, chunkSize int, metadata map[string]string) models.ProviderConfig {
+
+	meta := map[string]interface{}{}
+	for k, v := range metadata {
+		meta[k] = v
+	}
+
+	return models.ProviderConfig{
+		"bucket":           bucket,
+		"credentials_file": credentialsFile,
+		"credentials_json": credentialsJSON,
+		"endpoint":         endpoint,
+		"storage_class":    storageClass,
+		"chunk_size":       chunkSize,
+		"metadata":         meta,
+	}
+}
+
+func NewGCSProvider(config models.ProviderConfig) (*gcsProvider, error) {
+
+	prvd := &gcsProvider{
+		chunkSize: defaultChunkSize * mib,
+		metadata:  map[string]string{},
+	}
+	prvd.bucket, _ = config["bucket"].(string)
+	prvd.credentialsFile, _ = config["credentials_file"].(string)
+	prvd.credentialsJSON, _ = config["credentials_json"].(string)
+	prvd.endpoint, _ = config["endpoint"].(string)
+	prvd.storageClass, _ = config["storage_class"].(string)
+	prvd.storageClass = strings.ToUpper(prvd.storageClass)
+
+	if chunkSize, ok := config["chunk_size"].(int); ok && chunkSize > 0 {
+		prvd.chunkSize = chunkSize * mib
+	}
+
+	if metadata, ok := config["metadata"].(map[string]interface{}); ok {
+		for k, v := range metadata {
+			prvd.metadata[k] = fmt.Sprint(v)
+		}
+	}
+
+	if strings.TrimSpace(prvd.bucket) == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+
+	opts := []option.ClientOption{}
+	switch {
+	case prvd.credentialsJSON != "":
+		opts = append(opts, option.WithCredentialsJSON([]byte(prvd.credentialsJSON)))
+	case prvd.credentialsFile != "":
+		opts = append(opts, option.WithCredentialsFile(prvd.credentialsFile))
+	case prvd.endpoint != "":
+		opts = append(opts, option.WithoutAuthentication())
+	default:
+		return nil, fmt.Errorf("credentials_file or credentials_json is required")
+	}
+
+	// Emulators do not always serve the XML API, reads go through the JSON API
+	if prvd.endpoint != "" {
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(prvd.endpoint, "/")+"/storage/v1/"), storage.WithJSONReads())
+	}
+
+	c, err := storage.NewClient(context.TODO(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcs client, %w", err)
+	}
+	prvd.c = c
+
+	return prvd, nil
+}
+
+// objectName returns the name of the object at filePath
+func objectName(filePath string) string {
+	return strings.TrimPrefix(strings.ReplaceAll(filePath, "\\", "/"), "/")
+}
+
+func (g *gcsProvider) object(filePath string) *storage.ObjectHandle {
+	return g.c.Bucket(g.bucket).Object(objectName(filePath))
+}
+
+// UploadWithReader uploads the data with a resumable upload, chunk by chunk.
+func (g *gcsProvider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Cancelling the context aborts the upload. Otherwise, closing the writer would commit a partial object.
+	wCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := g.object(filePath).NewWriter(wCtx)
+	w.ContentType = contentType
+	w.ChunkSize = g.chunkSize
+	w.StorageClass = g.storageClass
+	w.Metadata = g.metadata
+
+	_, err := io.Copy(w, data)
+	if err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func (g *gcsProvider) DownloadWithWriter(ctx context.Context, filePath string, writer io.Writer) error {
+
+	r, err := g.object(filePath).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(writer, r)
+	return err
+}
+
+// toFileInfo converts the attributes of an object
+func toFileInfo(attrs *storage.ObjectAttrs) models.FileInfo {
+
+	metadata := map[string]string{
+		"content_type":  attrs.ContentType,
+		"etag":          attrs.Etag,
+		"storage_class": attrs.StorageClass,
+		"crc32c":        fmt.Sprintf("%08x", attrs.CRC32C),
+	}
+	if len(attrs.MD5) > 0 {
+		metadata[models.MD5Metadata] = fmt.Sprintf("%x", attrs.MD5)
+	}
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+
+	return models.FileInfo{
+		Key:      attrs.Name,
+		Size:     attrs.Size,
+		ModTime:  attrs.Updated,
+		Metadata: metadata,
+	}
+}
+
+func (g *gcsProvider) Info(ctx context.Context, filePath string) (*models.FileInfo, error) {
+
+	attrs, err := g.object(filePath).Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := toFileInfo(attrs)
+	info.Key = filePath
+
+	return &info, nil
+}
+
+func (g *gcsProvider) List(ctx context.Context, prefix string, fn models.ListFunc) error {
+
+	it := g.c.Bucket(g.bucket).Objects(ctx, &storage.Query{Prefix: objectName(prefix)})
+	pager := iterator.NewPager(it, models.ListPageSize, "")
+	for {
+
+		attrs := []*storage.ObjectAttrs{}
+		token, err := pager.NextPage(&attrs)
+		if err != nil {
+			return err
+		}
+
+		files := make([]models.FileInfo, 0, len(attrs))
+		for _, a := range attrs {
+			files = append(files, toFileInfo(a))
+		}
+		if len(files) > 0 {
+			if err := fn(files); err != nil {
+				return err
+			}
+		}
+
+		if token == "" {
+			return nil
+		}
+	}
+}
+
+// Delete removes the object. Deleting an object which does not exist is not an error.
+func (g *gcsProvider) Delete(ctx context.Context, filePath string) error {
+
+	err := g.object(filePath).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (g *gcsProvider) DeleteMany(ctx context.Context, filePaths []string) error {
+
+	errs := []error{}
+	for _, fp := range filePaths {
+		if err := g.Delete(ctx, fp); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Test tests the connection to the bucket.
+func (g *gcsProvider) Test(ctx context.Context, folder string) error {
+	return storing_probe.Run(ctx, g, folder)
+}
+
+// Close closes the client.
+func (g *gcsProvider) Close(ctx context.Context) error {
+	return g.c.Close()
+}
@@ -0,0 +1,97 @@
+package storing_gcs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+func TestGCS(t *testing.T) {
+
+	// In-process fake-gcs-server
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme: "http",
+		Host:   "127.0.0.1",
+		Port:   0,
+	})
+	if err != nil {
+		t.Fatalf("Error starting fake gcs server: %s", err.Error())
+	}
+	defer server.Stop()
+	server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: "harpo"})
+
+	p, err := NewGCSProvider(BuildGCSConfig("harpo", "", "", server.URL(), "NEARLINE", 1, map[string]string{"app": "harpo"}))
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err.Error())
+	}
+	defer p.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err = p.Test(ctx, "backup")
+	if err != nil {
+		t.Fatalf("Error testing provider: %s", err.Error())
+	}
+
+	// Upload an archive bigger than a chunk
+	data := bytes.Repeat([]byte("harpo"), 512*1024)
+	err = p.UploadWithReader(ctx, "backup/user1/a.harpo.zip", bytes.NewReader(data), "application/zip")
+	if err != nil {
+		t.Fatalf("Error uploading: %s", err.Error())
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = p.DownloadWithWriter(ctx, "backup/user1/a.harpo.zip", buf)
+	if err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("Downloaded %d bytes, error %v", buf.Len(), err)
+	}
+
+	info, err := p.Info(ctx, "backup/user1/a.harpo.zip")
+	if err != nil {
+		t.Fatalf("Error getting info: %s", err.Error())
+	}
+	if info.Size != int64(len(data)) || info.Metadata["app"] != "harpo" || info.Metadata["content_type"] != "application/zip" {
+		t.Fatalf("Unexpected info: %+v", info)
+	}
+
+	files, err := listAll(ctx, p, "backup/user1/")
+	if err != nil {
+		t.Fatalf("Error listing: %s", err.Error())
+	}
+	if len(files) != 1 || files[0].Key != "backup/user1/a.harpo.zip" {
+		t.Fatalf("Unexpected listed files: %+v", files)
+	}
+
+	err = p.DeleteMany(ctx, []string{"backup/user1/a.harpo.zip", "backup/missing.zip"})
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err.Error())
+	}
+
+	files, err = listAll(ctx, p, "backup/")
+	if err != nil || len(files) != 0 {
+		t.Fatalf("Listed %d files after deletion, error %v", len(files), err)
+	}
+
+	// Credentials are required without custom endpoint
+	_, err = NewGCSProvider(BuildGCSConfig("harpo", "", "", "", "", 0, nil))
+	if err == nil {
+		t.Fatalf("Provider created without credentials")
+	}
+}
+
+// listAll returns all the files listed under prefix
+func listAll(ctx context.Context, p *gcsProvider, prefix string) ([]models.FileInfo, error) {
+
+	files := []models.FileInfo{}
+	err := p.List(ctx, prefix, func(page []models.FileInfo) error {
+		files = append(files, page...)
+		return nil
+	})
+
+	return files, err
+}
@@ -0,0 +1,319 @@
+package storing_local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Polo44444/harpo/internal/ioctx"
+	"github.com/Polo44444/harpo/models"
+	storing_probe "github.com/Polo44444/harpo/storing/probe"
+)
+
+const (
+	defaultFileMode os.FileMode = 0640
+	defaultDirMode  os.FileMode = 0750
+
+	// Prefix of the temporary files written before being renamed
+	tmpFilePrefix = ".harpo-tmp-"
+)
+
+type localProvider struct {
+	root     string      // Directory where the files are stored
+	fsync    bool        // When true, files and directories are synced to the disk after each write
+	fileMode os.FileMode // Permissions of the written files
+	dirMode  os.FileMode // Permissions of the created directories
+}
+
+func BuildLocalConfig(root string, fsync bool, fileMode, dirMode os.FileMode) models.ProviderConfig {
+
+	return models.ProviderConfig{
+		"root":      root,
+		"fsync":     fsync,
+		"file_mode": fmt.Sprintf("%04o", fileMode),
+		"dir_mode":  fmt.Sprintf("%04o", dirMode),
+	}
+}
+
+func NewLocalProvider(config models.ProviderConfig) (*localProvider, error) {
+
+	root, _ := config["root"].(string)
+	if strings.TrimSpace(root) == "" {
+		return nil, fmt.Errorf("root directory is required")
+	}
+
+	prvd := &localProvider{
+		root:     filepath.Clean(root),
+		fileMode: defaultFileMode,
+		dirMode:  defaultDirMode,
+	}
+
+	if fsync, ok := config["fsync"].(bool); ok {
+		prvd.fsync = fsync
+	}
+
+	var err error
+	if prvd.fileMode, err = parseMode(config["file_mode"], defaultFileMode); err != nil {
+		return nil, fmt.Errorf("invalid file_mode: %w", err)
+	}
+	if prvd.dirMode, err = parseMode(config["dir_mode"], defaultDirMode); err != nil {
+		return nil, fmt.Errorf("invalid dir_mode: %w", err)
+	}
+
+	return prvd, nil
+}
+
+// parseMode parses an octal permission such as "0640". Integers are used as they are.
+func parseMode(value interface{}, defaultMode os.FileMode) (os.FileMode, error) {
+
+	switch v := value.(type) {
+	case nil:
+		return defaultMode, nil
+	case string:
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return 0, err
+		}
+		return os.FileMode(mode).Perm(), nil
+	case int:
+		return os.FileMode(v).Perm(), nil
+	case os.FileMode:
+		return v.Perm(), nil
+	default:
+		return 0, fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// absPath returns the path of filePath on disk. The path can not escape the root directory.
+func (l *localProvider) absPath(filePath string) (string, error) {
+
+	cleaned := path.Clean("/" + strings.ReplaceAll(filePath, "\\", "/"))
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid file path %s", filePath)
+	}
+
+	return filepath.Join(l.root, filepath.FromSlash(cleaned)), nil
+}
+
+// syncDir flushes the directory entries to the disk
+func (l *localProvider) syncDir(dir string) error {
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// UploadWithReader writes the data inside a temporary file then renames it, so readers never see a partial file.
+func (l *localProvider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
+
+	dst, err := l.absPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(dst)
+	err = os.MkdirAll(dir, l.dirMode)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, tmpFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name()) // No need to check errors here. The file does not exist anymore after a successful rename
+	}()
+
+	_, err = io.Copy(tmp, ioctx.NewReader(ctx, data))
+	if err != nil {
+		return err
+	}
+
+	if l.fsync {
+		err = tmp.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), l.fileMode)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), dst)
+	if err != nil {
+		return err
+	}
+
+	if l.fsync {
+		return l.syncDir(dir)
+	}
+
+	return nil
+}
+
+func (l *localProvider) DownloadWithWriter(ctx context.Context, filePath string, writer io.Writer) error {
+
+	src, err := l.absPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(writer, ioctx.NewReader(ctx, file))
+	return err
+}
+
+func (l *localProvider) Info(ctx context.Context, filePath string) (*models.FileInfo, error) {
+
+	src, err := l.absPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", filePath)
+	}
+
+	return &models.FileInfo{
+		Key:     filePath,
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+		Metadata: map[string]string{
+			"mode": fileInfo.Mode().Perm().String(),
+		},
+	}, nil
+}
+
+func (l *localProvider) List(ctx context.Context, prefix string, fn models.ListFunc) error {
+
+	// We only walk the deepest directory holding the prefix
+	prefix = strings.TrimPrefix(strings.ReplaceAll(prefix, "\\", "/"), "/")
+	walkRoot := l.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		walkRoot = filepath.Join(l.root, filepath.FromSlash(prefix[:i]))
+	}
+
+	files := []models.FileInfo{}
+	err := filepath.WalkDir(walkRoot, func(p string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tmpFilePrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, models.FileInfo{
+			Key:     key,
+			Size:    fileInfo.Size(),
+			ModTime: fileInfo.ModTime(),
+			Metadata: map[string]string{
+				"mode": fileInfo.Mode().Perm().String(),
+			},
+		})
+		if len(files) < models.ListPageSize {
+			return nil
+		}
+
+		err = fn(files)
+		files = []models.FileInfo{}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		return fn(files)
+	}
+
+	return nil
+}
+
+// Delete removes the file. Deleting a file which does not exist is not an error.
+func (l *localProvider) Delete(ctx context.Context, filePath string) error {
+
+	dst, err := l.absPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(dst)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *localProvider) DeleteMany(ctx context.Context, filePaths []string) error {
+
+	errs := []error{}
+	for _, fp := range filePaths {
+		if err := l.Delete(ctx, fp); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Test tests the access to the root directory.
+func (l *localProvider) Test(ctx context.Context, folder string) error {
+	return storing_probe.Run(ctx, l, folder)
+}
+
+// Close closes the provider.
+// Local provider does not need to be closed.
+func (l *localProvider) Close(ctx context.Context) error {
+	return nil
+}
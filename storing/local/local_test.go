@@ -0,0 +1,147 @@
+package storing_local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
+)
+
+func TestLocal(t *testing.T) {
+
+	root := t.TempDir()
+	p, err := NewLocalProvider(BuildLocalConfig(root, true, 0600, 0700))
+	if err != nil {
+		t.Fatalf("Error creating local provider: %s", err.Error())
+	}
+	defer p.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Connection test
+	err = p.Test(ctx, "backup")
+	if err != nil {
+		t.Fatalf("Error testing provider: %s", err.Error())
+	}
+
+	// Upload
+	err = p.UploadWithReader(ctx, "backup/user1/a.harpo.zip", bytes.NewBufferString("archive a"), "application/zip")
+	if err != nil {
+		t.Fatalf("Error uploading: %s", err.Error())
+	}
+	err = p.UploadWithReader(ctx, "backup/user2/b.harpo.zip", bytes.NewBufferString("archive b"), "application/zip")
+	if err != nil {
+		t.Fatalf("Error uploading: %s", err.Error())
+	}
+
+	fileInfo, err := os.Stat(filepath.Join(root, "backup", "user1", "a.harpo.zip"))
+	if err != nil {
+		t.Fatalf("Error checking uploaded file: %s", err.Error())
+	}
+	if fileInfo.Mode().Perm() != 0600 {
+		t.Fatalf("Uploaded file mode is %s, want %s", fileInfo.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// Paths can not escape the root directory
+	err = p.UploadWithReader(ctx, "../../escaped.txt", bytes.NewBufferString("escaped"), "text/plain")
+	if err != nil {
+		t.Fatalf("Error uploading: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err != nil {
+		t.Fatalf("File has not been written inside the root directory: %s", err.Error())
+	}
+
+	// List
+	files, err := listAll(ctx, p, "backup/user1/")
+	if err != nil {
+		t.Fatalf("Error listing: %s", err.Error())
+	}
+	if len(files) != 1 || files[0].Key != "backup/user1/a.harpo.zip" || files[0].Size != int64(len("archive a")) {
+		t.Fatalf("Unexpected listed files: %+v", files)
+	}
+
+	files, err = listAll(ctx, p, "backup/user")
+	if err != nil {
+		t.Fatalf("Error listing: %s", err.Error())
+	}
+	if len(files) != 2 {
+		t.Fatalf("Listed %d files, want 2", len(files))
+	}
+
+	// Delete many
+	err = p.DeleteMany(ctx, []string{"backup/user1/a.harpo.zip", "backup/user2/b.harpo.zip", "backup/missing.zip"})
+	if err != nil {
+		t.Fatalf("Error deleting files: %s", err.Error())
+	}
+
+	files, err = listAll(ctx, p, "")
+	if err != nil {
+		t.Fatalf("Error listing: %s", err.Error())
+	}
+	if len(files) != 1 || files[0].Key != "escaped.txt" {
+		t.Fatalf("Unexpected listed files: %+v", files)
+	}
+}
+
+func TestLocalListPages(t *testing.T) {
+
+	root := t.TempDir()
+	p, err := NewLocalProvider(BuildLocalConfig(root, true, 0600, 0700))
+	if err != nil {
+		t.Fatalf("Error creating local provider: %s", err.Error())
+	}
+	defer p.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for i := 0; i <= models.ListPageSize; i++ {
+		err = os.WriteFile(filepath.Join(root, fmt.Sprintf("%04d.txt", i)), []byte("data"), 0600)
+		if err != nil {
+			t.Fatalf("Error writing file: %s", err.Error())
+		}
+	}
+
+	// The files are handed one page at a time
+	sizes := []int{}
+	err = p.List(ctx, "", func(page []models.FileInfo) error {
+		sizes = append(sizes, len(page))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error listing: %s", err.Error())
+	}
+	if len(sizes) != 2 || sizes[0] != models.ListPageSize || sizes[1] != 1 {
+		t.Fatalf("Unexpected page sizes: %v", sizes)
+	}
+
+	// An error returned by the callback stops the listing
+	errStop := errors.New("stop")
+	pages := 0
+	err = p.List(ctx, "", func(page []models.FileInfo) error {
+		pages++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || pages != 1 {
+		t.Fatalf("Listing has not been stopped: %v after %d pages", err, pages)
+	}
+}
+
+// listAll returns all the files listed under prefix
+func listAll(ctx context.Context, p *localProvider, prefix string) ([]models.FileInfo, error) {
+
+	files := []models.FileInfo{}
+	err := p.List(ctx, prefix, func(page []models.FileInfo) error {
+		files = append(files, page...)
+		return nil
+	})
+
+	return files, err
+}
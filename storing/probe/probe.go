@@ -0,0 +1,70 @@
+package storing_probe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/Polo44444/harpo/models"
+)
+
+// Target is the part of a storage provider exercised by the probe
+type Target interface {
+	UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error
+	DownloadWithWriter(ctx context.Context, filePath string, writer io.Writer) error
+	Info(ctx context.Context, filePath string) (*models.FileInfo, error)
+	Delete(ctx context.Context, filePath string) error
+}
+
+// Run uploads, downloads, inspects and deletes a test file inside folder.
+// The returned error describes the steps which succeeded before the failure.
+func Run(ctx context.Context, t Target, folder string) error {
+
+	testFilePath := strings.ReplaceAll(filepath.Join(folder, "test.txt"), "\\", "/")
+	testFileContent := "Hi dear! It's Harpo!🤗"
+
+	// Upload
+	err := t.UploadWithReader(ctx, testFilePath, bytes.NewBufferString(testFileContent), "text/plain")
+	if err != nil {
+		return fmt.Errorf("1. Failed to upload test file, %w", err)
+	}
+
+	// Download
+	buf := bytes.NewBuffer(nil)
+	err = t.DownloadWithWriter(ctx, testFilePath, buf)
+	if err != nil {
+		return fmt.Errorf(`1. Upload successfull!
+2. failed to download test file, %w`, err)
+	}
+
+	// Check content
+	if testFileContent != buf.String() {
+		return fmt.Errorf(`1. Upload successfull!
+2. Download successfull!
+3. Test file content is not the same`)
+	}
+
+	// Info
+	info, err := t.Info(ctx, testFilePath)
+	if err != nil || info.Size != int64(len(testFileContent)) {
+		return fmt.Errorf(`1. Upload successfull!
+2. Download successfull!
+3. Test file content is the same!
+4. Failed to get info of test file, %w`, err)
+	}
+
+	// Delete
+	err = t.Delete(ctx, testFilePath)
+	if err != nil {
+		return fmt.Errorf(`1. Upload successfull!
+2. Download successfull!
+3. Test file content is the same!
+4. Info successfull!
+5. Failed to delete test file, %w`, err)
+	}
+
+	return nil
+}
@@ -5,11 +5,21 @@ import (
 	"io"
 
 	"github.com/Polo44444/harpo/models"
+	storing_azure "github.com/Polo44444/harpo/storing/azure"
+	storing_gcs "github.com/Polo44444/harpo/storing/gcs"
+	storing_local "github.com/Polo44444/harpo/storing/local"
 	storing_s3 "github.com/Polo44444/harpo/storing/s3"
+	storing_sftp "github.com/Polo44444/harpo/storing/sftp"
+	storing_webdav "github.com/Polo44444/harpo/storing/webdav"
 )
 
 const (
-	S3Provider models.ProviderEntity = "S3"
+	S3Provider     models.ProviderEntity = "S3"
+	LocalProvider  models.ProviderEntity = "LOCAL"
+	SFTPProvider   models.ProviderEntity = "SFTP"
+	WebDAVProvider models.ProviderEntity = "WEBDAV"
+	AzureProvider  models.ProviderEntity = "AZURE_BLOB"
+	GCSProvider    models.ProviderEntity = "GCS"
 )
 
 // Provider interface
@@ -37,6 +47,13 @@ type Provider interface {
 	*/
 	Info(ctx context.Context, filePath string) (*models.FileInfo, error)
 
+	/*List calls fn with the information of the files whose path starts with prefix, one page at a time.
+	`prefix` is the prefix of the files paths. An empty prefix lists all the files.
+	`fn` is called for each page of files. If it returns an error, the listing stops and List returns it.
+	*/
+	// Only one page is held in memory at once, so large storages can be listed.
+	List(ctx context.Context, prefix string, fn models.ListFunc) error
+
 	/*Delete deletes the data from the filePath.
 	`filePath` is the path where the data will be deleted.
 	*/
@@ -45,8 +62,8 @@ type Provider interface {
 	/*DeleteMany removes multiple files from the storage.
 	`filePaths` is a list of paths where the data will be deleted.
 	*/
-	// If one file fails to be deleted, the function will continue deleting the rest of the files.
-	// If all files fails to be deleted, the function will return an nil (no error).
+	// If one file fails to be deleted, the function will continue deleting the rest of the files,
+	// then return the errors of all the files which could not be deleted.
 	DeleteMany(ctx context.Context, filePaths []string) error
 
 	/*Close closes the provider.
@@ -54,6 +71,21 @@ type Provider interface {
 	Close(ctx context.Context) error
 }
 
+// ListAll returns the information of all the files whose path starts with prefix.
+func ListAll(ctx context.Context, prvd Provider, prefix string) ([]models.FileInfo, error) {
+
+	files := []models.FileInfo{}
+	err := prvd.List(ctx, prefix, func(page []models.FileInfo) error {
+		files = append(files, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
 // GetProvider returns a provider based on the entity and the config
 func GetProvider(entity models.ProviderEntity, config models.ProviderConfig) (Provider, error) {
 
@@ -63,6 +95,16 @@ func GetProvider(entity models.ProviderEntity, config models.ProviderConfig) (Pr
 	switch entity {
 	case S3Provider:
 		prvd, err = storing_s3.NewS3Provider(config)
+	case LocalProvider:
+		prvd, err = storing_local.NewLocalProvider(config)
+	case SFTPProvider:
+		prvd, err = storing_sftp.NewSFTPProvider(config)
+	case WebDAVProvider:
+		prvd, err = storing_webdav.NewWebDAVProvider(config)
+	case AzureProvider:
+		prvd, err = storing_azure.NewAzureProvider(config)
+	case GCSProvider:
+		prvd, err = storing_gcs.NewGCSProvider(config)
 	default:
 		err = models.ErrProviderNotSupported
 	}
@@ -1,14 +1,16 @@
 package storing_s3
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
 	"strings"
 
 	"github.com/Polo44444/harpo/models"
+	storing_probe "github.com/Polo44444/harpo/storing/probe"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	s3config "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
@@ -17,6 +19,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// deleteBatchSize is the maximum number of keys of a DeleteObjects request
+const deleteBatchSize = 1000
+
 type s3Provider struct {
 	c               *s3.Client
 	accessKeyID     string
@@ -82,13 +87,15 @@ func NewS3Provider(config models.ProviderConfig) (*s3Provider, error) {
 
 func (s *s3Provider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
 
+	// S3 checks the SHA-256 of each part. Single part uploads keep it as the checksum of the object, compared by QUICK verifications
 	uploader := manager.NewUploader(s.c)
 	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(filePath),
-		Body:        data,
-		ContentType: aws.String(contentType),
-		ACL:         types.ObjectCannedACLPrivate,
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(filePath),
+		Body:              data,
+		ContentType:       aws.String(contentType),
+		ACL:               types.ObjectCannedACLPrivate,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
 	})
 
 	return err
@@ -96,8 +103,18 @@ func (s *s3Provider) UploadWithReader(ctx context.Context, filePath string, data
 
 func (s *s3Provider) DownloadWithWriter(ctx context.Context, filePath string, writer io.Writer) error {
 
-	downloader := manager.NewDownloader(s.c)
-	_, err := downloader.Download(ctx, NewWriterAtFromWriter(writer), &s3.GetObjectInput{
+	// Files can be written concurrently, other writers need sequential parts.
+	w, ok := writer.(io.WriterAt)
+	concurrency := manager.DefaultDownloadConcurrency
+	if !ok {
+		w = NewWriterAtFromWriter(writer)
+		concurrency = 1
+	}
+
+	downloader := manager.NewDownloader(s.c, func(d *manager.Downloader) {
+		d.Concurrency = concurrency
+	})
+	_, err := downloader.Download(ctx, w, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(filePath),
 	})
@@ -108,96 +125,128 @@ func (s *s3Provider) DownloadWithWriter(ctx context.Context, filePath string, wr
 func (s *s3Provider) Info(ctx context.Context, filePath string) (*models.FileInfo, error) {
 
 	headObjectOutput, err := s.c.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(filePath),
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(filePath),
+		ChecksumMode: types.ChecksumModeEnabled,
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	etag := strings.Trim(aws.ToString(headObjectOutput.ETag), `"`)
+	metadata := map[string]string{
+		"content_type": aws.ToString(headObjectOutput.ContentType),
+		"etag":         etag,
+	}
+
+	// The ETag is the MD5 of the content for single part uploads without KMS or customer key encryption
+	sse := headObjectOutput.ServerSideEncryption
+	if len(etag) == 32 && !strings.Contains(etag, "-") && headObjectOutput.SSECustomerAlgorithm == nil &&
+		(sse == "" || sse == types.ServerSideEncryptionAes256) {
+		metadata[models.MD5Metadata] = etag
+	}
+
+	// The checksums of multipart uploads are checksums of their parts, suffixed by their count
+	if sum := aws.ToString(headObjectOutput.ChecksumSHA256); sum != "" && !strings.Contains(sum, "-") {
+		if data, err := base64.StdEncoding.DecodeString(sum); err == nil {
+			metadata[models.SHA256Metadata] = hex.EncodeToString(data)
+		}
+	}
+	for k, v := range headObjectOutput.Metadata {
+		metadata[k] = v
+	}
+
 	return &models.FileInfo{
-		Size: *headObjectOutput.ContentLength,
+		Key:      filePath,
+		Size:     aws.ToInt64(headObjectOutput.ContentLength),
+		ModTime:  aws.ToTime(headObjectOutput.LastModified),
+		Metadata: metadata,
 	}, nil
 }
 
-func (s *s3Provider) Delete(ctx context.Context, filePath string) error {
+func (s *s3Provider) List(ctx context.Context, prefix string, fn models.ListFunc) error {
 
-	_, err := s.c.DeleteObject(ctx, &s3.DeleteObjectInput{
+	paginator := s3.NewListObjectsV2Paginator(s.c, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(filePath),
+		Prefix: aws.String(prefix),
 	})
 
-	return err
-}
+	for paginator.HasMorePages() {
 
-func (s *s3Provider) DeleteMany(ctx context.Context, filePaths []string) error {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		files := make([]models.FileInfo, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			files = append(files, models.FileInfo{
+				Key:     aws.ToString(object.Key),
+				Size:    aws.ToInt64(object.Size),
+				ModTime: aws.ToTime(object.LastModified),
+				Metadata: map[string]string{
+					"etag":          strings.Trim(aws.ToString(object.ETag), `"`),
+					"storage_class": string(object.StorageClass),
+				},
+			})
+		}
 
-	// build objectIdentifiers
-	objects := make([]types.ObjectIdentifier, len(filePaths))
-	for i, fp := range filePaths {
-		objects[i] = types.ObjectIdentifier{
-			Key: aws.String(fp),
+		if err := fn(files); err != nil {
+			return err
 		}
 	}
 
-	_, err := s.c.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+	return nil
+}
+
+func (s *s3Provider) Delete(ctx context.Context, filePath string) error {
+
+	_, err := s.c.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
-		Delete: &types.Delete{
-			Objects: objects,
-			Quiet:   aws.Bool(true),
-		},
+		Key:    aws.String(filePath),
 	})
 
 	return err
 }
 
-// Test tests the connection to the provider.
-func (s *s3Provider) Test(ctx context.Context, folder string) error {
-
-	testFilePath := strings.ReplaceAll(filepath.Join(folder, "test.txt"), "\\", "/")
-	testFileContent := "Hi dear! It's Harpo!🤗"
+// DeleteMany deletes the files in batches, as S3 rejects the requests with more than 1000 keys.
+// The keys S3 refuses to delete are returned as errors, along with the failed batches.
+func (s *s3Provider) DeleteMany(ctx context.Context, filePaths []string) error {
 
-	// Upload
-	err := s.UploadWithReader(ctx, testFilePath, bytes.NewBufferString("Hi dear! It's Harpo!🤗"), "text/plain")
-	if err != nil {
-		return fmt.Errorf("1. Failed to upload test file, %w", err)
-	}
+	errs := []error{}
+	for start := 0; start < len(filePaths); start += deleteBatchSize {
 
-	// Download
-	buf := bytes.NewBuffer(nil)
-	err = s.DownloadWithWriter(ctx, testFilePath, buf)
-	if err != nil {
-		return fmt.Errorf(`1. Upload successfull!
-2. failed to download test file, %w`, err)
-	}
-
-	// Check content
-	if testFileContent != buf.String() {
-		return fmt.Errorf(`1. Upload successfull!
-2. Download successfull!
-3. Test file content is not the same`)
-	}
+		end := min(start+deleteBatchSize, len(filePaths))
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, fp := range filePaths[start:end] {
+			objects = append(objects, types.ObjectIdentifier{
+				Key: aws.String(fp),
+			})
+		}
 
-	// Info
-	info, err := s.Info(ctx, testFilePath)
-	if err != nil || info.Size != int64(len(testFileContent)) {
-		return fmt.Errorf(`1. Upload successfull!
-2. Download successfull!
-3. Test file content is the same!
-4. Failed to get info of test file, %w`, err)
+		// Quiet responses only list the keys which could not be deleted
+		out, err := s.c.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, e := range out.Errors {
+			errs = append(errs, fmt.Errorf("unable to delete %s: %s: %s", aws.ToString(e.Key), aws.ToString(e.Code), aws.ToString(e.Message)))
+		}
 	}
 
-	// Delete
-	err = s.Delete(ctx, testFilePath)
-	if err != nil {
-		return fmt.Errorf(`1. Upload successfull!
-2. Download successfull!
-3. Test file content is the same!
-4. Info successfull!
-5. Failed to delete test file, %w`, err)
-	}
+	return errors.Join(errs...)
+}
 
-	return nil
+// Test tests the connection to the provider.
+func (s *s3Provider) Test(ctx context.Context, folder string) error {
+	return storing_probe.Run(ctx, s, folder)
 }
 
 // Close closes the provider.
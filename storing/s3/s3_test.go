@@ -0,0 +1,157 @@
+package storing_s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
+)
+
+// fakeS3 is a fake S3 server keeping the last object uploaded and its SHA-256 checksum.
+// It records the number of keys of each delete request, and refuses to delete the refused keys.
+type fakeS3 struct {
+	*httptest.Server
+	mu           sync.Mutex
+	deleteCounts []int
+	refused      map[string]bool
+}
+
+// startTestServer starts a fake S3 server
+func startTestServer(t *testing.T) *fakeS3 {
+
+	var body []byte
+	checksum := ""
+	fake := &fakeS3{refused: map[string]bool{}}
+
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPost:
+			if !r.URL.Query().Has("delete") {
+				w.WriteHeader(http.StatusNotImplemented)
+				return
+			}
+			req := struct {
+				Objects []struct {
+					Key string
+				} `xml:"Object"`
+			}{}
+			if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if len(req.Objects) > 1000 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "<Error><Code>MalformedXML</Code><Message>Too many keys</Message></Error>")
+				return
+			}
+			fake.deleteCounts = append(fake.deleteCounts, len(req.Objects))
+			fmt.Fprint(w, "<DeleteResult>")
+			for _, o := range req.Objects {
+				if fake.refused[o.Key] {
+					fmt.Fprintf(w, "<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>", o.Key)
+				}
+			}
+			fmt.Fprint(w, "</DeleteResult>")
+		case http.MethodPut:
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = data
+			checksum = r.Header.Get("X-Amz-Checksum-Sha256")
+			if checksum == "" {
+				checksum = r.Trailer.Get("X-Amz-Checksum-Sha256")
+			}
+			w.Header().Set("ETag", `"etag"`)
+		case http.MethodHead:
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Header().Set("ETag", `"etag"`)
+			if checksum != "" {
+				w.Header().Set("X-Amz-Checksum-Sha256", checksum)
+			}
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+func TestS3Checksum(t *testing.T) {
+
+	server := startTestServer(t)
+	p, err := NewS3Provider(BuildS3Config("key", "secret", "harpo", "us-east-1", server.URL, true))
+	if err != nil {
+		t.Fatalf("Error creating S3 provider: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	data := []byte("archive")
+	err = p.UploadWithReader(ctx, "backup/a.harpo.zip", bytes.NewReader(data), "application/zip")
+	if err != nil {
+		t.Fatalf("Error uploading: %s", err.Error())
+	}
+
+	// The SHA-256 sent with the upload is the one QUICK verifications compare
+	info, err := p.Info(ctx, "backup/a.harpo.zip")
+	if err != nil {
+		t.Fatalf("Error getting info: %s", err.Error())
+	}
+	sum := sha256.Sum256(data)
+	if info.Metadata[models.SHA256Metadata] != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Upload checksum is %q, want %s", info.Metadata[models.SHA256Metadata], hex.EncodeToString(sum[:]))
+	}
+}
+
+func TestS3DeleteMany(t *testing.T) {
+
+	server := startTestServer(t)
+	p, err := NewS3Provider(BuildS3Config("key", "secret", "harpo", "us-east-1", server.URL, true))
+	if err != nil {
+		t.Fatalf("Error creating S3 provider: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	keys := []string{}
+	for i := 0; i < 2500; i++ {
+		keys = append(keys, fmt.Sprintf("backup/chunks/%04d", i))
+	}
+
+	// The keys are sent in batches S3 accepts
+	err = p.DeleteMany(ctx, keys)
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err.Error())
+	}
+	if fmt.Sprint(server.deleteCounts) != "[1000 1000 500]" {
+		t.Fatalf("Unexpected delete requests: %v", server.deleteCounts)
+	}
+
+	// The keys S3 refuses to delete are errors
+	server.refused["backup/chunks/1500"] = true
+	err = p.DeleteMany(ctx, keys)
+	if err == nil || !strings.Contains(err.Error(), "backup/chunks/1500") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("Refused key not returned: %v", err)
+	}
+}
@@ -0,0 +1,420 @@
+package storing_sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Polo44444/harpo/internal/ioctx"
+	"github.com/Polo44444/harpo/models"
+	storing_probe "github.com/Polo44444/harpo/storing/probe"
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultPort        = 22
+	defaultDialTimeout = 30 * time.Second
+
+	// Prefix of the temporary files written before being renamed
+	tmpFilePrefix = ".harpo-tmp-"
+)
+
+type sftpProvider struct {
+	host                 string
+	port                 int
+	user                 string
+	password             string
+	privateKey           string // Path of the private key file
+	privateKeyPassphrase string
+	knownHosts           string // Path of the known_hosts file used to verify the server
+	insecureIgnoreHost   bool   // When true, the server host key is not verified
+	baseDir              string // Remote directory where the files are stored. The login directory when empty
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+	root   string // Resolved baseDir, set on the first connection
+}
+
+func BuildSFTPConfig(host string, port int, user, password, privateKey, privateKeyPassphrase, knownHosts, baseDir string) models.ProviderConfig {
+
+	return models.ProviderConfig{
+		"host":                   host,
+		"port":                   port,
+		"user":                   user,
+		"password":               password,
+		"private_key":            privateKey,
+		"private_key_passphrase": privateKeyPassphrase,
+		"known_hosts":            knownHosts,
+		"base_dir":               baseDir,
+	}
+}
+
+func NewSFTPProvider(config models.ProviderConfig) (*sftpProvider, error) {
+
+	prvd := &sftpProvider{port: defaultPort}
+	prvd.host, _ = config["host"].(string)
+	prvd.user, _ = config["user"].(string)
+	prvd.password, _ = config["password"].(string)
+	prvd.privateKey, _ = config["private_key"].(string)
+	prvd.privateKeyPassphrase, _ = config["private_key_passphrase"].(string)
+	prvd.knownHosts, _ = config["known_hosts"].(string)
+	prvd.insecureIgnoreHost, _ = config["insecure_ignore_host_key"].(bool)
+	prvd.baseDir, _ = config["base_dir"].(string)
+
+	if port, ok := config["port"].(int); ok && port > 0 {
+		prvd.port = port
+	}
+
+	if strings.TrimSpace(prvd.host) == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	if strings.TrimSpace(prvd.user) == "" {
+		return nil, fmt.Errorf("user is required")
+	}
+	if prvd.password == "" && prvd.privateKey == "" {
+		return nil, fmt.Errorf("password or private_key is required")
+	}
+	if prvd.knownHosts == "" && !prvd.insecureIgnoreHost {
+		return nil, fmt.Errorf("known_hosts is required unless insecure_ignore_host_key is true")
+	}
+
+	return prvd, nil
+}
+
+// clientConfig builds the SSH client configuration
+func (s *sftpProvider) clientConfig() (*ssh.ClientConfig, error) {
+
+	auths := []ssh.AuthMethod{}
+
+	if s.privateKey != "" {
+
+		key, err := os.ReadFile(s.privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read private key: %w", err)
+		}
+
+		var signer ssh.Signer
+		if s.privateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(s.privateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key: %w", err)
+		}
+
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+
+	if s.password != "" {
+		auths = append(auths, ssh.Password(s.password))
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !s.insecureIgnoreHost {
+
+		var err error
+		hostKeyCallback, err = knownhosts.New(s.knownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load known hosts: %w", err)
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            s.user,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         defaultDialTimeout,
+	}, nil
+}
+
+// getClient returns the SFTP client. The connection is opened on first use and reopened after a failure.
+func (s *sftpProvider) getClient() (*sftp.Client, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	config, err := s.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(s.host, strconv.Itoa(s.port)), config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %w", s.host, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to start sftp session: %w", err)
+	}
+
+	// Without base directory, the files are stored in the login directory instead of the server root
+	if s.root == "" {
+
+		s.root = s.baseDir
+		if s.root == "" {
+			s.root, err = client.Getwd()
+			if err != nil {
+				client.Close()
+				conn.Close()
+				return nil, fmt.Errorf("unable to get the login directory: %w", err)
+			}
+		}
+	}
+
+	s.conn = conn
+	s.client = client
+
+	return client, nil
+}
+
+// checkConn drops the connection of client when err means it has been lost, so the next call reconnects.
+func (s *sftpProvider) checkConn(client *sftp.Client, err error) error {
+
+	if !errors.Is(err, sftp.ErrSSHFxConnectionLost) && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another call may already have reconnected, its connection is kept
+	if s.client == client {
+		s.close()
+	}
+
+	return err
+}
+
+// remotePath returns the path of filePath on the server. It must be called after getClient.
+func (s *sftpProvider) remotePath(filePath string) string {
+	return path.Join(s.root, path.Clean("/"+strings.ReplaceAll(filePath, "\\", "/")))
+}
+
+// UploadWithReader writes the data inside a temporary file then renames it, so readers never see a partial file.
+func (s *sftpProvider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
+
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+
+	dst := s.remotePath(filePath)
+	dir := path.Dir(dst)
+	err = client.MkdirAll(dir)
+	if err != nil {
+		return s.checkConn(client, err)
+	}
+
+	tmpPath := path.Join(dir, tmpFilePrefix+uuid.Must(uuid.NewRandom()).String())
+	tmp, err := client.Create(tmpPath)
+	if err != nil {
+		return s.checkConn(client, err)
+	}
+	defer client.Remove(tmpPath) // No need to check errors here. The file does not exist anymore after a successful rename
+
+	_, err = tmp.ReadFrom(ioctx.NewReader(ctx, data))
+	if err != nil {
+		tmp.Close()
+		return s.checkConn(client, err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return s.checkConn(client, err)
+	}
+
+	// POSIX rename replaces the destination atomically. Other servers refuse to overwrite it.
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return s.checkConn(client, client.PosixRename(tmpPath, dst))
+	}
+
+	err = client.Remove(dst)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return s.checkConn(client, err)
+	}
+
+	return s.checkConn(client, client.Rename(tmpPath, dst))
+}
+
+func (s *sftpProvider) DownloadWithWriter(ctx context.Context, filePath string, writer io.Writer) error {
+
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+
+	file, err := client.Open(s.remotePath(filePath))
+	if err != nil {
+		return s.checkConn(client, err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(writer, ioctx.NewReader(ctx, file))
+	return s.checkConn(client, err)
+}
+
+func (s *sftpProvider) Info(ctx context.Context, filePath string) (*models.FileInfo, error) {
+
+	client, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := client.Stat(s.remotePath(filePath))
+	if err != nil {
+		return nil, s.checkConn(client, err)
+	}
+	if fileInfo.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", filePath)
+	}
+
+	return &models.FileInfo{
+		Key:     filePath,
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+		Metadata: map[string]string{
+			"mode": fileInfo.Mode().Perm().String(),
+		},
+	}, nil
+}
+
+func (s *sftpProvider) List(ctx context.Context, prefix string, fn models.ListFunc) error {
+
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+
+	// We only walk the deepest directory holding the prefix
+	prefix = strings.TrimPrefix(strings.ReplaceAll(prefix, "\\", "/"), "/")
+	root := s.remotePath("")
+	walkRoot := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		walkRoot = s.remotePath(prefix[:i])
+	}
+
+	files := []models.FileInfo{}
+	walker := client.Walk(walkRoot)
+	for walker.Step() {
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return s.checkConn(client, err)
+		}
+
+		fileInfo := walker.Stat()
+		if fileInfo.IsDir() || strings.HasPrefix(fileInfo.Name(), tmpFilePrefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		files = append(files, models.FileInfo{
+			Key:     key,
+			Size:    fileInfo.Size(),
+			ModTime: fileInfo.ModTime(),
+			Metadata: map[string]string{
+				"mode": fileInfo.Mode().Perm().String(),
+			},
+		})
+
+		if len(files) == models.ListPageSize {
+			if err := fn(files); err != nil {
+				return err
+			}
+			files = []models.FileInfo{}
+		}
+	}
+
+	if len(files) > 0 {
+		return fn(files)
+	}
+
+	return nil
+}
+
+// Delete removes the file. Deleting a file which does not exist is not an error.
+func (s *sftpProvider) Delete(ctx context.Context, filePath string) error {
+
+	client, err := s.getClient()
+	if err != nil {
+		return err
+	}
+
+	err = client.Remove(s.remotePath(filePath))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return s.checkConn(client, err)
+	}
+
+	return nil
+}
+
+func (s *sftpProvider) DeleteMany(ctx context.Context, filePaths []string) error {
+
+	errs := []error{}
+	for _, fp := range filePaths {
+		if err := s.Delete(ctx, fp); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Test tests the connection to the server.
+func (s *sftpProvider) Test(ctx context.Context, folder string) error {
+	return storing_probe.Run(ctx, s, folder)
+}
+
+// Close closes the SFTP session and the SSH connection.
+func (s *sftpProvider) Close(ctx context.Context) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.close()
+}
+
+// close closes the connection. The mutex must be held.
+func (s *sftpProvider) close() error {
+
+	if s.client == nil {
+		return nil
+	}
+
+	s.client.Close()
+	err := s.conn.Close()
+	s.client = nil
+	s.conn = nil
+
+	return err
+}
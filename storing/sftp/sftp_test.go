@@ -0,0 +1,278 @@
+package storing_sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testUser     = "harpo"
+	testPassword = "secret"
+)
+
+// startTestServer starts an in-process SFTP server accepting the test password and the given public key.
+// It returns the server address, its host public key and the login directory.
+func startTestServer(t *testing.T, authorizedKey ssh.PublicKey) (string, ssh.PublicKey, string) {
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating host key: %s", err.Error())
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("Error creating host signer: %s", err.Error())
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == testUser && string(pass) == testPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid password")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == testUser && bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err.Error())
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	home := t.TempDir()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config, home)
+		}
+	}()
+
+	return listener.Addr().String(), hostSigner.PublicKey(), home
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig, home string) {
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+
+		server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(home))
+		if err != nil {
+			return
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+// testConfig builds a provider config for the test server
+func testConfig(t *testing.T, addr string, hostKey ssh.PublicKey) models.ProviderConfig {
+
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	err := os.WriteFile(knownHostsPath, []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing known hosts: %s", err.Error())
+	}
+
+	return BuildSFTPConfig(host, portNum, testUser, "", "", "", knownHostsPath, t.TempDir())
+}
+
+func TestSFTP(t *testing.T) {
+
+	// Client key
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating client key: %s", err.Error())
+	}
+	authorizedKey, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatalf("Error creating client public key: %s", err.Error())
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(clientPriv, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("Error encoding client key: %s", err.Error())
+	}
+	privateKeyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	err = os.WriteFile(privateKeyPath, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatalf("Error writing client key: %s", err.Error())
+	}
+
+	addr, hostKey, home := startTestServer(t, authorizedKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Password and private key authentications
+	passwordConf := testConfig(t, addr, hostKey)
+	passwordConf["password"] = testPassword
+
+	keyConf := testConfig(t, addr, hostKey)
+	keyConf["private_key"] = privateKeyPath
+	keyConf["private_key_passphrase"] = "passphrase"
+
+	for name, conf := range map[string]models.ProviderConfig{"password": passwordConf, "private key": keyConf} {
+
+		p, err := NewSFTPProvider(conf)
+		if err != nil {
+			t.Fatalf("%s: error creating provider: %s", name, err.Error())
+		}
+
+		err = p.Test(ctx, "backup")
+		if err != nil {
+			t.Fatalf("%s: error testing provider: %s", name, err.Error())
+		}
+
+		// Upload twice the same file, the second upload replaces the first one
+		for _, content := range []string{"archive v1", "archive v2"} {
+			err = p.UploadWithReader(ctx, "backup/user1/a.harpo.zip", bytes.NewBufferString(content), "application/zip")
+			if err != nil {
+				t.Fatalf("%s: error uploading: %s", name, err.Error())
+			}
+		}
+
+		buf := bytes.NewBuffer(nil)
+		err = p.DownloadWithWriter(ctx, "backup/user1/a.harpo.zip", buf)
+		if err != nil || buf.String() != "archive v2" {
+			t.Fatalf("%s: downloaded %q, error %v", name, buf.String(), err)
+		}
+
+		files, err := listAll(ctx, p, "backup/")
+		if err != nil {
+			t.Fatalf("%s: error listing: %s", name, err.Error())
+		}
+		if len(files) != 1 || files[0].Key != "backup/user1/a.harpo.zip" || files[0].Size != int64(len("archive v2")) {
+			t.Fatalf("%s: unexpected listed files: %+v", name, files)
+		}
+
+		err = p.DeleteMany(ctx, []string{"backup/user1/a.harpo.zip", "backup/missing.zip"})
+		if err != nil {
+			t.Fatalf("%s: error deleting: %s", name, err.Error())
+		}
+
+		_, err = p.Info(ctx, "backup/user1/a.harpo.zip")
+		if err == nil {
+			t.Fatalf("%s: deleted file still exists", name)
+		}
+
+		p.Close(ctx)
+	}
+
+	// Without base directory, the files are stored in the login directory
+	conf := testConfig(t, addr, hostKey)
+	conf["password"] = testPassword
+	conf["base_dir"] = ""
+
+	p, err := NewSFTPProvider(conf)
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err.Error())
+	}
+	err = p.UploadWithReader(ctx, "backup/a.harpo.zip", bytes.NewBufferString("archive"), "application/zip")
+	if err != nil {
+		t.Fatalf("Error uploading: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(home, "backup", "a.harpo.zip")); err != nil {
+		t.Fatalf("File has not been written in the login directory: %s", err.Error())
+	}
+	files, err := listAll(ctx, p, "backup/")
+	if err != nil || len(files) != 1 || files[0].Key != "backup/a.harpo.zip" {
+		t.Fatalf("Unexpected listed files: %+v, error %v", files, err)
+	}
+
+	// A lost connection is only dropped when it is still the current one
+	client, err := p.getClient()
+	if err != nil {
+		t.Fatalf("Error connecting: %s", err.Error())
+	}
+	p.Close(ctx)
+	fresh, err := p.getClient()
+	if err != nil {
+		t.Fatalf("Error reconnecting: %s", err.Error())
+	}
+	p.checkConn(client, io.EOF)
+	if p.client != fresh {
+		t.Fatalf("The current connection has been dropped for an error of a previous one")
+	}
+	p.checkConn(fresh, io.EOF)
+	if p.client != nil {
+		t.Fatalf("The lost connection has not been dropped")
+	}
+	p.Close(ctx)
+
+	// Unknown host keys are refused
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherHostKey, _ := ssh.NewPublicKey(otherKey)
+	conf = testConfig(t, addr, otherHostKey)
+	conf["password"] = testPassword
+
+	p, err = NewSFTPProvider(conf)
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err.Error())
+	}
+	err = p.Test(ctx, "backup")
+	if err == nil {
+		t.Fatalf("Connection to a server with an unknown host key succeeded")
+	}
+}
+
+// listAll returns all the files listed under prefix
+func listAll(ctx context.Context, p *sftpProvider, prefix string) ([]models.FileInfo, error) {
+
+	files := []models.FileInfo{}
+	err := p.List(ctx, prefix, func(page []models.FileInfo) error {
+		files = append(files, page...)
+		return nil
+	})
+
+	return files, err
+}
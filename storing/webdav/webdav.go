@@ -0,0 +1,435 @@
+package storing_webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Polo44444/harpo/models"
+	storing_probe "github.com/Polo44444/harpo/storing/probe"
+)
+
+// Properties requested by PROPFIND
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getlastmodified/>
+    <d:getetag/>
+    <d:getcontenttype/>
+  </d:prop>
+</d:propfind>`
+
+type webdavProvider struct {
+	c        *http.Client
+	baseURL  *url.URL // URL of the collection where the files are stored
+	username string   // Username of the basic authentication
+	password string   // Password of the basic authentication
+	token    string   // Token of the bearer authentication. Used instead of basic authentication when set
+
+	collections sync.Map // Collections known to exist
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ETag          string `xml:"DAV: getetag"`
+	ContentType   string `xml:"DAV: getcontenttype"`
+}
+
+func BuildWebDAVConfig(baseURL, username, password, token string) models.ProviderConfig {
+
+	return models.ProviderConfig{
+		"url":      baseURL,
+		"username": username,
+		"password": password,
+		"token":    token,
+	}
+}
+
+func NewWebDAVProvider(config models.ProviderConfig) (*webdavProvider, error) {
+
+	rawURL, _ := config["url"].(string)
+	if strings.TrimSpace(rawURL) == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+
+	baseURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid url scheme %s", baseURL.Scheme)
+	}
+	baseURL.Path = strings.TrimSuffix(baseURL.Path, "/")
+
+	prvd := &webdavProvider{
+		c:       &http.Client{},
+		baseURL: baseURL,
+	}
+	prvd.username, _ = config["username"].(string)
+	prvd.password, _ = config["password"].(string)
+	prvd.token, _ = config["token"].(string)
+
+	return prvd, nil
+}
+
+// cleanPath returns filePath without leading slash and with forward slashes
+func cleanPath(filePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(filePath, "\\", "/")), "/")
+}
+
+// fileURL returns the URL of the resource at filePath
+func (w *webdavProvider) fileURL(filePath string, collection bool) string {
+
+	u := *w.baseURL
+	u.Path = w.baseURL.Path + "/" + cleanPath(filePath)
+	if collection && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return u.String()
+}
+
+// do sends an authenticated request
+func (w *webdavProvider) do(ctx context.Context, method, url string, body io.Reader, headers map[string]string) (*http.Response, error) {
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	} else if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return w.c.Do(req)
+}
+
+// statusError builds the error of an unexpected response and closes its body
+func statusError(method, filePath string, resp *http.Response) error {
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	return fmt.Errorf("%s %s failed. Status Code: %s. %s", method, filePath, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// mkcolAll creates the collection at dir and all its missing parents
+func (w *webdavProvider) mkcolAll(ctx context.Context, dir string) error {
+
+	dir = cleanPath(dir)
+	if dir == "" {
+		return nil
+	}
+
+	current := ""
+	for _, segment := range strings.Split(dir, "/") {
+
+		current = path.Join(current, segment)
+		if _, ok := w.collections.Load(current); ok {
+			continue
+		}
+
+		resp, err := w.do(ctx, "MKCOL", w.fileURL(current, true), nil, nil)
+		if err != nil {
+			return err
+		}
+
+		// 405 Method Not Allowed means the collection already exists
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return statusError("MKCOL", current, resp)
+		}
+		resp.Body.Close()
+
+		w.collections.Store(current, true)
+	}
+
+	return nil
+}
+
+func (w *webdavProvider) UploadWithReader(ctx context.Context, filePath string, data io.Reader, contentType string) error {
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	err := w.mkcolAll(ctx, path.Dir(cleanPath(filePath)))
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.do(ctx, http.MethodPut, w.fileURL(filePath, false), data, map[string]string{
+		"Content-Type": contentType,
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(http.MethodPut, filePath, resp)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (w *webdavProvider) DownloadWithWriter(ctx context.Context, filePath string, writer io.Writer) error {
+
+	resp, err := w.do(ctx, http.MethodGet, w.fileURL(filePath, false), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return fmt.Errorf("%s: %w", filePath, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(http.MethodGet, filePath, resp)
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(writer, resp.Body)
+	return err
+}
+
+// propfind returns the properties of the resource at filePath and, with depth 1, of its members
+func (w *webdavProvider) propfind(ctx context.Context, filePath string, collection bool, depth string) ([]davResponse, error) {
+
+	resp, err := w.do(ctx, "PROPFIND", w.fileURL(filePath, collection), bytes.NewBufferString(propfindBody), map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusError("PROPFIND", filePath, resp)
+	}
+	defer resp.Body.Close()
+
+	ms := davMultistatus{}
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode PROPFIND response: %w", err)
+	}
+
+	return ms.Responses, nil
+}
+
+// keyFromHref returns the file path of a PROPFIND response href
+func (w *webdavProvider) keyFromHref(href string) (string, error) {
+
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(u.Path, w.baseURL.Path) {
+		return "", fmt.Errorf("href %s is outside of %s", href, w.baseURL.Path)
+	}
+
+	return cleanPath(strings.TrimPrefix(u.Path, w.baseURL.Path)), nil
+}
+
+// toFileInfo converts a PROPFIND response. It returns false for collections.
+func (w *webdavProvider) toFileInfo(r davResponse) (models.FileInfo, bool, error) {
+
+	for _, ps := range r.Propstats {
+
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+
+		if ps.Prop.ResourceType.Collection != nil {
+			return models.FileInfo{}, false, nil
+		}
+
+		key, err := w.keyFromHref(r.Href)
+		if err != nil {
+			return models.FileInfo{}, false, err
+		}
+
+		size, _ := strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+		modTime, _ := http.ParseTime(ps.Prop.LastModified)
+
+		return models.FileInfo{
+			Key:     key,
+			Size:    size,
+			ModTime: modTime,
+			Metadata: map[string]string{
+				"etag":         strings.Trim(ps.Prop.ETag, `"`),
+				"content_type": ps.Prop.ContentType,
+			},
+		}, true, nil
+	}
+
+	return models.FileInfo{}, false, nil
+}
+
+func (w *webdavProvider) Info(ctx context.Context, filePath string) (*models.FileInfo, error) {
+
+	responses, err := w.propfind(ctx, filePath, false, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("no properties returned for %s", filePath)
+	}
+
+	info, ok, err := w.toFileInfo(responses[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is a collection", filePath)
+	}
+	info.Key = filePath
+
+	return &info, nil
+}
+
+// List walks the collections with PROPFIND depth 1 requests, as many servers refuse infinite depth.
+func (w *webdavProvider) List(ctx context.Context, prefix string, fn models.ListFunc) error {
+
+	// We only walk the deepest collection holding the prefix
+	prefix = strings.TrimPrefix(strings.ReplaceAll(prefix, "\\", "/"), "/")
+	start := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = prefix[:i]
+	}
+
+	// Each collection is handed as a page
+	pending := []string{start}
+	for len(pending) > 0 {
+
+		dir := pending[0]
+		pending = pending[1:]
+
+		responses, err := w.propfind(ctx, dir, true, "1")
+		if err != nil {
+			// A missing collection holds no files
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		files := []models.FileInfo{}
+		for _, r := range responses {
+
+			key, err := w.keyFromHref(r.Href)
+			if err != nil {
+				return err
+			}
+			if key == cleanPath(dir) {
+				continue // The collection itself
+			}
+
+			info, ok, err := w.toFileInfo(r)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				// Only walk the collections which can hold the prefix
+				if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+					pending = append(pending, key)
+				}
+				continue
+			}
+
+			if strings.HasPrefix(info.Key, prefix) {
+				files = append(files, info)
+			}
+		}
+
+		if len(files) > 0 {
+			if err := fn(files); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the file. Deleting a file which does not exist is not an error.
+func (w *webdavProvider) Delete(ctx context.Context, filePath string) error {
+
+	resp, err := w.do(ctx, http.MethodDelete, w.fileURL(filePath, false), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusNotFound && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return statusError(http.MethodDelete, filePath, resp)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (w *webdavProvider) DeleteMany(ctx context.Context, filePaths []string) error {
+
+	errs := []error{}
+	for _, fp := range filePaths {
+		if err := w.Delete(ctx, fp); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Test tests the connection to the server.
+func (w *webdavProvider) Test(ctx context.Context, folder string) error {
+	return storing_probe.Run(ctx, w, folder)
+}
+
+// Close closes the idle connections of the provider.
+func (w *webdavProvider) Close(ctx context.Context) error {
+
+	w.c.CloseIdleConnections()
+	return nil
+}
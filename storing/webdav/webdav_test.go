@@ -0,0 +1,140 @@
+package storing_webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Polo44444/harpo/models"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	testUser     = "harpo"
+	testPassword = "secret"
+	testToken    = "token"
+)
+
+// startTestServer starts an in-process WebDAV server under /remote.php/dav/files/harpo.
+// It accepts the test basic credentials and the test bearer token.
+func startTestServer(t *testing.T) *httptest.Server {
+
+	prefix := "/remote.php/dav/files/harpo"
+	handler := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		user, pass, ok := r.BasicAuth()
+		basicOK := ok && user == testUser && pass == testPassword
+		bearerOK := r.Header.Get("Authorization") == "Bearer "+testToken
+		if !basicOK && !bearerOK {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestWebDAV(t *testing.T) {
+
+	server := startTestServer(t)
+	baseURL := server.URL + "/remote.php/dav/files/harpo/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	configs := map[string]models.ProviderConfig{
+		"basic":  BuildWebDAVConfig(baseURL, testUser, testPassword, ""),
+		"bearer": BuildWebDAVConfig(baseURL, "", "", testToken),
+	}
+
+	for name, conf := range configs {
+
+		p, err := NewWebDAVProvider(conf)
+		if err != nil {
+			t.Fatalf("%s: error creating provider: %s", name, err.Error())
+		}
+
+		err = p.Test(ctx, "backup/"+name)
+		if err != nil {
+			t.Fatalf("%s: error testing provider: %s", name, err.Error())
+		}
+
+		// Intermediate collections are created
+		err = p.UploadWithReader(ctx, "backup/"+name+"/user1/a.harpo.zip", bytes.NewBufferString("archive a"), "application/zip")
+		if err != nil {
+			t.Fatalf("%s: error uploading: %s", name, err.Error())
+		}
+		err = p.UploadWithReader(ctx, "backup/"+name+"/user 2/b.harpo.zip", bytes.NewBufferString("archive b"), "application/zip")
+		if err != nil {
+			t.Fatalf("%s: error uploading: %s", name, err.Error())
+		}
+
+		info, err := p.Info(ctx, "backup/"+name+"/user1/a.harpo.zip")
+		if err != nil || info.Size != int64(len("archive a")) {
+			t.Fatalf("%s: unexpected info %+v, error %v", name, info, err)
+		}
+
+		files, err := listAll(ctx, p, "backup/"+name+"/user")
+		if err != nil {
+			t.Fatalf("%s: error listing: %s", name, err.Error())
+		}
+		if len(files) != 2 {
+			t.Fatalf("%s: listed %d files, want 2: %+v", name, len(files), files)
+		}
+
+		files, err = listAll(ctx, p, "backup/"+name+"/user 2/")
+		if err != nil {
+			t.Fatalf("%s: error listing: %s", name, err.Error())
+		}
+		if len(files) != 1 || files[0].Key != "backup/"+name+"/user 2/b.harpo.zip" {
+			t.Fatalf("%s: unexpected listed files: %+v", name, files)
+		}
+
+		err = p.DeleteMany(ctx, []string{"backup/" + name + "/user1/a.harpo.zip", "backup/" + name + "/user 2/b.harpo.zip", "backup/missing.zip"})
+		if err != nil {
+			t.Fatalf("%s: error deleting: %s", name, err.Error())
+		}
+
+		_, err = p.Info(ctx, "backup/"+name+"/user1/a.harpo.zip")
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("%s: deleted file info returned %v", name, err)
+		}
+
+		p.Close(ctx)
+	}
+
+	// Bad credentials are refused
+	p, err := NewWebDAVProvider(BuildWebDAVConfig(baseURL, testUser, "wrong", ""))
+	if err != nil {
+		t.Fatalf("Error creating provider: %s", err.Error())
+	}
+	if err := p.Test(ctx, "backup"); err == nil {
+		t.Fatalf("Test with bad credentials succeeded")
+	}
+}
+
+// listAll returns all the files listed under prefix
+func listAll(ctx context.Context, p *webdavProvider, prefix string) ([]models.FileInfo, error) {
+
+	files := []models.FileInfo{}
+	err := p.List(ctx, prefix, func(page []models.FileInfo) error {
+		files = append(files, page...)
+		return nil
+	})
+
+	return files, err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Polo44444/harpo/backup"
+	"github.com/Polo44444/harpo/config"
+)
+
+// verify downloads an archive of a folder and checks it against its manifest
+func verify(settings *config.Settings, args []string) error {
+
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	folderName := fs.String("folder", "", "name of the folder to verify")
+	storageName := fs.String("storage", "", "name of the storage to download the archive from")
+	key := fs.String("key", "", "path of the archive to verify. Default is the newest archive of the folder")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [-c config] verify -folder name -storage name [-key path]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if strings.TrimSpace(*folderName) == "" || strings.TrimSpace(*storageName) == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	storages := settings.GetStorageProviders()
+	defer func() {
+		for _, storage := range storages {
+			storage.Close(context.Background())
+		}
+	}()
+
+	bck := backup.NewEngine(settings.Folders, storages, nil)
+	m, err := bck.Verify(*folderName, *storageName, *key)
+	if err != nil {
+		return fmt.Errorf("archive of folder %s is not valid: %w", *folderName, err)
+	}
+
+	log.Printf("Archive of folder %s is valid: %d file(s), %s archived on %s, SHA-256 %s\n",
+		*folderName, m.FileCount, formatSize(m.ArchiveSize), m.Host, m.ArchiveSHA256)
+
+	return nil
+}